@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+)
+
+const accessorUsage = `Usage: eg accessor -var path.V -get GetV [-set SetV] [-rename v] [-w] <packages>...
+
+Replaces the references to the package-level variable V from other packages
+with calls of its package's accessor functions: reads with GetV(), and
+assignments, including op= and ++, with SetV(x). The accessors are appended to
+the file declaring V unless its package declares them already, with the
+signatures func() T and func(T). With -rename, V is then renamed, e.g. to an
+unexported name, so nothing else can refer to it directly; its own package's
+references are left referring to it.
+
+References which take V's address, implicitly or not, or assign to its fields
+or elements, or assign to it without -set, can't be rewritten, and are
+reported.
+`
+
+func accessorMain(args []string) error {
+	fs := flag.NewFlagSet("accessor", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, accessorUsage) }
+	varFlag := fs.String("var", "", "the variable, as path.V")
+	getFlag := fs.String("get", "", "the name of the function returning the variable")
+	setFlag := fs.String("set", "", "the name of the function setting the variable")
+	renameFlag := fs.String("rename", "", "the variable's new name")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *varFlag == "" || *getFlag == "" {
+		return errors.New("-var and -get are required")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	obj, err := m.lookup(*varFlag)
+	if err != nil {
+		return err
+	}
+	v, ok := obj.(*types.Var)
+	if !ok || v.IsField() {
+		return fmt.Errorf("%s isn't a package-level variable", *varFlag)
+	}
+	a := &accessors{m: m, v: v, get: *getFlag, set: *setFlag}
+	for _, pkg := range m.pkgs {
+		if pkg.Types == v.Pkg() {
+			a.pkg = pkg
+		}
+	}
+	if err := a.declare(*renameFlag); err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, u := range m.uses(v) {
+		if u.pkg != a.pkg && !a.rewrite(u) {
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("some references couldn't be rewritten")
+	}
+	if *renameFlag != "" {
+		r := &renamer{m: m, obj: v, newName: *renameFlag}
+		if err := r.renamePackageLevel(); err != nil {
+			return err
+		}
+	}
+	return m.emit(false)
+}
+
+// accessors replaces the references to a variable with calls of its getter and setter.
+type accessors struct {
+	m        *migration
+	v        *types.Var
+	pkg      *packages.Package // the package declaring v
+	get, set string            // the names of the getter and setter, or "" if there's no setter
+}
+
+// declare checks the signatures of the accessors which v's package declares, and appends the declarations of those
+// it doesn't to the file declaring v, referring to it by the name it will have.
+func (a *accessors) declare(newName string) error {
+	name := a.v.Name()
+	if newName != "" {
+		name = newName
+	}
+	var file *ast.File
+	for _, f := range a.pkg.Syntax {
+		if f.Pos() <= a.v.Pos() && a.v.Pos() < f.End() {
+			file = f
+		}
+	}
+	typ := types.TypeString(a.v.Type(), a.m.qualifier(a.pkg, file))
+	param := "v"
+	if name == param {
+		param = "x"
+	}
+
+	var buf bytes.Buffer
+	scope := a.pkg.Types.Scope()
+	if obj := scope.Lookup(a.get); obj != nil {
+		if err := a.checkSig(obj, types.NewTuple(), types.NewTuple(types.NewVar(token.NoPos, nil, "", a.v.Type()))); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(&buf, "// %s returns %s.\nfunc %s() %s {\n\treturn %s\n}\n", a.get, a.v.Name(), a.get, typ, name)
+	}
+	if a.set != "" {
+		if obj := scope.Lookup(a.set); obj != nil {
+			if err := a.checkSig(obj, types.NewTuple(types.NewVar(token.NoPos, nil, "", a.v.Type())), types.NewTuple()); err != nil {
+				return err
+			}
+		} else {
+			if buf.Len() > 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(&buf, "// %s sets %s to %s.\nfunc %s(%s %s) {\n\t%s = %s\n}\n", a.set, a.v.Name(), param, a.set,
+				param, typ, name, param)
+		}
+	}
+	if buf.Len() > 0 {
+		a.m.appendDecls(a.pkg, file, buf.Bytes())
+	}
+	return nil
+}
+
+// checkSig reports an error unless obj is a function with the parameters and results given.
+func (a *accessors) checkSig(obj types.Object, params, results *types.Tuple) error {
+	want := types.NewSignature(nil, params, results, false)
+	if fn, ok := obj.(*types.Func); !ok || !types.Identical(fn.Type(), want) {
+		return fmt.Errorf("%s: %s is declared already, but isn't a function %s", a.m.fSet.Position(obj.Pos()),
+			obj.Name(), types.TypeString(want, (*types.Package).Name))
+	}
+	return nil
+}
+
+// rewrite replaces the reference u with a call of the getter, or its enclosing assignment with a call of the
+// setter, and reports whether it could.
+func (a *accessors) rewrite(u use) bool {
+	info := u.pkg.TypesInfo
+	var ref ast.Expr = u.id
+	i := 1
+	if sel, ok := u.path[1].(*ast.SelectorExpr); ok && sel.Sel == u.id {
+		ref, i = sel, 2
+	}
+	parent := u.path[i]
+
+	// find the innermost expression whose evaluation reads or writes v's storage, rather than a copy of its value
+	var e ast.Node = ref
+	for ; i < len(u.path); i++ {
+		switch p := u.path[i].(type) {
+		case *ast.ParenExpr:
+			e = p
+			continue
+		case *ast.SelectorExpr:
+			sel := info.Selections[p]
+			if sel == nil {
+				break
+			}
+			_, isPtr := info.TypeOf(p.X).Underlying().(*types.Pointer)
+			if sel.Kind() == types.FieldVal && !isPtr && !sel.Indirect() {
+				e = p
+				continue
+			}
+			if fn, ok := sel.Obj().(*types.Func); ok && !isPtr {
+				if _, ptrRecv := fn.Type().(*types.Signature).Recv().Type().(*types.Pointer); ptrRecv {
+					return a.report(u, "calls a method taking its address")
+				}
+			}
+		case *ast.IndexExpr:
+			if _, isArray := info.TypeOf(p.X).Underlying().(*types.Array); isArray && p.X == e {
+				e = p
+				continue
+			}
+		case *ast.SliceExpr:
+			if _, isArray := info.TypeOf(p.X).Underlying().(*types.Array); isArray && p.X == e {
+				return a.report(u, "slices it, taking its address")
+			}
+		case *ast.UnaryExpr:
+			if p.Op == token.AND {
+				return a.report(u, "takes its address")
+			}
+		case *ast.AssignStmt:
+			for _, lhs := range p.Lhs {
+				if lhs == e {
+					return a.rewriteAssign(u, ref, e, p, u.path[i+1])
+				}
+			}
+		case *ast.IncDecStmt:
+			return a.rewriteAssign(u, ref, e, p, u.path[i+1])
+		case *ast.RangeStmt:
+			if p.Key == e || p.Value == e {
+				return a.report(u, "assigns to it")
+			}
+		}
+		break
+	}
+
+	replaceNode(parent, ref, a.call(ref, a.get))
+	a.m.touch(u.pkg, u.file)
+	return true
+}
+
+// rewriteAssign replaces the statement stmt, within parent, assigning to e, the reference ref or an expression
+// selecting from it, with a call of the setter.
+func (a *accessors) rewriteAssign(u use, ref ast.Expr, e ast.Node, stmt ast.Stmt, parent ast.Node) bool {
+	if e != ref {
+		return a.report(u, "assigns to its fields or elements")
+	}
+	if a.set == "" {
+		return a.report(u, "assigns to it, but no -set function was given")
+	}
+	var value ast.Expr
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		if len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
+			return a.report(u, "assigns to it along with other variables")
+		}
+		value = stmt.Rhs[0]
+		if op, ok := assignOps[stmt.Tok]; ok {
+			if _, isBinary := value.(*ast.BinaryExpr); isBinary {
+				value = &ast.ParenExpr{Lparen: value.Pos(), X: value, Rparen: value.End()}
+			}
+			value = &ast.BinaryExpr{X: a.call(ref, a.get), OpPos: stmt.TokPos, Op: op, Y: value}
+		}
+	case *ast.IncDecStmt:
+		op := token.ADD
+		if stmt.Tok == token.DEC {
+			op = token.SUB
+		}
+		one := &ast.BasicLit{ValuePos: stmt.TokPos, Kind: token.INT, Value: "1"}
+		value = &ast.BinaryExpr{X: a.call(ref, a.get), OpPos: stmt.TokPos, Op: op, Y: one}
+	}
+	call := a.call(ref, a.set)
+	call.Args, call.Rparen = []ast.Expr{value}, stmt.End()
+	replaceNode(parent, stmt, &ast.ExprStmt{X: call})
+	a.m.touch(u.pkg, u.file)
+	return true
+}
+
+// assignOps maps the tokens of assignment operations to their binary operators.
+var assignOps = map[token.Token]token.Token{
+	token.ADD_ASSIGN:     token.ADD,
+	token.SUB_ASSIGN:     token.SUB,
+	token.MUL_ASSIGN:     token.MUL,
+	token.QUO_ASSIGN:     token.QUO,
+	token.REM_ASSIGN:     token.REM,
+	token.AND_ASSIGN:     token.AND,
+	token.OR_ASSIGN:      token.OR,
+	token.XOR_ASSIGN:     token.XOR,
+	token.SHL_ASSIGN:     token.SHL,
+	token.SHR_ASSIGN:     token.SHR,
+	token.AND_NOT_ASSIGN: token.AND_NOT,
+}
+
+// call returns a call of the accessor name, qualified as ref is.
+func (a *accessors) call(ref ast.Expr, name string) *ast.CallExpr {
+	var fun ast.Expr = &ast.Ident{NamePos: ref.Pos(), Name: name}
+	if sel, ok := ref.(*ast.SelectorExpr); ok {
+		x := sel.X.(*ast.Ident)
+		fun = &ast.SelectorExpr{X: &ast.Ident{NamePos: x.Pos(), Name: x.Name}, Sel: fun.(*ast.Ident)}
+	}
+	return &ast.CallExpr{Fun: fun, Lparen: ref.End(), Rparen: ref.End()}
+}
+
+// report reports that the reference u can't be rewritten, since its enclosing expression does what's described,
+// and returns false.
+func (a *accessors) report(u use, what string) bool {
+	fmt.Fprintf(os.Stderr, "%s: can't rewrite this reference to %s, which %s\n", a.m.position(u.id), a.v.Name(), what)
+	return false
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestAccessor(t *testing.T) {
+	runCommandCases(t, "accessor")
+}
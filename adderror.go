@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"strings"
+)
+
+const addErrorUsage = `Usage: eg add-error -func path.Func [-callers propagate|discard] [-w] <packages>...
+
+Changes a function or method (path.Type.Method) to return an additional error,
+rewriting its return statements to return nil for it, and updates every call
+site in the loaded packages to receive the error. By default, callers which
+return an error themselves propagate it, and other callers discard it into _;
+with -callers discard, every caller discards it. Calls within expressions are
+hoisted into statements of their own, where that doesn't change what's evaluated
+when, as by remove-param -hoist; other calls, and deferred calls and calls in go
+statements, whose errors can't be received, are reported.
+`
+
+func addErrorMain(args []string) error {
+	fs := flag.NewFlagSet("add-error", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, addErrorUsage) }
+	funcFlag := fs.String("func", "", "the function, as path.Func or path.Type.Method")
+	callersFlag := fs.String("callers", "propagate", "what callers do with the error: propagate or discard")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *funcFlag == "" {
+		return errors.New("-func is required")
+	}
+	if *callersFlag != "propagate" && *callersFlag != "discard" {
+		return fmt.Errorf("invalid -callers %q: want propagate or discard", *callersFlag)
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	fn, err := m.lookupFunc(*funcFlag)
+	if err != nil {
+		return err
+	}
+	decl, file, pkg := m.decl(fn)
+	if decl == nil {
+		return fmt.Errorf("the declaration of %s isn't among the loaded packages", *funcFlag)
+	}
+	sig := fn.Type().(*types.Signature)
+	if returnsError(sig) {
+		return fmt.Errorf("%s already returns an error", *funcFlag)
+	}
+	if sig.Recv() != nil {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s is a method; types may no longer implement interfaces\n",
+			m.position(decl), *funcFlag)
+	}
+
+	a := &errorAdder{m: m, fn: fn, sig: sig, propagate: *callersFlag == "propagate"}
+	if err := a.rewriteDecl(pkg, decl); err != nil {
+		return err
+	}
+	m.touch(pkg, file)
+	for _, u := range m.uses(fn) {
+		if err := a.rewriteUse(u); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", m.position(u.id), err)
+			a.failed = true
+			continue
+		}
+		m.touch(u.pkg, u.file)
+	}
+
+	if err := m.emit(a.failed); err != nil {
+		return err
+	}
+	if a.failed {
+		return errors.New("some uses couldn't be rewritten")
+	}
+	return nil
+}
+
+// An errorAdder adds an error result to fn, whose signature was sig, and rewrites its uses to receive it.
+type errorAdder struct {
+	m         *migration
+	fn        *types.Func
+	sig       *types.Signature
+	propagate bool
+	failed    bool
+}
+
+// rewriteDecl adds the error result to decl, and nil to its return statements.
+func (a *errorAdder) rewriteDecl(pkg *packages.Package, decl *ast.FuncDecl) error {
+	ft := decl.Type
+	pos := ft.Params.End()
+	if ft.Results != nil {
+		pos = ft.Results.End()
+	}
+	field := &ast.Field{Type: &ast.Ident{NamePos: pos, Name: "error"}}
+	if ft.Results != nil && len(ft.Results.List) > 0 && len(ft.Results.List[0].Names) > 0 {
+		if err := checkNewName(pkg.TypesInfo, decl, "err"); err != nil {
+			return err
+		}
+		field.Names = []*ast.Ident{{NamePos: pos, Name: "err"}}
+	}
+	if ft.Results == nil {
+		ft.Results = &ast.FieldList{}
+	}
+	ft.Results.List = append(ft.Results.List, field)
+
+	if decl.Body == nil {
+		return nil
+	}
+	n := a.sig.Results().Len()
+	ast.Inspect(decl.Body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			switch {
+			case len(node.Results) == 0 && n > 0:
+				// a bare return of named results, which returns err too
+			case len(node.Results) == 1 && n > 1:
+				if call, ok := node.Results[0].(*ast.CallExpr); ok && calleeFunc(pkg.TypesInfo, call) == a.fn {
+					break // a recursive call, which returns the error too
+				}
+				fmt.Fprintf(os.Stderr, "%s: can't add nil to a return of a multi-valued call\n", a.m.position(node))
+				a.failed = true
+			default:
+				node.Results = append(node.Results, &ast.Ident{NamePos: node.End(), Name: "nil"})
+			}
+		}
+		return true
+	})
+	if list := decl.Body.List; n == 0 && (len(list) == 0 || !terminating(list[len(list)-1])) {
+		decl.Body.List = append(list, &ast.ReturnStmt{
+			Return:  decl.Body.Rbrace,
+			Results: []ast.Expr{&ast.Ident{NamePos: decl.Body.Rbrace, Name: "nil"}},
+		})
+	}
+	return nil
+}
+
+// terminating reports whether stmt is a return or a call of panic, the common terminating statements.
+func terminating(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.ExprStmt:
+		if call, ok := stmt.X.(*ast.CallExpr); ok {
+			if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "panic" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rewriteUse rewrites the call at u to receive the error.
+func (a *errorAdder) rewriteUse(u use) error {
+	call := u.call()
+	if call == nil {
+		return fmt.Errorf("%s is used as a value; update it manually", u.id.Name)
+	}
+	var i int
+	for u.path[i] != call {
+		i++
+	}
+	parent := u.path[i+1]
+	n := a.sig.Results().Len()
+
+	// the statement returning the error from the enclosing function, if it can
+	var errReturn string
+	if enclosing, isFn := enclosingSig(u); enclosing != nil && (isFn || returnsError(enclosing)) {
+		results := enclosing.Results()
+		last := results.Len() - 1
+		if isFn {
+			last = results.Len()
+		}
+		var values []string
+		qual := a.m.qualifier(u.pkg, u.file)
+		for j := 0; j < last; j++ {
+			values = append(values, zeroValue(results.At(j).Type(), qual))
+		}
+		errReturn = "return " + strings.Join(values, ", ")
+		if len(values) > 0 {
+			errReturn += ", "
+		}
+	}
+	propagate := a.propagate && errReturn != ""
+	if a.propagate && !propagate {
+		fmt.Fprintf(os.Stderr, "%s: warning: the enclosing function doesn't return an error, so it's discarded\n",
+			a.m.position(call))
+	}
+	errCheck := func(name string) string {
+		return "if " + name + " != nil {\n" + errReturn + name + "\n}"
+	}
+
+	switch p := parent.(type) {
+	case *ast.DeferStmt:
+		return errors.New("can't receive the error of a deferred call; update it manually")
+	case *ast.GoStmt:
+		return errors.New("can't receive the error of a call in a go statement; update it manually")
+
+	case *ast.ExprStmt:
+		if !inStmtList(u.path[i+1:]) {
+			break // e.g. an if statement's init, which hoisting refuses
+		}
+		blanks := strings.Repeat("_, ", n)
+		src := blanks + "_ = egCall"
+		if propagate {
+			src = "if " + blanks + "err := egCall; " + errCheck("err")[3:]
+		}
+		stmts, err := parseStmts(src, call.Pos(), map[string]ast.Expr{"egCall": call})
+		if err != nil {
+			return err
+		}
+		spliceStmt(u.path[i+1:], func(ast.Stmt) []ast.Stmt { return stmts })
+		return nil
+
+	case *ast.AssignStmt:
+		if len(p.Rhs) != 1 || (p.Tok != token.ASSIGN && p.Tok != token.DEFINE) {
+			break
+		}
+		if !propagate {
+			p.Lhs = append(p.Lhs, &ast.Ident{NamePos: p.TokPos, Name: "_"})
+			return nil
+		}
+		if !inStmtList(u.path[i+1:]) {
+			break
+		}
+		name := "err"
+		var before []ast.Stmt
+		switch {
+		case p.Tok == token.DEFINE:
+			var redeclare bool
+			for _, lhs := range p.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && u.pkg.TypesInfo.Defs[id] != nil {
+					redeclare = true
+				}
+			}
+			name = a.m.errName(u.pkg, p.Pos(), redeclare)
+		case !errInScope(u.pkg, p.Pos()):
+			name = a.m.errName(u.pkg, p.Pos(), false)
+			before, _ = parseStmts("var "+name+" error", p.Pos(), nil)
+		}
+		p.Lhs = append(p.Lhs, &ast.Ident{NamePos: p.TokPos, Name: name})
+		after, err := parseStmts(errCheck(name), p.End(), nil)
+		if err != nil {
+			return err
+		}
+		spliceStmt(u.path[i+1:], func(stmt ast.Stmt) []ast.Stmt {
+			return append(append(before, stmt), after...)
+		})
+		return nil
+
+	case *ast.ValueSpec:
+		if len(p.Values) != 1 || p.Type != nil {
+			break
+		}
+		if !propagate {
+			p.Names = append(p.Names, &ast.Ident{NamePos: p.End(), Name: "_"})
+			return nil
+		}
+		if !inStmtList(u.path[i+3:]) { // the spec's declaration statement
+			break
+		}
+		name := a.m.errName(u.pkg, p.Pos(), false)
+		p.Names = append(p.Names, &ast.Ident{NamePos: p.End(), Name: name})
+		after, err := parseStmts(errCheck(name), p.End(), nil)
+		if err != nil {
+			return err
+		}
+		spliceStmt(u.path[i+1:], func(stmt ast.Stmt) []ast.Stmt { return append([]ast.Stmt{stmt}, after...) })
+		return nil
+
+	case *ast.ReturnStmt:
+		// returning the call's results directly remains valid if the enclosing function's results are the new ones
+		if enclosing, isFn := enclosingSig(u); len(p.Results) == 1 && enclosing != nil &&
+			(isFn || returnsError(enclosing) && types.Identical(withoutError(enclosing.Results()), a.sig.Results())) {
+			return nil
+		}
+	}
+
+	// the call is within an expression, so hoist it into a statement of its own
+	if n != 1 {
+		return errors.New("can't receive the error of a multi-valued call within an expression")
+	}
+	if reason := hoistable(u, call); reason != "" {
+		return fmt.Errorf("can't hoist the call to receive its error: %s", reason)
+	}
+	v := a.m.freshName(u.pkg, call.Pos(), "v")
+	src := v + ", _ := egCall"
+	if propagate {
+		name := a.m.errName(u.pkg, call.Pos(), true)
+		src = v + ", " + name + " := egCall\n" + errCheck(name)
+	}
+	stmts, err := parseStmts(src, call.Pos(), map[string]ast.Expr{"egCall": call})
+	if err != nil {
+		return err
+	}
+	astutil.Apply(parent, func(c *astutil.Cursor) bool {
+		if c.Node() == call {
+			c.Replace(&ast.Ident{NamePos: call.Pos(), Name: v})
+		}
+		return true
+	}, nil)
+	if !spliceStmt(u.path[i+1:], func(stmt ast.Stmt) []ast.Stmt { return append(stmts, stmt) }) {
+		return errors.New("can't hoist the call to receive its error: it's not in a block")
+	}
+	return nil
+}
+
+// enclosingSig returns the signature of the innermost function enclosing u, and whether it's the function being
+// changed, whose signature is as yet the old one.
+func enclosingSig(u use) (*types.Signature, bool) {
+	for _, n := range u.path {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			sig, _ := u.pkg.TypesInfo.TypeOf(n).(*types.Signature)
+			return sig, false
+		case *ast.FuncDecl:
+			fn, _ := u.pkg.TypesInfo.Defs[n.Name].(*types.Func)
+			if fn == nil {
+				return nil, false
+			}
+			return fn.Type().(*types.Signature), fn == u.pkg.TypesInfo.Uses[u.id]
+		}
+	}
+	return nil, false
+}
+
+func returnsError(sig *types.Signature) bool {
+	r := sig.Results()
+	return r.Len() > 0 && types.Identical(r.At(r.Len()-1).Type(), errorType)
+}
+
+// withoutError returns results without the last.
+func withoutError(results *types.Tuple) *types.Tuple {
+	var vars []*types.Var
+	for i := 0; i < results.Len()-1; i++ {
+		vars = append(vars, results.At(i))
+	}
+	return types.NewTuple(vars...)
+}
+
+var errorType = types.Universe.Lookup("error").Type()
+
+// errInScope reports whether an error variable named err is in scope at pos.
+func errInScope(pkg *packages.Package, pos token.Pos) bool {
+	scope := pkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		return false
+	}
+	_, obj := scope.LookupParent("err", pos)
+	v, ok := obj.(*types.Var)
+	return ok && types.Identical(v.Type(), errorType)
+}
+
+// zeroValue returns the source of the zero value of t.
+func zeroValue(t types.Type, qual types.Qualifier) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsString != 0:
+			return `""`
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		}
+		return "nil"
+	case *types.Struct, *types.Array:
+		return types.TypeString(t, qual) + "{}"
+	}
+	return "nil"
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestAddError(t *testing.T) {
+	runCommandCases(t, "add-error")
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const addMethodUsage = `Usage: eg add-method -t methods.go [-w] <packages>...
+
+Adds methods to every named type in the loaded packages which implements an
+interface, declared by a template of the form
+
+	// the types implementing matches, or whose pointers do, get the methods
+	type matches interface {
+		Validate() error
+	}
+
+	// T stands for each of them; embedding matches gives it its methods
+	type T struct{ matches }
+
+	// MustValidate panics if typeName, the type's name, isn't valid.
+	func (t T) MustValidate() {
+		if err := t.Validate(); err != nil {
+			panic(typeName + ": " + err.Error())
+		}
+	}
+
+	const typeName = ""
+
+Each method with a receiver of T or *T is added after the type's last method,
+or its declaration if it has none, with T referring to the type, and with a
+pointer receiver if only the type's pointer implements matches. Types which
+have a method of the same name already are left, so adding again changes
+nothing; generic types, and those with fields of the name, are reported.
+`
+
+func addMethodMain(args []string) error {
+	fs := flag.NewFlagSet("add-method", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, addMethodUsage) }
+	tmplFlag := fs.String("t", "", "the method template")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplFlag == "" {
+		return errors.New("no -t template given")
+	}
+
+	m, tmpl, tmplFile, err := loadWithTemplate(*tmplFlag, fs.Args())
+	if err != nil {
+		return err
+	}
+	a, err := newMethodAdder(m, tmpl, tmplFile)
+	if err != nil {
+		return err
+	}
+	var failed bool
+	for _, pkg := range m.pkgs {
+		for _, tn := range namedTypes(pkg) {
+			if err := a.add(pkg, tn); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: can't add methods to %s: %v\n", m.fSet.Position(tn.Pos()), tn.Name(), err)
+				failed = true
+			}
+		}
+	}
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some methods couldn't be added")
+	}
+	return nil
+}
+
+// A typeTemplate is a template applied to each named type implementing its interface matches, which declares a
+// placeholder type T for them, and optionally a constant typeName for their names.
+type typeTemplate struct {
+	m        *migration
+	tmpl     *packages.Package
+	matches  *types.Interface
+	t        *types.TypeName
+	typeName types.Object
+}
+
+// newTypeTemplate returns the type template of the package tmpl, checking it declares matches and T.
+func newTypeTemplate(m *migration, tmpl *packages.Package) (*typeTemplate, error) {
+	tt := &typeTemplate{m: m, tmpl: tmpl}
+	scope := tmpl.Types.Scope()
+	if obj, ok := scope.Lookup("matches").(*types.TypeName); ok {
+		tt.matches, _ = obj.Type().Underlying().(*types.Interface)
+	}
+	if tt.matches == nil {
+		return nil, errors.New("template doesn't declare the interface matches")
+	}
+	tt.t, _ = scope.Lookup("T").(*types.TypeName)
+	if tt.t == nil {
+		return nil, errors.New("template doesn't declare the type T")
+	}
+	if obj, ok := scope.Lookup("typeName").(*types.Const); ok {
+		tt.typeName = obj
+	}
+	return tt, nil
+}
+
+// implemented reports whether tn, a non-interface type, or its pointer implements matches, and whether only its
+// pointer does.
+func (tt *typeTemplate) implemented(tn *types.TypeName) (ok, ptr bool) {
+	named, isNamed := tn.Type().(*types.Named)
+	if !isNamed || types.IsInterface(named) {
+		return false, false
+	}
+	if types.Implements(named, tt.matches) {
+		return true, false
+	}
+	return types.Implements(types.NewPointer(named), tt.matches), true
+}
+
+// subst returns the substitutions of the placeholders for tn, positioned at pos.
+func (tt *typeTemplate) subst(tn *types.TypeName, pos token.Pos) map[string]ast.Expr {
+	subst := map[string]ast.Expr{tt.t.Name(): &ast.Ident{NamePos: pos, Name: tn.Name()}}
+	if tt.typeName != nil {
+		subst[tt.typeName.Name()] = &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(tn.Name())}
+	}
+	return subst
+}
+
+// A methodAdder applies a method template.
+type methodAdder struct {
+	*typeTemplate
+	methods []*ast.FuncDecl
+}
+
+// newMethodAdder validates the method template tmplFile.
+func newMethodAdder(m *migration, tmpl *packages.Package, tmplFile *ast.File) (*methodAdder, error) {
+	tt, err := newTypeTemplate(m, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	a := &methodAdder{typeTemplate: tt}
+
+	for _, decl := range tmplFile.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || recvTypeName(tmpl.TypesInfo, fn) != a.t {
+			continue
+		}
+		if err := m.checkTemplateRefs(tmpl, fn, a.t, a.typeName); err != nil {
+			return nil, err
+		}
+		a.methods = append(a.methods, fn)
+	}
+	if len(a.methods) == 0 {
+		return nil, errors.New("template declares no methods of T")
+	}
+	return a, nil
+}
+
+// namedTypes returns the types declared at package level by pkg, in file order.
+func namedTypes(pkg *packages.Package) []*types.TypeName {
+	var names []*types.TypeName
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.TYPE {
+				for _, spec := range gen.Specs {
+					if tn, ok := pkg.TypesInfo.Defs[spec.(*ast.TypeSpec).Name].(*types.TypeName); ok && !tn.IsAlias() {
+						names = append(names, tn)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// add adds the template's methods to tn if it, or its pointer, implements matches.
+func (a *methodAdder) add(pkg *packages.Package, tn *types.TypeName) error {
+	// if only its pointer implements matches, the methods are added with pointer receivers to call its methods
+	ok, ptr := a.implemented(tn)
+	if !ok {
+		return nil
+	}
+	named := tn.Type().(*types.Named)
+	if named.TypeParams().Len() > 0 {
+		return errors.New("it has type parameters")
+	}
+	var methods []*ast.FuncDecl
+	for _, fn := range a.methods {
+		obj, _, _ := types.LookupFieldOrMethod(named, true, pkg.Types, fn.Name.Name)
+		switch obj.(type) {
+		case nil:
+			methods = append(methods, fn)
+		case *types.Var:
+			return fmt.Errorf("it has a field %s", fn.Name.Name)
+		}
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+
+	after, file := lastDecl(a.m.fSet, pkg, tn)
+	pos := after.End()
+	subst := a.subst(tn, pos)
+	var buf bytes.Buffer
+	for _, fn := range methods {
+		decl, err := a.instantiate(pkg, file, pos, fn, ptr, subst)
+		if err != nil {
+			return err
+		}
+		if fn.Doc != nil {
+			for _, line := range strings.Split(strings.TrimSuffix(fn.Doc.Text(), "\n"), "\n") {
+				fmt.Fprintf(&buf, "// %s\n", line)
+			}
+		}
+		fmt.Fprintf(&buf, "%s\n\n", nodeString(a.m.fSet, decl))
+	}
+	a.m.insertDecls(pkg, file, after, buf.Bytes())
+	fmt.Fprintf(os.Stderr, "%s: added %s to %s\n", a.m.fSet.Position(tn.Pos()), methodNames(methods), tn.Name())
+	return nil
+}
+
+// instantiate returns a copy of the template method fn for insertion into file at pos, with a pointer receiver if
+// ptr is set.
+func (a *methodAdder) instantiate(pkg *packages.Package, file *ast.File, pos token.Pos, fn *ast.FuncDecl, ptr bool,
+	subst map[string]ast.Expr) (*ast.FuncDecl, error) {
+	recv, err := a.m.instantiate(a.tmpl, fn.Recv.List[0].Type, pkg, file, pos, subst)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := a.m.instantiate(a.tmpl, fn.Type, pkg, file, pos, subst)
+	if err != nil {
+		return nil, err
+	}
+	body, err := a.m.instantiate(a.tmpl, fn.Body, pkg, file, pos, subst)
+	if err != nil {
+		return nil, err
+	}
+	if _, isPtr := recv.(*ast.StarExpr); ptr && !isPtr {
+		recv = &ast.StarExpr{Star: pos, X: recv.(ast.Expr)}
+	}
+	field := &ast.Field{Names: fn.Recv.List[0].Names, Type: recv.(ast.Expr)}
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{Opening: pos, List: []*ast.Field{field}, Closing: pos},
+		Name: &ast.Ident{NamePos: pos, Name: fn.Name.Name},
+		Type: typ.(*ast.FuncType),
+		Body: body.(*ast.BlockStmt),
+	}, nil
+}
+
+// lastDecl returns the last declaration of a method of tn in pkg, ordered by file name and position, with the file
+// containing it, or tn's own if it has none.
+func lastDecl(fSet *token.FileSet, pkg *packages.Package, tn *types.TypeName) (ast.Decl, *ast.File) {
+	var last ast.Decl
+	var lastFile *ast.File
+	files := append([]*ast.File(nil), pkg.Syntax...)
+	sort.Slice(files, func(i, j int) bool {
+		return fSet.File(files[i].Pos()).Name() < fSet.File(files[j].Pos()).Name()
+	})
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if recvTypeName(pkg.TypesInfo, decl) == tn {
+					last, lastFile = decl, file
+				}
+			case *ast.GenDecl:
+				if last == nil && decl.Pos() <= tn.Pos() && tn.Pos() < decl.End() {
+					last, lastFile = decl, file
+				}
+			}
+		}
+	}
+	return last, lastFile
+}
+
+// methodNames returns the names of methods, separated by commas.
+func methodNames(methods []*ast.FuncDecl) string {
+	var names []string
+	for _, fn := range methods {
+		names = append(names, fn.Name.Name)
+	}
+	return strings.Join(names, ", ")
+}
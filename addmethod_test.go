@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestAddMethod(t *testing.T) {
+	runCommandCases(t, "add-method")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"os"
+)
+
+const addParamUsage = `Usage: eg add-param -func path.Func -name name -type type -default expr [-at index] [-w] <packages>...
+
+Adds a parameter to a function or method (path.Type.Method), rewriting its
+declaration and every call site in the loaded packages in one pass. Adding one
+to an interface method adds it to the methods implementing it in the loaded
+packages too. Calls pass the default expression, except those from within the
+functions changed, which pass the new parameter along. Package names in -type
+and -default are imported as needed: either the last element of an -import
+path, or the import path itself (e.g. context).
+`
+
+func addParamMain(args []string) error {
+	fs := flag.NewFlagSet("add-param", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, addParamUsage) }
+	funcFlag := fs.String("func", "", "the function, as path.Func or path.Type.Method")
+	nameFlag := fs.String("name", "", "the name of the new parameter")
+	typeFlag := fs.String("type", "", "the type of the new parameter")
+	defaultFlag := fs.String("default", "", "the argument passed for the parameter at each call site")
+	atFlag := fs.Int("at", -1, "the index of the new parameter (by default, the last, or before a variadic one)")
+	var imports arrayFlags
+	fs.Var(&imports, "import", "the import path of a package named in -type or -default (repeatable)")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *funcFlag == "" || *nameFlag == "" || *typeFlag == "" || *defaultFlag == "" {
+		return errors.New("-func, -name, -type and -default are required")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	fn, err := m.lookupFunc(*funcFlag)
+	if err != nil {
+		return err
+	}
+	sigs, outside, err := m.sigDecls(fn)
+	if err != nil {
+		return err
+	}
+
+	sig := fn.Type().(*types.Signature)
+	n := sig.Params().Len()
+	at := *atFlag
+	if at < 0 {
+		at = n
+		if sig.Variadic() {
+			at--
+		}
+	}
+	if at > n || sig.Variadic() && at == n {
+		return fmt.Errorf("-at %d is out of range for %s, which has %d parameters", at, *funcFlag, n)
+	}
+	for _, sd := range sigs {
+		if sd.decl != nil {
+			if err := checkNewName(sd.pkg.TypesInfo, sd.decl, *nameFlag); err != nil {
+				return err
+			}
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if sig.Params().At(i).Name() == *nameFlag {
+				return fmt.Errorf("%s already has a parameter named %s", *funcFlag, *nameFlag)
+			}
+		}
+	}
+	if sig.Recv() != nil && len(sigs) == 1 {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s is a method; types may no longer implement interfaces\n",
+			m.position(sigs[0].decl), *funcFlag)
+	}
+	failed := m.reportOutside(outside, fn)
+
+	named := make(map[*ast.FuncDecl]bool) // the declarations whose new parameter is named
+	var objs []types.Object
+	for _, sd := range sigs {
+		objs = append(objs, sd.fn)
+		pos := sd.typ.Params.Closing
+		if at < n {
+			pos = sd.fn.Type().(*types.Signature).Params().At(at).Pos()
+		}
+		typ, err := m.parseExpr(sd.pkg, sd.file, pos, *typeFlag, imports)
+		if err != nil {
+			return err
+		}
+		field := &ast.Field{Type: typ}
+		if n == 0 || len(sd.typ.Params.List[0].Names) > 0 {
+			field.Names = []*ast.Ident{{NamePos: pos, Name: *nameFlag}}
+			named[sd.decl] = true
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: warning: %s has unnamed parameters, so the new one is unnamed too\n",
+				m.position(sd.typ), sd.fn.FullName())
+		}
+		insertField(sd.typ.Params, at, field)
+		m.touch(sd.pkg, sd.file)
+	}
+
+	for _, u := range m.uses(objs...) {
+		call := u.call()
+		if call == nil {
+			fmt.Fprintf(os.Stderr, "%s: %s is used as a value; update it manually\n", m.position(u.id), u.id.Name)
+			failed = true
+			continue
+		}
+		if len(call.Args) == 1 && n > 1 {
+			fmt.Fprintf(os.Stderr, "%s: can't add an argument to a call with a multi-valued argument\n", m.position(call))
+			failed = true
+			continue
+		}
+		pos := call.Rparen
+		if at < len(call.Args) {
+			pos = call.Args[at].Pos()
+		}
+		var arg ast.Expr
+		if decl, ok := u.enclosingFunc().(*ast.FuncDecl); ok && named[decl] {
+			arg = &ast.Ident{NamePos: pos, Name: *nameFlag}
+		} else if arg, err = m.parseExpr(u.pkg, u.file, pos, *defaultFlag, imports); err != nil {
+			return err
+		}
+		call.Args = append(call.Args[:at], append([]ast.Expr{arg}, call.Args[at:]...)...)
+		m.touch(u.pkg, u.file)
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some uses couldn't be rewritten")
+	}
+	return nil
+}
+
+// checkNewName reports an error if a new parameter of decl named name would conflict with a parameter or result,
+// or shadow an object that the body refers to.
+func checkNewName(info *types.Info, decl *ast.FuncDecl, name string) error {
+	if scope := info.Scopes[decl.Type]; scope != nil && scope.Lookup(name) != nil {
+		return fmt.Errorf("%s already has a parameter or result named %s", decl.Name.Name, name)
+	}
+	if decl.Recv != nil {
+		for _, f := range decl.Recv.List {
+			for _, id := range f.Names {
+				if id.Name == name {
+					return fmt.Errorf("the receiver of %s is named %s", decl.Name.Name, name)
+				}
+			}
+		}
+	}
+	var err error
+	if decl.Body != nil {
+		ast.Inspect(decl.Body, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok || id.Name != name || err != nil {
+				return err == nil
+			}
+			if obj := info.Uses[id]; obj != nil && (obj.Pos() < decl.Pos() || obj.Pos() >= decl.End()) {
+				err = fmt.Errorf("a parameter named %s would shadow %s, used in the body of %s", name, obj.Name(), decl.Name.Name)
+			}
+			return true
+		})
+	}
+	return err
+}
+
+// insertField inserts field, declaring a single name or none, into fields so that it's the at'th entry, splitting
+// a group of names declared together if necessary.
+func insertField(fields *ast.FieldList, at int, field *ast.Field) {
+	var list []*ast.Field
+	var i int
+	inserted := false
+	for _, f := range fields.List {
+		count := len(f.Names)
+		if count == 0 {
+			count = 1
+		}
+		if !inserted && at < i+count {
+			if split := at - i; split > 0 {
+				list = append(list, &ast.Field{Names: f.Names[:split], Type: f.Type})
+				f = &ast.Field{Names: f.Names[split:], Type: f.Type, Tag: f.Tag, Comment: f.Comment}
+			}
+			list = append(list, field)
+			inserted = true
+		}
+		list = append(list, f)
+		i += count
+	}
+	if !inserted {
+		list = append(list, field)
+	}
+	fields.List = list
+}
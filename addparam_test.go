@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestAddParam(t *testing.T) {
+	runCommandCases(t, "add-param")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// annotationComment returns the comment -annotate appends for marker, which may be given with its slashes or not.
+func annotationComment(marker string) string {
+	return "// " + strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(marker), "//"))
+}
+
+// annotate returns src, the rewrite of the Go source orig, with the comment of marker appended to each line it
+// changes which doesn't end with it already, formatted. A line ending within a multiline token, a raw string or a
+// /* */ comment, isn't annotated.
+func annotate(orig, src []byte, marker string) ([]byte, error) {
+	comment := annotationComment(marker)
+	lineStarts := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	lineOf := func(offset int) int {
+		i := 0
+		for i+1 < len(lineStarts) && lineStarts[i+1] <= offset {
+			i++
+		}
+		return i
+	}
+	changed := make(map[int]bool)
+	for _, e := range sourceEdits(orig, src) {
+		end := e.NewEnd
+		if end > e.NewStart {
+			end-- // the last byte of the range
+		}
+		for l := lineOf(e.NewStart); l <= lineOf(end); l++ {
+			changed[l] = true
+		}
+	}
+	if len(changed) == 0 {
+		return src, nil
+	}
+
+	// the lines spanned by multiline tokens, except their last, can't be annotated
+	fSet := token.NewFileSet()
+	file := fSet.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if n := strings.Count(lit, "\n"); n > 0 && (tok == token.STRING || tok == token.COMMENT) {
+			first := file.Line(pos) - 1
+			for l := first; l < first+n; l++ {
+				delete(changed, l)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for l, start := range lineStarts {
+		end := len(src)
+		if l+1 < len(lineStarts) {
+			end = lineStarts[l+1]
+		}
+		line := string(src[start:end])
+		body := strings.TrimRight(line, "\r\n")
+		buf.WriteString(body)
+		if changed[l] && strings.TrimSpace(body) != "" && !strings.HasSuffix(body, comment) {
+			buf.WriteString(" " + comment)
+		}
+		buf.WriteString(line[len(body):])
+	}
+	return format.Source(buf.Bytes())
+}
+
+const stripAnnotationsUsage = `Usage: eg strip-annotations [-w] -marker marker <packages>...
+
+Removes the comments eg -annotate marker appended to the lines it rewrote, once
+the audit they were for is done, from the files of the packages, and of their
+tests.
+`
+
+func stripAnnotationsMain(args []string) error {
+	fs := flag.NewFlagSet("strip-annotations", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, stripAnnotationsUsage) }
+	marker := fs.String("marker", "", "the marker of the comments to remove, as given to -annotate")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if strings.TrimSpace(*marker) == "" {
+		return errors.New("no -marker specified")
+	}
+	comment := annotationComment(*marker)
+	// the comment is removed with the space before it, and a comment it was appended to keeps its text
+	annotation := regexp.MustCompile(`(?m)[ \t]*` + regexp.QuoteMeta(comment) + `[ \t]*(\r?)$`)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	configs, err := newConfigs(wd)
+	if err != nil {
+		return err
+	}
+	conf, err := configs.forDir(wd)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(conf); err != nil {
+		return err
+	}
+	if err := lockRun(wd); err != nil {
+		return err
+	}
+
+	// the tests are loaded too, since a run including them may have annotated them
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName | packages.NeedFiles, Tests: true}, fs.Args()...)
+	if err != nil {
+		return fmt.Errorf("load: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 && !*tolerateFlag {
+		return errors.New("error loading packages")
+	}
+	var hadErrors bool
+	seen := make(map[string]bool) // the files of a package's test variant are those of the package, and its tests
+	for _, pkg := range pkgs {
+		for _, filename := range pkg.GoFiles {
+			if seen[filename] {
+				continue
+			}
+			seen[filename] = true
+			conf, err := configs.forDir(filepath.Dir(filename))
+			if err != nil {
+				return err
+			}
+			if conf.excluded(filename) {
+				continue
+			}
+			src, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			n := len(annotation.FindAll(src, -1))
+			if n == 0 {
+				continue
+			}
+			stripped, err := format.Source(annotation.ReplaceAll(src, []byte("$1")))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "eg: %s: %v\n", filename, err)
+				hadErrors = true
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "=== %s (%d annotations)\n", filename, n)
+			if err := emitSource(filename, stripped, editInfo{pkg: pkg.PkgPath}); err != nil {
+				if _, ok := err.(abortError); ok {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+				hadErrors = true
+			}
+		}
+	}
+	if hadErrors {
+		return errors.New("some files couldn't be rewritten")
+	}
+	return nil
+}
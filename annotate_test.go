@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripAnnotations(t *testing.T) {
+	runCommandCases(t, "strip-annotations")
+}
+
+// TestAnnotateRoundTrip checks that stripping the annotations of a rewrite, of the packages and their tests, leaves
+// the files as the rewrite without -annotate writes them.
+func TestAnnotateRoundTrip(t *testing.T) {
+	eg := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), mainEnv+"=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("eg %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	var dirs [2]string
+	for i := range dirs {
+		dir, err := ioutil.TempDir("", "eg-annotate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := copyDir(dir, filepath.Join("testdata", "annotate-round-trip")); err != nil {
+			t.Fatal(err)
+		}
+		dirs[i] = dir
+	}
+	tmpl := filepath.Join("templates", "contains", "contains.go")
+	eg(dirs[0], "-w", "-t", tmpl, "./p")
+	eg(dirs[1], "-w", "-annotate", "MIGRATED(T1)", "-t", tmpl, "./p")
+	for _, name := range []string{"p.go", "p_test.go", "x_test.go"} {
+		src, err := ioutil.ReadFile(filepath.Join(dirs[1], "p", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(src), "// MIGRATED(T1)") {
+			t.Errorf("%s wasn't annotated:\n%s", name, src)
+		}
+	}
+	eg(dirs[1], "strip-annotations", "-w", "-marker", "MIGRATED(T1)", "./p")
+	for _, name := range []string{"p.go", "p_test.go", "x_test.go"} {
+		want, err := ioutil.ReadFile(filepath.Join(dirs[0], "p", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dirs[1], "p", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s is, stripped of its annotations,\n%s\nbut without them is\n%s", name, got, want)
+		}
+	}
+}
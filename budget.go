@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// matchBudget tracks the matches of a run against -max-matches and -max-per-file, and those left by them.
+type matchBudget struct {
+	spent    int
+	perFile  map[string]int // the matches rewritten, by file
+	withheld map[string]int // the matches left for a later run, by file
+}
+
+var budget = matchBudget{perFile: make(map[string]int), withheld: make(map[string]int)}
+
+// allows reports whether a match in filename may be rewritten within the budget, recording it as withheld if not.
+func (b *matchBudget) allows(filename string) bool {
+	if *maxMatchesFlag > 0 && b.spent >= *maxMatchesFlag || *maxPerFileFlag > 0 && b.perFile[filename] >= *maxPerFileFlag {
+		b.withheld[filename]++
+		return false
+	}
+	return true
+}
+
+// limited reports whether -max-matches or -max-per-file was given, without which allows always does.
+func (b *matchBudget) limited() bool {
+	return *maxMatchesFlag > 0 || *maxPerFileFlag > 0
+}
+
+// spend records the rewrite of a match in filename, if there's a budget to count it against.
+func (b *matchBudget) spend(filename string) {
+	if !b.limited() {
+		return
+	}
+	b.spent++
+	b.perFile[filename]++
+}
+
+// report prints the matches the budget left, by file, so that the run can be repeated until there are none.
+func (b *matchBudget) report() {
+	if len(b.withheld) == 0 {
+		return
+	}
+	var names []string
+	var total int
+	for name, n := range b.withheld {
+		names = append(names, name)
+		total += n
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "eg: the budget was reached, leaving %d matches in %d files for a later run:\n", total, len(names))
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "\t%s (%d matches)\n", name, b.withheld[name])
+	}
+}
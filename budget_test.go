@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestBudget(t *testing.T) {
+	runMainCases(t, "budget")
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+)
+
+const builderUsage = `Usage: eg builder [-w] <packages>...
+
+Rewrites strings accumulated by concatenation in loops to use a
+strings.Builder, which doesn't copy the string on every iteration:
+
+	var s string                       var b strings.Builder
+	for _, w := range words {          for _, w := range words {
+		s += w                             b.WriteString(w)
+	}                                  }
+	return s                           return b.String()
+
+The string must be declared in the statement list containing the loop, and
+only appended to, with += or s = s + x, by the loop; its initial value, if it
+has one, is written to the builder first. A single use after the loop becomes
+a call of the builder's String method; several use a variable of the same name
+declared after the loop instead.
+`
+
+func builderMain(args []string) error {
+	fs := flag.NewFlagSet("builder", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, builderUsage) }
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, pkg := range m.pkgs {
+		for _, file := range pkg.Syntax {
+			b := builderRewriter{m: m, pkg: pkg, file: file}
+			ast.Inspect(file, func(n ast.Node) bool {
+				if list, ok := stmtList(n); ok {
+					for i := 0; i < len(*list); i++ {
+						b.rewrite(list, i)
+					}
+				}
+				return true
+			})
+		}
+	}
+	return m.emit(false)
+}
+
+// A builderRewriter rewrites the strings accumulated in loops of a file to use strings.Builder.
+type builderRewriter struct {
+	m    *migration
+	pkg  *packages.Package
+	file *ast.File
+}
+
+// rewrite rewrites the string declared by the ith statement of list, if the first statement after it referring to
+// the string is a loop appending to it.
+func (b *builderRewriter) rewrite(list *[]ast.Stmt, i int) {
+	info := b.pkg.TypesInfo
+	decl := (*list)[i]
+	v, init := b.declared(decl)
+	if v == nil || !types.Identical(v.Type(), types.Typ[types.String]) {
+		return
+	}
+	j := i + 1
+	for j < len(*list) && !usesObj(info, (*list)[j], v) {
+		j++
+	}
+	if j == len(*list) {
+		return
+	}
+	loop := (*list)[j]
+	appends := b.appends(loop, v)
+	if len(appends) == 0 {
+		return
+	}
+	var uses []*ast.Ident
+	for _, s := range (*list)[j+1:] {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && info.Uses[id] == v {
+				uses = append(uses, id)
+			}
+			return true
+		})
+	}
+
+	name := b.m.freshName(b.pkg, decl.Pos(), "b")
+	var single bool // whether the builder's String method replaces the string's one use after the loop
+	positions := []token.Pos{decl.End()}
+	for _, a := range appends {
+		positions = append(positions, a.stmt.Pos())
+	}
+	if len(uses) == 1 && b.readOnly(uses[0]) {
+		single = true
+		positions = append(positions, uses[0].Pos())
+	}
+	for _, pos := range positions {
+		// a declaration of the same name between them would shadow the builder
+		if _, obj := b.pkg.Types.Scope().Innermost(pos).LookupParent(name, pos); obj != nil {
+			fmt.Fprintf(os.Stderr, "%s: can't use a strings.Builder for %s, since %s is declared at %s\n",
+				b.m.position(decl), v.Name(), name, b.m.fSet.Position(obj.Pos()))
+			return
+		}
+	}
+
+	builderType, err := b.m.stdName(b.pkg, b.file, decl.Pos(), "strings", "Builder")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: can't use a strings.Builder for %s: %v\n", b.m.position(decl), v.Name(), err)
+		return
+	}
+	spec := &ast.ValueSpec{Names: []*ast.Ident{{NamePos: decl.Pos(), Name: name}}, Type: builderType}
+	stmts := []ast.Stmt{&ast.DeclStmt{Decl: &ast.GenDecl{TokPos: decl.Pos(), Tok: token.VAR, Specs: []ast.Spec{spec}}}}
+	if init != nil && !b.isEmpty(init) {
+		stmts = append(stmts, &ast.ExprStmt{X: b.method(decl.Pos(), name, "WriteString", init)})
+	}
+	for _, a := range appends {
+		replaceNode(loop, a.stmt, &ast.ExprStmt{X: b.method(a.stmt.Pos(), name, "WriteString", a.x)})
+	}
+	var redecl []ast.Stmt
+	switch {
+	case single:
+		for _, s := range (*list)[j+1:] {
+			replaceNode(s, uses[0], b.method(uses[0].Pos(), name, "String"))
+		}
+	case len(uses) > 0:
+		pos := loop.End()
+		redecl = append(redecl, &ast.AssignStmt{
+			Lhs:    []ast.Expr{&ast.Ident{NamePos: pos, Name: v.Name()}},
+			TokPos: pos,
+			Tok:    token.DEFINE,
+			Rhs:    []ast.Expr{b.method(pos, name, "String")},
+		})
+	}
+	var out []ast.Stmt
+	out = append(append(out, (*list)[:i]...), stmts...)
+	out = append(append(out, (*list)[i+1:j+1]...), redecl...)
+	*list = append(out, (*list)[j+1:]...)
+	b.m.touch(b.pkg, b.file)
+}
+
+// declared returns the variable declared by stmt, if it declares a single one, with its initial value, or nil if
+// it has none.
+func (b *builderRewriter) declared(stmt ast.Stmt) (*types.Var, ast.Expr) {
+	var id *ast.Ident
+	var init ast.Expr
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		if stmt.Tok != token.DEFINE || len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
+			return nil, nil
+		}
+		id, _ = stmt.Lhs[0].(*ast.Ident)
+		init = stmt.Rhs[0]
+	case *ast.DeclStmt:
+		gen := stmt.Decl.(*ast.GenDecl)
+		if gen.Tok != token.VAR || len(gen.Specs) != 1 {
+			return nil, nil
+		}
+		spec := gen.Specs[0].(*ast.ValueSpec)
+		if len(spec.Names) != 1 || len(spec.Values) > 1 {
+			return nil, nil
+		}
+		id = spec.Names[0]
+		if len(spec.Values) == 1 {
+			init = spec.Values[0]
+		}
+	}
+	if id == nil {
+		return nil, nil
+	}
+	v, _ := b.pkg.TypesInfo.Defs[id].(*types.Var)
+	return v, init
+}
+
+// An appendStmt is an assignment appending x to a string.
+type appendStmt struct {
+	stmt *ast.AssignStmt
+	x    ast.Expr
+}
+
+// appends returns the statements of the loop stmt appending to v, or nil if it isn't a loop, or refers to v
+// otherwise.
+func (b *builderRewriter) appends(stmt ast.Stmt, v *types.Var) []appendStmt {
+	info := b.pkg.TypesInfo
+	if l, ok := stmt.(*ast.LabeledStmt); ok {
+		stmt = l.Stmt
+	}
+	var body *ast.BlockStmt
+	switch loop := stmt.(type) {
+	case *ast.ForStmt:
+		for _, n := range []ast.Node{loop.Init, loop.Cond, loop.Post} {
+			if n != nil && usesObj(info, n, v) {
+				return nil
+			}
+		}
+		body = loop.Body
+	case *ast.RangeStmt:
+		for _, n := range []ast.Node{loop.Key, loop.Value, loop.X} {
+			if n != nil && usesObj(info, n, v) {
+				return nil
+			}
+		}
+		body = loop.Body
+	default:
+		return nil
+	}
+
+	var appends []appendStmt
+	ok := true
+	ast.Inspect(body, func(n ast.Node) bool {
+		if !ok {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			// which may be called after the loop
+			ok = !usesObj(info, n, v)
+			return false
+		case *ast.AssignStmt:
+			if x := b.appended(n, v); x != nil {
+				ok = !usesObj(info, x, v)
+				appends = append(appends, appendStmt{n, x})
+				return false
+			}
+		case *ast.Ident:
+			ok = info.Uses[n] != v
+		}
+		return true
+	})
+	if !ok {
+		return nil
+	}
+	return appends
+}
+
+// appended returns the string stmt appends to v, if it's of the form v += x or v = v + x, or nil.
+func (b *builderRewriter) appended(stmt *ast.AssignStmt, v *types.Var) ast.Expr {
+	if len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 || !b.refersTo(stmt.Lhs[0], v) {
+		return nil
+	}
+	switch stmt.Tok {
+	case token.ADD_ASSIGN:
+		return stmt.Rhs[0]
+	case token.ASSIGN:
+		return b.afterOperand(stmt.Rhs[0], v)
+	}
+	return nil
+}
+
+// afterOperand returns the concatenation e, of the form v + x (+ y...), without its first operand v, or nil if it's
+// not of that form.
+func (b *builderRewriter) afterOperand(e ast.Expr, v *types.Var) ast.Expr {
+	bin, ok := e.(*ast.BinaryExpr)
+	if !ok || bin.Op != token.ADD {
+		return nil
+	}
+	if b.refersTo(bin.X, v) {
+		return bin.Y
+	}
+	x := b.afterOperand(bin.X, v)
+	if x == nil {
+		return nil
+	}
+	return &ast.BinaryExpr{X: x, OpPos: bin.OpPos, Op: token.ADD, Y: bin.Y}
+}
+
+// refersTo reports whether e is an identifier referring to v.
+func (b *builderRewriter) refersTo(e ast.Expr, v *types.Var) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && b.pkg.TypesInfo.Uses[id] == v
+}
+
+// isEmpty reports whether e is the constant "".
+func (b *builderRewriter) isEmpty(e ast.Expr) bool {
+	tv := b.pkg.TypesInfo.Types[e]
+	return tv.Value != nil && constant.StringVal(tv.Value) == ""
+}
+
+// readOnly reports whether the reference id is neither assigned to nor has its address taken.
+func (b *builderRewriter) readOnly(id *ast.Ident) bool {
+	path, _ := astutil.PathEnclosingInterval(b.file, id.Pos(), id.End())
+	if len(path) < 2 {
+		return false
+	}
+	switch parent := path[1].(type) {
+	case *ast.AssignStmt:
+		for _, lhs := range parent.Lhs {
+			if lhs == id {
+				return false
+			}
+		}
+	case *ast.UnaryExpr:
+		return parent.Op != token.AND
+	case *ast.RangeStmt:
+		return parent.Key != id && parent.Value != id
+	}
+	return true
+}
+
+// method returns a call of the method name of the builder with args.
+func (b *builderRewriter) method(pos token.Pos, builder, name string, args ...ast.Expr) *ast.CallExpr {
+	fun := &ast.SelectorExpr{X: &ast.Ident{NamePos: pos, Name: builder}, Sel: &ast.Ident{NamePos: pos, Name: name}}
+	end := pos
+	if len(args) > 0 {
+		end = args[len(args)-1].End()
+	}
+	return &ast.CallExpr{Fun: fun, Lparen: pos, Args: args, Rparen: end}
+}
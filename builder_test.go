@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	runCommandCases(t, "builder")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/internal/diff"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const compareUsage = `Usage: eg compare -t old.go -t2 new.go <args>...
+
+Applies two versions of a template to the packages matched by args, without
+rewriting anything, and reports how their rewrites differ: the files only one
+of them rewrites, and, for those both rewrite differently, their matches and a
+diff from the old version's output to the new one's, so that a change to a
+template can be validated against real code before it's released. It exits
+with status 1 if they differ.
+`
+
+// emitCapture, if set, receives each rewritten file instead of its being emitted, as eg compare collects them.
+var emitCapture func(filename string, src []byte, info editInfo)
+
+// A comparedFile is a file as rewritten by a version of a template.
+type comparedFile struct {
+	src     []byte
+	matches int
+}
+
+func compareMain(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, compareUsage) }
+	oldFlag := fs.String("t", "", "the old version of the template")
+	newFlag := fs.String("t2", "", "the new version of the template")
+	fs.BoolVar(tolerateFlag, "tolerate-errors", false, "skip the packages and files with errors, rather than failing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *oldFlag == "" || *newFlag == "" {
+		return errors.New("both -t and -t2 must be specified")
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	configs, err := newConfigs(wd)
+	if err != nil {
+		return err
+	}
+	var versions [2]map[string]comparedFile
+	for i, t := range []string{*oldFlag, *newFlag} {
+		tmplPath, err := filepath.Abs(t)
+		if err != nil {
+			return fmt.Errorf("unable to resolve %s: %v", t, err)
+		}
+		files := make(map[string]comparedFile)
+		emitCapture = func(filename string, src []byte, info editInfo) {
+			files[filename] = comparedFile{src: src, matches: info.matches}
+		}
+		err = applyTemplate(tmplPath, fs.Args(), configs, platform{}, make(map[string]bool))
+		emitCapture = nil
+		if err != nil {
+			return err
+		}
+		versions[i] = files
+	}
+
+	return reportComparison(wd, versions[0], versions[1])
+}
+
+// reportComparison prints how the rewrites of the new version of a template differ from those of the old one,
+// naming the files relative to dir, and returns an error if they do.
+func reportComparison(dir string, old, new map[string]comparedFile) error {
+	seen := make(map[string]bool)
+	var names []string
+	for _, files := range []map[string]comparedFile{old, new} {
+		for name := range files {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var same, differ, onlyOld, onlyNew, oldMatches, newMatches int
+	for _, name := range names {
+		rel := name
+		if r, err := filepath.Rel(dir, name); err == nil {
+			rel = filepath.ToSlash(r)
+		}
+		o, inOld := old[name]
+		n, inNew := new[name]
+		oldMatches += o.matches
+		newMatches += n.matches
+		switch {
+		case !inNew:
+			onlyOld++
+			fmt.Printf("%s: rewritten only by the old version (%d matches)\n", rel, o.matches)
+		case !inOld:
+			onlyNew++
+			fmt.Printf("%s: rewritten only by the new version (%d matches)\n", rel, n.matches)
+		case bytes.Equal(o.src, n.src):
+			same++
+		default:
+			differ++
+			fmt.Printf("%s: rewritten differently (%d matches, and %d)\n", rel, o.matches, n.matches)
+			os.Stdout.Write(diff.Unified("old/"+rel, "new/"+rel, o.src, n.src))
+		}
+	}
+	fmt.Printf("old: %d matches in %d files; new: %d matches in %d files\n", oldMatches, len(old), newMatches, len(new))
+	fmt.Printf("%d files rewritten the same, %d differently, %d only by the old version and %d only by the new\n",
+		same, differ, onlyOld, onlyNew)
+	if differ+onlyOld+onlyNew > 0 {
+		return errors.New("the versions' rewrites differ")
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	runCommandCases(t, "compare")
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/egrun"
+	"go/parser"
+	"go/token"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// configName is the name of eg's config files. The one at the root of the module supplies the defaults of a
+// run, and those in its subdirectories adjust the settings for their subtrees.
+const configName = ".eg.yaml"
+
+// repoConfig is the contents of a config file, or the effective config of a directory, which merges the
+// environment's config and then the config files from the module root down to it. Flags take precedence over it.
+type repoConfig struct {
+	// Templates are the template files, or directories of them, applied when no -t flag is given. In a nested
+	// config, they replace those of the enclosing directories.
+	Templates []string `yaml:"templates,omitempty"`
+	// Rules are templates written in the config itself, applied along with Templates. In a nested config, they
+	// replace those of the enclosing directories.
+	Rules []templateRule `yaml:"rules,omitempty"`
+	// Exclude are glob patterns of files eg won't rewrite. A pattern matches a path relative to the directory of
+	// its config file, or a leading directory of one; a pattern without a slash matches any element of the path,
+	// and "**" matches any number of elements. Nested configs add to the patterns of enclosing ones.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Include are patterns, as for Exclude, of the only files eg rewrites, if there are any, e.g. **/*_test.go.
+	// Nested configs narrow the files of enclosing ones: a file must match one pattern of each.
+	Include []string `yaml:"include,omitempty"`
+	// SkipGenerated excludes the generated files, those with a "// Code generated ... DO NOT EDIT." comment before
+	// their package clause, which are to be generated anew rather than rewritten.
+	SkipGenerated bool `yaml:"skipgenerated,omitempty"`
+	// Vendor rewrites the files of vendor directories, which are otherwise skipped, being copies of other modules.
+	Vendor bool `yaml:"vendor,omitempty"`
+	// Disable are patterns, as for Exclude but relative to the module root, of templates which aren't applied to
+	// files in the subtree. Nested configs add to the patterns of enclosing ones.
+	Disable []string `yaml:"disable,omitempty"`
+	// Scopes restrict templates, and rules, to the files matching patterns, as for Exclude but relative to the
+	// module root, so that each part of the tree gets its own in one run. They're only read from the config at
+	// the module root.
+	Scopes []templateScope `yaml:"scopes,omitempty"`
+	// Forbid are the contexts rewrites are refused in, and reported: init functions, //go:nosplit functions, and
+	// those using unsafe. Nested configs add to those of enclosing ones.
+	Forbid []string `yaml:"forbid,omitempty"`
+	// Frozen are patterns, as for Exclude, of files whose matches are refused, and reported, rather than silently
+	// skipped. Nested configs add to the patterns of enclosing ones.
+	Frozen []string `yaml:"frozen,omitempty"`
+	// Format is the output format when files aren't rewritten in place.
+	Format string `yaml:"format,omitempty"`
+	// BeforeEdit and AfterEdit are the edit hook commands, as for -beforeedit and -afteredit.
+	BeforeEdit []string `yaml:"beforeedit,omitempty"`
+	AfterEdit  []string `yaml:"afteredit,omitempty"`
+	// BeforeRun and AfterRun are the run hook commands, as for -beforerun and -afterrun.
+	BeforeRun []string `yaml:"beforerun,omitempty"`
+	AfterRun  []string `yaml:"afterrun,omitempty"`
+	// HookShell runs the edit hooks with the platform's shell, as for -hook-shell.
+	HookShell bool `yaml:"hookshell,omitempty"`
+	// HookPolicy is what a failing beforeedit hook does, as for -hook-policy.
+	HookPolicy string `yaml:"hookpolicy,omitempty"`
+
+	root     string     // the module root, against which the merged patterns are relative
+	sources  []string   // the config files merged, outermost first
+	includes [][]string // the Include of each config merged, which a file must match one pattern of each of
+}
+
+// A templateRule is a template written in a config file, either as the expressions before and after, with the
+// wildcards params, or as the source of a template file.
+type templateRule struct {
+	Name string `yaml:"name"`
+	// Doc is the rationale of the rule, which becomes the package doc comment of its template.
+	Doc string `yaml:"doc,omitempty"`
+	// Params declares the wildcards, as a function's parameters would, e.g. "s, sub string".
+	Params string `yaml:"params,omitempty"`
+	Before string `yaml:"before,omitempty"`
+	After  string `yaml:"after,omitempty"`
+	// Imports are the paths of packages the expressions refer to, which are otherwise found as goimports would.
+	Imports []string `yaml:"imports,omitempty"`
+	Source  string   `yaml:"source,omitempty"`
+	// Rewrite is a rule of gofmt -r, as for -r, rather than a template, matched by syntax alone. The rewrite rules
+	// are applied after the templates.
+	Rewrite string `yaml:"rewrite,omitempty"`
+}
+
+// A templateScope applies its templates, files or directories of them, and the rules of the config it names only
+// to the files matching Paths. A pattern ending in /... matches the directory and everything within it.
+type templateScope struct {
+	Paths     []string `yaml:"paths"`
+	Templates []string `yaml:"templates,omitempty"`
+	Rules     []string `yaml:"rules,omitempty"`
+}
+
+// lists reports whether the template at tmplPath is among those of s.
+func (s *templateScope) lists(tmplPath string) bool {
+	for _, name := range s.Rules {
+		if templateLabels[tmplPath] == name {
+			return true
+		}
+	}
+	for _, tmpl := range s.Templates {
+		if tmplPath == tmpl || strings.HasPrefix(tmplPath, tmpl+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// source returns the source of the rule's template.
+func (r *templateRule) source() ([]byte, error) {
+	src := []byte(r.Source)
+	if r.Source == "" {
+		var err error
+		if src, err = exprTemplate(r.Params, r.Before, r.After, r.Imports); err != nil {
+			return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+	}
+	if r.Doc == "" {
+		return src, nil
+	}
+	var doc bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSpace(r.Doc), "\n") {
+		doc.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+	}
+	return append(doc.Bytes(), src...), nil
+}
+
+const configUsage = `Usage: eg config show [path]
+
+Prints the effective config of path (by default, the current directory): the
+merge of the EG_* environment variables and then of the .eg.yaml files from
+the root of its module down to it, which override them, each of which is
+listed.
+`
+
+func configMain(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, configUsage) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || fs.Arg(0) != "show" || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	target := "."
+	if fs.NArg() == 2 {
+		target = fs.Arg(1)
+	}
+	dir, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	configs, err := newConfigs(dir)
+	if err != nil {
+		return err
+	}
+	conf, err := configs.forDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(conf.sources) == 0 {
+		return errors.New("no config files apply to " + target)
+	}
+	return conf.write(os.Stdout)
+}
+
+// write writes c to w as YAML, preceded by comments listing its sources.
+func (c *repoConfig) write(w io.Writer) error {
+	for _, source := range c.sources {
+		fmt.Fprintf(w, "# %s\n", source)
+	}
+	if c.root != "" {
+		fmt.Fprintf(w, "# patterns are relative to %s\n", c.root)
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// outputFormats are the valid values of -format, with the first being the default.
+var outputFormats = []string{"source", "list", "patch"}
+
+func validFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// configs computes the effective config of directories within a module, caching the result for each.
+type configs struct {
+	root  string
+	dir   string      // the directory the patterns are relative to outside any module, as in GOPATH mode
+	env   *repoConfig // the config set by the environment, if any, which the files override
+	flags *repoConfig // the config set by the flags, if any, which overrides the files
+	byDir map[string]*repoConfig
+	files map[string]*repoConfig // the merged env and config files of each directory, without flags
+}
+
+// newConfigs returns the configs of the module enclosing dir. It's valid, if empty, when dir isn't in a module.
+func newConfigs(dir string) (*configs, error) {
+	env, err := envConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := flagConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &configs{
+		root:  moduleRoot(dir),
+		dir:   dir,
+		env:   env,
+		flags: flags,
+		byDir: make(map[string]*repoConfig),
+		files: make(map[string]*repoConfig),
+	}, nil
+}
+
+// envConfig returns the config set by the environment, with the templates relative to dir, or nil if none is.
+func envConfig(dir string) (*repoConfig, error) {
+	conf := &repoConfig{}
+	for _, v := range []struct {
+		name string
+		list *[]string
+		str  *string
+	}{
+		{name: "EG_TEMPLATES", list: &conf.Templates},
+		{name: "EG_EXCLUDE", list: &conf.Exclude},
+		{name: "EG_DISABLE", list: &conf.Disable},
+		{name: "EG_FORMAT", str: &conf.Format},
+		{name: "EG_BEFOREEDIT", list: &conf.BeforeEdit},
+		{name: "EG_AFTEREDIT", list: &conf.AfterEdit},
+		{name: "EG_BEFORERUN", list: &conf.BeforeRun},
+		{name: "EG_AFTERRUN", list: &conf.AfterRun},
+	} {
+		val := os.Getenv(v.name)
+		if val == "" {
+			continue
+		}
+		conf.sources = append(conf.sources, "$"+v.name)
+		switch {
+		case v.str != nil:
+			*v.str = val
+		case strings.HasSuffix(v.name, "EDIT") || strings.HasSuffix(v.name, "RUN"):
+			// commands may contain the list separator, so they're a line each
+			*v.list = nil
+			for _, cmd := range strings.Split(val, "\n") {
+				if cmd = strings.TrimSpace(cmd); cmd != "" {
+					*v.list = append(*v.list, cmd)
+				}
+			}
+		default:
+			*v.list = filepath.SplitList(val)
+		}
+	}
+	if len(conf.sources) == 0 {
+		return nil, nil
+	}
+	if err := conf.validate(strings.Join(conf.sources, ", ")); err != nil {
+		return nil, err
+	}
+	for i, tmpl := range conf.Templates {
+		if !filepath.IsAbs(tmpl) {
+			conf.Templates[i] = filepath.Join(dir, tmpl)
+		}
+	}
+	return conf, nil
+}
+
+// flagConfig returns the config set by the -exclude, -include, -skip-generated and -vendor flags, or nil if none
+// is.
+func flagConfig() (*repoConfig, error) {
+	conf := &repoConfig{}
+	for _, v := range []struct {
+		name string
+		list *[]string
+		flag arrayFlags
+	}{
+		{name: "-exclude", list: &conf.Exclude, flag: excludeFlags},
+		{name: "-include", list: &conf.Include, flag: includeFlags},
+	} {
+		if len(v.flag) > 0 {
+			*v.list = append(*v.list, v.flag...)
+			conf.sources = append(conf.sources, v.name)
+		}
+	}
+	if *generatedFlag {
+		conf.SkipGenerated = true
+		conf.sources = append(conf.sources, "-skip-generated")
+	}
+	if *vendorFlag {
+		conf.Vendor = true
+		conf.sources = append(conf.sources, "-vendor")
+	}
+	if len(conf.sources) == 0 {
+		return nil, nil
+	}
+	if err := conf.validate(strings.Join(conf.sources, ", ")); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// forDir returns the effective config of dir. Directories outside the module have only the environment's and the
+// flags' configs.
+func (c *configs) forDir(dir string) (*repoConfig, error) {
+	if conf, ok := c.byDir[dir]; ok {
+		return conf, nil
+	}
+	conf, err := c.filesFor(dir)
+	if err != nil {
+		return nil, err
+	}
+	if c.flags != nil {
+		conf = conf.merge(c.flags, ".")
+	}
+	c.byDir[dir] = conf
+	return conf, nil
+}
+
+// filesFor returns the merge of the environment's config and the config files from the module root down to dir.
+func (c *configs) filesFor(dir string) (*repoConfig, error) {
+	if conf, ok := c.files[dir]; ok {
+		return conf, nil
+	}
+	if c.root == "" {
+		return c.envFor(c.dir), nil // with no config files, but the patterns of the environment
+	}
+	rel, err := filepath.Rel(c.root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return c.envFor(c.root), nil
+	}
+
+	parent := c.envFor(c.root)
+	if rel != "." {
+		if parent, err = c.filesFor(filepath.Dir(dir)); err != nil {
+			return nil, err
+		}
+	}
+	conf, err := readConfig(filepath.Join(dir, configName))
+	if err != nil {
+		return nil, err
+	}
+	if conf != nil && rel != "." && len(conf.Scopes) > 0 {
+		return nil, fmt.Errorf("%s: scopes are only read from the config at the module root", conf.sources[0])
+	}
+	merged := parent
+	if conf != nil {
+		merged = parent.merge(conf, filepath.ToSlash(rel))
+	}
+	c.files[dir] = merged
+	return merged, nil
+}
+
+// envFor returns the environment's config, with patterns relative to root, as the base the config files override.
+func (c *configs) envFor(root string) *repoConfig {
+	conf := &repoConfig{root: root}
+	if c.env != nil {
+		conf = conf.merge(c.env, ".")
+	}
+	return conf
+}
+
+// readConfig reads and validates the config file at filename, returning nil if it doesn't exist.
+func readConfig(filename string) (*repoConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &repoConfig{sources: []string{filename}}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := conf.validate(filename); err != nil {
+		return nil, err
+	}
+	for i, tmpl := range conf.Templates {
+		if !filepath.IsAbs(tmpl) {
+			conf.Templates[i] = filepath.Join(filepath.Dir(filename), tmpl)
+		}
+	}
+	for i := range conf.Scopes {
+		s := &conf.Scopes[i]
+		for j, tmpl := range s.Templates {
+			if !filepath.IsAbs(tmpl) {
+				s.Templates[j] = filepath.Join(filepath.Dir(filename), tmpl)
+			}
+		}
+		for j, pattern := range s.Paths {
+			if pattern == "..." || strings.HasSuffix(pattern, "/...") {
+				s.Paths[j] = strings.TrimSuffix(pattern, "...") + "**"
+			}
+		}
+	}
+	return conf, nil
+}
+
+// validate checks the settings of c, which was read from source.
+func (c *repoConfig) validate(source string) error {
+	if c.Format != "" && !validFormat(c.Format) {
+		return fmt.Errorf("%s: invalid format %q: want one of %s", source, c.Format, strings.Join(outputFormats, ", "))
+	}
+	if c.HookPolicy != "" && !validPolicy(c.HookPolicy) {
+		return fmt.Errorf("%s: invalid hookpolicy %q: want one of %s", source, c.HookPolicy, strings.Join(hookPolicies, ", "))
+	}
+	for _, context := range c.Forbid {
+		if !validContext(context) {
+			return fmt.Errorf("%s: invalid forbid %q: want one of %s", source, context, strings.Join(forbiddenContexts, ", "))
+		}
+	}
+	for _, pattern := range append(append(append(c.Exclude, c.Disable...), c.Frozen...), c.Include...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %v", source, pattern, err)
+		}
+	}
+	names := make(map[string]bool)
+	for _, r := range c.Rules {
+		switch {
+		case !token.IsIdentifier(r.Name):
+			return fmt.Errorf("%s: invalid rule name %q: want an identifier", source, r.Name)
+		case names[r.Name]:
+			return fmt.Errorf("%s: duplicate rule %s", source, r.Name)
+		case r.Rewrite != "" && (r.Source != "" || r.Before != "" || r.After != "" || r.Params != "" || len(r.Imports) > 0):
+			return fmt.Errorf("%s: rule %s: want one of rewrite, before and after, or source", source, r.Name)
+		case r.Rewrite != "":
+			if _, err := parseRule(r.Rewrite); err != nil {
+				return fmt.Errorf("%s: rule %s: %v", source, r.Name, err)
+			}
+		case (r.Source != "") == (r.Before != "" || r.After != "" || r.Params != "" || len(r.Imports) > 0):
+			return fmt.Errorf("%s: rule %s: want either before and after or source", source, r.Name)
+		case r.Source == "" && (r.Before == "" || r.After == ""):
+			return fmt.Errorf("%s: rule %s: want both before and after", source, r.Name)
+		}
+		names[r.Name] = true
+	}
+	for i, s := range c.Scopes {
+		if len(s.Paths) == 0 || len(s.Templates)+len(s.Rules) == 0 {
+			return fmt.Errorf("%s: scope %d: want paths and the templates or rules they apply", source, i+1)
+		}
+		for _, pattern := range s.Paths {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: scope %d: invalid pattern %q: %v", source, i+1, pattern, err)
+			}
+		}
+		for _, name := range s.Rules {
+			if !names[name] {
+				return fmt.Errorf("%s: scope %d: no rule %s", source, i+1, name)
+			}
+		}
+	}
+	return nil
+}
+
+// merge returns the config resulting from applying child, the config file of the directory at dir relative to
+// the module root, to c.
+func (c *repoConfig) merge(child *repoConfig, dir string) *repoConfig {
+	merged := *c
+	merged.sources = append(append([]string(nil), c.sources...), child.sources...)
+	if len(child.Templates) > 0 {
+		merged.Templates = child.Templates
+	}
+	if len(child.Rules) > 0 {
+		merged.Rules = child.Rules
+	}
+	if child.Format != "" {
+		merged.Format = child.Format
+	}
+	if len(child.BeforeEdit) > 0 {
+		merged.BeforeEdit = child.BeforeEdit
+	}
+	if len(child.AfterEdit) > 0 {
+		merged.AfterEdit = child.AfterEdit
+	}
+	if len(child.BeforeRun) > 0 {
+		merged.BeforeRun = child.BeforeRun
+	}
+	if len(child.AfterRun) > 0 {
+		merged.AfterRun = child.AfterRun
+	}
+	merged.HookShell = merged.HookShell || child.HookShell
+	if child.HookPolicy != "" {
+		merged.HookPolicy = child.HookPolicy
+	}
+	merged.Exclude = append([]string(nil), c.Exclude...)
+	for _, pattern := range child.Exclude {
+		merged.Exclude = append(merged.Exclude, rootPattern(dir, pattern))
+	}
+	if len(child.Include) > 0 {
+		var include []string
+		for _, pattern := range child.Include {
+			include = append(include, rootPattern(dir, pattern))
+		}
+		merged.Include = append(append([]string(nil), c.Include...), include...)
+		merged.includes = append(append([][]string(nil), c.includes...), include)
+	}
+	merged.SkipGenerated = merged.SkipGenerated || child.SkipGenerated
+	merged.Vendor = merged.Vendor || child.Vendor
+	merged.Disable = append([]string(nil), c.Disable...)
+	for _, pattern := range child.Disable {
+		merged.Disable = append(merged.Disable, rootPattern(".", pattern))
+	}
+	merged.Forbid = append(append([]string(nil), c.Forbid...), child.Forbid...)
+	merged.Frozen = append([]string(nil), c.Frozen...)
+	for _, pattern := range child.Frozen {
+		merged.Frozen = append(merged.Frozen, rootPattern(dir, pattern))
+	}
+	merged.Scopes = append([]templateScope(nil), c.Scopes...)
+	for _, s := range child.Scopes {
+		paths := s.Paths
+		s.Paths = nil
+		for _, pattern := range paths {
+			s.Paths = append(s.Paths, rootPattern(dir, pattern))
+		}
+		merged.Scopes = append(merged.Scopes, s)
+	}
+	return &merged
+}
+
+// rootPattern rewrites pattern, relative to dir, as a pattern relative to the module root.
+func rootPattern(dir, pattern string) string {
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	if dir == "." {
+		return pattern
+	}
+	return dir + "/" + pattern
+}
+
+// moduleRoot returns the directory of the go.mod enclosing dir, or "" if there is none.
+func moduleRoot(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// templatePaths resolves the configured templates, and those of the scopes, into template files: directories are
+// searched recursively.
+func (c *repoConfig) templatePaths() ([]string, error) {
+	var args []string
+	tmpls := append([]string(nil), c.Templates...)
+	for _, s := range c.Scopes {
+		tmpls = append(tmpls, s.Templates...)
+	}
+	for _, tmpl := range tmpls {
+		if strings.HasSuffix(tmpl, ".go") {
+			args = append(args, tmpl)
+		} else {
+			args = append(args, tmpl+string(filepath.Separator)+"...")
+		}
+	}
+	paths, err := egrun.FindTemplates("", args)
+	if err != nil {
+		return nil, err
+	}
+	// a template may be both configured and scoped, and the paths are sorted
+	var unique []string
+	for i, p := range paths {
+		if i == 0 || p != paths[i-1] {
+			unique = append(unique, p)
+		}
+	}
+	return unique, nil
+}
+
+// excluded reports whether the file at filename matches any of the exclude patterns, or none of the include
+// patterns of a config merged, or is in a vendor directory, or is generated, if those are skipped.
+func (c *repoConfig) excluded(filename string) bool {
+	if c.matchAny(c.Exclude, filename) {
+		return true
+	}
+	for _, include := range c.includes {
+		if !c.matchAny(include, filename) {
+			return true
+		}
+	}
+	if !c.Vendor && vendored(filename) {
+		return true
+	}
+	return c.SkipGenerated && isGenerated(filename)
+}
+
+// vendored reports whether the file at filename is in a vendor directory.
+func vendored(filename string) bool {
+	elems := strings.Split(filepath.ToSlash(filename), "/")
+	for _, elem := range elems[:len(elems)-1] {
+		if elem == "vendor" {
+			return true
+		}
+	}
+	return false
+}
+
+// tests reports whether the packages are loaded with their tests, which are otherwise left: when an include pattern
+// of the environment, or of the config at the module root, names test files, e.g. **/*_test.go.
+func (c *configs) tests() (bool, error) {
+	dir := c.root
+	if dir == "" {
+		dir = c.dir
+	}
+	conf, err := c.forDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, pattern := range conf.Include {
+		if strings.HasSuffix(pattern, "_test.go") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// generatedComment matches the comment marking a generated file, as https://golang.org/s/generatedcode says.
+var generatedComment = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether the Go file at filename is generated: whether it has the comment marking it so before
+// its package clause.
+func isGenerated(filename string) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false
+	}
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if generatedComment.MatchString(c.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// disabled reports whether the template at tmplPath is disabled.
+func (c *repoConfig) disabled(tmplPath string) bool {
+	return c.matchAny(c.Disable, tmplPath)
+}
+
+// inScope reports whether the template at tmplPath applies to the file at filename: it's listed by no scope, or by
+// one whose paths match filename.
+func (c *repoConfig) inScope(tmplPath, filename string) bool {
+	scoped := false
+	for i := range c.Scopes {
+		if !c.Scopes[i].lists(tmplPath) {
+			continue
+		}
+		if c.matchAny(c.Scopes[i].Paths, filename) {
+			return true
+		}
+		scoped = true
+	}
+	return !scoped
+}
+
+func (c *repoConfig) matchAny(patterns []string, filename string) bool {
+	if c.root == "" || len(patterns) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(c.root, filename)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+	for _, pattern := range patterns {
+		// a pattern matching a leading directory matches everything within it
+		for n := len(elems); n > 0; n-- {
+			if matchElems(strings.Split(pattern, "/"), elems[:n]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchElems matches the elements of a path against those of a pattern, in which "**" matches any number of
+// elements.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree writes files, by their slash-separated paths, to a new temporary directory, returning it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "eg-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestConfigMerge checks the settings of nested configs, which override the environment's, and which the flags'
+// override.
+func TestConfigMerge(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod": "module example.com/m\n",
+		".eg.yaml": `templates: [tools/eg]
+exclude: ["*.pb.go"]
+include: [svc/**]
+format: list
+beforeedit: ["p4 edit {}"]
+`,
+		"svc/.eg.yaml": `templates: [eg]
+exclude: [gen]
+include: ["*_test.go"]
+disable: [tools/eg/errorf.go]
+`,
+		"svc/api/.eg.yaml": "format: source\n",
+	})
+	defer os.RemoveAll(root)
+
+	for _, test := range []struct {
+		name     string
+		env      map[string]string
+		excludes []string
+		dir      string
+		want     repoConfig
+	}{
+		{
+			name: "root",
+			dir:  ".",
+			want: repoConfig{
+				Templates:  []string{filepath.Join(root, "tools/eg")},
+				Exclude:    []string{"**/*.pb.go"},
+				Include:    []string{"svc/**"},
+				Format:     "list",
+				BeforeEdit: []string{"p4 edit {}"},
+				includes:   [][]string{{"svc/**"}},
+				sources:    []string{".eg.yaml"},
+			},
+		},
+		{
+			name: "nested",
+			dir:  "svc/api",
+			want: repoConfig{
+				Templates:  []string{filepath.Join(root, "svc/eg")},
+				Exclude:    []string{"**/*.pb.go", "svc/**/gen"},
+				Include:    []string{"svc/**", "svc/**/*_test.go"},
+				Disable:    []string{"tools/eg/errorf.go"},
+				Format:     "source",
+				BeforeEdit: []string{"p4 edit {}"},
+				includes:   [][]string{{"svc/**"}, {"svc/**/*_test.go"}},
+				sources:    []string{".eg.yaml", "svc/.eg.yaml", "svc/api/.eg.yaml"},
+			},
+		},
+		{
+			name:     "environment and flags",
+			dir:      "svc/api",
+			env:      map[string]string{"EG_FORMAT": "list", "EG_EXCLUDE": "old" + string(os.PathListSeparator) + "x/*.go"},
+			excludes: []string{"tmp"},
+			want: repoConfig{
+				Templates:  []string{filepath.Join(root, "svc/eg")},
+				Exclude:    []string{"**/old", "x/*.go", "**/*.pb.go", "svc/**/gen", "**/tmp"},
+				Include:    []string{"svc/**", "svc/**/*_test.go"},
+				Disable:    []string{"tools/eg/errorf.go"},
+				Format:     "source", // svc/api's, rather than the environment's
+				BeforeEdit: []string{"p4 edit {}"},
+				includes:   [][]string{{"svc/**"}, {"svc/**/*_test.go"}},
+				sources:    []string{"$EG_EXCLUDE", "$EG_FORMAT", ".eg.yaml", "svc/.eg.yaml", "svc/api/.eg.yaml", "-exclude"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for name, val := range test.env {
+				t.Setenv(name, val)
+			}
+			saved := excludeFlags
+			defer func() { excludeFlags = saved }()
+			excludeFlags = test.excludes
+
+			configs, err := newConfigs(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := configs.forDir(filepath.Join(root, filepath.FromSlash(test.dir)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, source := range got.sources {
+				if rel, err := filepath.Rel(root, source); err == nil && !strings.HasPrefix(rel, "..") {
+					got.sources[i] = filepath.ToSlash(rel)
+				}
+			}
+			test.want.root = root
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("got  %+v\nwant %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+// TestEnvConfig checks that the config files override the environment's variables, and the flags both, and that
+// each hook variable holds a command per line.
+func TestEnvConfig(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod": "module example.com/m\n",
+		".eg.yaml": `templates: [tools/eg]
+format: list
+beforeedit: ["p4 edit {}"]
+afteredit: ["gofmt -w {}"]
+afterrun: ["go build ./..."]
+`,
+	})
+	defer os.RemoveAll(root)
+
+	t.Setenv("EG_TEMPLATES", "ci/eg"+string(os.PathListSeparator)+"more/eg")
+	t.Setenv("EG_DISABLE", "ci/eg/old.go")
+	t.Setenv("EG_FORMAT", "patch")
+	t.Setenv("EG_BEFOREEDIT", "chmod u+w {}\n\ngit checkout -- {}\n")
+	t.Setenv("EG_BEFORERUN", "echo a:b")
+	configs, err := newConfigs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := configs.forDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file's settings win, and the environment's fill in those it doesn't set, or add to its lists
+	want := repoConfig{
+		Templates:  []string{filepath.Join(root, "tools/eg")},
+		Disable:    []string{"ci/eg/old.go"},
+		Format:     "list",
+		BeforeEdit: []string{"p4 edit {}"},
+		AfterEdit:  []string{"gofmt -w {}"},
+		BeforeRun:  []string{"echo a:b"},
+		AfterRun:   []string{"go build ./..."},
+		root:       root,
+		sources: []string{"$EG_TEMPLATES", "$EG_DISABLE", "$EG_FORMAT", "$EG_BEFOREEDIT", "$EG_BEFORERUN",
+			filepath.Join(root, ".eg.yaml")},
+	}
+	if !reflect.DeepEqual(*conf, want) {
+		t.Errorf("got  %+v\nwant %+v", *conf, want)
+	}
+
+	// in a module without a config file, the environment's settings apply
+	bare := writeTree(t, map[string]string{"go.mod": "module example.com/bare\n"})
+	defer os.RemoveAll(bare)
+	bareConfigs, err := newConfigs(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bareConf, err := bareConfigs.forDir(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bareConf.Format != "patch" || !reflect.DeepEqual(bareConf.BeforeEdit, []string{"chmod u+w {}", "git checkout -- {}"}) ||
+		!reflect.DeepEqual(bareConf.Templates, []string{filepath.Join(bare, "ci/eg"), filepath.Join(bare, "more/eg")}) {
+		t.Errorf("without a config file, got %+v, want the environment's settings", *bareConf)
+	}
+
+	savedFormat, savedBefore, savedAfter, savedBeforeRun, savedAfterRun, savedPolicy :=
+		*formatFlag, beforeEditFlags, afterEditFlags, beforeRunFlags, afterRunFlags, *policyFlag
+	defer func() {
+		*formatFlag, beforeEditFlags, afterEditFlags, beforeRunFlags, afterRunFlags, *policyFlag =
+			savedFormat, savedBefore, savedAfter, savedBeforeRun, savedAfterRun, savedPolicy
+	}()
+	*formatFlag, beforeEditFlags = "source", arrayFlags{"touch {}"}
+	if err := applyConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if *formatFlag != "source" || !reflect.DeepEqual([]string(beforeEditFlags), []string{"touch {}"}) {
+		t.Errorf("the flags -format %s -beforeedit %q were overridden", *formatFlag, beforeEditFlags)
+	}
+	if !reflect.DeepEqual([]string(afterEditFlags), want.AfterEdit) ||
+		!reflect.DeepEqual([]string(beforeRunFlags), want.BeforeRun) {
+		t.Errorf("-afteredit %q and -beforerun %q weren't set from the config", afterEditFlags, beforeRunFlags)
+	}
+}
+
+// TestExcluded checks which files the patterns of the configs, and of the flags, leave.
+func TestExcluded(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":           "module example.com/m\n",
+		".eg.yaml":         "exclude: [\"*.pb.go\", legacy/gen]\n",
+		"legacy/.eg.yaml":  "exclude: [old.go]\n",
+		"svc/.eg.yaml":     "include: [api/**, \"**/*_test.go\"]\n",
+		"svc/api/.eg.yaml": "include: [\"*_test.go\"]\n",
+	})
+	defer os.RemoveAll(root)
+
+	for _, test := range []struct {
+		file     string
+		includes []string // -include
+		excludes []string // -exclude
+		excluded bool
+	}{
+		{file: "main.go"},
+		{file: "api.pb.go", excluded: true},
+		{file: "legacy/gen/a.go", excluded: true}, // a leading directory
+		{file: "legacy/a/gen/a.go"},               // but only at the root of the module
+		{file: "legacy/old.go", excluded: true},   // without a slash, any element
+		{file: "legacy/sub/old.go", excluded: true},
+		{file: "old.go"},                            // but only within the config's directory
+		{file: "svc/internal/a.go", excluded: true}, // matching none of svc's includes
+		{file: "svc/internal/a_test.go"},
+		{file: "svc/api/a.go", excluded: true}, // matching svc's include, but not svc/api's
+		{file: "svc/api/a_test.go"},
+		{file: "svc/api/v1/a_test.go"},
+		{file: "vendor/example.com/v/v.go", excluded: true},
+		{file: "main.go", includes: []string{"cmd/**"}, excluded: true},
+		{file: "cmd/eg/main.go", includes: []string{"cmd/**"}},
+		{file: "svc/api/a_test.go", includes: []string{"cmd/**"}, excluded: true}, // -include narrows the configs'
+		{file: "svc/api/a_test.go", includes: []string{"svc/**/a_*.go"}},
+		{file: "cmd/eg/main.go", excludes: []string{"cmd/**/*.go"}, excluded: true},
+		{file: "cmd/eg/main.go", excludes: []string{"cmd/*.go"}},
+		{file: "cmd/eg/main.go", excludes: []string{"**/eg"}, excluded: true},
+	} {
+		savedIncludes, savedExcludes := includeFlags, excludeFlags
+		includeFlags, excludeFlags = test.includes, test.excludes
+		configs, err := newConfigs(root)
+		includeFlags, excludeFlags = savedIncludes, savedExcludes
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(root, filepath.FromSlash(test.file))
+		conf, err := configs.forDir(filepath.Dir(filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conf.excluded(filename); got != test.excluded {
+			t.Errorf("%s, with -include %v and -exclude %v: excluded %v, want %v", test.file, test.includes,
+				test.excludes, got, test.excluded)
+		}
+	}
+}
+
+func TestMatchElems(t *testing.T) {
+	for _, test := range []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"a/b.go", "a/b.go", true},
+		{"a/*.go", "a/b.go", true},
+		{"a/*.go", "a/b/c.go", false},
+		{"**", "a/b/c.go", true},
+		{"**/c.go", "c.go", true},
+		{"**/c.go", "a/b/c.go", true},
+		{"a/**/c.go", "a/c.go", true},
+		{"a/**/c.go", "a/b/b/c.go", true},
+		{"a/**/c.go", "b/c.go", false},
+		{"a/**", "a", true},
+		{"a", "a/b.go", false}, // matchAny matches the leading directories
+		{"**/*_test.go", "a/b_test.go", true},
+		{"**/*_test.go", "a/b.go", false},
+		{"a/[bc].go", "a/c.go", true},
+	} {
+		if got := matchElems(strings.Split(test.pattern, "/"), strings.Split(test.path, "/")); got != test.match {
+			t.Errorf("matchElems(%q, %q) = %v, want %v", test.pattern, test.path, got, test.match)
+		}
+	}
+}
+
+// TestConfigShow prints the effective config of a directory with a config of its own, which adjusts the module's.
+func TestConfigShow(t *testing.T) {
+	runCommandCases(t, "config")
+}
+
+// TestNestedConfig checks that a template a nested config disables isn't applied to its subtree, but still is
+// elsewhere.
+func TestNestedConfig(t *testing.T) {
+	runMainCases(t, "nested-config")
+}
+
+// TestConfigRules applies the templates a config holds as rules, as expressions before and after, or as source.
+func TestConfigRules(t *testing.T) {
+	runMainCases(t, "config-rules")
+}
+
+// TestConfigScopes applies the templates and rules of the root config's scopes only to the files their paths match,
+// and rejects scopes naming no rule, or in a nested config.
+func TestConfigScopes(t *testing.T) {
+	runMainCases(t, "config-scopes")
+}
+
+// TestFileFlags checks the files the -exclude, -include, -skip-generated and -vendor flags have eg rewrite, and that
+// the root config's exclude still applies with each.
+func TestFileFlags(t *testing.T) {
+	runMainCases(t, "file-flags")
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const constantsUsage = `Usage: eg constants -table table.txt [-w] <packages>...
+
+Replaces references to constants, and literal values passed as arguments of
+functions, with other constants, as given by each line of the table:
+
+	# old                             new
+	example.com/log.Info              example.com/log/v2.LevelInfo
+	os.SEEK_SET                       io.SeekStart
+	example.com/log.SetLevel(0)=2     example.com/log.LevelWarn
+
+The first two lines replace references to the constant on the left; the last
+replaces the literal 2 when it's SetLevel's first argument (counting from 0).
+Blank lines and those starting with # are ignored. The packages of the new
+constants must be among those loaded, or imported by them. The new constant's
+type must be that of the old, or if the old is typed, the new may be untyped
+with the same default type; it must be assignable to the parameter it's
+passed as. References within the new constant's declaration, or from packages
+it imports, are left.
+`
+
+func constantsMain(args []string) error {
+	fs := flag.NewFlagSet("constants", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, constantsUsage) }
+	table := fs.String("table", "", "the file mapping old constants and values to new constants")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *table == "" {
+		return errors.New("-table is required")
+	}
+	src, err := ioutil.ReadFile(*table)
+	if err != nil {
+		return err
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	mappings, err := parseConstTable(m, *table, string(src))
+	if err != nil {
+		return err
+	}
+	c := constReplacer{m: m, consts: make(map[types.Object]*constMapping), args: make(map[types.Object][]*constMapping)}
+	var objs []types.Object
+	for _, cm := range mappings {
+		if cm.fn != nil {
+			c.args[cm.fn] = append(c.args[cm.fn], cm)
+			objs = append(objs, cm.fn)
+		} else {
+			c.consts[cm.old] = cm
+			objs = append(objs, cm.old)
+		}
+	}
+
+	for _, u := range m.uses(objs...) {
+		if cm := c.consts[u.pkg.TypesInfo.Uses[u.id]]; cm != nil {
+			c.replaceConst(u, cm)
+		} else if call := u.call(); call != nil {
+			c.replaceArg(u, call)
+		}
+	}
+	return m.emit(false)
+}
+
+// A constMapping is a line of the table, mapping an old constant, or a value passed as an argument of a function,
+// to a new constant.
+type constMapping struct {
+	old   *types.Const   // the constant replaced, or nil if a value is
+	fn    *types.Func    // the function to which the value is passed
+	arg   int            // the index of the argument
+	value constant.Value // the value
+	new   *types.Const
+	line  string // the table's file and line, for messages
+}
+
+// parseConstTable parses the table src, read from the file named filename, resolving its names in the packages m
+// has loaded.
+func parseConstTable(m *migration, filename, src string) ([]*constMapping, error) {
+	var mappings []*constMapping
+	seen := make(map[string]string)
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cm := &constMapping{line: fmt.Sprintf("%s:%d", filename, i+1)}
+		if err := cm.parse(m, line); err != nil {
+			return nil, fmt.Errorf("%s: %v", cm.line, err)
+		}
+		old := line[:strings.LastIndexAny(line, " \t")]
+		if prev, ok := seen[strings.Join(strings.Fields(old), "")]; ok {
+			return nil, fmt.Errorf("%s: %s is already mapped, at %s", cm.line, strings.TrimSpace(old), prev)
+		}
+		seen[strings.Join(strings.Fields(old), "")] = cm.line
+		mappings = append(mappings, cm)
+	}
+	return mappings, nil
+}
+
+// parse parses line, of the form "path.Name new" or "path.F(i)=value new", into the mapping.
+func (cm *constMapping) parse(m *migration, line string) error {
+	sep := strings.LastIndexAny(line, " \t")
+	if sep < 0 {
+		return fmt.Errorf("invalid line %q: want the old constant or value, then the new constant", line)
+	}
+	old, newSpec := strings.TrimSpace(line[:sep]), line[sep+1:]
+	var err error
+	if cm.new, err = lookupConst(m, newSpec); err != nil {
+		return err
+	}
+
+	paren := strings.Index(old, "(")
+	if paren < 0 {
+		if cm.old, err = lookupConst(m, old); err != nil {
+			return err
+		}
+		oldType, newType := cm.old.Type(), cm.new.Type()
+		if !types.Identical(types.Default(oldType), types.Default(newType)) || isUntyped(oldType) && !isUntyped(newType) {
+			return fmt.Errorf("%s has type %s, which %s, of type %s, can't replace", old, oldType, newSpec, newType)
+		}
+		return nil
+	}
+
+	end := strings.Index(old, ")")
+	if end < paren || !strings.HasPrefix(strings.TrimSpace(old[end+1:]), "=") {
+		return fmt.Errorf("invalid value %q: want path.F(index)=value", old)
+	}
+	obj, err := m.lookupWith(strings.TrimSpace(old[:paren]), true)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	if cm.fn, ok = obj.(*types.Func); !ok {
+		return fmt.Errorf("%s isn't a function or method", old[:paren])
+	}
+	if cm.arg, err = strconv.Atoi(strings.TrimSpace(old[paren+1 : end])); err != nil || cm.arg < 0 {
+		return fmt.Errorf("invalid argument index %q", old[paren+1:end])
+	}
+	valueSrc := strings.TrimSpace(old[end+1:])[1:]
+	tv, err := types.Eval(token.NewFileSet(), nil, token.NoPos, valueSrc)
+	if err != nil || tv.Value == nil {
+		return fmt.Errorf("invalid value %q: want a constant", valueSrc)
+	}
+	cm.value = tv.Value
+
+	sig := cm.fn.Type().(*types.Signature)
+	param := paramType(sig, cm.arg)
+	if param == nil {
+		return fmt.Errorf("%s takes only %d parameters", cm.fn.Name(), sig.Params().Len())
+	}
+	if !isUntyped(cm.new.Type()) && !types.AssignableTo(cm.new.Type(), param) {
+		return fmt.Errorf("%s has type %s, which can't be passed as a %s", newSpec, cm.new.Type(), param)
+	}
+	return nil
+}
+
+// lookupConst resolves spec, as path.Name, to a constant in the loaded packages or those they import.
+func lookupConst(m *migration, spec string) (*types.Const, error) {
+	obj, err := m.lookupWith(spec, true)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := obj.(*types.Const)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a constant", spec)
+	}
+	return c, nil
+}
+
+// isUntyped reports whether t is an untyped constant's type.
+func isUntyped(t types.Type) bool {
+	b, ok := t.(*types.Basic)
+	return ok && b.Info()&types.IsUntyped != 0
+}
+
+// paramType returns the type of the value passed as the ith argument of a call of a function with signature sig,
+// or nil if there's none.
+func paramType(sig *types.Signature, i int) types.Type {
+	n := sig.Params().Len()
+	switch {
+	case sig.Variadic() && i >= n-1:
+		return sig.Params().At(n - 1).Type().(*types.Slice).Elem()
+	case i < n:
+		return sig.Params().At(i).Type()
+	}
+	return nil
+}
+
+// A constReplacer replaces the references to constants and the values passed as arguments mapped by the table.
+type constReplacer struct {
+	m      *migration
+	consts map[types.Object]*constMapping   // by the old constant
+	args   map[types.Object][]*constMapping // by the function
+}
+
+// replaceConst replaces the reference u to the old constant of cm with the new one.
+func (c *constReplacer) replaceConst(u use, cm *constMapping) {
+	if c.excluded(u.pkg, u.file, u.id, cm.new) {
+		return
+	}
+	var old ast.Node = u.id
+	parent := u.path[1]
+	if sel, ok := parent.(*ast.SelectorExpr); ok && sel.Sel == u.id {
+		old, parent = sel, u.path[2]
+	}
+	e, err := c.ref(u.pkg, u.file, old.Pos(), cm.new)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: can't replace %s: %v\n", c.m.position(old), cm.old.Name(), err)
+		return
+	}
+	replaceNode(parent, old, e)
+	c.m.touch(u.pkg, u.file)
+}
+
+// replaceArg replaces the arguments of call, a call of a function the table maps values passed to, which are
+// literals of the values mapped.
+func (c *constReplacer) replaceArg(u use, call *ast.CallExpr) {
+	for _, cm := range c.args[u.pkg.TypesInfo.Uses[u.id]] {
+		if cm.arg >= len(call.Args) || call.Ellipsis.IsValid() && cm.arg >= len(call.Args)-1 {
+			continue
+		}
+		arg := call.Args[cm.arg]
+		tv := u.pkg.TypesInfo.Types[arg]
+		if !isLiteral(arg) || tv.Value == nil || !sameConst(tv.Value, cm.value) || c.excluded(u.pkg, u.file, arg, cm.new) {
+			continue
+		}
+		e, err := c.ref(u.pkg, u.file, arg.Pos(), cm.new)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: can't replace %s: %v\n", c.m.position(arg), nodeString(c.m.fSet, arg), err)
+			continue
+		}
+		call.Args[cm.arg] = e
+		c.m.touch(u.pkg, u.file)
+	}
+}
+
+// excluded reports whether n is within the declaration of the new constant, or in a package it imports, which the
+// reference to it would create a cycle with.
+func (c *constReplacer) excluded(pkg *packages.Package, file *ast.File, n ast.Node, new *types.Const) bool {
+	if new.Pkg() != pkg.Types && importedPackage([]*packages.Package{{Types: new.Pkg()}}, pkg.PkgPath) != nil {
+		fmt.Fprintf(os.Stderr, "%s: can't refer to %s, whose package imports %s; replace it manually\n",
+			c.m.position(n), new.Name(), pkg.PkgPath)
+		return true
+	}
+	for _, d := range file.Decls {
+		if d.Pos() <= n.Pos() && n.End() <= d.End() {
+			return d.Pos() <= new.Pos() && new.Pos() < d.End()
+		}
+	}
+	return false
+}
+
+// ref returns a reference to the constant for insertion into file at pos, adding an import of its package if
+// need be. It's an error if the reference would refer to something else there.
+func (c *constReplacer) ref(pkg *packages.Package, file *ast.File, pos token.Pos, k *types.Const) (ast.Expr, error) {
+	scope := pkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		scope = pkg.Types.Scope()
+	}
+	if k.Pkg() == pkg.Types {
+		if _, obj := scope.LookupParent(k.Name(), pos); obj != k {
+			return nil, fmt.Errorf("%s refers to the %s declared at %s", k.Name(), obj.Name(), c.m.fSet.Position(obj.Pos()))
+		}
+		return &ast.Ident{NamePos: pos, Name: k.Name()}, nil
+	}
+	name := k.Pkg().Name()
+	for _, imp := range file.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path == k.Pkg().Path() && imp.Name != nil {
+			name = imp.Name.Name
+		}
+	}
+	if _, obj := scope.LookupParent(name, pos); obj != nil {
+		if pn, ok := obj.(*types.PkgName); !ok || pn.Imported() != k.Pkg() {
+			return nil, fmt.Errorf("%s refers to the %s declared at %s", name, obj.Name(), c.m.fSet.Position(obj.Pos()))
+		}
+	}
+	q := c.m.qualifier(pkg, file)(k.Pkg())
+	return &ast.SelectorExpr{X: &ast.Ident{NamePos: pos, Name: q}, Sel: &ast.Ident{NamePos: pos, Name: k.Name()}}, nil
+}
+
+// isLiteral reports whether e is a basic literal, negated or not.
+func isLiteral(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isLiteral(e.X)
+	case *ast.UnaryExpr:
+		_, ok := e.X.(*ast.BasicLit)
+		return ok && (e.Op == token.SUB || e.Op == token.ADD)
+	}
+	return false
+}
+
+// sameConst reports whether the constant values x and y are equal.
+func sameConst(x, y constant.Value) bool {
+	numeric := func(v constant.Value) bool {
+		k := v.Kind()
+		return k == constant.Int || k == constant.Float || k == constant.Complex
+	}
+	if x.Kind() != y.Kind() && !(numeric(x) && numeric(y)) {
+		return false
+	}
+	return constant.Compare(x, token.EQL, y)
+}
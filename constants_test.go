@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestConstants(t *testing.T) {
+	runCommandCases(t, "constants")
+}
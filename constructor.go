@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"strings"
+)
+
+const useConstructorUsage = `Usage: eg use-constructor -type path.T -func path.NewT -arg Field[=default]... [-w] <packages>...
+
+Replaces composite literals of the struct type T throughout the loaded packages
+with calls of its constructor, e.g. rewriting
+
+	srv := &server.Server{Addr: addr, Handler: h}
+
+as server.NewServer(addr, h, 30*time.Second), given
+
+	-arg Addr -arg Handler -arg 'Timeout=30*time.Second'
+
+Each -arg names the field of the literal whose value is passed as the
+constructor's next parameter, with the expression passed instead if the
+literal has no value for it. The constructor must return T or *T; literals
+of T become the dereferenced call if it returns *T. Literals with values for
+fields which aren't passed, or within the constructor itself, are left.
+`
+
+func useConstructorMain(args []string) error {
+	fs := flag.NewFlagSet("use-constructor", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, useConstructorUsage) }
+	typeFlag := fs.String("type", "", "the struct type, as path.T")
+	funcFlag := fs.String("func", "", "the constructor, as path.NewT")
+	var argFlags, imports arrayFlags
+	fs.Var(&argFlags, "arg", "the field passed as the next parameter, as Field or Field=default (repeatable)")
+	fs.Var(&imports, "import", "the import path of a package named in a default (repeatable)")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *typeFlag == "" || *funcFlag == "" {
+		return errors.New("-type and -func are required")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	obj, err := m.lookup(*typeFlag)
+	if err != nil {
+		return err
+	}
+	tn, ok := obj.(*types.TypeName)
+	if !ok {
+		return fmt.Errorf("%s isn't a type", *typeFlag)
+	}
+	st, ok := tn.Type().Underlying().(*types.Struct)
+	if !ok {
+		return fmt.Errorf("%s isn't a struct type", *typeFlag)
+	}
+	fn, err := m.lookupFunc(*funcFlag)
+	if err != nil {
+		return err
+	}
+
+	c := &constructorUse{m: m, typ: tn.Type(), st: st, fn: fn, imports: imports}
+	if err := c.checkSig(argFlags); err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, pkg := range m.pkgs {
+		for _, file := range pkg.Syntax {
+			c.joined = nil
+			var enclosing *types.Func
+			astutil.Apply(file, func(cur *astutil.Cursor) bool {
+				switch n := cur.Node().(type) {
+				case *ast.FuncDecl:
+					enclosing, _ = pkg.TypesInfo.Defs[n.Name].(*types.Func)
+				case *ast.UnaryExpr:
+					if lit, ok := n.X.(*ast.CompositeLit); ok && n.Op == token.AND && c.isLit(pkg.TypesInfo, lit) {
+						return c.replace(cur, pkg, file, lit, true, enclosing, &failed)
+					}
+				case *ast.CompositeLit:
+					if c.isLit(pkg.TypesInfo, n) {
+						// a literal whose type is elided within a slice or map of *T is its address
+						_, ptr := pkg.TypesInfo.TypeOf(n).(*types.Pointer)
+						return c.replace(cur, pkg, file, n, ptr, enclosing, &failed)
+					}
+				}
+				return true
+			}, func(cur *astutil.Cursor) bool {
+				if _, ok := cur.Node().(*ast.FuncDecl); ok {
+					enclosing = nil
+				}
+				return true
+			})
+			joinLines(m.fSet.File(file.Pos()), c.joined)
+		}
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some literals couldn't be rewritten")
+	}
+	return nil
+}
+
+// A constructorUse replaces the composite literals of a struct type with calls of its constructor.
+type constructorUse struct {
+	m       *migration
+	typ     types.Type
+	st      *types.Struct
+	fn      *types.Func
+	imports []string
+
+	fields   []string // the field passed as each parameter
+	defaults []string // the expression passed for each parameter if the literal has no value for its field
+	ptr      bool     // whether the constructor returns *T
+
+	joined []posRange // the literals in the file rewritten as calls on one line
+}
+
+// checkSig parses the -arg flags, and checks they match the parameters of the constructor.
+func (c *constructorUse) checkSig(argFlags []string) error {
+	sig := c.fn.Type().(*types.Signature)
+	if sig.Recv() != nil || sig.Variadic() {
+		return fmt.Errorf("%s must be a function taking a fixed number of parameters", c.fn.Name())
+	}
+	if sig.Results().Len() != 1 {
+		return fmt.Errorf("%s must return a single %s or pointer to one", c.fn.Name(), c.typ)
+	}
+	switch res := sig.Results().At(0).Type(); {
+	case types.Identical(res, c.typ):
+	case types.Identical(res, types.NewPointer(c.typ)):
+		c.ptr = true
+	default:
+		return fmt.Errorf("%s returns %s, not %s or a pointer to one", c.fn.Name(), res, c.typ)
+	}
+	if len(argFlags) != sig.Params().Len() {
+		return fmt.Errorf("%s takes %d parameters, but %d -arg flags were given", c.fn.Name(), sig.Params().Len(), len(argFlags))
+	}
+	for i, arg := range argFlags {
+		field, def := arg, ""
+		if eq := strings.Index(arg, "="); eq >= 0 {
+			field, def = strings.TrimSpace(arg[:eq]), strings.TrimSpace(arg[eq+1:])
+		}
+		f := c.field(field)
+		if f == nil {
+			return fmt.Errorf("%s has no field %s", c.typ, field)
+		}
+		if p := sig.Params().At(i); !types.AssignableTo(f.Type(), p.Type()) {
+			return fmt.Errorf("field %s is a %s, which can't be passed as parameter %s, a %s", field, f.Type(),
+				p.Name(), p.Type())
+		}
+		c.fields = append(c.fields, field)
+		c.defaults = append(c.defaults, def)
+	}
+	return nil
+}
+
+// field returns the struct's field named name, or nil if it has none.
+func (c *constructorUse) field(name string) *types.Var {
+	for i := 0; i < c.st.NumFields(); i++ {
+		if f := c.st.Field(i); f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// isLit reports whether lit is a literal of the struct type.
+func (c *constructorUse) isLit(info *types.Info, lit *ast.CompositeLit) bool {
+	t := info.TypeOf(lit)
+	if p, ok := t.(*types.Pointer); ok && lit.Type == nil {
+		t = p.Elem()
+	}
+	return t != nil && types.Identical(t, c.typ)
+}
+
+// replace replaces the literal lit at cur, or its address if addr is set, with a call of the constructor, unless
+// it's within the constructor itself, and reports whether to rewrite the literals within it.
+func (c *constructorUse) replace(cur *astutil.Cursor, pkg *packages.Package, file *ast.File, lit *ast.CompositeLit,
+	addr bool, enclosing *types.Func, failed *bool) bool {
+	if enclosing == c.fn {
+		return true
+	}
+	var call *ast.CallExpr
+	err := fmt.Errorf("%s returns a %s, whose address can't be taken", c.fn.Name(), c.typ)
+	if !addr || c.ptr {
+		call, err = c.call(pkg, file, lit)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: can't rewrite this literal: %v\n", c.m.position(lit), err)
+		*failed = true
+		return false
+	}
+	var e ast.Expr = call
+	if c.ptr && !addr {
+		e = &ast.StarExpr{Star: call.Pos(), X: call}
+	}
+	cur.Replace(e)
+	c.m.touch(pkg, file)
+	return true
+}
+
+// call returns the call of the constructor equivalent to lit.
+func (c *constructorUse) call(pkg *packages.Package, file *ast.File, lit *ast.CompositeLit) (*ast.CallExpr, error) {
+	values := make(map[string]ast.Expr)
+	var order []string // the fields with values, in the literal's order
+	for i, elt := range lit.Elts {
+		name := c.st.Field(i).Name()
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			name, elt = kv.Key.(*ast.Ident).Name, kv.Value
+		}
+		values[name] = elt
+		order = append(order, name)
+	}
+	passed := make(map[string]int)
+	for i, field := range c.fields {
+		passed[field] = i
+	}
+	for _, name := range order {
+		if _, ok := passed[name]; !ok {
+			return nil, fmt.Errorf("it sets %s, which %s doesn't take", name, c.fn.Name())
+		}
+	}
+
+	// values which may have side effects must be evaluated in the same order
+	last := -1
+	for _, name := range order {
+		if sideEffectFree(pkg.TypesInfo, values[name]) {
+			continue
+		}
+		if passed[name] < last {
+			return nil, fmt.Errorf("the value of %s may have side effects, and would be evaluated out of order", name)
+		}
+		last = passed[name]
+	}
+
+	var args []ast.Expr
+	inOrder := true
+	for i, field := range c.fields {
+		if v, ok := values[field]; ok {
+			inOrder = inOrder && (len(args) == 0 || args[len(args)-1].Pos() < v.Pos())
+			args = append(args, v)
+			continue
+		}
+		if c.defaults[i] == "" {
+			return nil, fmt.Errorf("it has no value for %s, and -arg gives no default", field)
+		}
+		def, err := c.m.parseExpr(pkg, file, lit.Rbrace, c.defaults[i], c.imports)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, def)
+	}
+
+	var fun ast.Expr = &ast.Ident{NamePos: lit.Pos(), Name: c.fn.Name()}
+	if q := c.m.qualifier(pkg, file)(c.fn.Pkg()); q != "" {
+		fun = &ast.SelectorExpr{X: &ast.Ident{NamePos: lit.Pos(), Name: q}, Sel: fun.(*ast.Ident)}
+	}
+	call := &ast.CallExpr{Fun: fun, Lparen: lit.Lbrace, Args: args, Rparen: lit.Rbrace}
+	if !inOrder {
+		// the literal's line breaks no longer fit the values, so the call goes on one line
+		setPos(call, lit.Pos())
+		c.joined = append(c.joined, posRange{lit.Pos(), lit.End()})
+	}
+	return call, nil
+}
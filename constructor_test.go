@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestUseConstructor(t *testing.T) {
+	runCommandCases(t, "use-constructor")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+)
+
+// bindingCoverage counts the types and kinds of expression each wildcard of a template bound to in the matches
+// rewritten, for -binding-report, so that its author can spot the usages the template didn't anticipate.
+type bindingCoverage struct {
+	matches int
+	names   []string // the wildcards, in the order first bound
+	byName  map[string]*wildcardCoverage
+}
+
+// wildcardCoverage counts the bindings of a wildcard by type and by kind of expression, such as *ast.CallExpr.
+type wildcardCoverage struct {
+	types, kinds bindingCounts
+}
+
+// bindingCounts counts the bindings of a wildcard by a property, with the position of the first of each.
+type bindingCounts struct {
+	order  []string
+	counts map[string]int
+	sample map[string]string
+}
+
+// add records the binding at pos with the property key.
+func (c *bindingCounts) add(fSet *token.FileSet, pos token.Pos, key string) {
+	if c.counts == nil {
+		c.counts, c.sample = make(map[string]int), make(map[string]string)
+	}
+	if _, ok := c.counts[key]; !ok {
+		c.order = append(c.order, key)
+		c.sample[key] = reportPos(fSet, pos)
+	}
+	c.counts[key]++
+}
+
+// add records the bindings of a match, as of info.
+func (c *bindingCoverage) add(fSet *token.FileSet, info *types.Info, bindings []eg.Binding) {
+	if c.byName == nil {
+		c.byName = make(map[string]*wildcardCoverage)
+	}
+	c.matches++
+	for _, b := range bindings {
+		w, ok := c.byName[b.Name]
+		if !ok {
+			w = &wildcardCoverage{}
+			c.byName[b.Name] = w
+			c.names = append(c.names, b.Name)
+		}
+		typ := "unknown, since it was matched by syntax only"
+		if info != nil {
+			if t := info.TypeOf(b.Expr); t != nil {
+				typ = types.TypeString(t, nil)
+			}
+		}
+		w.types.add(fSet, b.Expr.Pos(), typ)
+		w.kinds.add(fSet, b.Expr.Pos(), fmt.Sprintf("%T", b.Expr))
+	}
+}
+
+// report prints the types and kinds each wildcard of the template at tmplPath bound to, most frequent first.
+func (c *bindingCoverage) report(tmplPath string) {
+	if c.matches == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: the wildcards bound, in %d matches:\n", templateLabel(tmplPath), c.matches)
+	for _, name := range c.names {
+		w := c.byName[name]
+		for _, by := range []struct {
+			what   string
+			counts *bindingCounts
+		}{{"type", &w.types}, {"kind of expression", &w.kinds}} {
+			fmt.Fprintf(os.Stderr, "\t%s, by %s:\n", name, by.what)
+			keys := append([]string(nil), by.counts.order...)
+			sort.SliceStable(keys, func(i, j int) bool { return by.counts.counts[keys[i]] > by.counts.counts[keys[j]] })
+			for _, key := range keys {
+				fmt.Fprintf(os.Stderr, "\t\t%d: %s, e.g. at %s\n", by.counts.counts[key], key, by.counts.sample[key])
+			}
+		}
+	}
+}
@@ -4,34 +4,83 @@
 package main // import "golang.org/x/tools/cmd/eg"
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/jwilner/eg/egrun"
+	"github.com/jwilner/eg/internal/eg"
 	"go/ast"
 	"go/build"
-	"go/format"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/buildutil"
 	"golang.org/x/tools/go/packages"
-	"golang.org/x/tools/refactor/eg"
+	"io"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
-	helpFlag       = flag.Bool("help", false, "show detailed help message")
-	templateFlag   = flag.String("t", "", "template.go file specifying the refactoring")
-	writeFlag      = flag.Bool("w", false, "rewrite input files in place (by default, the results are printed to standard output)")
-	verboseFlag    = flag.Bool("v", false, "show verbose matcher diagnostics")
+	helpFlag        = flag.Bool("help", false, "show detailed help message")
+	exprFlag        = flag.String("e", "", "an inline template, of the form '[params:] before -> after'")
+	ruleguardFlag   = flag.String("ruleguard", "", "a ruleguard rules file, whose rules with a Suggest are applied as a config's rules would be")
+	ruleFlag        = flag.String("r", "", "a rewrite rule, as for gofmt -r, of the form 'pattern -> replacement', matched by syntax alone")
+	writeFlag       = flag.Bool("w", false, "rewrite input files in place (by default, the results are printed to standard output)")
+	verboseFlag     = new(verbosity)
+	vscopeFlag      = flag.String("vscope", "", "limit the matcher diagnostics of -v to a file, directory or package")
+	interactiveFlag = flag.Bool("interactive", false, "prompt for whether to rewrite each match, writing those accepted in place")
+	promptFlag      = flag.Bool("i", false, "prompt for whether to rewrite each match, as -interactive, writing those accepted in place only with -w")
+	messageFlag     = flag.String("emit-message", "", "a file to write a description of the changes to, for a commit message or PR body")
+	annotateFlag    = flag.String("annotate", "", "a marker, e.g. MIGRATED(T123), to append as a comment to each line a rewrite changes")
+	sourceMapFlag   = flag.String("sourcemap", "", "a file to write the byte ranges of each file's edits to, as JSON, to remap positions held from before the run")
+	metricsFlag     = flag.String("metrics", "", "a file to write the run's counters to, as JSON")
+	matrixFlag      = flag.String("matrix", "", "a file to write the matches of each template in each package to, as CSV if it ends in .csv, or else JSON")
+	metricsPushFlag = flag.String("metrics-push", "", "the URL of a Prometheus pushgateway group to push the run's counters to")
+	traceFlag       = flag.String("trace-pos", "", "file.go:line[:column] of the expression, and those enclosing it, to trace every matcher decision for")
+	bumpFlag        = flag.Bool("bump-requires", false, "update the module's go.mod to the versions of modules a template's eg:require directives declare")
+	strictFlag      = flag.Bool("strict-types", false, "refuse rewrites whose replacement changes the type of the expression at all")
+	jsonFlag        = flag.Bool("json", false, "print a report of each match rewritten, and of the files, as JSON, rather than the rewritten files and the progress")
+	diffFlag        = flag.Bool("d", false, "print a unified diff of each file a rewrite changes, as -format patch, and exit 1 if there are any")
+	formatFlag      = flag.String("format", "", "output format when not rewriting in place: source (the default), list or patch")
+	splitByFlag     = flag.String("split-by", "", "with -format patch, write a patch for each pkg, template or dir, to -patch-dir")
+	patchDirFlag    = flag.String("patch-dir", "patches", "the directory -split-by writes the patches and their manifest.json to")
+	tolerateFlag    = flag.Bool("tolerate-errors", false, "skip the packages and files with errors, rather than failing")
+	onMatchFlag     = flag.String("onmatch", "", "a command to exec for each match, which refuses the rewrite by failing")
+	policyFlag      = flag.String("hook-policy", "", "what a failing beforeedit hook does: warn (the default), skip-file or abort")
+	lockWaitFlag    = flag.Duration("lock-wait", 0, "how long to wait for another run writing the module to finish, rather than failing")
+	shellFlag       = flag.Bool("hook-shell", false, "run the edit hooks with the platform's shell: sh -c, or cmd /c on Windows")
+	platformFlag    = flag.String("platforms", "", "comma-separated GOOS/GOARCH pairs to load and rewrite the packages for in turn")
+	maxMatchesFlag  = flag.Int("max-matches", 0, "the most matches to rewrite in the run, leaving the rest, which are reported, for later runs")
+	maxPerFileFlag  = flag.Int("max-per-file", 0, "the most matches to rewrite in each file, leaving the rest, which are reported, for later runs")
+	allowFlag       = flag.Bool("allow-forbidden", false, "rewrite the matches in the contexts, and the frozen files, the config forbids rewrites in")
+	bindingFlag     = flag.Bool("binding-report", false, "report the types and kinds of expression each wildcard of a template bound to in its matches")
+	sampleFlag      = flag.String("sample", "", "rewrite only a deterministic sample of the files matched, e.g. 5% or 20files, listing the rest")
+	vendorFlag      = flag.Bool("vendor", false, "rewrite the files of vendor directories, which are otherwise skipped")
+	generatedFlag   = flag.Bool("skip-generated", false, "skip the generated files, those with a \"// Code generated ... DO NOT EDIT.\" comment")
+	parallelFlag    = flag.Int("parallel", runtime.GOMAXPROCS(0), "the number of files to transform at once; by default, GOMAXPROCS")
 
+	templateFlags   arrayFlags
+	excludeFlags    arrayFlags
+	includeFlags    arrayFlags
 	beforeEditFlags arrayFlags
 	afterEditFlags  arrayFlags
+	beforeRunFlags  arrayFlags
+	afterRunFlags   arrayFlags
 )
 
 func init() {
 	flag.Var((*buildutil.TagsFlag)(&build.Default.BuildTags), "tags", buildutil.TagsFlagDoc)
+	flag.Var(&templateFlags, "t", "template.go file specifying the refactoring, or a directory of them, or - to read it from standard input; repeatable")
+	flag.Var(&excludeFlags, "exclude", "a glob of files not to rewrite, as for the config's exclude; repeatable")
+	flag.Var(&includeFlags, "include", "a glob of the only files to rewrite, e.g. '**/*_test.go'; repeatable")
+	flag.Var(verboseFlag, "v", "show verbose matcher diagnostics: -v=1, or -v, the matches, 2 also the candidates rejected, 3 also the bindings")
 	flag.Var(
 		&beforeEditFlags,
 		"beforeedit",
@@ -44,67 +93,304 @@ func init() {
 		"A command to exec after each file is edited (e.g sed).  Whitespace delimits argument words.  The string "+
 			"'{}' is replaced by the file name.",
 	)
+	flag.Var(&beforeRunFlags, "beforerun", "A command to exec once, before the first file is edited.")
+	flag.Var(
+		&afterRunFlags,
+		"afterrun",
+		"A command to exec once, after the run, if any file was edited.  The string '{files}' is replaced by the "+
+			"name of a file listing them.",
+	)
 }
 
 const usage = `eg: an example-based refactoring tool.
 
 Usage: eg -t template.go [-w] <args>...
+       eg -e '[params:] before -> after' [-w] <args>...
+       eg config show [path]
+       eg add-param -func path.Func -name name -type type -default expr <args>...
+       eg remove-param -func path.Func (-name name | -at index) <args>...
+       eg plumb-ctx -entry path.Func... <args>...
+       eg add-error -func path.Func [-callers propagate|discard] <args>...
+       eg rename path.Name newpath.NewName <args>...
+       eg rewrite-import old.example.com/mod new.example.com/mod
+       eg to-method -func path.F <args>...
+       eg to-func -func path.T.M <args>...
+       eg wrap -t wrap.go <args>...
+       eg use-constructor -type path.T -func path.NewT -arg Field... <args>...
+       eg errors <args>...
+       eg loops <args>...
+       eg instrument -t instrument.go -func path.F <args>...
+       eg keyed [-type path.T]... <args>...
+       eg builder <args>...
+       eg constants -table table.txt <args>...
+       eg accessor -var path.V -get GetV [-set SetV] <args>...
+       eg generic -func path.F [-param name]... <args>...
+       eg add-method -t addmethod.go <args>...
+       eg register -t register.go [-import path]... <args>...
+       eg explain -t template.go
+       eg export-rf -t template.go
+       eg import-semgrep [-o .eg.yaml] rules.yaml
+       eg import-comby [-o .eg.yaml] comby.toml
+       eg why -t template.go file.go:line[:column]
+       eg compare -t old.go -t2 new.go <args>...
+       eg strip-annotations -marker marker <args>...
+       eg review -t template.go [-addr host:port] <args>...
+       eg test [-update] <templates>...
+       eg fuzz -t template.go [-n programs]
 
 -help            show detailed help message
--t template_file specifies the template file (use -help to see explanation)
+-t template_file specifies the template file (use -help to see explanation),
+                 or - to read it from standard input. It may be repeated, or
+                 name a directory of templates, to apply several, in order, to
+                 each file over one load of the packages; since a file isn't
+                 type checked again between them, a template doesn't match the
+                 expressions an earlier one rewrote.
+-e template      an inline template, "[params:] before -> after", where the
+                 params declare the wildcards as a function's would, e.g.
+                 -e 's, sub string: strings.Index(s, sub) != -1 -> strings.Contains(s, sub)';
+                 the packages it refers to are imported as goimports would.
+-r rule          a rewrite rule, as for gofmt -r, "pattern -> replacement", e.g.
+                 -r 'a[b:len(a)] -> a[b:]', rather than a template, where each
+                 identifier of a single lowercase letter is a wildcard matching
+                 any expression. It's matched by syntax alone, without types.
+-ruleguard file  apply the rules of a ruleguard (go-critic) rules file with a
+                 Suggest, as a config's rules: as templates, if Where requires
+                 the types of their metavariables with Type.Is, or else as
+                 rewrite rules. Those which can't be translated are reported.
 -w          	 causes files to be re-written in place.
--v               show verbose matcher diagnostics
+-v               show verbose matcher diagnostics, at levels: -v=1, or -v, each
+                 match, -v=2 also why each candidate resembling the pattern
+                 doesn't match, and -v=3 also the wildcard bindings tried.
+-vscope s        limit the matcher diagnostics of -v to the file, directory or
+                 package (by import path) s.
+-interactive     for each match, show the diff of its rewrite and prompt, as git
+                 add -p does, whether to write it: y, n, a (this and all the
+                 later ones), q (none of the later ones), or e (edit the
+                 replacement). Those accepted are written in place.
+-i               prompt for each match as -interactive does, but write the rewrites
+                 accepted in place only with -w, and otherwise print them in the
+                 output format, e.g. -i -d for a diff of those accepted.
+-emit-message f  write a description of the changes to the file f, e.g. for the
+                 commit message or pull request of a migration bot: each
+                 template which matched, with its rationale, the package doc
+                 comment of the template, or of its before function, or the doc
+                 of a config's rule, and its files and matches.
+-annotate m      append the comment "// m" to each line a rewrite changes, e.g.
+                 -annotate 'MIGRATED(T123)', for a temporary audit of the
+                 migration; eg strip-annotations -marker m removes them.
+-sourcemap f     write the edits of each file rewritten to the file f, as JSON:
+                 the byte ranges of its source as it was, old_start to old_end,
+                 each replaced by one of the rewrite, new_start to new_end, so
+                 that positions held from before the run, e.g. by coverage data
+                 or lint baselines, can be remapped. Those outside any edit are
+                 moved by the edits preceding them.
+-metrics file    write the run's counters to file, as JSON: for each template,
+                 the packages and files visited, the files rewritten, the
+                 matches, the files which failed, and how long it took.
+-metrics-push u  push the same counters to the Prometheus pushgateway group at
+                 the URL u, e.g. http://pushgateway:9091/metrics/job/eg.
+-matrix file     write the matches of each template in each package to file, as
+                 CSV, a row for each package and a column for each template, if
+                 its name ends in .csv, or else as JSON, by template and then
+                 package, to see where the old patterns remain.
+-trace-pos loc   print every decision of the matcher for the expression at loc,
+                 file.go:line:column, and those enclosing it, or, given only
+                 file.go:line, for those beginning on the line.
+-format fmt      the output format without -w: "source" prints each rewritten file,
+                 "list" only its name, and "patch" a patch of its changes, for
+                 patch -p1 or git apply in the working directory.
+-json            print a report of the run to standard output, as JSON, rather than
+                 the rewritten files, which -w still writes: each match, with its
+                 file, package and template, the line and column it starts and
+                 ends at, and its source and replacement, then the files, with
+                 their matches, those which couldn't be rewritten, with why,
+                 and the total. The progress lines on stderr, such as "===
+                 file", are left out; warnings are not.
+-d               print a unified diff of each file rewritten, as -format patch, and
+                 exit with status 1 if there are any, e.g. for a CI check that a
+                 migration is complete.
+-split-by unit   with -format patch, write a patch for each unit, pkg, template
+                 or dir, to -patch-dir (by default, patches), numbered, with a
+                 manifest.json listing each patch's unit, files and matches.
+-bump-requires   where a template declares the version of a module its replacement
+                 needs, with "// eg:require golang.org/x/sync v0.3.0", and the
+                 module rewritten requires an older one, update its go.mod and
+                 go.sum to it, along with the rewritten files, rather than
+                 failing.
+-strict-types    refuse rewrites which change the type of the rewritten expression,
+                 rather than only those whose new type isn't assignable to the old.
+-exclude glob    don't rewrite the files matching glob, a path relative to the
+                 module root, or outside any module the working directory, or
+                 of a leading directory, as for the exclude of a .eg.yaml: one
+                 without a slash matches any element of the path, e.g. -exclude
+                 '*.pb.go', and "**" any number of them. Repeatable.
+-include glob    rewrite only the files matching any -include, e.g. -include
+                 'svc/api/**'. Repeatable. One naming test files, such as
+                 '**/*_test.go', loads the packages' tests, which are otherwise
+                 left.
+-skip-generated  skip the generated files, those with a "// Code generated ...
+                 DO NOT EDIT." comment before their package clause, e.g. *.pb.go.
+-vendor          rewrite the files of vendor directories, which are skipped.
+-tolerate-errors skip the packages, or files, with errors, and rewrite the rest,
+                 rather than failing; the skipped ones are reported. Files
+                 with only type errors are still matched by their syntax if
+                 the template needs no type information.
 -beforeedit cmd  a command to exec before each file is modified.
                  "{}" represents the name of the file.
 -afteredit  cmd  a command to exec after each file is edited (e.g sed).
-                 "{}" represents the name of the file.
+                 "{}" represents the name of the file. Hooks may also refer
+                 to {file}, the same, {pkg}, the file's package, {template}
+                 and {matches}, the template applied and its matches, and
+                 {tmpdir}, a directory the hooks of the run share. The values
+                 are in their environment too, as EG_FILE, EG_PACKAGE,
+                 EG_TEMPLATE and EG_MATCH_COUNT, with EG_RUN_ID.
+-beforerun  cmd  a command to exec once, before the first file is edited, e.g. to
+                 open a changelist.
+-afterrun   cmd  a command to exec once, at the end of a run which edited files,
+                 e.g. to build once. "{files}" represents the name of a file
+                 listing them, one per line, also in $EG_FILES.
+-onmatch    cmd  a command to exec for each match, before it's rewritten, which
+                 refuses the rewrite by failing. It may refer to the edit hooks'
+                 placeholders, and {line}, and has their environment, with
+                 EG_LINE, EG_COLUMN, EG_BEFORE and EG_AFTER; the match is on its
+                 standard input as JSON, with the source and type each of the
+                 template's wildcards is bound to.
+-hook-policy p   what a failing -beforeedit hook does: "warn" and edit the file
+                 anyway (the default), "skip-file", leaving it as it was and
+                 failing at the end, or "abort" the run.
+-lock-wait d     how long to wait for another eg run writing the module in place,
+                 which holds its .eg/lock, to finish, rather than failing.
+-hook-shell      run the edit hooks with the platform's shell (sh -c, or cmd /c
+                 on Windows), with "{}" replaced by the quoted file name,
+                 rather than as a command with quotable arguments.
+-platforms list  comma-separated GOOS/GOARCH pairs, e.g. linux/amd64,windows/amd64,
+                 to load and rewrite the packages for in turn, so the files built
+                 only for some platforms are rewritten too.
+-max-matches n   rewrite at most n matches in the run, and -max-per-file n at most
+-max-per-file n  n in each file, leaving the rest, which are reported by file, for
+                 later runs, so that a large migration lands in increments.
+-allow-forbidden rewrite the matches which the config's forbid and frozen settings
+                 refuse, which are otherwise reported: those in init functions,
+                 //go:nosplit functions or functions using unsafe, or in frozen
+                 files, e.g. those of a vendored or a released code path.
+-binding-report  after each template, report the types, and the kinds of expression,
+                 e.g. *ast.CallExpr, each of its wildcards bound to in its matches,
+                 with their counts and an example of each, to reveal the usages
+                 the template didn't anticipate.
+-sample s        rewrite only a sample of the files matched, s, a percentage of
+                 them, e.g. 5%, or a number, e.g. 20files, chosen by the hashes
+                 of their paths, so the same tree always yields the same sample,
+                 and list the rest, e.g. for a canary before the full rollout.
+-parallel n      transform n files at once (by default, GOMAXPROCS), reporting
+                 them, and writing them, in order, as one at a time would. It's
+                 1 with -interactive, -onmatch, -max-matches, -max-per-file, -v
+                 and -trace-pos, which act on each match in turn.
+
+Defaults for -t (as "templates", files or directories of them, or "rules",
+templates written in the file itself), -format and the edit hooks, and globs
+of files to exclude, may be set in a .eg.yaml file at the root of the module.
+Nested .eg.yaml files adjust the settings for their subtrees, e.g. excluding
+more files or disabling templates; "eg config show" prints the effective
+config of a directory. The environment variables EG_TEMPLATES, EG_EXCLUDE and
+EG_DISABLE (lists, separated as in $PATH), EG_FORMAT, and EG_BEFOREEDIT,
+EG_AFTEREDIT, EG_BEFORERUN and EG_AFTERRUN (commands, one per line) set
+defaults beneath the config files, which override them, as the flags override
+both.
+
+A template may declare the minimum Go version its replacement needs with a
+"// eg:go 1.13" comment; files in modules whose go directive is older are
+skipped, unless their //go:build constraint requires the version, as are
+those whose constraint builds them only with older versions. It may declare
+the minimum version of a module its replacement needs with "// eg:require
+golang.org/x/sync v0.3.0", which -bump-requires updates the module to.
+
+Run in a GOPATH workspace, outside any module, eg loads the packages in GOPATH
+mode, as with GO111MODULE=off, unless GO111MODULE is set.
+
+Directory arguments in several modules, e.g. ./svc-a/... ./svc-b/..., are
+loaded and rewritten one module at a time, each with its own .eg.yaml.
 `
 
 func main() {
-	if err := doMain(); err != nil {
+	err := doMain()
+	writeMetrics(err)
+	endRun()
+	if err == nil && *diffFlag && diffedFiles > 0 {
+		err = fmt.Errorf("%d files would be rewritten", diffedFiles)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "eg: %s\n", err)
 		os.Exit(1)
 	}
 }
 
+// subcommands maps the name of each subcommand to its entrypoint, which receives the arguments
+// following the name.
+var subcommands = map[string]func(args []string) error{
+	"accessor":          accessorMain,
+	"add-error":         addErrorMain,
+	"add-method":        addMethodMain,
+	"add-param":         addParamMain,
+	"builder":           builderMain,
+	"compare":           compareMain,
+	"config":            configMain,
+	"constants":         constantsMain,
+	"errors":            errorsMain,
+	"explain":           explainMain,
+	"export-rf":         exportRFMain,
+	"import-comby":      importCombyMain,
+	"import-semgrep":    importSemgrepMain,
+	"fuzz":              fuzzMain,
+	"generic":           genericMain,
+	"instrument":        instrumentMain,
+	"keyed":             keyedMain,
+	"loops":             loopsMain,
+	"plumb-ctx":         plumbCtxMain,
+	"register":          registerMain,
+	"remove-param":      removeParamMain,
+	"rename":            renameMain,
+	"review":            reviewMain,
+	"rewrite-import":    rewriteImportMain,
+	"strip-annotations": stripAnnotationsMain,
+	"test":              testMain,
+	"to-func":           toFuncMain,
+	"to-method":         toMethodMain,
+	"use-constructor":   useConstructorMain,
+	"why":               whyMain,
+	"wrap":              wrapMain,
+}
+
 // finds the transformer and removes the template package from pkgs
 func buildTransformer(tmplPath string, fSet *token.FileSet, pkgs *[]*packages.Package) (*eg.Transformer, error) {
-	// find the template package in the processed packages according to the absolute file path
-	var tmplPkg *packages.Package
-	for i := 0; tmplPkg == nil && i < len(*pkgs); i++ {
-		pkg := (*pkgs)[i]
-		for _, f := range pkg.GoFiles {
-			if f == tmplPath {
-				tmplPkg = pkg
-				*pkgs = append((*pkgs)[:i], (*pkgs)[i+1:]...)
-				break
-			}
-		}
+	xform, err := egrun.BuildTransformer(fSet, tmplPath, pkgs)
+	if _, ok := err.(*egrun.NotFoundError); ok {
+		return nil, templateNotFound(tmplPath)
 	}
-	if tmplPkg == nil {
-		return nil, errors.New("didn't find template in module path")
+	if err != nil {
+		return nil, err
 	}
+	xform.StrictTypes = *strictFlag
+	xform.Verbosity = int(*verboseFlag)
+	return xform, nil
+}
 
-	var tmplFile *ast.File
-	for _, f := range tmplPkg.Syntax {
-		if tmplPath == fSet.File(f.Pos()).Name() {
-			tmplFile = f
-			break
+func doMain() error {
+	if wd, err := os.Getwd(); err == nil {
+		if err := useGOPATH(wd); err != nil {
+			return err
 		}
 	}
-	if tmplFile == nil {
-		panic("didn't find template in template package somehow")
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			return cmd(os.Args[2:])
+		}
 	}
 
-	return eg.NewTransformer(fSet, tmplPkg.Types, tmplFile, tmplPkg.TypesInfo, *verboseFlag)
-}
-
-func doMain() error {
 	flag.Parse()
 	args := flag.Args()
 
 	if *helpFlag {
-		fmt.Fprint(os.Stderr, eg.Help)
+		io.WriteString(os.Stderr, eg.Help)
 		os.Exit(2)
 	}
 
@@ -113,92 +399,1008 @@ func doMain() error {
 		os.Exit(1)
 	}
 
-	if *templateFlag == "" {
-		return fmt.Errorf("no -t template.go file specified")
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	configs, err := newConfigs(wd)
+	if err != nil {
+		return err
+	}
+	conf, err := configs.forDir(wd)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(conf); err != nil {
+		return err
+	}
+	lock := lockRun
+	if *promptFlag {
+		*interactiveFlag = true
+	}
+	if *ruleFlag != "" && (len(templateFlags) > 0 || *exprFlag != "" || *ruleguardFlag != "" || *interactiveFlag) {
+		return errors.New("-r can't be used with -t, -e, -ruleguard or -interactive")
+	}
+	if *interactiveFlag {
+		if templateFlags.contains("-") {
+			return errors.New("-interactive reads the answers from standard input, so -t - can't read the template from it")
+		}
+		if *platformFlag != "" {
+			return errors.New("-interactive can't be used with -platforms")
+		}
+		if !*promptFlag {
+			lock = lockReview
+		}
+	}
+	if *splitByFlag != "" {
+		if !validSplit(*splitByFlag) {
+			return fmt.Errorf("invalid -split-by %q: want one of %s", *splitByFlag, strings.Join(splitUnits, ", "))
+		}
+		if *formatFlag != "patch" || *writeFlag || *interactiveFlag {
+			return errors.New("-split-by splits the output of -format patch, without -w or -interactive")
+		}
+	}
+	if err := parseSample(*sampleFlag); err != nil {
+		return err
+	}
+	if sample.enabled() && *interactiveFlag {
+		return errors.New("-sample can't be used with -interactive")
+	}
+	patchRoot = wd
+	if err := lock(wd); err != nil {
+		return err
+	}
+
+	platforms, err := parsePlatforms(*platformFlag)
+	if err != nil {
+		return err
+	}
+	groups := moduleGroups(wd, args)
+	if len(groups) > 1 && *interactiveFlag {
+		return errors.New("-interactive can't be used with packages of several modules")
+	}
+	// forGroups calls f with the arguments of each module in turn, loading from the module's root, with its configs
+	modConfigs := configs
+	forGroups := func(f func(args []string) error) error {
+		defer func() { moduleDir, modConfigs = "", configs }()
+		for _, g := range groups {
+			moduleDir, modConfigs = g.root, configs
+			if g.root != "" {
+				progressf("eg: rewriting the packages of module %s\n", g.root)
+				if modConfigs, err = newConfigs(g.root); err != nil {
+					return err
+				}
+			}
+			if err := f(g.args); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if *ruleFlag != "" {
+		r, err := parseRule(*ruleFlag)
+		if err != nil {
+			return err
+		}
+		err = forGroups(func(args []string) error {
+			done := make(map[string]bool)
+			for _, p := range platforms {
+				if err := applyRule(r, args, modConfigs, p, done); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return finishRun(err)
+	}
+
+	if *ruleguardFlag != "" {
+		if len(templateFlags) > 0 || *exprFlag != "" {
+			return errors.New("-ruleguard can't be used with -t or -e")
+		}
+		rules, err := loadRuleguard(*ruleguardFlag)
+		if err != nil {
+			return err
+		}
+		ruleguard := *conf
+		ruleguard.Templates, ruleguard.Rules = nil, rules
+		conf = &ruleguard
+	}
+	rewrites, err := configRewrites(conf)
+	if err != nil {
+		return err
 	}
-	tmplPath, err := filepath.Abs(*templateFlag)
+	tmplPaths, cleanup, err := resolveTemplates(conf)
 	if err != nil {
-		return fmt.Errorf("unable to resolve tmpl flag: %v", templateFlag)
+		return err
+	}
+	defer cleanup()
+	if *interactiveFlag {
+		if len(rewrites) > 0 {
+			return errors.New("-interactive can't review the rewrite rules of the config")
+		}
+		return reviewRun(tmplPaths, args, configs, promptDecide, !*promptFlag || *writeFlag)
+	}
+
+	err = forGroups(func(args []string) error {
+		// the templates given by -t are applied together, over one load of the packages
+		if len(templateFlags) > 0 && len(tmplPaths) > 1 {
+			done := make(map[string]bool)
+			for _, p := range platforms {
+				if err := applyTemplates(tmplPaths, args, modConfigs, p, done); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, tmplPath := range tmplPaths {
+			// a file is rewritten for the first platform it matches under, so those common to several are emitted once
+			done := make(map[string]bool)
+			for _, p := range platforms {
+				if err := applyTemplate(tmplPath, args, modConfigs, p, done); err != nil {
+					return err
+				}
+			}
+		}
+		for _, r := range rewrites {
+			done := make(map[string]bool)
+			for _, p := range platforms {
+				if err := applyRule(r, args, modConfigs, p, done); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return finishRun(err)
+}
+
+// configRewrites returns the rewrite rules of conf, which are applied unless a template is given.
+func configRewrites(conf *repoConfig) ([]*rewriteRule, error) {
+	if len(templateFlags) > 0 || *exprFlag != "" {
+		return nil, nil
+	}
+	var rules []*rewriteRule
+	for _, r := range conf.Rules {
+		if r.Rewrite == "" {
+			continue
+		}
+		rule, err := parseRule(r.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+		templateLabels[rule.text] = r.Name
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// errRewrites is the failure of a run some of whose files couldn't be rewritten, each of which is reported.
+var errRewrites = errors.New("some files couldn't be rewritten")
+
+// finishRun writes the outputs gathered over the run, once it ended with err: the patches of -split-by, the map of
+// -sourcemap, the message of -emit-message, and the report of -json, which lists the files which couldn't be
+// rewritten, if that's why it failed. It returns err, or the failure to write them.
+func finishRun(err error) error {
+	if err != nil && err != errRewrites {
+		return err
+	}
+	budget.report()
+	sample.report()
+	if *splitByFlag != "" {
+		if err := writePatches(*patchDirFlag); err != nil {
+			return err
+		}
+	}
+	if *sourceMapFlag != "" {
+		if err := writeSourceMap(*sourceMapFlag); err != nil {
+			return err
+		}
+	}
+	if *messageFlag != "" {
+		if err := writeMessage(*messageFlag); err != nil {
+			return err
+		}
+	}
+	if *jsonFlag {
+		if err := writeJSONReport(); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// resolveTemplates returns the paths of the templates to apply: those of -t, or of -e or -t -, written to a temporary
+// file, or else those of conf, with its rules written to temporary files, and a function removing the temporary
+// files.
+func resolveTemplates(conf *repoConfig) (tmplPaths []string, cleanup func(), err error) {
+	var cleanups []func()
+	removeAll := func() {
+		for _, f := range cleanups {
+			f()
+		}
+	}
+	defer func() {
+		if err != nil {
+			removeAll()
+		}
+	}()
+
+	switch {
+	case *exprFlag != "" || templateFlags.contains("-"):
+		if *exprFlag != "" && len(templateFlags) > 0 {
+			return nil, nil, fmt.Errorf("both -e and -t given")
+		}
+		if len(templateFlags) > 1 {
+			return nil, nil, fmt.Errorf("-t - reads the template from standard input, so it can't be given with others")
+		}
+		var src []byte
+		name := "stdin.go"
+		if *exprFlag != "" {
+			name = "inline.go"
+			src, err = inlineTemplate(*exprFlag)
+		} else {
+			src, err = stdinTemplate()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		tmplPath, remove, err := writeTempTemplate(name, src)
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanups = append(cleanups, remove)
+		templateLabels[tmplPath] = "-"
+		if *exprFlag != "" {
+			templateLabels[tmplPath] = *exprFlag
+		}
+		tmplPaths = append(tmplPaths, tmplPath)
+	case len(templateFlags) > 0:
+		// the templates are applied in the order given, and those of a directory in the order of their paths
+		for _, t := range templateFlags {
+			// a file not named .go is taken as a template, for its load to explain why it's not one
+			fi, err := os.Stat(t)
+			if os.IsNotExist(err) {
+				return nil, nil, templateNotFound(t)
+			}
+			if strings.HasSuffix(t, ".go") || err == nil && !fi.IsDir() {
+				tmplPath, err := filepath.Abs(t)
+				if err != nil {
+					return nil, nil, fmt.Errorf("unable to resolve tmpl flag: %v", t)
+				}
+				tmplPaths = append(tmplPaths, tmplPath)
+				continue
+			}
+			dir, err := filepath.Abs(t)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to resolve tmpl flag: %v", t)
+			}
+			found, err := egrun.FindTemplates("", []string{dir + string(filepath.Separator) + "..."})
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(found) == 0 {
+				return nil, nil, fmt.Errorf("no templates found in %s", t)
+			}
+			tmplPaths = append(tmplPaths, found...)
+		}
+	case len(conf.Templates) > 0 || len(conf.Rules) > 0 || len(conf.Scopes) > 0:
+		if tmplPaths, err = conf.templatePaths(); err != nil {
+			return nil, nil, err
+		}
+		if len(tmplPaths) == 0 && len(conf.Rules) == 0 {
+			return nil, nil, fmt.Errorf("no templates found in %s", strings.Join(conf.Templates, ", "))
+		}
+		// each rule is a package of its own, so they're written to separate directories
+		for _, r := range conf.Rules {
+			if r.Rewrite != "" {
+				continue // applied by configRewrites
+			}
+			src, err := r.source()
+			if err != nil {
+				return nil, nil, err
+			}
+			tmplPath, remove, err := writeTempTemplate(r.Name+".go", src)
+			if err != nil {
+				return nil, nil, err
+			}
+			cleanups = append(cleanups, remove)
+			templateLabels[tmplPath] = r.Name
+			tmplPaths = append(tmplPaths, tmplPath)
+		}
+	default:
+		return nil, nil, fmt.Errorf("no -t template.go file specified")
+	}
+	return tmplPaths, removeAll, nil
+}
+
+// applyConfig sets the output flags which weren't given from conf, and validates them.
+func applyConfig(conf *repoConfig) error {
+	if *jsonFlag && (*diffFlag || *formatFlag != "") {
+		return errors.New("-json prints a report rather than the rewritten files, so can't be used with -d or -format")
+	}
+	if *diffFlag {
+		if *writeFlag || *interactiveFlag {
+			return errors.New("-d prints the diffs of the rewrites rather than writing them, so can't be used with -w or -interactive")
+		}
+		if *formatFlag != "" && *formatFlag != "patch" {
+			return fmt.Errorf("-d prints patches, so can't be used with -format %s", *formatFlag)
+		}
+		*formatFlag = "patch"
+	}
+	if *formatFlag == "" {
+		*formatFlag = conf.Format
+	}
+	if *formatFlag == "" {
+		*formatFlag = outputFormats[0]
+	}
+	if !validFormat(*formatFlag) {
+		return fmt.Errorf("invalid -format %q: want one of %s", *formatFlag, strings.Join(outputFormats, ", "))
+	}
+	if len(beforeEditFlags) == 0 {
+		beforeEditFlags = conf.BeforeEdit
+	}
+	if len(afterEditFlags) == 0 {
+		afterEditFlags = conf.AfterEdit
+	}
+	if len(beforeRunFlags) == 0 {
+		beforeRunFlags = conf.BeforeRun
+	}
+	if len(afterRunFlags) == 0 {
+		afterRunFlags = conf.AfterRun
+	}
+	if !*shellFlag {
+		*shellFlag = conf.HookShell
+	}
+	if *policyFlag == "" {
+		*policyFlag = conf.HookPolicy
+	}
+	if *policyFlag == "" {
+		*policyFlag = hookPolicies[0]
+	}
+	if !validPolicy(*policyFlag) {
+		return fmt.Errorf("invalid -hook-policy %q: want one of %s", *policyFlag, strings.Join(hookPolicies, ", "))
 	}
+	return nil
+}
+
+// applyTemplate applies the template at tmplPath to the packages matched by args, as built for p, subject to the
+// effective config of each file's directory. The files in done, already rewritten, are left, and those rewritten
+// are added.
+func applyTemplate(tmplPath string, args []string, configs *configs, p platform, done map[string]bool) error {
+	return applyTemplates([]string{tmplPath}, args, configs, p, done)
+}
+
+// A templateRun is a template being applied by applyTemplates, with what it gathers over the packages.
+type templateRun struct {
+	tmplPath   string
+	xform      *eg.Transformer
+	m          *templateMetrics
+	minVersion string // the go version the template requires, if any
+	rejected   rejections
+	coverage   bindingCoverage
+}
+
+// A transformedFile is the state of a file being transformed by the templates of a run, the Data of its egrun.File,
+// with what's to be reported of it, which is, once it's done, in the order of the files, however many are
+// transformed at once.
+type transformedFile struct {
+	*egrun.File
+	conf       *repoConfig
+	inCgo      bool        // whether it's processed by cgo, so its matches aren't rewritten
+	cgoMatches []token.Pos // the matches of the template being applied, if inCgo
+	found      []jsonMatch // the matches rewritten, for -json
+	reports    []func()    // what's to be reported of it, in order
+	current    int         // the index of the template being applied
+}
+
+// later records report to be made once f is done.
+func (f *transformedFile) later(report func()) {
+	f.reports = append(f.reports, report)
+}
 
+// fileState returns the state of f, transformed by applyTemplates.
+func fileState(f *egrun.File) *transformedFile {
+	return f.Data.(*transformedFile)
+}
+
+// matchesInOrder reports whether the run acts on each match as it's found, e.g. prompting for it, running a hook,
+// or counting it against a budget, or prints the matcher's diagnostics, so that the files must be transformed one at
+// a time, in order, whatever -parallel.
+func matchesInOrder() bool {
+	return matchFilter != nil || matchRevise != nil || *onMatchFlag != "" || budget.limited() || *verboseFlag > 0 ||
+		*traceFlag != ""
+}
+
+// applyTemplates is applyTemplate for several templates, which are applied, in order, to each file of the packages,
+// as loaded once for them all, and which the file is then written with the rewrites of. Since a file's rewrites
+// aren't type checked again between the templates, a template doesn't match the expressions an earlier one rewrote.
+func applyTemplates(tmplPaths []string, args []string, configs *configs, p platform, done map[string]bool) error {
+	runs := make([]*templateRun, len(tmplPaths))
+	for i, tmplPath := range tmplPaths {
+		runs[i] = &templateRun{tmplPath: tmplPath, m: metrics.template(tmplPath)}
+	}
+	start := time.Now()
+	// the templates share the time taken, most of which is the load
+	defer func() {
+		for _, r := range runs {
+			r.m.DurationSeconds += time.Since(start).Seconds() / float64(len(runs))
+		}
+	}()
 	fSet := token.NewFileSet()
-	cfg := &packages.Config{
-		Mode: packages.NeedFiles |
-			packages.NeedImports |
-			packages.NeedTypes |
-			packages.NeedSyntax |
-			packages.NeedTypesInfo,
-		Fset: fSet,
+	cfg := &packages.Config{Mode: egrun.LoadMode, Fset: fSet, Env: p.env(), Dir: moduleDir, Overlay: reviewOverlay}
+	var err error
+	if cfg.Tests, err = configs.tests(); err != nil {
+		return err
 	}
 
-	pkgs, err := packages.Load(cfg, append([]string{"file=" + tmplPath}, flag.Args()...)...) // forward CLI args
+	var patterns []string
+	for _, tmplPath := range tmplPaths {
+		patterns = append(patterns, "file="+tmplPath)
+	}
+	patterns = append(patterns, args...) // forward CLI args
+	pkgs, reqs, err := loadPackages(cfg, patterns...)
 	if err != nil {
 		return fmt.Errorf("load: %v\n", err)
 	}
-	if packages.PrintErrors(pkgs) > 0 {
-		return errors.New("error loading packages")
+	defer reqs.remove()
+	if cfg.Tests {
+		pkgs = dropTestDuplicates(pkgs)
+	}
+	pkgs, skipped, err := dropBroken(fSet, pkgs, patterns)
+	if err != nil {
+		return err
 	}
 
-	xform, err := buildTransformer(tmplPath, fSet, &pkgs)
+	for _, r := range runs {
+		for _, pkg := range pkgs {
+			if tmplFile := findFile(fSet, pkg, r.tmplPath); tmplFile != nil {
+				if r.minVersion, err = templateGoVersion(tmplFile); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, r := range runs {
+		if r.xform, err = buildTransformer(r.tmplPath, fSet, &pkgs); err != nil {
+			return err
+		}
+	}
 
-	fmt.Fprintf(os.Stderr, "visiting %v packages", len(pkgs))
+	pkgs = dependencyOrder(pkgs)
+	for _, r := range runs {
+		r.m.Packages += len(pkgs)
+	}
+	if p == (platform{}) {
+		progressf("visiting %v packages\n", len(pkgs))
+	} else {
+		progressf("visiting %v packages for %s\n", len(pkgs), p)
+	}
+	reportOrder(pkgs)
 
 	var hadErrors bool
-	for _, pkg := range pkgs {
-		for _, file := range pkg.Syntax {
-			n := xform.Transform(pkg.TypesInfo, pkg.Types, file)
-			if n == 0 {
-				continue
+	versions := make(moduleVersions)
+	// supported reports whether file is built only by the go version the template requires, minVersion, or later: by
+	// its build constraint, or else by the go directive of its module, and if not, why it's skipped
+	supported := func(file *ast.File, minVersion string) (bool, string, error) {
+		if minVersion == "" {
+			return true, "", nil
+		}
+		filename := fSet.File(file.Pos()).Name()
+		lo, hi := fileGoVersions(file)
+		if hi != "" && !goVersionLess(minVersion, hi) {
+			return false, fmt.Sprintf("skipping %s: its build constraint builds it only before go %s, but the template requires go %s\n",
+				filename, hi, minVersion), nil
+		}
+		if lo != "" && !goVersionLess(lo, minVersion) {
+			return true, "", nil
+		}
+		version, gomod, err := versions.lookup(filepath.Dir(filename))
+		if err != nil {
+			return false, "", err
+		}
+		if version != "" && goVersionLess(version, minVersion) {
+			return false, fmt.Sprintf("skipping %s: %s declares go %s, but the template requires go %s\n",
+				filename, gomod, version, minVersion), nil
+		}
+		return true, "", nil
+	}
+	var matched bool
+	var aborted error // the failure of a beforeedit hook under -hook-policy abort
+	trace, err := parseTracePos(*traceFlag)
+	if err != nil {
+		return err
+	}
+	pass := &egrun.Pass{Fset: fSet, Xforms: make([]*eg.Transformer, len(runs)), Workers: *parallelFlag}
+	for i, r := range runs {
+		pass.Xforms[i] = r.xform
+	}
+	if matchesInOrder() {
+		pass.Workers = 1
+	}
+	pass.OnMatch = func(ef *egrun.File, i int, xform *eg.Transformer, orig, repl ast.Node) bool {
+		f, r := fileState(ef), runs[i]
+		if f.inCgo {
+			f.cgoMatches = append(f.cgoMatches, orig.Pos())
+			return false
+		}
+		if reason := f.conf.forbidden(fSet, f.Syntax, orig.Pos()); reason != "" {
+			pos := orig.Pos()
+			f.later(func() { reportForbidden(fSet, pos, reason) })
+			return false
+		}
+		filename := fSet.File(orig.Pos()).Name()
+		if !budget.allows(filename) {
+			return false
+		}
+		if *onMatchFlag != "" {
+			pos := fSet.PositionFor(orig.Pos(), false)
+			var logical string
+			if adjusted := fSet.Position(orig.Pos()); adjusted != pos {
+				logical = adjusted.String()
+			}
+			if !runMatchHook(*onMatchFlag, matchReport{
+				File:     pos.Filename,
+				Line:     pos.Line,
+				Column:   pos.Column,
+				Logical:  logical,
+				Package:  f.Pkg.PkgPath,
+				Template: r.tmplPath,
+				Before:   nodeString(fSet, orig),
+				After:    nodeString(fSet, repl),
+				Bindings: bindingReports(fSet, f.Pkg.TypesInfo, xform.Bindings()),
+			}) {
+				return false
+			}
+		}
+		if matchFilter != nil && !matchFilter(fSet, r.tmplPath, orig, repl) {
+			return false
+		}
+		budget.spend(filename)
+		if *bindingFlag {
+			info, bindings := f.Pkg.TypesInfo, xform.Bindings()
+			f.later(func() { r.coverage.add(fSet, info, bindings) })
+		}
+		return true
+	}
+	if matchRevise != nil || *jsonFlag {
+		pass.Revise = func(ef *egrun.File, i int, orig, repl ast.Node) ast.Node {
+			f := fileState(ef)
+			if matchRevise != nil {
+				repl = matchRevise(fSet, orig, repl)
+			}
+			if *jsonFlag {
+				f.found = append(f.found, newJSONMatch(fSet, f.Pkg.PkgPath, runs[i].tmplPath, orig, repl))
 			}
-			filename := fSet.File(file.Pos()).Name()
-			fmt.Fprintf(os.Stderr, "=== %s (%d matches)\n", filename, n)
-			if *writeFlag {
-				// Run the before-edit command (e.g. "chmod +w",  "checkout") if any.
-				for _, f := range beforeEditFlags {
-					if err := runCmdOnFile(f, filename); err != nil {
-						fmt.Fprintf(os.Stderr, "Warning: before edit hook %q failed (%s)\n", f, err)
-					}
+			return repl
+		}
+	}
+	fileMatches := make(map[string][]int) // the matches of each template in each file rewritten
+	// emit writes the rewrite of f, returning the failure of its beforeedit hook if it aborts the run
+	emit := func(f matchedFile) error {
+		matched = true
+		var applied []string
+		for i, r := range runs {
+			if n := fileMatches[f.filename][i]; n > 0 {
+				r.m.addMatches(f.pkg.PkgPath, n)
+				r.m.addFile(f.filename)
+				applied = append(applied, r.tmplPath)
+			}
+		}
+		if f.syntactic {
+			progressf("=== %s (%d matches, by syntax only, since it doesn't type check)\n", f.filename, f.matches)
+		} else {
+			progressf("=== %s (%d matches)\n", f.filename, f.matches)
+		}
+		info := editInfo{pkg: f.pkg.PkgPath, template: strings.Join(applied, ", "), matches: f.matches, found: f.found,
+			gaps: f.gaps}
+		if err := emitFile(fSet, f.filename, f.file, info); err != nil {
+			if _, ok := err.(abortError); ok {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+			reportFailure(f.filename, err)
+			hadErrors = true
+			for i, r := range runs {
+				if fileMatches[f.filename][i] > 0 {
+					r.m.Failures++
 				}
-				if err := eg.WriteAST(fSet, filename, file); err != nil {
-					fmt.Fprintf(os.Stderr, "eg: %s\n", err)
-					hadErrors = true
+			}
+		}
+		return nil
+	}
+	var sampled []matchedFile // the files matched, for -sample to choose among
+	var inScope bool          // whether any file transformed is in -vscope, which -v transforms by one worker
+	// prepare returns file, of pkg, to be transformed by the templates which apply to it, by its syntax alone if it
+	// doesn't type check, or nil if none do
+	prepare := func(pkg *packages.Package, file *ast.File, syntactic bool) (*egrun.File, error) {
+		filename := fSet.File(file.Pos()).Name()
+		conf, err := configs.forDir(filepath.Dir(filename))
+		if err != nil {
+			return nil, err
+		}
+		if conf.excluded(filename) || done[filename] {
+			return nil, nil
+		}
+		ef := &egrun.File{Name: filename, Package: pkg.PkgPath, Pkg: pkg, Syntax: file, Syntactic: syntactic}
+		f := &transformedFile{File: ef, conf: conf, inCgo: cgoProcessed(pkg, filename)}
+		ef.Data = f
+		for i, r := range runs {
+			if conf.disabled(r.tmplPath) || !conf.inScope(r.tmplPath, filename) || syntactic && !r.xform.Syntactic() {
+				continue
+			}
+			ok, skip, err := supported(file, r.minVersion)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				f.later(func() { fmt.Fprint(os.Stderr, skip) })
+				continue
+			}
+			ef.Applied = append(ef.Applied, i)
+		}
+		return ef, nil
+	}
+	// the templates are applied with the worker's transformers, whose diagnostics of each file are reported once it's
+	// finished, in order
+	pass.Before = func(ef *egrun.File, i int, xform *eg.Transformer) {
+		f, r := fileState(ef), runs[i]
+		f.current = i
+		f.later(func() { r.m.Files++ })
+		f.cgoMatches = nil
+		xform.Verbosity = 0
+		if *verboseFlag > 0 && inVerboseScope(f.Pkg, f.Name) {
+			xform.Verbosity = int(*verboseFlag)
+			inScope = true
+		}
+		xform.Trace = nil
+		if trace.filename == f.Name {
+			xform.Trace = make(map[ast.Expr]bool)
+			for _, e := range exprsAt(fSet, f.Syntax, trace.line, trace.col) {
+				xform.Trace[e] = true
+			}
+		}
+	}
+	pass.After = func(ef *egrun.File, i int, xform *eg.Transformer) {
+		f, r := fileState(ef), runs[i]
+		changes, refusals, rejections := xform.TypeChanges(), xform.Refusals(), xform.Rejections()
+		cgoMatches := f.cgoMatches
+		f.later(func() {
+			if !f.Syntactic {
+				reportTypeChanges(fSet, changes)
+			}
+			reportRefusals(fSet, refusals)
+			r.rejected.addFile(fSet, rejections, changes, refusals)
+			for _, pos := range cgoMatches {
+				fmt.Fprintf(os.Stderr, "%s: matches, but isn't rewritten, since cgo processes the file; rewrite it by hand\n",
+					reportPos(fSet, pos))
+			}
+		})
+	}
+	// finish reports what transforming f found and writes it, if it matched, returning the failure of its beforeedit
+	// hook if it aborts the run
+	pass.Finish = func(ef *egrun.File) error {
+		f := fileState(ef)
+		if done[f.Name] {
+			return nil // as transformed, at the same time, for another package it's in
+		}
+		for _, report := range f.reports {
+			report()
+		}
+		if f.Err != nil {
+			// a panic rewriting the file, e.g. for a bug in the matcher, skips it rather than ending the run
+			err := f.Err
+			if pe, ok := err.(*egrun.PanicError); ok && *verboseFlag > 0 {
+				err = fmt.Errorf("%v\n%s", pe, pe.Stack)
+			}
+			fmt.Fprintf(os.Stderr, "eg: %s: skipped, since rewriting it failed: %v\n", f.Name, err)
+			reportFailure(f.Name, err)
+			hadErrors = true
+			runs[f.current].m.Failures++
+			return nil
+		}
+		if f.Matches == 0 {
+			return nil
+		}
+		done[f.Name] = true
+		fileMatches[f.Name] = f.Counts
+		mf := matchedFile{pkg: f.Pkg, filename: f.Name, file: f.Syntax, matches: f.Matches, syntactic: f.Syntactic,
+			found: f.found, gaps: f.Gaps}
+		if sample.enabled() {
+			sampled = append(sampled, mf) // written once the files matched are all known
+			return nil
+		}
+		aborted = emit(mf)
+		return aborted
+	}
+
+	err = pass.Run(func(add func(f *egrun.File) error) error {
+		visit := func(pkg *packages.Package, file *ast.File, syntactic bool) error {
+			f, err := prepare(pkg, file, syntactic)
+			if err != nil || f == nil {
+				return err
+			}
+			return add(f)
+		}
+		for _, pkg := range pkgs {
+			for _, file := range pkg.Syntax {
+				if err := visit(pkg, file, false); err != nil {
+					return err
 				}
-				for _, f := range afterEditFlags {
-					if err := runCmdOnFile(f, filename); err != nil {
-						fmt.Fprintf(os.Stderr, "Warning: before edit hook %q failed (%s)\n", f, err)
-					}
+			}
+		}
+
+		// the files skipped for type errors can be matched by syntax, by the templates which need no type
+		// information
+		var syntactic bool
+		for _, r := range runs {
+			syntactic = syntactic || r.xform.Syntactic()
+		}
+		var names []string
+		for name, f := range skipped {
+			if f.file != nil && syntactic {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f := skipped[name]
+			if err := visit(f.pkg, f.file, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *verboseFlag > 0 && *vscopeFlag != "" && !inScope {
+		fmt.Fprintf(os.Stderr, "eg: warning: -vscope %s names no file, directory or package transformed\n", *vscopeFlag)
+	}
+
+	for _, f := range sample.choose(sampled) {
+		if err := emit(f); err != nil {
+			return err
+		}
+	}
+
+	// the module only needs the templates' imports if they were applied
+	if reqs != nil && matched {
+		staged, err := reqs.staged()
+		if err != nil {
+			return err
+		}
+		for _, f := range staged {
+			if done[f.name] {
+				continue
+			}
+			done[f.name] = true
+			progressf("=== %s (requiring %s)\n", f.name, strings.Join(reqs.paths, ", "))
+			if err := emitSource(f.name, f.src, editInfo{template: strings.Join(tmplPaths, ", ")}); err != nil {
+				if _, ok := err.(abortError); ok {
+					return err
 				}
-			} else {
-				format.Node(os.Stdout, fSet, file)
+				fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+				reportFailure(f.name, err)
+				hadErrors = true
+				runs[0].m.Failures++
 			}
 		}
 	}
+
+	for _, r := range runs {
+		r.rejected.report(r.tmplPath)
+		r.coverage.report(r.tmplPath)
+	}
 	if hadErrors {
-		os.Exit(1)
+		reqs.remove()
+		return errRewrites
 	}
 	return nil
 }
 
-func runCmdOnFile(flag string, filename string) error {
-	args := strings.Fields(flag)
-	if len(args) == 0 {
+// emitFile writes the rewritten file in place, running the edit hooks, if -w was given, or otherwise prints it
+// in the output format.
+func emitFile(fSet *token.FileSet, filename string, file *ast.File, info editInfo) error {
+	var buf bytes.Buffer
+	if err := eg.Format(&buf, fSet, file, info.gaps); err != nil {
+		return err
+	}
+	return emitSource(filename, buf.Bytes(), info)
+}
+
+// emitSource is emitFile for the rewritten source of a file, which needn't be Go, e.g. a go.mod file.
+func emitSource(filename string, src []byte, info editInfo) error {
+	if emitCapture != nil {
+		emitCapture(filename, src, info)
+		return nil
+	}
+	if *annotateFlag != "" && strings.HasSuffix(filename, ".go") {
+		orig, err := ioutil.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if src, err = annotate(orig, src, *annotateFlag); err != nil {
+			return fmt.Errorf("%s: annotating: %v", filename, err)
+		}
+	}
+	if *sourceMapFlag != "" {
+		if err := recordSourceMap(filename, src); err != nil {
+			return err
+		}
+	}
+	if !*writeFlag {
+		if *jsonFlag {
+			reportFile(filename, info)
+			return nil
+		}
+		switch *formatFlag {
+		case "list":
+			fmt.Println(filename)
+			return nil
+		case "patch":
+			patch, err := egrun.Patch(patchRoot, filename, src)
+			if err != nil || patch == nil {
+				return err
+			}
+			diffedFiles++
+			if *splitByFlag != "" {
+				return addPatch(filename, patch, info)
+			}
+			_, err = os.Stdout.Write(patch)
+			return err
+		}
+		_, err := os.Stdout.Write(src)
+		return err
+	}
+
+	// Run the before-edit command (e.g. "chmod +w",  "checkout") if any.
+	before := func(f *egrun.File) error { return runBeforeHooks(f.Name, info) }
+	after := func(f *egrun.File) error {
+		editedFiles = append(editedFiles, f.Name)
+		if *jsonFlag {
+			reportFile(f.Name, info)
+		}
+		for _, hook := range afterEditFlags {
+			if err := runCmdOnFile(hook, f.Name, info); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: after edit hook %q failed (%s)\n", hook, err)
+			}
+		}
 		return nil
 	}
-	// Replace "{}" with the filename, like find(1).
-	for i := range args {
-		if i > 0 {
-			args[i] = strings.Replace(args[i], "{}", filename, -1)
+	f := &egrun.File{Name: filename, Package: info.pkg, Matches: info.matches, Src: src}
+	return egrun.WriteFile(f, []func(*egrun.File) error{before}, []func(*egrun.File) error{after}, os.Stderr)
+}
+
+// diffedFiles counts the files whose patches were printed, which -d exits 1 for.
+var diffedFiles int
+
+// cgoOutputs are the files generated by cgo among those loaded, by name.
+var cgoOutputs = make(map[string]bool)
+
+// cgoProcessed reports whether filename, of pkg, is the output of cgo, in the build cache, rather than one of the
+// package's files, noting it among the cgoOutputs if so.
+func cgoProcessed(pkg *packages.Package, filename string) bool {
+	for _, f := range pkg.GoFiles {
+		if f == filename {
+			return false
 		}
 	}
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%q failed: %v", args, err)
+	cgoOutputs[filename] = true
+	return true
+}
+
+// reportPos returns the position of pos in its file, followed by the position a //line directive maps it to, if
+// any, since the file is what's rewritten, but the directive names the source a user may know. Positions in the
+// output of cgo are those in the file it was generated from alone, since that's the file to edit.
+func reportPos(fSet *token.FileSet, pos token.Pos) string {
+	physical := fSet.PositionFor(pos, false)
+	if cgoOutputs[physical.Filename] {
+		return fSet.Position(pos).String()
+	}
+	if logical := fSet.Position(pos); logical != physical {
+		return fmt.Sprintf("%s (%s)", physical, logical)
+	}
+	return physical.String()
+}
+
+// reportRefusals reports each match which wasn't rewritten for a reason other than its type.
+func reportRefusals(fSet *token.FileSet, refusals []eg.Refusal) {
+	for _, r := range refusals {
+		fmt.Fprintf(os.Stderr, "%s: refused rewrite: %s\n", reportPos(fSet, r.Pos), r.Reason)
+	}
+}
+
+// reportTypeChanges warns about each rewrite which changed the type of an expression, or was refused for doing so or
+// for not type checking.
+func reportTypeChanges(fSet *token.FileSet, changes []eg.TypeChange) {
+	for _, c := range changes {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", reportPos(fSet, c.Pos), typeChangeMessage(c))
+	}
+}
+
+// typeChangeMessage describes the type change c, and whether it's refused.
+func typeChangeMessage(c eg.TypeChange) string {
+	switch {
+	case c.Err != nil:
+		msg := c.Err.Error()
+		if e, ok := c.Err.(types.Error); ok {
+			msg = e.Msg
+		}
+		return "refused rewrite: replacement doesn't type check here: " + msg
+	case !c.Assignable:
+		return fmt.Sprintf("refused rewrite: replacement has type %s, which is not assignable to %s", c.After, c.Before)
+	case c.Refused:
+		return fmt.Sprintf("refused rewrite: replacement changes type from %s to %s", c.Before, c.After)
+	}
+	return fmt.Sprintf("warning: replacement changes type from %s to %s", c.Before, c.After)
+}
+
+// A verbosity is the level of -v, which may be given alone, for 1.
+type verbosity int
+
+func (v *verbosity) String() string {
+	if v == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) Set(s string) error {
+	switch s {
+	case "true":
+		*v = 1
+	case "false":
+		*v = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < eg.Quiet || n > eg.VerboseBindings {
+			return fmt.Errorf("want a level from %d to %d", eg.Quiet, eg.VerboseBindings)
+		}
+		*v = verbosity(n)
 	}
 	return nil
 }
 
+func (v *verbosity) IsBoolFlag() bool { return true }
+
+// A tracePos is the location of -trace-pos.
+type tracePos struct {
+	filename  string
+	line, col int
+}
+
+// parseTracePos parses the location of -trace-pos, if given.
+func parseTracePos(loc string) (tracePos, error) {
+	if loc == "" {
+		return tracePos{}, nil
+	}
+	filename, line, col, err := parseLocation(loc)
+	if err != nil {
+		return tracePos{}, fmt.Errorf("-trace-pos: %v", err)
+	}
+	if filename, err = filepath.Abs(filename); err != nil {
+		return tracePos{}, fmt.Errorf("-trace-pos: %v", err)
+	}
+	return tracePos{filename, line, col}, nil
+}
+
+// inVerboseScope reports whether the matcher diagnostics of -v are printed for filename, of pkg: whether -vscope
+// names it, its directory or one enclosing it, or its package, if it's given.
+func inVerboseScope(pkg *packages.Package, filename string) bool {
+	if *vscopeFlag == "" {
+		return true
+	}
+	if *vscopeFlag == pkg.PkgPath {
+		return true
+	}
+	scope, err := filepath.Abs(*vscopeFlag)
+	if err != nil {
+		return false
+	}
+	return filename == scope || strings.HasPrefix(filename, scope+string(filepath.Separator))
+}
+
 type arrayFlags []string
 
 func (i *arrayFlags) String() string {
@@ -209,3 +1411,13 @@ func (i *arrayFlags) Set(value string) error {
 	*i = append(*i, value)
 	return nil
 }
+
+// contains reports whether value was given.
+func (i arrayFlags) contains(value string) bool {
+	for _, v := range i {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestTemplates applies several templates in one run, given by repeating -t, or as a directory of them.
+func TestTemplates(t *testing.T) {
+	runMainCases(t, "templates")
+}
+
+// TestInlineTemplate applies a template given inline, with -e, or read from standard input, with -t -.
+func TestInlineTemplate(t *testing.T) {
+	runMainCases(t, "inline-template")
+}
+
+// TestContainsPanics checks that a panic rewriting a file skips it, failing the run once the rest are rewritten.
+func TestContainsPanics(t *testing.T) {
+	runMainCases(t, "panic")
+}
+
+// TestLineDirectives checks that positions are reported in the file, followed by where its //line directives map
+// them, and that a match spanning a directive is refused.
+func TestLineDirectives(t *testing.T) {
+	runMainCases(t, "line-directive")
+}
+
+// TestCgo checks that matches in a file cgo processes are reported at their positions in it, rather than rewritten.
+func TestCgo(t *testing.T) {
+	if out, err := exec.Command("go", "env", "CGO_ENABLED").Output(); err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Skip("cgo isn't enabled")
+	}
+	runMainCases(t, "cgo")
+}
+
+// TestPlatforms rewrites the files built for each platform given by -platforms, each once.
+func TestPlatforms(t *testing.T) {
+	runMainCases(t, "platforms")
+}
+
+// TestVerbose checks the matcher diagnostics of each level of -v, and those -vscope limits them to.
+func TestVerbose(t *testing.T) {
+	runMainCases(t, "verbose")
+}
+
+// TestTracePos checks the matcher's decisions -trace-pos prints about the expressions at a position, or on a line.
+func TestTracePos(t *testing.T) {
+	runMainCases(t, "trace-pos")
+}
+
+// TestBindingReport checks the counts -binding-report prints of the types and kinds of expression each wildcard bound.
+func TestBindingReport(t *testing.T) {
+	runMainCases(t, "binding-report")
+}
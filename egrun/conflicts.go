@@ -0,0 +1,123 @@
+package egrun
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A ConflictSkipper skips the files with merge conflict markers as packages are loaded, rather than failing the load
+// with the confusing errors of parsing them, or rewriting them half resolved. Its ParseFile is the packages.Config
+// hook, and DropErrors removes the errors the go command reports for the files skipped from the packages loaded. A
+// Runner skips them with one of its own; the eg command shares one among the loads of its run.
+type ConflictSkipper struct {
+	Log io.Writer // where each file skipped is reported, once
+
+	mu      sync.Mutex
+	skipped map[string]bool // by name, since a file may be parsed by the goroutines of several loads
+}
+
+// ParseFile parses the file as go/packages does by default, unless it has merge conflict markers, when it's reported
+// and parsed as an empty file with its package clause, so that the package's other files are still type checked.
+func (s *ConflictSkipper) ParseFile(fSet *token.FileSet, filename string, src []byte) (*ast.File, error) {
+	if line := conflictMarker(src); line > 0 {
+		if f, err := parser.ParseFile(fSet, filename, src, parser.PackageClauseOnly); err == nil {
+			s.mu.Lock()
+			if !s.skipped[filename] {
+				fmt.Fprintf(s.Log, "%s:%d: skipped, since it has merge conflict markers\n", filename, line)
+				if s.skipped == nil {
+					s.skipped = make(map[string]bool)
+				}
+				s.skipped[filename] = true
+			}
+			s.mu.Unlock()
+			return f, nil
+		}
+	}
+	return parser.ParseFile(fSet, filename, src, parser.AllErrors|parser.ParseComments)
+}
+
+// DropErrors removes from pkgs, loaded from dir, the errors the go command reports compiling the files ParseFile
+// skipped, which it does to export the packages' types. Each is the output of the compiler, a line per error after
+// one naming the package, and is dropped if every error is in a skipped file.
+func (s *ConflictSkipper) DropErrors(pkgs []*packages.Package, dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.skipped) == 0 {
+		return
+	}
+	for _, pkg := range pkgs {
+		var kept []packages.Error
+		for _, e := range pkg.Errors {
+			if e.Kind != packages.ListError || !s.inSkipped(e.Msg, dir) {
+				kept = append(kept, e)
+			}
+		}
+		pkg.Errors = kept
+	}
+}
+
+// inSkipped reports whether each error of the compiler output msg is in a file ParseFile skipped, the names of which
+// are relative to dir.
+func (s *ConflictSkipper) inSkipped(msg, dir string) bool {
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(msg), "\n") {
+		if strings.HasPrefix(line, "# ") {
+			continue
+		}
+		file := positionFile(strings.SplitN(line, ": ", 2)[0])
+		if file == "" {
+			return false
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if !s.skipped[file] {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// positionFile returns the name of the file of pos, of the form file:line:column, or file:line, or "" if it isn't.
+func positionFile(pos string) string {
+	file := pos
+	for i := 0; i < 2; i++ {
+		colon := strings.LastIndex(file, ":")
+		if colon < 0 {
+			break
+		}
+		if _, err := strconv.Atoi(file[colon+1:]); err != nil {
+			break
+		}
+		file = file[:colon]
+	}
+	if file == pos {
+		return ""
+	}
+	return file
+}
+
+// conflictMarker returns the line of the first merge conflict marker in src, of a conflict which is ended too, or 0
+// if there's none.
+func conflictMarker(src []byte) int {
+	var start int
+	for i, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		switch {
+		case start == 0 && (line == "<<<<<<<" || strings.HasPrefix(line, "<<<<<<< ")):
+			start = i + 1
+		case start > 0 && (line == ">>>>>>>" || strings.HasPrefix(line, ">>>>>>> ")):
+			return start
+		}
+	}
+	return 0
+}
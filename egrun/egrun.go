@@ -0,0 +1,299 @@
+// Package egrun applies eg templates to packages, as the eg command does, for programs which embed example-based
+// refactoring rather than running the command:
+//
+//	r := egrun.New(egrun.Templates("tools/eg/errors"), egrun.Write(), egrun.Log(ioutil.Discard))
+//	res, err := r.Run("./...")
+//
+// It's the core of the command, which applies templates with the same pieces a Runner does: FindTemplates,
+// BuildTransformer, a Pass transforming the files, several at once, and Patch and WriteFile emitting them. The
+// command's configs, platforms, budgets and hooks which run commands are left to it.
+package egrun
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// LoadMode is the packages.LoadMode needed to build transformers and apply them to files.
+const LoadMode = packages.NeedName |
+	packages.NeedFiles |
+	packages.NeedImports |
+	packages.NeedTypes |
+	packages.NeedSyntax |
+	packages.NeedTypesInfo
+
+// A Runner applies templates to packages. It's configured by the Options given to New.
+type Runner struct {
+	templates             []string
+	write, diff, strict   bool
+	verbosity, workers    int
+	beforeEdit, afterEdit []func(f *File) error
+	onMatch               func(m *Match) bool
+	out, log              io.Writer
+	dir                   string
+	env                   []string
+}
+
+// New returns a Runner configured by opts.
+func New(opts ...Option) *Runner {
+	r := &Runner{out: os.Stdout, log: os.Stderr, workers: runtime.GOMAXPROCS(0)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// A File is a file a run transforms, and rewrites if the templates match in it.
+type File struct {
+	Name    string // the path of the file
+	Package string // the import path of its package
+	Matches int    // of all the templates
+	Src     []byte // the rewritten source
+	Err     error  // why it wasn't rewritten, printed or written, if it wasn't, e.g. for a panic or a BeforeEdit hook
+
+	Pkg       *packages.Package // its package, as loaded
+	Syntax    *ast.File         // its syntax, which transforming it rewrites in place
+	Syntactic bool              // whether it's transformed by syntax alone, since it doesn't type check
+	Applied   []int             // the indexes of the transformers of a Pass applied to it, in order
+	Counts    []int             // the matches of each transformer of the Pass
+	Gaps      []eg.Gap          // the lines the statement replacements left blank, which eg.Format closes
+	Data      interface{}       // the state of the caller of the Pass transforming it, if any
+
+	ready chan struct{} // closed once it's transformed, if by another goroutine
+}
+
+// A Match is a match of a template in a file, which OnMatch decides whether to rewrite.
+type Match struct {
+	File          *File
+	Template      string         // the path of the template
+	Pos           token.Position // of the match
+	Before, After string         // the source of the match and of its replacement
+}
+
+// A Result is the outcome of a run.
+type Result struct {
+	Files []*File // rewritten, in the order of the packages
+}
+
+// Matches returns the matches of all the files.
+func (res *Result) Matches() int {
+	var n int
+	for _, f := range res.Files {
+		n += f.Matches
+	}
+	return n
+}
+
+// Run applies the templates to the packages matched by patterns, as given to go list, returning the files it
+// rewrote. It fails if the packages or the templates don't load, or if any of the files couldn't be transformed,
+// printed or written, which are still among the Result's, with their Err. Files with merge conflict markers are
+// skipped, and reported to the Log.
+func (r *Runner) Run(patterns ...string) (*Result, error) {
+	if len(r.templates) == 0 {
+		return nil, errors.New("no templates")
+	}
+	tmplPaths, err := r.templatePaths()
+	if err != nil {
+		return nil, err
+	}
+	fSet := token.NewFileSet()
+	// the files with merge conflict markers are skipped, as by the eg command
+	conflicts := &ConflictSkipper{Log: r.log}
+	cfg := &packages.Config{Mode: LoadMode, Fset: fSet, Dir: r.dir, Env: r.env, ParseFile: conflicts.ParseFile}
+	var all []string
+	for _, tmplPath := range tmplPaths {
+		all = append(all, "file="+tmplPath)
+	}
+	pkgs, err := packages.Load(cfg, append(all, patterns...)...)
+	if err != nil {
+		return nil, fmt.Errorf("load: %v", err)
+	}
+	dir := r.dir
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	conflicts.DropErrors(pkgs, dir)
+	var nerrs int
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, e := range pkg.Errors {
+			fmt.Fprintln(r.log, e)
+			nerrs++
+		}
+	})
+	if nerrs > 0 {
+		return nil, errors.New("error loading packages")
+	}
+
+	pass := &Pass{Fset: fSet, Xforms: make([]*eg.Transformer, len(tmplPaths)), Workers: r.workers}
+	for i, tmplPath := range tmplPaths {
+		xform, err := BuildTransformer(fSet, tmplPath, &pkgs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", tmplPath, err)
+		}
+		xform.StrictTypes = r.strict
+		xform.Log = r.log
+		pass.Xforms[i] = xform
+	}
+	if r.verbosity > 0 {
+		pass.Workers = 1 // so that the diagnostics of the files aren't interleaved
+	}
+	if r.onMatch != nil {
+		pass.Workers = 1 // calling it for the matches in order
+		pass.OnMatch = func(f *File, i int, _ *eg.Transformer, orig, repl ast.Node) bool {
+			return r.onMatch(&Match{
+				File:     f,
+				Template: tmplPaths[i],
+				Pos:      fSet.Position(orig.Pos()),
+				Before:   nodeString(fSet, orig),
+				After:    nodeString(fSet, repl),
+			})
+		}
+	}
+	pass.Before = func(f *File, _ int, xform *eg.Transformer) {
+		xform.Verbosity = r.verbosity
+	}
+	// the refusals of each file are logged as it's finished, in order
+	pass.After = func(f *File, _ int, xform *eg.Transformer) {
+		r.reportRefusals(f.Data.(*bytes.Buffer), fSet, xform)
+	}
+	fmt.Fprintf(r.log, "visiting %v packages\n", len(pkgs))
+
+	res := &Result{}
+	var failed int
+	done := make(map[string]bool) // the files rewritten, which may be in several packages
+	pass.Finish = func(f *File) error {
+		if done[f.Name] {
+			return nil // as transformed, at the same time, for another package it's in
+		}
+		r.log.Write(f.Data.(*bytes.Buffer).Bytes())
+		if f.Err != nil {
+			fmt.Fprintf(r.log, "eg: %s: skipped, since rewriting it failed: %v\n", f.Name, f.Err)
+			res.Files = append(res.Files, f)
+			failed++
+			return nil
+		}
+		if f.Matches == 0 {
+			return nil
+		}
+		done[f.Name] = true
+		var buf bytes.Buffer
+		if err := eg.Format(&buf, fSet, f.Syntax, f.Gaps); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		f.Src = buf.Bytes()
+		res.Files = append(res.Files, f)
+		fmt.Fprintf(r.log, "=== %s (%d matches)\n", f.Name, f.Matches)
+		if f.Err = r.emit(f); f.Err != nil {
+			fmt.Fprintf(r.log, "eg: %s: %v\n", f.Name, f.Err)
+			failed++
+		}
+		return nil
+	}
+	applied := make([]int, len(pass.Xforms))
+	for i := range applied {
+		applied[i] = i
+	}
+	err = pass.Run(func(add func(f *File) error) error {
+		for _, pkg := range pkgs {
+			for _, file := range pkg.Syntax {
+				filename := fSet.File(file.Pos()).Name()
+				if done[filename] {
+					continue
+				}
+				f := &File{Name: filename, Package: pkg.PkgPath, Pkg: pkg, Syntax: file, Applied: applied, Data: new(bytes.Buffer)}
+				if err := add(f); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	if failed > 0 {
+		return res, fmt.Errorf("%d files couldn't be rewritten", failed)
+	}
+	return res, nil
+}
+
+// templatePaths returns the absolute paths of the template files, those of a directory, and its subdirectories, in
+// the order of their paths.
+func (r *Runner) templatePaths() ([]string, error) {
+	var args []string
+	for _, path := range r.templates {
+		if !strings.HasSuffix(path, ".go") {
+			if !filepath.IsAbs(path) {
+				path = "." + string(filepath.Separator) + filepath.Clean(path) // a directory, not an import path
+			}
+			path += string(filepath.Separator) + "..."
+		}
+		args = append(args, path)
+	}
+	return FindTemplates(r.dir, args)
+}
+
+// reportRefusals writes the matches xform refused to rewrite in the file it last transformed, and why, to w.
+func (r *Runner) reportRefusals(w io.Writer, fSet *token.FileSet, xform *eg.Transformer) {
+	for _, c := range xform.TypeChanges() {
+		pos := fSet.Position(c.Pos)
+		switch {
+		case c.Err != nil:
+			msg := c.Err.Error()
+			if e, ok := c.Err.(types.Error); ok {
+				msg = e.Msg
+			}
+			fmt.Fprintf(w, "%s: refused rewrite: replacement doesn't type check here: %s\n", pos, msg)
+		case !c.Assignable:
+			fmt.Fprintf(w, "%s: refused rewrite: replacement has type %s, which is not assignable to %s\n",
+				pos, c.After, c.Before)
+		case c.Refused:
+			fmt.Fprintf(w, "%s: refused rewrite: replacement changes type from %s to %s\n", pos, c.Before, c.After)
+		default:
+			fmt.Fprintf(w, "%s: warning: replacement changes type from %s to %s\n", pos, c.Before, c.After)
+		}
+	}
+	for _, ref := range xform.Refusals() {
+		fmt.Fprintf(w, "%s: refused rewrite: %s\n", fSet.Position(ref.Pos), ref.Reason)
+	}
+}
+
+// emit writes f in place, running the edit hooks, with Write, or otherwise prints it, or its diff, to the output.
+func (r *Runner) emit(f *File) error {
+	if r.write {
+		return WriteFile(f, r.beforeEdit, r.afterEdit, r.log)
+	}
+	src := f.Src
+	if r.diff {
+		dir, err := filepath.Abs(r.dir)
+		if err != nil {
+			return err
+		}
+		if src, err = Patch(dir, f.Name, f.Src); err != nil {
+			return err
+		}
+	}
+	_, err := r.out.Write(src)
+	return err
+}
+
+// nodeString returns the source of n.
+func nodeString(fSet *token.FileSet, n ast.Node) string {
+	var buf bytes.Buffer
+	format.Node(&buf, fSet, n)
+	return buf.String()
+}
@@ -0,0 +1,68 @@
+package egrun
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunContainsPanics checks that a panic transforming a file skips it, recording it as the file's Err, and that
+// the rest are still rewritten.
+func TestRunContainsPanics(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("testdata", "panic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 4} {
+		var out, log bytes.Buffer
+		r := New(Templates("template"), Dir(dir), Diff(), Output(&out), Log(&log), Parallel(workers))
+		res, err := r.Run("./...")
+		if err == nil {
+			t.Fatalf("-parallel %d: the run didn't fail", workers)
+		}
+		if len(res.Files) != 2 {
+			t.Fatalf("-parallel %d: got %d files, want 2:\n%s", workers, len(res.Files), log.Bytes())
+		}
+		q, r2 := res.Files[0], res.Files[1]
+		if _, ok := q.Err.(*PanicError); !ok || q.Name != filepath.Join(dir, "q", "q.go") {
+			t.Errorf("-parallel %d: got %s with error %v, want q.go, with a PanicError", workers, q.Name, q.Err)
+		}
+		if r2.Err != nil || r2.Matches != 1 || r2.Package != "example.com/panic/r" {
+			t.Errorf("-parallel %d: got %s with %d matches of %s and error %v, want r.go with 1 match", workers,
+				r2.Name, r2.Matches, r2.Package, r2.Err)
+		}
+		want := "-func G(t string) bool { return t == \"\" }\n+func G(t string) bool { return len(t) == 0 }\n"
+		if !strings.Contains(out.String(), want) || strings.Contains(out.String(), "q.go") {
+			t.Errorf("-parallel %d: got the diff\n%s\nwant only r.go's", workers, out.Bytes())
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, "r", "r.go"))
+		if err != nil || !bytes.Contains(src, []byte(`t == ""`)) {
+			t.Errorf("-parallel %d: r.go was written without Write", workers)
+		}
+	}
+}
+
+// TestRunSkipsConflicts checks that a file with merge conflict markers is reported and skipped, as by the eg command,
+// rather than failing the load, and that the rest of its package is still rewritten.
+func TestRunSkipsConflicts(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("testdata", "conflicts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, log bytes.Buffer
+	res, err := New(Templates("template"), Dir(dir), Diff(), Output(&out), Log(&log)).Run("./...")
+	if err != nil {
+		t.Fatalf("the run failed: %v\n%s", err, log.Bytes())
+	}
+	if len(res.Files) != 1 || res.Files[0].Name != filepath.Join(dir, "p", "a.go") || res.Files[0].Matches != 1 {
+		t.Errorf("got the files %v, want a.go, with 1 match", res.Files)
+	}
+	if want := filepath.Join(dir, "p", "b.go") + ":3: skipped, since it has merge conflict markers"; !strings.Contains(log.String(), want) {
+		t.Errorf("the log doesn't report b.go skipped:\n%s", log.Bytes())
+	}
+	if strings.Contains(out.String(), "b.go") {
+		t.Errorf("b.go was rewritten:\n%s", out.Bytes())
+	}
+}
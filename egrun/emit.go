@@ -0,0 +1,48 @@
+package egrun
+
+import (
+	"fmt"
+	"github.com/jwilner/eg/internal/diff"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Patch returns the unified diff rewriting the file at filename, or creating it if it doesn't exist, as src, with
+// its path relative to root, if it's within it, or nil if src is unchanged.
+func Patch(root, filename string, src []byte) ([]byte, error) {
+	rel := filename
+	if r, err := filepath.Rel(root, filename); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	rel = filepath.ToSlash(rel)
+	old, err := ioutil.ReadFile(filename)
+	aName := "a/" + rel
+	if os.IsNotExist(err) {
+		old, aName = nil, "/dev/null"
+	} else if err != nil {
+		return nil, err
+	}
+	return diff.Unified(aName, "b/"+rel, old, src), nil
+}
+
+// WriteFile writes f's Src in place, calling the before hooks first, whose failure is returned, as is, and skips the
+// file, and then the after hooks, whose failures are logged.
+func WriteFile(f *File, before, after []func(f *File) error, log io.Writer) error {
+	for _, hook := range before {
+		if err := hook(f); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(f.Name, f.Src, 0666); err != nil {
+		return err
+	}
+	for _, hook := range after {
+		if err := hook(f); err != nil {
+			fmt.Fprintf(log, "Warning: after edit hook failed for %s (%s)\n", f.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package egrun
+
+import "io"
+
+// An Option configures a Runner.
+type Option func(*Runner)
+
+// Templates adds the templates to apply, files or directories of them, applied to each file in the order given, those
+// of a directory, and its subdirectories, in the order of their paths. A relative path is relative to the Dir.
+func Templates(paths ...string) Option {
+	return func(r *Runner) { r.templates = append(r.templates, paths...) }
+}
+
+// Write has the rewritten files written in place, running the edit hooks, rather than printed.
+func Write() Option {
+	return func(r *Runner) { r.write = true }
+}
+
+// Diff has a unified diff of each rewritten file printed, relative to the Dir, rather than its source.
+func Diff() Option {
+	return func(r *Runner) { r.diff = true }
+}
+
+// StrictTypes refuses the rewrites whose replacement changes the type of the expression at all, rather than only
+// those whose new type isn't assignable to the old.
+func StrictTypes() Option {
+	return func(r *Runner) { r.strict = true }
+}
+
+// Verbosity sets the level of the matcher's diagnostics, which are written to the log: 1 for each match, 2 also why
+// each candidate resembling the pattern doesn't match, and 3 also the wildcard bindings tried.
+func Verbosity(level int) Option {
+	return func(r *Runner) { r.verbosity = level }
+}
+
+// Parallel sets the number of files transformed at once, by default GOMAXPROCS. They're still printed, or written,
+// in order. With Verbosity, or OnMatch, they're transformed one at a time.
+func Parallel(n int) Option {
+	return func(r *Runner) { r.workers = n }
+}
+
+// OnMatch sets a hook called with each match, in order, which is rewritten only if it returns true.
+func OnMatch(hook func(m *Match) bool) Option {
+	return func(r *Runner) { r.onMatch = hook }
+}
+
+// BeforeEdit adds a hook called with each file before it's written, which skips the file by failing, with the
+// hook's error as its Err.
+func BeforeEdit(hook func(f *File) error) Option {
+	return func(r *Runner) { r.beforeEdit = append(r.beforeEdit, hook) }
+}
+
+// AfterEdit adds a hook called with each file once it's written, whose failure is logged.
+func AfterEdit(hook func(f *File) error) Option {
+	return func(r *Runner) { r.afterEdit = append(r.afterEdit, hook) }
+}
+
+// Output sets where the rewritten files, or their diffs, are printed, by default standard output.
+func Output(w io.Writer) Option {
+	return func(r *Runner) { r.out = w }
+}
+
+// Log sets where the progress, the refusals and the matcher's diagnostics are written, by default standard error.
+func Log(w io.Writer) Option {
+	return func(r *Runner) { r.log = w }
+}
+
+// Dir sets the directory the packages are loaded from, by default the working directory.
+func Dir(dir string) Option {
+	return func(r *Runner) { r.dir = dir }
+}
+
+// Env sets the environment of the go command loading the packages, e.g. to set GOOS, by default the process's.
+func Env(env []string) Option {
+	return func(r *Runner) { r.env = env }
+}
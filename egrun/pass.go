@@ -0,0 +1,156 @@
+package egrun
+
+import (
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/token"
+	"runtime/debug"
+)
+
+// A Pass applies transformers to files, several at once, and finishes each, once it's transformed, in the order
+// they're added. A panic transforming a file, e.g. for a bug in the matcher, is contained to it: it's recorded as
+// the file's Err, and the file is finished unrewritten by the rest of the transformers.
+type Pass struct {
+	Fset   *token.FileSet
+	Xforms []*eg.Transformer
+	// Workers is the number of files transformed at once. With more than one, each worker applies copies of Xforms.
+	Workers int
+
+	// OnMatch, if set, decides whether each match xform, a worker's copy of Xforms[i], finds in f is rewritten.
+	OnMatch func(f *File, i int, xform *eg.Transformer, orig, repl ast.Node) bool
+	// Revise, if set, revises the replacement of each match of Xforms[i] in f which is rewritten.
+	Revise func(f *File, i int, orig, repl ast.Node) ast.Node
+	// Before and After, if set, are called by the worker transforming f before and after it applies xform, its copy
+	// of Xforms[i], e.g. to collect its diagnostics, which are reported as f is finished.
+	Before, After func(f *File, i int, xform *eg.Transformer)
+	// Finish is called with each file transformed, in order. Its failure ends the pass.
+	Finish func(f *File) error
+}
+
+// A PanicError is the panic transforming a file.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// A worker transforms a file at a time with transformers of its own.
+type worker struct {
+	xforms []*eg.Transformer
+	f      *File // the file being transformed, for OnMatch and Revise
+}
+
+// Run transforms each file visit adds, of whose transformers Applied are the indexes, and finishes them in order.
+func (p *Pass) Run(visit func(add func(f *File) error) error) error {
+	workers := p.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	// the files are transformed by the workers as they're added, and finished in order, a window of them ahead
+	if workers == 1 {
+		w := p.newWorker(false)
+		return visit(func(f *File) error {
+			p.transform(w, f)
+			return p.Finish(f)
+		})
+	}
+	work := make(chan *File)
+	defer close(work)
+	for i := 0; i < workers; i++ {
+		w := p.newWorker(true)
+		go func() {
+			for f := range work {
+				p.transform(w, f)
+				close(f.ready)
+			}
+		}()
+	}
+	var pending []*File
+	// drain finishes the files transformed until at most n are pending
+	drain := func(n int) error {
+		for len(pending) > n {
+			f := pending[0]
+			pending = pending[1:]
+			<-f.ready
+			if err := p.Finish(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := visit(func(f *File) error {
+		f.ready = make(chan struct{})
+		pending = append(pending, f)
+		work <- f
+		return drain(2 * workers)
+	})
+	if err != nil {
+		for _, f := range pending {
+			<-f.ready // so that the files aren't transformed as the caller returns
+		}
+		return err
+	}
+	return drain(0)
+}
+
+// newWorker returns a worker applying the transformers, copies of them if clone.
+func (p *Pass) newWorker(clone bool) *worker {
+	w := &worker{xforms: make([]*eg.Transformer, len(p.Xforms))}
+	for i, xform := range p.Xforms {
+		i, xform := i, xform
+		if clone {
+			xform = xform.Clone()
+		}
+		w.xforms[i] = xform
+		if p.OnMatch != nil {
+			xform.OnMatch = func(orig, repl ast.Node) bool { return p.OnMatch(w.f, i, xform, orig, repl) }
+		}
+		if p.Revise != nil {
+			xform.Revise = func(orig, repl ast.Node) ast.Node { return p.Revise(w.f, i, orig, repl) }
+		}
+	}
+	return w
+}
+
+// transform applies the transformers of w to f, in turn, by its syntax alone if it's Syntactic.
+func (p *Pass) transform(w *worker, f *File) {
+	w.f = f
+	defer func() { w.f = nil }()
+	f.Counts = make([]int, len(p.Xforms))
+	for _, i := range f.Applied {
+		xform := w.xforms[i]
+		if p.Before != nil {
+			p.Before(f, i, xform)
+		}
+		if f.Err = contain(func() {
+			if f.Syntactic {
+				f.Counts[i] = xform.TransformSyntax(f.Pkg.Types, f.Syntax)
+			} else {
+				f.Counts[i] = xform.Transform(f.Pkg.TypesInfo, f.Pkg.Types, f.Syntax)
+			}
+		}); f.Err != nil {
+			f.Matches = 0
+			return
+		}
+		f.Matches += f.Counts[i]
+		f.Gaps = append(f.Gaps, xform.Gaps()...)
+		if p.After != nil {
+			p.After(f, i, xform)
+		}
+	}
+}
+
+// contain calls fn, returning the panic it raises, if any, as a PanicError.
+func contain(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	fn()
+	return nil
+}
@@ -0,0 +1,104 @@
+package egrun
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/token"
+	"golang.org/x/tools/go/packages"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FindTemplates returns the template files args name, sorted: those ending in .go, and the files of the packages
+// the others match, as patterns given to go list from dir, e.g. tools/eg/... for a directory of them and those
+// within it. A relative path is relative to dir, by default the working directory.
+func FindTemplates(dir string, args []string) ([]string, error) {
+	var paths, patterns []string
+	for _, arg := range args {
+		if strings.HasSuffix(arg, ".go") {
+			if !filepath.IsAbs(arg) && dir != "" {
+				arg = filepath.Join(dir, arg)
+			}
+			abs, err := filepath.Abs(arg)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, abs)
+			continue
+		}
+		patterns = append(patterns, arg)
+	}
+	if len(patterns) > 0 {
+		pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName | packages.NeedFiles, Dir: dir}, patterns...)
+		if err != nil {
+			return nil, fmt.Errorf("load: %v", err)
+		}
+		if packages.PrintErrors(pkgs) > 0 {
+			return nil, errors.New("error loading packages")
+		}
+		for _, pkg := range pkgs {
+			paths = append(paths, pkg.GoFiles...)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// A NotFoundError is the failure to find a template among the packages loaded.
+type NotFoundError struct {
+	Path string // of the template
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("didn't find template %s in the loaded packages", e.Path)
+}
+
+// BuildTransformer returns the transformer of the template at tmplPath, removing its package, which isn't rewritten,
+// from pkgs, along with its tests, if they're loaded.
+func BuildTransformer(fSet *token.FileSet, tmplPath string, pkgs *[]*packages.Package) (*eg.Transformer, error) {
+	// find the template package in the processed packages according to the absolute file path
+	var tmplPkg *packages.Package
+	for i := 0; tmplPkg == nil && i < len(*pkgs); i++ {
+		pkg := (*pkgs)[i]
+		for _, f := range pkg.GoFiles {
+			if f == tmplPath {
+				tmplPkg = pkg
+				*pkgs = append((*pkgs)[:i], (*pkgs)[i+1:]...)
+				break
+			}
+		}
+	}
+	if tmplPkg == nil {
+		return nil, &NotFoundError{tmplPath}
+	}
+	for i := 0; i < len(*pkgs); i++ {
+		if TestOf((*pkgs)[i]) == tmplPkg.PkgPath {
+			*pkgs = append((*pkgs)[:i], (*pkgs)[i+1:]...)
+			i--
+		}
+	}
+
+	var tmplFile *ast.File
+	for _, f := range tmplPkg.Syntax {
+		if fSet.File(f.Pos()).Name() == tmplPath {
+			tmplFile = f
+		}
+	}
+	if tmplFile == nil {
+		return nil, fmt.Errorf("template %s is among the files of %s, but not its syntax", tmplPath, tmplPkg.ID)
+	}
+	return eg.NewTransformer(fSet, tmplPkg.Types, tmplFile, tmplPkg.TypesInfo, false)
+}
+
+// TestOf returns the import path of the package pkg was loaded with the tests of, as its ID says, e.g. p for
+// "p [p.test]" or "p_test [p.test]", or "" if it's no test variant.
+func TestOf(pkg *packages.Package) string {
+	i := strings.Index(pkg.ID, " [")
+	if i < 0 || !strings.HasSuffix(pkg.ID, ".test]") {
+		return ""
+	}
+	return strings.TrimSuffix(pkg.ID[i+len(" ["):], ".test]")
+}
@@ -0,0 +1,3 @@
+module example.com/conflicts
+
+go 1.18
@@ -0,0 +1,3 @@
+package p
+
+func G(t string) bool { return t == "" }
@@ -0,0 +1,7 @@
+package p
+
+<<<<<<< HEAD
+func H(t string) bool { return t == "" }
+=======
+func H(t string) bool { return t == "x" }
+>>>>>>> branch
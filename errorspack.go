@@ -0,0 +1,271 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const errorsUsage = `Usage: eg errors [-w] <packages>...
+
+Converts comparisons with sentinel errors, as in err == io.EOF, to calls of
+errors.Is, and the assertions on errors of if statements, as in
+
+	if e, ok := err.(*os.PathError); ok {
+
+to calls of errors.As, so wrapped errors match too:
+
+	var e *os.PathError
+	if errors.As(err, &e) {
+
+Comparisons with nil, or with errors which aren't package-level variables,
+are left, as are switch statements on errors, which are reported, and the
+bodies of Is, As and Unwrap methods, which implement the comparisons. Packages
+in modules declaring a go version before 1.13, which added errors.Is and
+errors.As, are skipped.
+`
+
+func errorsMain(args []string) error {
+	fs := flag.NewFlagSet("errors", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, errorsUsage) }
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	versions := make(moduleVersions)
+	for _, pkg := range m.pkgs {
+		if len(pkg.GoFiles) > 0 {
+			dir := filepath.Dir(pkg.GoFiles[0])
+			version, gomod, err := versions.lookup(dir)
+			if err != nil {
+				return err
+			}
+			if version != "" && goVersionLess(version, "1.13") {
+				fmt.Fprintf(os.Stderr, "skipping %s: %s declares go %s, but errors.Is needs go 1.13\n", dir, gomod, version)
+				continue
+			}
+		}
+		for _, file := range pkg.Syntax {
+			c := errorsConverter{m: m, pkg: pkg, file: file}
+			astutil.Apply(file, c.pre, nil)
+		}
+	}
+	return m.emit(false)
+}
+
+// An errorsConverter converts the comparisons and assertions of errors in a file.
+type errorsConverter struct {
+	m    *migration
+	pkg  *packages.Package
+	file *ast.File
+}
+
+// pre converts the node at cur, if it's a comparison or an if statement asserting on an error, and reports
+// switch statements on errors.
+func (c *errorsConverter) pre(cur *astutil.Cursor) bool {
+	info := c.pkg.TypesInfo
+	switch n := cur.Node().(type) {
+	case *ast.FuncDecl:
+		// these implement the comparisons errors.Is and errors.As make
+		if n.Recv != nil && (n.Name.Name == "Is" || n.Name.Name == "As" || n.Name.Name == "Unwrap") {
+			return false
+		}
+	case *ast.BinaryExpr:
+		if n.Op != token.EQL && n.Op != token.NEQ {
+			break
+		}
+		x, sentinel := n.X, n.Y
+		if c.isSentinel(x) {
+			x, sentinel = sentinel, x
+		}
+		if !types.Identical(info.TypeOf(x), errorType) || !c.isSentinel(sentinel) {
+			break
+		}
+		var e ast.Expr = c.errorsCall(n.Pos(), "Is", x, sentinel)
+		if e == nil {
+			break
+		}
+		if n.Op == token.NEQ {
+			e = &ast.UnaryExpr{OpPos: n.Pos(), Op: token.NOT, X: e}
+		}
+		cur.Replace(e)
+		c.m.touch(c.pkg, c.file)
+	case *ast.SwitchStmt:
+		if n.Tag != nil && types.Identical(info.TypeOf(n.Tag), errorType) {
+			fmt.Fprintf(os.Stderr, "%s: switch on %s compares errors with ==; convert it manually\n",
+				c.m.position(n), nodeString(c.m.fSet, n.Tag))
+		}
+	case *ast.TypeSwitchStmt:
+		if x := typeSwitchOperand(n); x != nil && types.Identical(info.TypeOf(x), errorType) {
+			fmt.Fprintf(os.Stderr, "%s: type switch on %s asserts the error's type; convert it manually\n",
+				c.m.position(n), nodeString(c.m.fSet, x))
+		}
+	case *ast.IfStmt:
+		c.convertAssertion(cur, n)
+	}
+	return true
+}
+
+// isSentinel reports whether e refers to an error which is a package-level variable.
+func (c *errorsConverter) isSentinel(e ast.Expr) bool {
+	var id *ast.Ident
+	switch e := astutil.Unparen(e).(type) {
+	case *ast.Ident:
+		id = e
+	case *ast.SelectorExpr:
+		id = e.Sel
+	}
+	v, ok := c.pkg.TypesInfo.Uses[id].(*types.Var)
+	return ok && v.Pkg() != nil && v.Parent() == v.Pkg().Scope() && types.Implements(v.Type(), errorType.Underlying().(*types.Interface))
+}
+
+// errorsCall returns a call of the function name of the errors package with args, or nil if the package can't
+// be referred to at pos.
+func (c *errorsConverter) errorsCall(pos token.Pos, name string, args ...ast.Expr) *ast.CallExpr {
+	call, err := c.m.stdCall(c.pkg, c.file, pos, "errors", name, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v; convert this manually\n", c.m.fSet.Position(pos), err)
+		return nil
+	}
+	return call
+}
+
+// typeSwitchOperand returns the expression whose type the type switch n switches on.
+func typeSwitchOperand(n *ast.TypeSwitchStmt) ast.Expr {
+	var e ast.Expr
+	switch s := n.Assign.(type) {
+	case *ast.ExprStmt:
+		e = s.X
+	case *ast.AssignStmt:
+		e = s.Rhs[0]
+	}
+	if ta, ok := e.(*ast.TypeAssertExpr); ok {
+		return ta.X
+	}
+	return nil
+}
+
+// convertAssertion converts the if statement n, if it's of the form
+//
+//	if e, ok := err.(T); ok {
+//
+// or tests !ok, to a call of errors.As, declaring e before it if n is within a block, or otherwise within its
+// init statement.
+func (c *errorsConverter) convertAssertion(cur *astutil.Cursor, n *ast.IfStmt) {
+	info := c.pkg.TypesInfo
+	assign, ok := n.Init.(*ast.AssignStmt)
+	if !ok || assign.Tok != token.DEFINE || len(assign.Lhs) != 2 || len(assign.Rhs) != 1 {
+		return
+	}
+	ta, ok := assign.Rhs[0].(*ast.TypeAssertExpr)
+	if !ok || ta.Type == nil || !types.Identical(info.TypeOf(ta.X), errorType) {
+		return
+	}
+	e, okID := assign.Lhs[0].(*ast.Ident), assign.Lhs[1].(*ast.Ident)
+	okObj := info.Defs[okID]
+	cond, negated := n.Cond, false
+	if un, ok := cond.(*ast.UnaryExpr); ok && un.Op == token.NOT {
+		cond, negated = un.X, true
+	}
+	if id, ok := cond.(*ast.Ident); !ok || okObj == nil || info.Uses[id] != okObj || usesObj(info, n.Body, okObj) ||
+		n.Else != nil && usesObj(info, n.Else, okObj) {
+		return
+	}
+	// errors.As panics unless its target is an interface, or implements error
+	target := info.TypeOf(ta.Type)
+	if _, isIface := target.Underlying().(*types.Interface); !isIface && !types.Implements(target, errorType.Underlying().(*types.Interface)) {
+		return
+	}
+
+	pos := n.Pos()
+	var arg ast.Expr
+	var init, decl ast.Stmt
+	switch {
+	case e.Name == "_":
+		arg = &ast.CallExpr{Fun: &ast.Ident{NamePos: pos, Name: "new"}, Lparen: pos, Args: []ast.Expr{ta.Type}, Rparen: pos}
+	case c.canDeclare(cur, n, e.Name):
+		arg = &ast.UnaryExpr{OpPos: pos, Op: token.AND, X: e}
+		spec := &ast.ValueSpec{Names: []*ast.Ident{{NamePos: pos, Name: e.Name}}, Type: ta.Type}
+		decl = &ast.DeclStmt{Decl: &ast.GenDecl{TokPos: pos, Tok: token.VAR, Specs: []ast.Spec{spec}}}
+	default:
+		// e remains scoped to the if statement, initialized to nil
+		switch target.Underlying().(type) {
+		case *types.Interface, *types.Pointer:
+		default:
+			return
+		}
+		arg = &ast.UnaryExpr{OpPos: pos, Op: token.AND, X: &ast.Ident{NamePos: pos, Name: e.Name}}
+		conv := &ast.CallExpr{
+			Fun:    &ast.ParenExpr{Lparen: pos, X: ta.Type, Rparen: pos},
+			Lparen: pos,
+			Args:   []ast.Expr{&ast.Ident{NamePos: pos, Name: "nil"}},
+			Rparen: pos,
+		}
+		init = &ast.AssignStmt{Lhs: []ast.Expr{e}, TokPos: pos, Tok: token.DEFINE, Rhs: []ast.Expr{conv}}
+	}
+	call := c.errorsCall(pos, "As", ta.X, arg)
+	if call == nil {
+		return
+	}
+	n.Init = init
+	if decl != nil {
+		cur.InsertBefore(decl)
+	}
+	n.Cond = call
+	if negated {
+		n.Cond = &ast.UnaryExpr{OpPos: pos, Op: token.NOT, X: call}
+	}
+	c.m.touch(c.pkg, c.file)
+}
+
+// canDeclare reports whether name can be declared before the statement n at cur, in the block containing it,
+// without conflicting with the block's declarations, or shadowing an outer declaration it refers to after n.
+func (c *errorsConverter) canDeclare(cur *astutil.Cursor, n ast.Stmt, name string) bool {
+	list, ok := stmtList(cur.Parent())
+	if !ok {
+		return false
+	}
+	info := c.pkg.TypesInfo
+	scope := c.pkg.Types.Scope().Innermost(n.Pos())
+	if scope != nil && scope.Pos() == n.Pos() {
+		scope = scope.Parent() // n's own
+	}
+	if scope == nil || scope.Lookup(name) != nil {
+		return false
+	}
+	after := false
+	for _, s := range *list {
+		if s == n {
+			after = true
+			continue
+		}
+		if after && usesOuter(info, &ast.BlockStmt{List: []ast.Stmt{s}}, name, scope) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// usesObj reports whether n refers to obj.
+func usesObj(info *types.Info, n ast.Node, obj types.Object) bool {
+	var found bool
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && info.Uses[id] == obj {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestErrors(t *testing.T) {
+	runCommandCases(t, "errors")
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"strings"
+)
+
+const explainUsage = `Usage: eg explain -t template.go
+
+Prints the parsed before and after expressions of the template (or statements,
+for a statement template), the holes (the parameters of before) together with
+their types, and the strategy the matcher will use to find candidates, in order
+to debug why a template behaves as it does.
+`
+
+func explainMain(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, explainUsage) }
+	tmplPath := fs.String("t", "", "template.go file to explain")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplPath == "" {
+		return errors.New("no -t template.go file specified")
+	}
+
+	fSet := token.NewFileSet()
+	tmplPkg, tmplFile, err := loadTemplate(fSet, *tmplPath)
+	if err != nil {
+		return err
+	}
+	return explain(os.Stdout, fSet, tmplPkg.Types, tmplPkg.TypesInfo, tmplFile)
+}
+
+// explain writes a human readable account of how the template in tmplFile will be matched and applied to w.
+func explain(w io.Writer, fSet *token.FileSet, tmplPkg *types.Package, info *types.Info, tmplFile *ast.File) error {
+	fmt.Fprintf(w, "template: %s (package %s)\n", fSet.File(tmplFile.Pos()).Name(), tmplPkg.Path())
+
+	if _, err := eg.NewTransformer(fSet, tmplPkg, tmplFile, info, false); err != nil {
+		fmt.Fprintf(w, "status: rejected: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "status: ok\n")
+	}
+
+	beforeDecl, afterDecl := templateFuncs(tmplFile)
+	if beforeDecl == nil || afterDecl == nil || beforeDecl.Body == nil || afterDecl.Body == nil {
+		return errors.New("template must declare before and after functions with bodies")
+	}
+	if eg.IsStatementTemplate(beforeDecl) {
+		return explainStmts(w, fSet, tmplPkg, info, beforeDecl, afterDecl)
+	}
+	before, afterStmts, after := templateExprs(beforeDecl), afterDecl.Body.List, templateExprs(afterDecl)
+	if len(afterStmts) > 0 {
+		afterStmts = afterStmts[:len(afterStmts)-1]
+	}
+
+	fmt.Fprintf(w, "\nbefore:\n\t%s\n", nodeString(fSet, before))
+	fmt.Fprintf(w, "after:\n")
+	for _, s := range afterStmts {
+		fmt.Fprintf(w, "\t%s\n", nodeString(fSet, s))
+	}
+	fmt.Fprintf(w, "\t%s\n", nodeString(fSet, after))
+
+	holes := templateHoles(info, beforeDecl)
+	fmt.Fprintf(w, "\nholes:\n")
+	if len(holes) == 0 {
+		fmt.Fprintf(w, "\t(none: the pattern only matches itself)\n")
+	}
+	qual := types.RelativeTo(tmplPkg)
+	for _, h := range holes {
+		fmt.Fprintf(w, "\t%s %s\t(before: %d, after: %d)\n",
+			h.Name(), types.TypeString(h.Type(), qual), countUses(info, before, h), countNamed(after, afterStmts, h.Name()))
+	}
+
+	fmt.Fprintf(w, "\nstrategy:\n")
+	for _, line := range strategy(info, qual, before, afterStmts, holes) {
+		fmt.Fprintf(w, "\t- %s\n", line)
+	}
+
+	fmt.Fprintf(w, "\nbefore AST:\n")
+	if before != nil {
+		ast.Fprint(w, fSet, before, ast.NotNilFilter)
+	}
+	fmt.Fprintf(w, "\nafter AST:\n")
+	for _, s := range afterStmts {
+		ast.Fprint(w, fSet, s, ast.NotNilFilter)
+	}
+	if after != nil {
+		ast.Fprint(w, fSet, after, ast.NotNilFilter)
+	}
+	return nil
+}
+
+// explainStmts is explain for a statement template, whose before and after functions are beforeDecl and afterDecl.
+func explainStmts(w io.Writer, fSet *token.FileSet, tmplPkg *types.Package, info *types.Info, beforeDecl, afterDecl *ast.FuncDecl) error {
+	before, after := eg.TemplateStmts(info, beforeDecl), eg.TemplateStmts(info, afterDecl)
+	fmt.Fprintf(w, "\nbefore (statements):\n")
+	for _, s := range before {
+		fmt.Fprintf(w, "\t%s\n", strings.Replace(nodeString(fSet, s), "\n", "\n\t", -1))
+	}
+	fmt.Fprintf(w, "after (statements):\n")
+	if len(after) == 0 {
+		fmt.Fprintf(w, "\t(none: the matches are deleted)\n")
+	}
+	for _, s := range after {
+		fmt.Fprintf(w, "\t%s\n", strings.Replace(nodeString(fSet, s), "\n", "\n\t", -1))
+	}
+
+	holes := templateHoles(info, beforeDecl)
+	fmt.Fprintf(w, "\nholes:\n")
+	if len(holes) == 0 {
+		fmt.Fprintf(w, "\t(none: the pattern only matches itself)\n")
+	}
+	qual := types.RelativeTo(tmplPkg)
+	for _, h := range holes {
+		var uses int
+		for _, s := range before {
+			uses += countUses(info, s, h)
+		}
+		fmt.Fprintf(w, "\t%s %s\t(before: %d, after: %d)\n",
+			h.Name(), types.TypeString(h.Type(), qual), uses, countNamed(nil, after, h.Name()))
+	}
+
+	fmt.Fprintf(w, "\nstrategy:\n")
+	lines := []string{
+		fmt.Sprintf("every run of %d statement(s) of every block is compared against the pattern, innermost blocks first", len(before)),
+		"the variables the pattern declares match any variable of the input declared or used in the same way, of an assignable type",
+		"identifiers match only if they denote the same object, however they are spelled in the input",
+	}
+	for _, stmts := range [][]ast.Stmt{before, after} {
+		var returns bool
+		for _, s := range stmts {
+			ast.Inspect(s, func(n ast.Node) bool {
+				_, ok := n.(*ast.ReturnStmt)
+				returns = returns || ok
+				return !returns
+			})
+		}
+		if returns {
+			lines = append(lines, "the template returns, so it only matches in functions whose results are of its types")
+			break
+		}
+	}
+	for _, line := range lines {
+		fmt.Fprintf(w, "\t- %s\n", line)
+	}
+
+	fmt.Fprintf(w, "\nbefore AST:\n")
+	for _, s := range before {
+		ast.Fprint(w, fSet, s, ast.NotNilFilter)
+	}
+	fmt.Fprintf(w, "\nafter AST:\n")
+	for _, s := range after {
+		ast.Fprint(w, fSet, s, ast.NotNilFilter)
+	}
+	return nil
+}
+
+// templateExprs returns the expression of the final return or expression statement of fn, or nil if there is none.
+func templateExprs(fn *ast.FuncDecl) ast.Expr {
+	if len(fn.Body.List) == 0 {
+		return nil
+	}
+	switch stmt := fn.Body.List[len(fn.Body.List)-1].(type) {
+	case *ast.ReturnStmt:
+		if len(stmt.Results) == 1 {
+			return stmt.Results[0]
+		}
+	case *ast.ExprStmt:
+		return stmt.X
+	}
+	return nil
+}
+
+// templateHoles returns the parameters of fn in declaration order.
+func templateHoles(info *types.Info, fn *ast.FuncDecl) []*types.Var {
+	var holes []*types.Var
+	for _, field := range fn.Type.Params.List {
+		for _, name := range field.Names {
+			if v, ok := info.Defs[name].(*types.Var); ok {
+				holes = append(holes, v)
+			}
+		}
+	}
+	return holes
+}
+
+// countUses returns the number of references to v within n.
+func countUses(info *types.Info, n ast.Node, v *types.Var) int {
+	var count int
+	if n != nil {
+		ast.Inspect(n, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && info.Uses[id] == v {
+				count++
+			}
+			return true
+		})
+	}
+	return count
+}
+
+// countNamed returns the number of identifiers spelled name within after and stmts; substitution into the
+// replacement is by name, so this is the number of copies of the bound expression the replacement will contain.
+func countNamed(after ast.Expr, stmts []ast.Stmt, name string) int {
+	var count int
+	visit := func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			count++
+		}
+		return true
+	}
+	for _, s := range stmts {
+		ast.Inspect(s, visit)
+	}
+	if after != nil {
+		ast.Inspect(after, visit)
+	}
+	return count
+}
+
+// strategy describes, one line per decision, how the matcher will treat the pattern.
+func strategy(info *types.Info, qual types.Qualifier, before ast.Expr, afterStmts []ast.Stmt, holes []*types.Var) []string {
+	if before == nil {
+		return []string{"no pattern: before must consist of a single return or expression statement"}
+	}
+	isHole := make(map[types.Object]bool, len(holes))
+	for _, h := range holes {
+		isHole[h] = true
+	}
+
+	lines := []string{"every expression of every file is compared against the pattern, innermost first"}
+	lines = append(lines, "candidates must be "+describeExpr(info, qual, isHole, before))
+
+	if t, ok := info.TypeOf(before).(*types.Tuple); ok && t.Len() == 0 {
+		lines = append(lines, "the pattern has no result, so it only occurs as an expression statement")
+	}
+
+	var hasFuncLit, hasTypes bool
+	ast.Inspect(before, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			hasFuncLit = true
+		case *ast.SelectorExpr:
+			if id, ok := n.X.(*ast.Ident); ok && isHole[info.Uses[id]] {
+				lines = append(lines, fmt.Sprintf(
+					"hole %s is used as a receiver of .%s, so it binds any expression with a field or method %s, regardless of its type",
+					id.Name, n.Sel.Name, n.Sel.Name,
+				))
+			}
+		case ast.Expr:
+			if info.Types[n].IsType() {
+				hasTypes = true
+			}
+		}
+		return true
+	})
+	for _, h := range holes {
+		if n := countUses(info, before, h); n > 1 {
+			lines = append(lines, fmt.Sprintf("hole %s occurs %d times, so every occurrence must bind an identical expression", h.Name(), n))
+		}
+	}
+	if hasTypes {
+		lines = append(lines, "type syntax is matched semantically: it matches any spelling of an identical type")
+	}
+	lines = append(lines, "identifiers match only if they denote the same object, however they are spelled in the input")
+	if hasFuncLit {
+		lines = append(lines, "the pattern contains a function literal and will never match")
+	}
+	if len(afterStmts) > 0 {
+		lines = append(lines, fmt.Sprintf("the %d statement(s) preceding the replacement are inserted before each statement containing a match", len(afterStmts)))
+	}
+	return lines
+}
+
+// describeExpr summarizes the kind of input expression that can match the pattern e.
+func describeExpr(info *types.Info, qual types.Qualifier, isHole map[types.Object]bool, e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return describeExpr(info, qual, isHole, e.X)
+	case *ast.Ident:
+		if obj := info.Uses[e]; isHole[obj] {
+			return fmt.Sprintf("any expression assignable to %s (the pattern is a bare hole)", types.TypeString(obj.Type(), qual))
+		}
+		return fmt.Sprintf("references to %s", e.Name)
+	case *ast.SelectorExpr:
+		if _, ok := info.Selections[e]; !ok {
+			return fmt.Sprintf("references to %s.%s", types.ExprString(e.X), e.Sel.Name)
+		}
+		return fmt.Sprintf("selections of .%s", e.Sel.Name)
+	case *ast.CallExpr:
+		if info.Types[e.Fun].IsType() {
+			return fmt.Sprintf("conversions to %s", types.TypeString(info.TypeOf(e.Fun), qual))
+		}
+		variadic := ""
+		if e.Ellipsis.IsValid() {
+			variadic = ", the last one spread with ..."
+		}
+		return fmt.Sprintf("calls of %s with %d argument(s)%s", types.ExprString(e.Fun), len(e.Args), variadic)
+	case *ast.BinaryExpr:
+		return fmt.Sprintf("binary %s expressions", e.Op)
+	case *ast.UnaryExpr:
+		return fmt.Sprintf("unary %s expressions", e.Op)
+	case *ast.StarExpr:
+		return "pointer indirections"
+	case *ast.CompositeLit:
+		return fmt.Sprintf("composite literals of type %s with %d element(s)", types.TypeString(info.TypeOf(e), qual), len(e.Elts))
+	case *ast.BasicLit:
+		return fmt.Sprintf("literals with the constant value %s", e.Value)
+	case *ast.IndexExpr:
+		return "index expressions"
+	case *ast.SliceExpr:
+		return "slice expressions"
+	case *ast.TypeAssertExpr:
+		return fmt.Sprintf("type assertions to %s", types.TypeString(info.TypeOf(e.Type), qual))
+	case *ast.FuncLit:
+		return "function literals, which never match"
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", e), "*ast.") + " nodes"
+}
+
+// nodeString formats n as Go source.
+func nodeString(fSet *token.FileSet, n ast.Node) string {
+	if n == nil {
+		return "<missing>"
+	}
+	var node interface{} = n
+	if stmts, ok := n.(eg.Stmts); ok {
+		node = []ast.Stmt(stmts) // the match of a statement template, printed a statement to a line
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fSet, node); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestExplain(t *testing.T) {
+	runCommandCases(t, "explain")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+)
+
+const exportRFUsage = `Usage: eg export-rf -t template.go
+
+Prints a script for the rf refactoring tool (rsc.io/rf) equivalent to the
+template: an ex block declaring the template's imports and its wildcards, as
+variables of their types, and rewriting before to after. A template which rf
+can't express fails, with each of the reasons: after statements preceding its
+result, variadic wildcards, renamed imports, or references to the template's
+own package, other than to the wildcards, which an ex block can't declare.
+`
+
+func exportRFMain(args []string) error {
+	fs := flag.NewFlagSet("export-rf", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, exportRFUsage) }
+	tmplPath := fs.String("t", "", "template.go file to export")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplPath == "" {
+		return errors.New("no -t template.go file specified")
+	}
+
+	fSet := token.NewFileSet()
+	tmplPkg, tmplFile, err := loadTemplate(fSet, *tmplPath)
+	if err != nil {
+		return err
+	}
+	if _, err := eg.NewTransformer(fSet, tmplPkg.Types, tmplFile, tmplPkg.TypesInfo, false); err != nil {
+		return err
+	}
+	script, err := rfScript(fSet, tmplPkg.Types, tmplPkg.TypesInfo, tmplFile)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(script)
+	return err
+}
+
+// rfScript returns the rf script equivalent to the template in tmplFile, which NewTransformer accepts, or an error
+// giving each of the reasons rf can't express it.
+func rfScript(fSet *token.FileSet, tmplPkg *types.Package, info *types.Info, tmplFile *ast.File) ([]byte, error) {
+	var reasons []string
+	before, after := templateFuncs(tmplFile)
+	beforeExpr, afterExpr := rfExpr(before), rfExpr(after)
+	if beforeExpr == nil {
+		reasons = append(reasons, "it's a statement template, and an ex block rewrites expressions")
+	} else if afterExpr == nil {
+		reasons = append(reasons, "after has statements preceding its result, which an ex block can't insert")
+	}
+
+	params := make(map[types.Object]bool)
+	for _, field := range before.Type.Params.List {
+		if _, ok := field.Type.(*ast.Ellipsis); ok {
+			reasons = append(reasons, fmt.Sprintf("the wildcards %s are variadic", fieldNames(field)))
+		}
+		for _, name := range field.Names {
+			params[info.Defs[name]] = true
+		}
+	}
+	// the wildcards of after are those of before, by position, but an ex block names them once
+	renamed := make(map[types.Object]string)
+	var i int
+	for _, field := range after.Type.Params.List {
+		for _, name := range field.Names {
+			if bname := paramName(before, i); bname != name.Name {
+				renamed[info.Defs[name]] = bname
+			}
+			params[info.Defs[name]] = true
+			i++
+		}
+	}
+
+	for _, imp := range tmplFile.Imports {
+		if imp.Name != nil && imp.Name.Name != "_" {
+			reasons = append(reasons, fmt.Sprintf("the import of %s is renamed %s", imp.Path.Value, imp.Name.Name))
+		}
+	}
+	own := make(map[string]bool)
+	for _, fn := range []*ast.FuncDecl{before, after} {
+		ast.Inspect(fn.Type, func(n ast.Node) bool { return rfOwnRefs(n, info, tmplPkg, params, own) })
+		ast.Inspect(fn.Body, func(n ast.Node) bool { return rfOwnRefs(n, info, tmplPkg, params, own) })
+	}
+	for name := range own {
+		reasons = append(reasons, fmt.Sprintf("it refers to %s, declared by the template", name))
+	}
+	if len(reasons) > 0 {
+		sort.Strings(reasons)
+		return nil, fmt.Errorf("rf can't express the template:\n\t%s", strings.Join(reasons, "\n\t"))
+	}
+
+	// the wildcards of after are renamed in place, since the template is only loaded to be exported
+	if len(renamed) > 0 {
+		ast.Inspect(afterExpr, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if name, ok := renamed[info.Uses[id]]; ok {
+					id.Name = name
+				}
+			}
+			return true
+		})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("ex {\n")
+	for _, imp := range tmplFile.Imports {
+		if imp.Name == nil {
+			fmt.Fprintf(&buf, "\timport %s;\n", imp.Path.Value)
+		}
+	}
+	for _, field := range before.Type.Params.List {
+		fmt.Fprintf(&buf, "\tvar %s %s;\n", fieldNames(field), nodeString(fSet, field.Type))
+	}
+	fmt.Fprintf(&buf, "\t%s -> %s;\n", nodeString(fSet, beforeExpr), nodeString(fSet, afterExpr))
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+// rfExpr returns the sole expression of fn, a before or after function, which it returns or calls as a statement,
+// or nil if it has other statements.
+func rfExpr(fn *ast.FuncDecl) ast.Expr {
+	if len(fn.Body.List) != 1 {
+		return nil
+	}
+	switch s := fn.Body.List[0].(type) {
+	case *ast.ReturnStmt:
+		if len(s.Results) == 1 {
+			return s.Results[0]
+		}
+	case *ast.ExprStmt:
+		return s.X
+	}
+	return nil
+}
+
+// rfOwnRefs records in own the names of the objects of tmplPkg which n refers to, other than the wildcards in
+// params, for ast.Inspect.
+func rfOwnRefs(n ast.Node, info *types.Info, tmplPkg *types.Package, params map[types.Object]bool, own map[string]bool) bool {
+	if id, ok := n.(*ast.Ident); ok {
+		if obj := info.Uses[id]; obj != nil && obj.Pkg() == tmplPkg && !params[obj] && obj.Parent() == tmplPkg.Scope() {
+			own[obj.Name()] = true
+		}
+	}
+	return true
+}
+
+// fieldNames returns the names of field, separated by commas.
+func fieldNames(field *ast.Field) string {
+	names := make([]string, len(field.Names))
+	for i, name := range field.Names {
+		names[i] = name.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// paramName returns the name of the i'th parameter of fn.
+func paramName(fn *ast.FuncDecl, i int) string {
+	for _, field := range fn.Type.Params.List {
+		if i < len(field.Names) {
+			return field.Names[i].Name
+		}
+		i -= len(field.Names)
+	}
+	return ""
+}
@@ -0,0 +1,8 @@
+package main
+
+import "testing"
+
+// TestExportRF checks the rf scripts printed for templates, and the reasons given for one rf can't express.
+func TestExportRF(t *testing.T) {
+	runCommandCases(t, "export-rf")
+}
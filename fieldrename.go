@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// checkConversions warns of conversions between recv, the type of the field being renamed, and other struct types,
+// which need the fields of both to have the same names.
+func (r *renamer) checkConversions(recv types.Type) {
+	isRecv := func(t types.Type) bool {
+		if p, ok := t.(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		return types.Identical(t, recv)
+	}
+	for _, pkg := range r.m.pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) != 1 {
+					return true
+				}
+				if tv, ok := pkg.TypesInfo.Types[call.Fun]; ok && tv.IsType() {
+					to, from := tv.Type, pkg.TypesInfo.TypeOf(call.Args[0])
+					if from != nil && isRecv(to) != isRecv(from) {
+						fmt.Fprintf(os.Stderr, "%s: warning: converting %s to %s will fail once the field is renamed\n",
+							r.m.position(call), from, to)
+					}
+				}
+				return true
+			})
+		}
+	}
+}
+
+// renameTags renames the values of the struct tags of the field being renamed which match its name.
+func (r *renamer) renameTags() {
+	pkg := r.pkgOf(r.obj.Pkg().Path())
+	for _, file := range pkg.Syntax {
+		for id, obj := range pkg.TypesInfo.Defs {
+			if obj != r.obj || id.Pos() < file.Pos() || id.Pos() > file.End() {
+				continue
+			}
+			path, _ := astutil.PathEnclosingInterval(file, id.Pos(), id.End())
+			field, _ := path[1].(*ast.Field)
+			if field == nil || field.Tag == nil || len(path) < 4 {
+				continue
+			}
+			st, _ := path[3].(*ast.StructType)
+			tag, err := strconv.Unquote(field.Tag.Value)
+			if err != nil || st == nil {
+				continue
+			}
+			if renamed, ok := renameTag(tag, r.obj.Name(), r.newName, siblingTags(st, field)); ok {
+				field.Tag.Value = quoteTag(renamed, field.Tag.Value)
+				r.m.touch(pkg, file)
+			}
+		}
+	}
+}
+
+// siblingTags returns the tags of the fields of st other than field.
+func siblingTags(st *ast.StructType, field *ast.Field) []string {
+	var tags []string
+	for _, f := range st.Fields.List {
+		if f == field || f.Tag == nil {
+			continue
+		}
+		if tag, err := strconv.Unquote(f.Tag.Value); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// quoteTag quotes tag as orig, the literal it replaces, was quoted: in backquotes if possible.
+func quoteTag(tag, orig string) string {
+	if strings.HasPrefix(orig, "`") && !strings.Contains(tag, "`") {
+		return "`" + tag + "`"
+	}
+	return strconv.Quote(tag)
+}
+
+// nameStyles are the ways a tag value may spell a field's name, in order of preference.
+var nameStyles = []struct {
+	name  string
+	apply func(string) string
+}{
+	{"exact", func(s string) string { return s }},
+	{"lowerCamel", lowerFirst},
+	{"snake", snakeCase},
+	{"lower", strings.ToLower},
+}
+
+// renameTag renames the values in the struct tag which spell old, in one of the nameStyles, as new in the same
+// style. Where a value fits several styles, e.g. a renamed field Count's "count", the style used by the sibling
+// tags of the same key, if any, wins.
+func renameTag(tag, old, new string, siblings []string) (string, bool) {
+	var b strings.Builder
+	var changed bool
+	for tag != "" {
+		// as in reflect.StructTag.Lookup
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		b.WriteString(tag[:i])
+		tag = tag[i:]
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			b.WriteString(tag)
+			break
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			b.WriteString(key + ":" + tag)
+			break
+		}
+		quoted := tag[:i+1]
+		tag = tag[i+1:]
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			b.WriteString(key + ":" + quoted)
+			continue
+		}
+		name, opts := value, ""
+		if comma := strings.Index(value, ","); comma >= 0 {
+			name, opts = value[:comma], value[comma:]
+		}
+		if style := tagStyle(name, old, key, siblings); style != nil {
+			quoted = strconv.Quote(style(new) + opts)
+			changed = true
+		}
+		b.WriteString(key + ":" + quoted)
+	}
+	return b.String(), changed
+}
+
+// tagStyle returns the style in which name spells old, preferring that of the siblings' values for key, or nil if
+// it doesn't.
+func tagStyle(name, old, key string, siblings []string) func(string) string {
+	var matches []int
+	for i, style := range nameStyles {
+		if name != "" && style.apply(old) == name {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	for _, tag := range siblings {
+		value, ok := lookupTag(tag, key)
+		if !ok {
+			continue
+		}
+		if strings.Contains(value, "_") {
+			for _, i := range matches {
+				if nameStyles[i].name == "snake" {
+					return nameStyles[i].apply
+				}
+			}
+		}
+	}
+	return nameStyles[matches[0]].apply
+}
+
+// lookupTag returns the name in the value of key in tag, if it has one.
+func lookupTag(tag, key string) (string, bool) {
+	value, ok := reflect.StructTag(tag).Lookup(key)
+	if comma := strings.Index(value, ","); comma >= 0 {
+		value = value[:comma]
+	}
+	return value, ok
+}
+
+// lowerFirst returns s with its leading upper case run lowered, as in userID for UserID, or url for URL.
+func lowerFirst(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if !unicode.IsUpper(r) {
+			break
+		}
+		// in URLParser, the P begins the next word
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(r)
+	}
+	return string(runes)
+}
+
+// snakeCase returns s in lower case with its words separated by underscores, as in user_id for UserID.
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// forbiddenContexts are the contexts a config may forbid rewrites in, with forbid: init functions, //go:nosplit
+// functions, and functions, or package-level declarations, which use package unsafe.
+var forbiddenContexts = []string{"init", "nosplit", "unsafe"}
+
+func validContext(context string) bool {
+	for _, c := range forbiddenContexts {
+		if c == context {
+			return true
+		}
+	}
+	return false
+}
+
+// forbidden returns why the config forbids rewriting the match at pos in file, or "" if it doesn't: the file is
+// frozen, or the match is in a context it forbids. -allow-forbidden overrides it.
+func (c *repoConfig) forbidden(fSet *token.FileSet, file *ast.File, pos token.Pos) string {
+	if *allowFlag {
+		return ""
+	}
+	if c.matchAny(c.Frozen, fSet.File(pos).Name()) {
+		return "its file is frozen"
+	}
+	if len(c.Forbid) == 0 {
+		return ""
+	}
+	var decl ast.Decl
+	for _, d := range file.Decls {
+		if d.Pos() <= pos && pos < d.End() {
+			decl = d
+		}
+	}
+	if decl == nil {
+		return ""
+	}
+	fn, _ := decl.(*ast.FuncDecl)
+	for _, context := range c.Forbid {
+		switch context {
+		case "init":
+			if fn != nil && fn.Recv == nil && fn.Name.Name == "init" {
+				return "it's in an init function"
+			}
+		case "nosplit":
+			if fn != nil && fn.Doc != nil {
+				for _, comment := range fn.Doc.List {
+					if strings.HasPrefix(comment.Text, "//go:nosplit") {
+						return "it's in a //go:nosplit function"
+					}
+				}
+			}
+		case "unsafe":
+			if usesUnsafe(file, decl) {
+				if fn != nil {
+					return "it's in a function using unsafe"
+				}
+				return "it's in a declaration using unsafe"
+			}
+		}
+	}
+	return ""
+}
+
+// usesUnsafe reports whether decl, of file, refers to package unsafe.
+func usesUnsafe(file *ast.File, decl ast.Decl) bool {
+	name := ""
+	for _, imp := range file.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path == "unsafe" {
+			name = "unsafe"
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+		}
+	}
+	if name == "" || name == "_" {
+		return false
+	}
+	var uses bool
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Name == name {
+				uses = true
+			}
+		}
+		return !uses
+	})
+	return uses
+}
+
+// reportForbidden reports the match at pos which isn't rewritten, since the config forbids it, for reason.
+func reportForbidden(fSet *token.FileSet, pos token.Pos, reason string) {
+	fmt.Fprintf(os.Stderr, "%s: matches, but isn't rewritten, since %s, which the config forbids; -allow-forbidden overrides it\n",
+		reportPos(fSet, pos), reason)
+}
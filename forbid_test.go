@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestForbid(t *testing.T) {
+	runMainCases(t, "forbid")
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"io"
+	"os"
+)
+
+const toMethodUsage = `Usage: eg to-method -func path.F [-name M] [-w] <packages>...
+
+Converts a function whose first parameter is of a named type T declared in the
+same package, or a pointer to one, into a method of T, rewriting calls
+pkg.F(x, args) as x.F(args) throughout the loaded packages. Other uses of the
+function become the method expression pkg.T.F.
+`
+
+const toFuncUsage = `Usage: eg to-func -func path.T.M [-name F] [-w] <packages>...
+
+Converts a method into a function taking the receiver as its first parameter,
+rewriting calls x.M(args) as pkg.M(x, args) throughout the loaded packages,
+and the method expression pkg.T.M as the function. Method values, and calls
+through interfaces, can't be rewritten; types may no longer implement
+interfaces.
+`
+
+func toMethodMain(args []string) error {
+	fs := flag.NewFlagSet("to-method", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, toMethodUsage) }
+	funcFlag := fs.String("func", "", "the function, as path.F")
+	nameFlag := fs.String("name", "", "the name of the method (by default, that of the function)")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *funcFlag == "" {
+		return errors.New("-func is required")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	fn, err := m.lookupFunc(*funcFlag)
+	if err != nil {
+		return err
+	}
+	decl, file, pkg := m.decl(fn)
+	if decl == nil {
+		return fmt.Errorf("the declaration of %s isn't among the loaded packages", *funcFlag)
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() != nil {
+		return fmt.Errorf("%s is already a method", *funcFlag)
+	}
+	name := fn.Name()
+	if *nameFlag != "" {
+		name = *nameFlag
+	}
+
+	// the first parameter must be of a type which can have methods declared here
+	if sig.Params().Len() == 0 || sig.Variadic() && sig.Params().Len() == 1 {
+		return fmt.Errorf("%s has no parameter to become the receiver", *funcFlag)
+	}
+	recvType := sig.Params().At(0).Type()
+	ptr, isPtr := recvType.(*types.Pointer)
+	named, ok := recvType.(*types.Named)
+	if isPtr {
+		named, ok = ptr.Elem().(*types.Named)
+	}
+	if !ok || named.Obj().Pkg() != fn.Pkg() {
+		return fmt.Errorf("the first parameter of %s isn't of a named type declared in %s, or a pointer to one",
+			*funcFlag, fn.Pkg().Path())
+	}
+	switch named.Underlying().(type) {
+	case *types.Interface, *types.Pointer:
+		return fmt.Errorf("%s can't have methods", named)
+	}
+	if other, _, _ := types.LookupFieldOrMethod(types.NewPointer(named), false, fn.Pkg(), name); other != nil {
+		return fmt.Errorf("%s: %s already has a field or method named %s", m.fSet.Position(other.Pos()), named, name)
+	}
+
+	var failed bool
+	type edit struct {
+		u    use
+		call *ast.CallExpr
+	}
+	var edits []edit
+	for _, u := range m.uses(fn) {
+		call := u.call()
+		if call != nil && len(call.Args) == 1 && sig.Params().Len() > 1 {
+			fmt.Fprintf(os.Stderr, "%s: can't convert a call with a multi-valued argument\n", m.position(call))
+			failed = true
+			continue
+		}
+		edits = append(edits, edit{u, call})
+	}
+
+	// the first parameter becomes the receiver
+	first := decl.Type.Params.List[0]
+	recv := &ast.Field{Type: first.Type}
+	if len(first.Names) > 0 {
+		recv.Names = first.Names[:1]
+	}
+	removeField(decl.Type.Params, 0)
+	decl.Recv = &ast.FieldList{Opening: decl.Name.Pos(), List: []*ast.Field{recv}, Closing: decl.Name.Pos()}
+	decl.Name.Name = name
+	m.touch(pkg, file)
+
+	for _, e := range edits {
+		u := e.u
+		if e.call == nil {
+			// a method expression has the function's type
+			typ := types.TypeString(named, m.qualifier(u.pkg, u.file))
+			if isPtr {
+				typ = "(*" + typ + ")"
+			}
+			expr, err := m.parseExpr(u.pkg, u.file, u.id.Pos(), typ+"."+name, nil)
+			if err != nil {
+				return err
+			}
+			parent, n := qualifiedIdent(u)
+			replaceNode(parent, n, expr)
+			m.touch(u.pkg, u.file)
+			continue
+		}
+		x := receiverExpr(e.call.Args[0], isPtr)
+		e.call.Fun = &ast.SelectorExpr{X: x, Sel: &ast.Ident{NamePos: e.call.Args[0].End(), Name: name}}
+		e.call.Args = e.call.Args[1:]
+		m.touch(u.pkg, u.file)
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some uses couldn't be rewritten")
+	}
+	return nil
+}
+
+// qualifiedIdent returns the qualified identifier of which the use is the name, or its identifier when it's
+// unqualified, with its parent.
+func qualifiedIdent(u use) (ast.Node, ast.Node) {
+	if sel, ok := u.path[1].(*ast.SelectorExpr); ok && sel.Sel == u.id {
+		return u.path[2], sel
+	}
+	return u.path[1], u.id
+}
+
+// receiverExpr returns the argument arg, passed as the receiver of a method, as the operand of a selector: &v
+// becomes v for a pointer receiver, and other expressions which aren't primary are parenthesized.
+func receiverExpr(arg ast.Expr, ptrRecv bool) ast.Expr {
+	if un, ok := arg.(*ast.UnaryExpr); ok && ptrRecv && un.Op == token.AND {
+		if _, ok := astutil.Unparen(un.X).(*ast.CompositeLit); !ok {
+			return un.X
+		}
+	}
+	switch arg.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.CallExpr, *ast.IndexExpr, *ast.SliceExpr, *ast.ParenExpr,
+		*ast.TypeAssertExpr, *ast.BasicLit:
+		return arg
+	}
+	return &ast.ParenExpr{Lparen: arg.Pos(), X: arg, Rparen: arg.End()}
+}
+
+func toFuncMain(args []string) error {
+	fs := flag.NewFlagSet("to-func", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, toFuncUsage) }
+	funcFlag := fs.String("func", "", "the method, as path.T.M")
+	nameFlag := fs.String("name", "", "the name of the function (by default, that of the method)")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *funcFlag == "" {
+		return errors.New("-func is required")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	fn, err := m.lookupFunc(*funcFlag)
+	if err != nil {
+		return err
+	}
+	decl, file, pkg := m.decl(fn)
+	if decl == nil || decl.Recv == nil {
+		return fmt.Errorf("%s isn't a method declared in the loaded packages", *funcFlag)
+	}
+	sig := fn.Type().(*types.Signature)
+	_, ptrRecv := sig.Recv().Type().(*types.Pointer)
+	name := fn.Name()
+	if *nameFlag != "" {
+		name = *nameFlag
+	}
+	if other := fn.Pkg().Scope().Lookup(name); other != nil {
+		return fmt.Errorf("%s: %s already declares %s", m.fSet.Position(other.Pos()), fn.Pkg().Path(), name)
+	}
+	for _, f := range pkg.Syntax {
+		if scope := pkg.TypesInfo.Scopes[f]; scope != nil && scope.Lookup(name) != nil {
+			return fmt.Errorf("%s: a package named %s is imported", m.position(f), name)
+		}
+	}
+
+	var failed bool
+	type edit struct {
+		u    use
+		sel  *ast.SelectorExpr
+		call *ast.CallExpr
+	}
+	var edits []edit
+	for _, u := range m.uses(fn) {
+		sel, _ := u.path[1].(*ast.SelectorExpr)
+		selection := u.pkg.TypesInfo.Selections[sel]
+		call := u.call()
+		switch {
+		case selection == nil:
+			fmt.Fprintf(os.Stderr, "%s: can't convert this use of %s; update it manually\n", m.position(u.id), u.id.Name)
+			failed = true
+			continue
+		case selection.Kind() == types.MethodExpr:
+			if _, ptr := selection.Recv().(*types.Pointer); ptr != ptrRecv {
+				fmt.Fprintf(os.Stderr, "%s: method expression %s has a different receiver type to the method; "+
+					"update it manually\n", m.position(sel), nodeString(m.fSet, sel))
+				failed = true
+				continue
+			}
+			call = nil
+		case call == nil:
+			fmt.Fprintf(os.Stderr, "%s: method value %s can't be converted; update it manually\n", m.position(sel),
+				nodeString(m.fSet, sel))
+			failed = true
+			continue
+		}
+		if u.pkg == pkg {
+			scope := pkg.Types.Scope().Innermost(u.id.Pos())
+			if _, other := scope.LookupParent(name, u.id.Pos()); other != nil && other.Parent() != pkg.Types.Scope() {
+				return fmt.Errorf("%s: %s would refer to the %s declared at %s", m.position(u.id), name, other.Name(),
+					m.fSet.Position(other.Pos()))
+			}
+		}
+		edits = append(edits, edit{u, sel, call})
+	}
+	fmt.Fprintf(os.Stderr, "warning: types may no longer implement interfaces with a method %s\n", fn.Name())
+
+	// the receiver becomes the first parameter
+	recv := decl.Recv.List[0]
+	params := decl.Type.Params
+	switch named := len(recv.Names) > 0; {
+	case len(params.List) == 0:
+	case named && len(params.List[0].Names) == 0:
+		for _, f := range params.List {
+			f.Names = []*ast.Ident{{NamePos: f.Type.Pos(), Name: "_"}}
+		}
+	case !named && len(params.List[0].Names) > 0:
+		recv.Names = []*ast.Ident{{NamePos: recv.Type.Pos(), Name: "_"}}
+	}
+	insertField(params, 0, recv)
+	decl.Recv = nil
+	decl.Name.Name = name
+	m.touch(pkg, file)
+
+	for _, e := range edits {
+		u := e.u
+		fun := &ast.Ident{NamePos: e.sel.Pos(), Name: name}
+		var qualified ast.Expr = fun
+		if q := m.qualifier(u.pkg, u.file)(fn.Pkg()); q != "" {
+			qualified = &ast.SelectorExpr{X: &ast.Ident{NamePos: e.sel.Pos(), Name: q}, Sel: fun}
+		}
+		if e.call == nil {
+			replaceNode(u.path[2], e.sel, qualified)
+		} else {
+			x := implicitSelections(u.pkg.TypesInfo, e.sel, ptrRecv)
+			e.call.Fun = qualified
+			e.call.Args = append([]ast.Expr{x}, e.call.Args...)
+		}
+		m.touch(u.pkg, u.file)
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some uses couldn't be rewritten")
+	}
+	return nil
+}
+
+// implicitSelections returns the receiver of the method value sel, with the selections of embedded fields, and
+// the address taken or pointer indirected, which the selector leaves implicit.
+func implicitSelections(info *types.Info, sel *ast.SelectorExpr, ptrRecv bool) ast.Expr {
+	x := sel.X
+	t := info.TypeOf(sel.X)
+	index := info.Selections[sel].Index()
+	for _, i := range index[:len(index)-1] {
+		if p, ok := t.Underlying().(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		field := t.Underlying().(*types.Struct).Field(i)
+		x = &ast.SelectorExpr{X: x, Sel: &ast.Ident{NamePos: sel.Sel.Pos(), Name: field.Name()}}
+		t = field.Type()
+	}
+	_, isPtr := t.Underlying().(*types.Pointer)
+	switch {
+	case ptrRecv && !isPtr:
+		if star, ok := x.(*ast.StarExpr); ok {
+			return star.X
+		}
+		return &ast.UnaryExpr{OpPos: x.Pos(), Op: token.AND, X: x}
+	case !ptrRecv && isPtr:
+		return &ast.StarExpr{Star: x.Pos(), X: x}
+	}
+	return x
+}
@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestToMethod(t *testing.T) {
+	runCommandCases(t, "to-method")
+}
+
+func TestToFunc(t *testing.T) {
+	runCommandCases(t, "to-func")
+}
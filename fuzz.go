@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fuzzUsage = `Usage: eg fuzz -t template.go [-n programs] [-seed seed]
+
+Hardens the matcher by generating random well-typed programs containing
+planted instances of the template's pattern, in varied contexts and with
+varied bindings for its holes, and verifying that every instance is found and
+that the rewritten programs still parse and type check. Failing programs are
+saved to the temporary directory for inspection.
+`
+
+func fuzzMain(args []string) error {
+	fs := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, fuzzUsage) }
+	tmplFlag := fs.String("t", "", "template.go file specifying the refactoring")
+	n := fs.Int("n", 100, "number of programs to generate")
+	seed := fs.Int64("seed", 0, "seed of the generator (by default, the current time)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplFlag == "" {
+		return errors.New("no -t template.go file specified")
+	}
+	tmplPath, err := filepath.Abs(*tmplFlag)
+	if err != nil {
+		return fmt.Errorf("unable to resolve tmpl flag: %v", *tmplFlag)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	g, err := newFuzzer(tmplPath)
+	if err != nil {
+		return err
+	}
+	fSet := token.NewFileSet()
+	tmplPkg, tmplFile, imp, err := loadTemplateWith(fSet, tmplPath, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("fuzzing %s with seed %d\n", tmplPath, *seed)
+	rng := rand.New(rand.NewSource(*seed))
+	var failures, planted int
+	for i := 0; i < *n; i++ {
+		src, count := g.program(rng)
+		planted += count
+		if err := fuzzOne(fSet, tmplPkg.Types, tmplFile, tmplPkg.TypesInfo, imp, src, count); err != nil {
+			failures++
+			saved := filepath.Join(os.TempDir(), fmt.Sprintf("eg-fuzz-%d-%d.go", *seed, i))
+			if werr := ioutil.WriteFile(saved, src, 0644); werr != nil {
+				saved = werr.Error()
+			}
+			fmt.Printf("--- FAIL: program %d (%s)\n\t%v\n", i, saved, err)
+			if _, ok := err.(illTyped); ok {
+				return fmt.Errorf("template can't be fuzzed: the generated programs don't type check")
+			}
+		}
+	}
+	if failures > 0 {
+		fmt.Println("FAIL")
+		// returned rather than exiting, so the run still ends through endRun, releasing its lock and running its hooks
+		return fmt.Errorf("%d of %d programs failed", failures, *n)
+	}
+	fmt.Printf("ok\t%d programs, %d planted instances\n", *n, planted)
+	return nil
+}
+
+// illTyped is the error of a generated program which didn't type check prior to transformation, which indicates a
+// problem with the generator or template rather than with the matcher.
+type illTyped struct{ error }
+
+// fuzzOne applies the template to the program src, verifying that it finds exactly the count planted instances and
+// that the output is still well typed.
+func fuzzOne(fSet *token.FileSet, tmplPkg *types.Package, tmplFile *ast.File, tmplInfo *types.Info, imp types.Importer, src []byte, count int) error {
+	f, err := parser.ParseFile(fSet, "fuzz.go", src, parser.ParseComments)
+	if err != nil {
+		return illTyped{err}
+	}
+	pkg, info, err := checkFile(fSet, f, imp)
+	if err != nil {
+		return illTyped{err}
+	}
+
+	xform, err := eg.NewTransformer(fSet, tmplPkg, tmplFile, tmplInfo, false)
+	if err != nil {
+		return err
+	}
+	if n := xform.Transform(info, pkg, f); n != count {
+		return fmt.Errorf("found %d matches of %d planted instances", n, count)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fSet, f); err != nil {
+		return fmt.Errorf("formatting output: %v", err)
+	}
+	// the output is checked afresh, but still within the template's type universe. Soft errors are tolerated,
+	// since the transformer doesn't remove imports which it leaves unused.
+	outSet := token.NewFileSet()
+	out, err := parser.ParseFile(outSet, "fuzz.go", buf.Bytes(), 0)
+	if err != nil {
+		return fmt.Errorf("output doesn't parse: %v", err)
+	}
+	var hard error
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); (!ok || !terr.Soft) && hard == nil {
+				hard = err
+			}
+		},
+	}
+	conf.Check(out.Name.Name, outSet, []*ast.File{out}, nil)
+	if hard != nil {
+		return fmt.Errorf("output doesn't type check: %v", hard)
+	}
+	return nil
+}
+
+// A fuzzer generates programs containing instances of a template's pattern.
+type fuzzer struct {
+	params  string            // the parameter list of before, spelled as in the template
+	holes   []fuzzHole        // the holes of the pattern, in declaration order
+	pattern string            // the source of the pattern
+	refs    []holeRef         // the occurrences of holes in pattern, in order
+	imports map[string]string // the template's imports, by package name
+	results int               // the number of results of the pattern
+}
+
+type fuzzHole struct {
+	name string
+	kind types.BasicKind // types.Invalid unless the hole's type is a predeclared basic type
+}
+
+// holeRef is an occurrence of a hole in the pattern, as a byte range of its source.
+type holeRef struct {
+	start, end int
+	hole       string
+}
+
+func newFuzzer(tmplPath string) (*fuzzer, error) {
+	src, err := ioutil.ReadFile(tmplPath)
+	if err != nil {
+		return nil, err
+	}
+	fSet := token.NewFileSet()
+	f, err := parser.ParseFile(fSet, tmplPath, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	beforeDecl, _ := templateFuncs(f)
+	if beforeDecl == nil || beforeDecl.Body == nil {
+		return nil, errors.New("no 'before' func found in template")
+	}
+	if eg.IsStatementTemplate(beforeDecl) {
+		return nil, errors.New("statement templates can't be fuzzed, since the programs planted are expressions")
+	}
+	pattern := templateExprs(beforeDecl)
+	if pattern == nil {
+		return nil, errors.New("before must contain a single return or expression statement")
+	}
+	offset := func(p token.Pos) int { return fSet.Position(p).Offset }
+
+	g := &fuzzer{
+		params:  string(src[offset(beforeDecl.Type.Params.Pos()):offset(beforeDecl.Type.Params.End())]),
+		pattern: string(src[offset(pattern.Pos()):offset(pattern.End())]),
+		imports: make(map[string]string),
+	}
+	if beforeDecl.Type.Results != nil {
+		g.results = beforeDecl.Type.Results.NumFields()
+	}
+
+	params := make(map[*ast.Object]string)
+	for _, field := range beforeDecl.Type.Params.List {
+		kind := types.Invalid
+		if id, ok := field.Type.(*ast.Ident); ok {
+			kind = basicKinds[id.Name]
+		}
+		for _, name := range field.Names {
+			params[name.Obj] = name.Name
+			g.holes = append(g.holes, fuzzHole{name: name.Name, kind: kind})
+		}
+	}
+	ast.Inspect(pattern, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Obj != nil {
+			if name, ok := params[id.Obj]; ok {
+				start := offset(id.Pos()) - offset(pattern.Pos())
+				g.refs = append(g.refs, holeRef{start: start, end: start + len(id.Name), hole: name})
+			}
+		}
+		return true
+	})
+
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		g.imports[name] = imp.Path.Value
+	}
+	return g, nil
+}
+
+// basicKinds are the predeclared types for which the fuzzer can generate literal bindings.
+var basicKinds = map[string]types.BasicKind{
+	"bool":    types.Bool,
+	"int":     types.Int,
+	"int64":   types.Int64,
+	"uint":    types.Uint,
+	"float64": types.Float64,
+	"string":  types.String,
+}
+
+// program generates a program and reports how many pattern instances it planted in it.
+func (g *fuzzer) program(rng *rand.Rand) ([]byte, int) {
+	var body bytes.Buffer
+	count := 1 + rng.Intn(5)
+	for i := 0; i < count; i++ {
+		for j := rng.Intn(3); j > 0; j-- {
+			g.filler(&body, rng)
+		}
+		g.plant(&body, g.instance(rng), rng)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("package fuzz\n\n")
+	var names []string
+	for name := range g.imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ref := name + "."; strings.Contains(g.params, ref) || strings.Contains(body.String(), ref) {
+			fmt.Fprintf(&buf, "import %s %s\n", name, g.imports[name])
+		}
+	}
+	fmt.Fprintf(&buf, "\nfunc egSink(...interface{}) {}\n\nfunc egFuzz%s {\n%s}\n", g.params, body.String())
+	return buf.Bytes(), count
+}
+
+// instance returns the pattern with each hole bound to a generated expression, consistently across occurrences.
+func (g *fuzzer) instance(rng *rand.Rand) string {
+	bindings := make(map[string]string)
+	for _, h := range g.holes {
+		bindings[h.name] = g.binding(h, rng)
+	}
+	var buf strings.Builder
+	var last int
+	for _, ref := range g.refs {
+		buf.WriteString(g.pattern[last:ref.start])
+		buf.WriteString(bindings[ref.hole])
+		last = ref.end
+	}
+	buf.WriteString(g.pattern[last:])
+	return buf.String()
+}
+
+// binding generates an expression assignable to the type of h.
+func (g *fuzzer) binding(h fuzzHole, rng *rand.Rand) string {
+	if h.kind == types.Invalid || rng.Intn(3) == 0 {
+		if rng.Intn(2) == 0 {
+			return "(" + h.name + ")"
+		}
+		return h.name
+	}
+	switch h.kind {
+	case types.Bool:
+		return []string{"true", "false", "!" + h.name, "(" + h.name + " && true)"}[rng.Intn(4)]
+	case types.String:
+		return []string{strconv.Quote(fmt.Sprintf("s%d", rng.Intn(100))), "(" + h.name + ` + "x")`}[rng.Intn(2)]
+	case types.Float64:
+		return []string{"1.5", "(" + h.name + " * 2)"}[rng.Intn(2)]
+	default:
+		return []string{strconv.Itoa(rng.Intn(100)), "(" + h.name + " + 1)"}[rng.Intn(2)]
+	}
+}
+
+// plant writes a statement containing inst, in a randomly chosen context, to w.
+func (g *fuzzer) plant(w io.Writer, inst string, rng *rand.Rand) {
+	var stmt string
+	switch {
+	case g.results == 0:
+		stmt = inst
+	case g.results > 1:
+		stmt = strings.Repeat("_, ", g.results-1) + "_ = " + inst
+	case rng.Intn(2) == 0:
+		stmt = "_ = " + inst
+	default:
+		stmt = "egSink(" + inst + ")"
+	}
+	switch rng.Intn(4) {
+	case 0:
+		fmt.Fprintf(w, "\t%s\n", stmt)
+	case 1:
+		fmt.Fprintf(w, "\tfunc() {\n\t\t%s\n\t}()\n", stmt)
+	case 2:
+		fmt.Fprintf(w, "\tif len(%q) > 0 {\n\t\t%s\n\t}\n", "x", stmt)
+	default:
+		fmt.Fprintf(w, "\tfor egI := 0; egI < 1; egI++ {\n\t\t%s\n\t}\n", stmt)
+	}
+}
+
+// filler writes a statement which doesn't contain the pattern to w.
+func (g *fuzzer) filler(w io.Writer, rng *rand.Rand) {
+	if len(g.holes) > 0 && rng.Intn(2) == 0 {
+		fmt.Fprintf(w, "\t_ = %s\n", g.holes[rng.Intn(len(g.holes))].name)
+		return
+	}
+	fmt.Fprintf(w, "\tegSink(%d)\n", rng.Intn(100))
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+const genericUsage = `Usage: eg generic -func path.F [-param name]... [-w] <packages>...
+
+Converts the parameters of type interface{} (or any) of the function F to
+type parameters, e.g. rewriting
+
+	func Describe(v interface{}) string {
+		switch v := v.(type) {
+
+as
+
+	func Describe[T any](v T) string {
+		switch v := any(v).(type) {
+
+so calls pass their arguments' own types rather than boxing them. A parameter
+is converted only if each use of it within F is a type switch or assertion,
+which becomes one on any(v), or passes, assigns or returns it as an empty
+interface; with -param, only those named are, and it's an error if they can't
+be. Calls which can't infer the type arguments, since they pass nil, and uses
+of F as a value instantiate it with any. Every package referring to F must be
+in a module declaring go 1.18 or later, which added type parameters.
+`
+
+func genericMain(args []string) error {
+	fs := flag.NewFlagSet("generic", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, genericUsage) }
+	funcFlag := fs.String("func", "", "the function, as path.F")
+	var paramFlags arrayFlags
+	fs.Var(&paramFlags, "param", "a parameter to convert (repeatable; by default, all those which can be)")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *funcFlag == "" {
+		return errors.New("-func is required")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	fn, err := m.lookupFunc(*funcFlag)
+	if err != nil {
+		return err
+	}
+	g := &genericizer{m: m, fn: fn}
+	if err := g.check(); err != nil {
+		return err
+	}
+	if err := g.convert(paramFlags); err != nil {
+		return err
+	}
+	return m.emit(false)
+}
+
+// A genericizer converts the empty interface parameters of a function to type parameters.
+type genericizer struct {
+	m    *migration
+	fn   *types.Func
+	decl *ast.FuncDecl
+	file *ast.File
+	pkg  *packages.Package
+	uses []use
+}
+
+// check reports an error if fn can't have type parameters, or a package referring to it can't use them.
+func (g *genericizer) check() error {
+	sig := g.fn.Type().(*types.Signature)
+	if sig.Recv() != nil {
+		return fmt.Errorf("%s is a method, which can't have type parameters", g.fn.Name())
+	}
+	if sig.TypeParams().Len() > 0 {
+		return fmt.Errorf("%s has type parameters already", g.fn.Name())
+	}
+	g.decl, g.file, g.pkg = g.m.decl(g.fn)
+	if g.decl == nil || g.decl.Body == nil {
+		return fmt.Errorf("%s isn't declared with a body in the loaded packages", g.fn.Name())
+	}
+
+	g.uses = g.m.uses(g.fn)
+	versions := make(moduleVersions)
+	checked := map[*packages.Package]bool{g.pkg: true}
+	pkgs := []*packages.Package{g.pkg}
+	for _, u := range g.uses {
+		if !checked[u.pkg] {
+			checked[u.pkg] = true
+			pkgs = append(pkgs, u.pkg)
+		}
+	}
+	for _, pkg := range pkgs {
+		if len(pkg.GoFiles) == 0 {
+			continue
+		}
+		version, gomod, err := versions.lookup(filepath.Dir(pkg.GoFiles[0]))
+		if err != nil {
+			return err
+		}
+		if version != "" && goVersionLess(version, "1.18") {
+			return fmt.Errorf("%s refers to %s, but %s declares go %s, and type parameters need go 1.18", pkg.PkgPath,
+				g.fn.Name(), gomod, version)
+		}
+	}
+	return nil
+}
+
+// convert converts the parameters named, or if none are, all those which can be.
+func (g *genericizer) convert(names []string) error {
+	info := g.pkg.TypesInfo
+	want := make(map[string]bool)
+	for _, name := range names {
+		want[name] = true
+	}
+
+	converted := make(map[*types.Var]bool)
+	var asserts []*ast.TypeAssertExpr
+	for _, field := range g.decl.Type.Params.List {
+		for _, name := range field.Names {
+			p := info.Defs[name].(*types.Var)
+			if len(want) > 0 && !want[name.Name] {
+				continue
+			}
+			delete(want, name.Name)
+			if _, variadic := field.Type.(*ast.Ellipsis); variadic || !isEmptyInterface(p.Type()) {
+				if len(names) > 0 {
+					return fmt.Errorf("%s isn't a parameter of type interface{}", name.Name)
+				}
+				continue
+			}
+			a, err := g.paramUses(p)
+			if err != nil {
+				if len(names) > 0 {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "%v; leaving it\n", err)
+				continue
+			}
+			converted[p] = true
+			asserts = append(asserts, a...)
+		}
+	}
+	for name := range want {
+		return fmt.Errorf("%s has no parameter %s", g.fn.Name(), name)
+	}
+	if len(converted) == 0 {
+		return fmt.Errorf("%s has no parameters which can be converted", g.fn.Name())
+	}
+	if err := g.checkAny(g.pkg, g.decl.Pos()); err != nil {
+		return err
+	}
+
+	// each parameter converted gets a type parameter of its own, so fields declaring several are split
+	var tparams []*ast.Ident
+	var params []*ast.Field
+	for _, field := range g.decl.Type.Params.List {
+		var kept []*ast.Ident
+		for _, name := range field.Names {
+			if !converted[info.Defs[name].(*types.Var)] {
+				kept = append(kept, name)
+				continue
+			}
+			if len(kept) > 0 {
+				params = append(params, &ast.Field{Names: kept, Type: field.Type})
+				kept = nil
+			}
+			t := &ast.Ident{NamePos: name.Pos(), Name: g.m.freshName(g.pkg, g.decl.Body.Pos(), "T")}
+			tparams = append(tparams, &ast.Ident{NamePos: g.decl.Name.End(), Name: t.Name})
+			params = append(params, &ast.Field{Names: []*ast.Ident{name}, Type: t})
+		}
+		if len(kept) > 0 || len(field.Names) == 0 {
+			params = append(params, &ast.Field{Doc: field.Doc, Names: kept, Type: field.Type, Comment: field.Comment})
+		}
+	}
+	pos := g.decl.Name.End()
+	g.decl.Type.TypeParams = &ast.FieldList{
+		Opening: pos,
+		List:    []*ast.Field{{Names: tparams, Type: &ast.Ident{NamePos: pos, Name: "any"}}},
+		Closing: pos,
+	}
+	g.decl.Type.Params.List = params
+	for _, a := range asserts {
+		a.X = anyConversion(astutil.Unparen(a.X))
+	}
+	g.m.touch(g.pkg, g.file)
+
+	return g.instantiate(converted, len(tparams))
+}
+
+// paramUses returns the type assertions on the parameter p within fn, or an error if another of its uses within fn
+// requires it to be an interface.
+func (g *genericizer) paramUses(p *types.Var) ([]*ast.TypeAssertExpr, error) {
+	info := g.pkg.TypesInfo
+	var asserts []*ast.TypeAssertExpr
+	var err error
+	ast.Inspect(g.decl.Body, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || err != nil || info.Uses[id] != p {
+			return err == nil
+		}
+		path, _ := astutil.PathEnclosingInterval(g.file, id.Pos(), id.End())
+		var e ast.Expr = id
+		i := 1
+		for ; i < len(path); i++ {
+			paren, ok := path[i].(*ast.ParenExpr)
+			if !ok {
+				break
+			}
+			e = paren
+		}
+		if ta, ok := path[i].(*ast.TypeAssertExpr); ok && ta.X == e {
+			asserts = append(asserts, ta)
+			return true
+		}
+		if !isEmptyInterface(g.targetType(path[i], e)) {
+			err = fmt.Errorf("%s: %s is used as an interface{}, rather than only passed as one", g.m.position(id), p.Name())
+		}
+		return true
+	})
+	return asserts, err
+}
+
+// targetType returns the type to which the value of e, a child of parent, is converted implicitly, or nil if it
+// isn't, or it's not known.
+func (g *genericizer) targetType(parent ast.Node, e ast.Expr) types.Type {
+	info := g.pkg.TypesInfo
+	switch parent := parent.(type) {
+	case *ast.CallExpr:
+		sig, ok := info.TypeOf(parent.Fun).(*types.Signature)
+		if tv := info.Types[parent.Fun]; !ok || tv.IsType() || tv.IsBuiltin() {
+			return nil
+		}
+		for i, arg := range parent.Args {
+			if arg != e {
+				continue
+			}
+			if sig.Variadic() && i >= sig.Params().Len()-1 && !parent.Ellipsis.IsValid() {
+				return sig.Params().At(sig.Params().Len() - 1).Type().(*types.Slice).Elem()
+			}
+			if i < sig.Params().Len() {
+				return sig.Params().At(i).Type()
+			}
+		}
+	case *ast.AssignStmt:
+		if parent.Tok != token.ASSIGN || len(parent.Lhs) != len(parent.Rhs) {
+			return nil
+		}
+		for i, rhs := range parent.Rhs {
+			if rhs == e {
+				return info.TypeOf(parent.Lhs[i])
+			}
+		}
+	case *ast.ValueSpec:
+		if parent.Type != nil {
+			for _, v := range parent.Values {
+				if v == e {
+					return info.TypeOf(parent.Type)
+				}
+			}
+		}
+	case *ast.ReturnStmt:
+		results := g.fn.Type().(*types.Signature).Results()
+		for i, r := range parent.Results {
+			if r == e && len(parent.Results) == results.Len() && !g.inFuncLit(parent) {
+				return results.At(i).Type()
+			}
+		}
+	case *ast.CompositeLit:
+		switch t := info.TypeOf(parent).Underlying().(type) {
+		case *types.Slice:
+			return t.Elem()
+		case *types.Array:
+			return t.Elem()
+		}
+	case *ast.KeyValueExpr:
+		if parent.Value == e {
+			if lit, ok := g.enclosingLit(parent); ok {
+				switch t := info.TypeOf(lit).Underlying().(type) {
+				case *types.Map:
+					return t.Elem()
+				case *types.Slice:
+					return t.Elem()
+				case *types.Array:
+					return t.Elem()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// inFuncLit reports whether n is within a function literal within fn.
+func (g *genericizer) inFuncLit(n ast.Node) bool {
+	path, _ := astutil.PathEnclosingInterval(g.file, n.Pos(), n.End())
+	for _, p := range path {
+		switch p.(type) {
+		case *ast.FuncLit:
+			return true
+		case *ast.FuncDecl:
+			return false
+		}
+	}
+	return false
+}
+
+// enclosingLit returns the composite literal of which kv is an element.
+func (g *genericizer) enclosingLit(kv *ast.KeyValueExpr) (*ast.CompositeLit, bool) {
+	path, _ := astutil.PathEnclosingInterval(g.file, kv.Pos(), kv.End())
+	if len(path) < 2 {
+		return nil, false
+	}
+	lit, ok := path[1].(*ast.CompositeLit)
+	return lit, ok
+}
+
+// instantiate instantiates fn with any for each of its n type parameters where it's used as a value, or called
+// with nil for one of the parameters converted.
+func (g *genericizer) instantiate(converted map[*types.Var]bool, n int) error {
+	sig := g.fn.Type().(*types.Signature)
+	for _, u := range g.uses {
+		if call := u.call(); call != nil && !g.passesNil(u.pkg.TypesInfo, call, sig, converted) {
+			continue
+		}
+		var ref ast.Expr = u.id
+		parent := u.path[1]
+		if sel, ok := parent.(*ast.SelectorExpr); ok && sel.Sel == u.id {
+			ref, parent = sel, u.path[2]
+		}
+		if err := g.checkAny(u.pkg, ref.Pos()); err != nil {
+			return err
+		}
+		var args []ast.Expr
+		for i := 0; i < n; i++ {
+			args = append(args, &ast.Ident{NamePos: ref.End(), Name: "any"})
+		}
+		var inst ast.Expr = &ast.IndexExpr{X: ref, Lbrack: ref.End(), Index: args[0], Rbrack: ref.End()}
+		if n > 1 {
+			inst = &ast.IndexListExpr{X: ref, Lbrack: ref.End(), Indices: args, Rbrack: ref.End()}
+		}
+		// astutil.Apply predates index lists, so the reference is replaced directly
+		if !replaceChild(parent, ref, inst) {
+			return fmt.Errorf("%s: can't instantiate %s here", g.m.position(ref), g.fn.Name())
+		}
+		g.m.touch(u.pkg, u.file)
+	}
+	return nil
+}
+
+// passesNil reports whether call passes an untyped nil, from which no type argument can be inferred, for one of
+// the parameters converted, or passes the results of another call for them.
+func (g *genericizer) passesNil(info *types.Info, call *ast.CallExpr, sig *types.Signature, converted map[*types.Var]bool) bool {
+	if len(call.Args) == 1 && sig.Params().Len() > 1 {
+		return true
+	}
+	for i, arg := range call.Args {
+		if i < sig.Params().Len() && converted[sig.Params().At(i)] && info.Types[arg].IsNil() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAny reports an error if any doesn't refer to the predeclared type at pos in pkg.
+func (g *genericizer) checkAny(pkg *packages.Package, pos token.Pos) error {
+	scope := pkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		scope = pkg.Types.Scope()
+	}
+	if _, obj := scope.LookupParent("any", pos); obj != types.Universe.Lookup("any") {
+		return fmt.Errorf("%s: any refers to the %s declared at %s", g.m.fSet.Position(pos), obj.Name(),
+			g.m.fSet.Position(obj.Pos()))
+	}
+	return nil
+}
+
+// isEmptyInterface reports whether t is an interface without methods.
+func isEmptyInterface(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	iface, ok := t.Underlying().(*types.Interface)
+	return ok && iface.Empty()
+}
+
+// anyConversion returns the conversion of e to any.
+func anyConversion(e ast.Expr) ast.Expr {
+	fun := &ast.Ident{NamePos: e.Pos(), Name: "any"}
+	return &ast.CallExpr{Fun: fun, Lparen: e.Pos(), Args: []ast.Expr{e}, Rparen: e.End()}
+}
+
+// replaceChild replaces old, a child of parent, with new, and reports whether it was one.
+func replaceChild(parent, old, new ast.Node) bool {
+	v := reflect.ValueOf(parent).Elem()
+	oldV := reflect.ValueOf(old)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Interface, reflect.Ptr:
+			if f.Interface() == oldV.Interface() && reflect.TypeOf(new).AssignableTo(f.Type()) {
+				f.Set(reflect.ValueOf(new))
+				return true
+			}
+		case reflect.Slice:
+			for j := 0; j < f.Len(); j++ {
+				if e := f.Index(j); e.Interface() == oldV.Interface() && reflect.TypeOf(new).AssignableTo(e.Type()) {
+					e.Set(reflect.ValueOf(new))
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
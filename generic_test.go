@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestGeneric(t *testing.T) {
+	runCommandCases(t, "generic")
+}
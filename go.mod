@@ -1,5 +1,13 @@
 module github.com/jwilner/eg
 
-go 1.12
+go 1.26.0
 
-require golang.org/x/tools v0.0.0-20191018212557-ed542cd5b28a
+require (
+	golang.org/x/tools v0.50.0
+	gopkg.in/yaml.v2 v2.2.8
+)
+
+require (
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
+)
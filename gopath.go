@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gopathRoot returns the src directory of the GOPATH workspace enclosing dir, if it's in one and not in a module,
+// as the legacy trees which haven't migrated to modules are, or "" otherwise.
+func gopathRoot(dir string) string {
+	if moduleRoot(dir) != "" {
+		return ""
+	}
+	for _, p := range filepath.SplitList(build.Default.GOPATH) {
+		src := filepath.Join(p, "src")
+		if r, err := filepath.Rel(src, dir); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			return src
+		}
+	}
+	return ""
+}
+
+// useGOPATH makes the go commands eg runs, to load packages, load them in GOPATH mode, if dir is in a GOPATH
+// workspace and not a module, since the go command otherwise refuses to outside a module. It's left to the user if
+// they set GO111MODULE.
+func useGOPATH(dir string) error {
+	if os.Getenv("GO111MODULE") != "" {
+		return nil
+	}
+	src := gopathRoot(dir)
+	if src == "" {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "eg: %s isn't in a module, but in the GOPATH workspace %s; loading packages in GOPATH mode\n",
+		dir, filepath.Dir(src))
+	return os.Setenv("GO111MODULE", "off")
+}
@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+// TestGOPATH rewrites a package of a GOPATH workspace, outside any module, loading it in GOPATH mode, unless
+// GO111MODULE is set.
+func TestGOPATH(t *testing.T) {
+	runMainCases(t, "gopath")
+}
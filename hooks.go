@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// editInfo describes the edit of a file, for the placeholders of the edit hooks.
+type editInfo struct {
+	pkg      string      // the import path of the file's package, if it's Go source
+	template string      // the template applied, if the edit applies one
+	matches  int         // the number of its matches
+	found    []jsonMatch // the matches, for -json
+	gaps     []eg.Gap    // the lines the statement templates' replacements left blank, closed in printing it
+}
+
+// hookPolicies are the valid values of -hook-policy, with the first being the default.
+var hookPolicies = []string{"warn", "skip-file", "abort"}
+
+func validPolicy(policy string) bool {
+	for _, p := range hookPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// An abortError is the failure of a beforeedit hook under -hook-policy abort, which ends the run.
+type abortError struct{ error }
+
+// runBeforeHooks runs the beforeedit hooks for filename. A failing hook is reported, unless the -hook-policy
+// isn't warn, in which case it's returned, as an abortError under abort, and the rest aren't run.
+func runBeforeHooks(filename string, info editInfo) error {
+	if err := startRun(); err != nil {
+		return err
+	}
+	for _, hook := range beforeEditFlags {
+		err := runCmdOnFile(hook, filename, info)
+		if err == nil {
+			continue
+		}
+		switch *policyFlag {
+		case "skip-file":
+			return fmt.Errorf("%s: before edit hook %q failed (%s); not rewritten", filename, hook, err)
+		case "abort":
+			return abortError{fmt.Errorf("%s: before edit hook %q failed (%s); aborting", filename, hook, err)}
+		}
+		fmt.Fprintf(os.Stderr, "Warning: before edit hook %q failed (%s)\n", hook, err)
+	}
+	return nil
+}
+
+// runCmdOnFile runs the edit hook command flag for the file filename, replacing its placeholders in any word of the
+// command, including the first: "{}" or "{file}" by the file name, like find(1), "{pkg}" by the import path of its
+// package, "{template}" and "{matches}" by the template applied and its number of matches in the file, each empty
+// if not applicable, and "{tmpdir}" by a directory the hooks of the run share. The command is split into words at
+// spaces, except within single or double quotes, so that it may name paths containing them, or with -hook-shell is
+// run by the platform's shell. The hook's environment has the values too, as EG_FILE, EG_PACKAGE, EG_TEMPLATE and
+// EG_MATCH_COUNT, along with EG_RUN_ID, which identifies the run.
+func runCmdOnFile(flag string, filename string, info editInfo) error {
+	vars, err := hookVars(flag, hookPath(filename), info)
+	if err != nil {
+		return err
+	}
+	cmd, err := hookCommand(flag, vars)
+	if cmd == nil || err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), hookEnv(vars)...)
+	cmd.Stdout = os.Stderr // the rewritten files may be printed to standard output
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%q failed: %v", cmd.Args, err)
+	}
+	return nil
+}
+
+// hookCommand returns the command running the hook flag with the placeholders vars, as pairs for a
+// strings.Replacer, replaced, or nil if flag is empty.
+func hookCommand(flag string, vars []string) (*exec.Cmd, error) {
+	if *shellFlag {
+		quoted := make([]string, len(vars))
+		for i := range vars {
+			quoted[i] = vars[i]
+			if i%2 == 1 {
+				quoted[i] = shellQuote(vars[i])
+			}
+		}
+		line := strings.NewReplacer(quoted...).Replace(flag)
+		if runtime.GOOS == "windows" {
+			return exec.Command("cmd", "/c", line), nil
+		}
+		return exec.Command("sh", "-c", line), nil
+	}
+	args, err := splitCommand(flag)
+	if err != nil || len(args) == 0 {
+		return nil, err
+	}
+	r := strings.NewReplacer(vars...)
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// A matchReport describes a match to the -onmatch hook, on its standard input.
+type matchReport struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Logical  string `json:"logical,omitempty"` // the position a //line directive maps the match to, if any
+	Package  string `json:"package"`
+	Template string `json:"template"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+
+	Bindings []bindingReport `json:"bindings,omitempty"` // of the template's wildcards, in order
+}
+
+// A bindingReport describes the expression a match binds a wildcard of the template to.
+type bindingReport struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Type   string `json:"type,omitempty"` // with its packages' full paths; absent if it isn't known
+}
+
+// bindingReports describes the bindings of a match, whose types are those of info, if it's known.
+func bindingReports(fSet *token.FileSet, info *types.Info, bindings []eg.Binding) []bindingReport {
+	var reports []bindingReport
+	for _, b := range bindings {
+		r := bindingReport{Name: b.Name, Source: nodeString(fSet, b.Expr)}
+		if info != nil {
+			if t := info.TypeOf(b.Expr); t != nil {
+				r.Type = types.TypeString(t, nil)
+			}
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// runMatchHook runs the -onmatch hook flag for the match m, and reports whether it accepts the rewrite, by exiting
+// successfully. The hook's placeholders and environment are those of the edit hooks, with {line}, EG_LINE,
+// EG_COLUMN, EG_BEFORE and EG_AFTER too, and it reads m as JSON from its standard input.
+func runMatchHook(flag string, m matchReport) bool {
+	err := matchHook(flag, m)
+	if err == nil {
+		return true
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: warning: -onmatch hook %q failed (%s); rewrite refused\n",
+			m.File, m.Line, m.Column, flag, err)
+	} else if *verboseFlag > 0 {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: rewrite refused by -onmatch hook: %s\n", m.File, m.Line, m.Column, err)
+	}
+	return false
+}
+
+// matchHook runs the -onmatch hook flag for the match m, for runMatchHook.
+func matchHook(flag string, m matchReport) error {
+	vars, err := hookVars(flag, hookPath(m.File), editInfo{pkg: m.Package, template: m.Template})
+	if err != nil {
+		return err
+	}
+	vars = append(vars, "{line}", strconv.Itoa(m.Line))
+	cmd, err := hookCommand(flag, vars)
+	if cmd == nil || err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), hookEnv(vars)...)
+	cmd.Env = append(cmd.Env,
+		"EG_LINE="+strconv.Itoa(m.Line),
+		"EG_COLUMN="+strconv.Itoa(m.Column),
+		"EG_BEFORE="+m.Before,
+		"EG_AFTER="+m.After)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stderr // the rewritten files may be printed to standard output
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// hookVars returns the placeholders of the edit hooks and their values for the edit of filename, as pairs for a
+// strings.Replacer, creating the hooks' temporary directory if flag refers to it.
+func hookVars(flag, filename string, info editInfo) ([]string, error) {
+	var matches, tmpDir string
+	if info.matches > 0 {
+		matches = strconv.Itoa(info.matches)
+	}
+	if strings.Contains(flag, "{tmpdir}") {
+		var err error
+		if tmpDir, err = hookDir(); err != nil {
+			return nil, err
+		}
+	}
+	return []string{
+		"{}", filename,
+		"{file}", filename,
+		"{pkg}", info.pkg,
+		"{template}", info.template,
+		"{matches}", matches,
+		"{tmpdir}", tmpDir,
+	}, nil
+}
+
+// hookEnv returns the environment variables of the edit hooks, given the values of their placeholders.
+func hookEnv(vars []string) []string {
+	value := make(map[string]string)
+	for i := 0; i < len(vars); i += 2 {
+		value[vars[i]] = vars[i+1]
+	}
+	return []string{
+		"EG_FILE=" + value["{file}"],
+		"EG_PACKAGE=" + value["{pkg}"],
+		"EG_TEMPLATE=" + value["{template}"],
+		"EG_MATCH_COUNT=" + value["{matches}"],
+		"EG_RUN_ID=" + runID,
+	}
+}
+
+// runID identifies the run to the edit hooks, e.g. to group the edits of one run in a log.
+var runID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), os.Getpid())
+
+// hookTmpDir is the directory the hooks of the run share, created when one first refers to it.
+var hookTmpDir string
+
+// hookDir returns hookTmpDir, creating it if need be.
+func hookDir() (string, error) {
+	if hookTmpDir == "" {
+		dir, err := ioutil.TempDir("", "eg-hooks")
+		if err != nil {
+			return "", err
+		}
+		hookTmpDir = dir
+	}
+	return hookTmpDir, nil
+}
+
+// runStarted is whether the beforerun hooks have been run, and editedFiles are the files the run has written, for
+// the afterrun hooks.
+var (
+	runStarted  bool
+	editedFiles []string
+)
+
+// startRun runs the beforerun hooks, before the first file of the run is edited. A failing one is reported, or
+// returned as an abortError under -hook-policy abort.
+func startRun() error {
+	if runStarted {
+		return nil
+	}
+	runStarted = true
+	for _, hook := range beforeRunFlags {
+		if err := runRunHook(hook, ""); err != nil {
+			if *policyFlag == "abort" {
+				return abortError{fmt.Errorf("before run hook %q failed (%s); aborting", hook, err)}
+			}
+			fmt.Fprintf(os.Stderr, "Warning: before run hook %q failed (%s)\n", hook, err)
+		}
+	}
+	return nil
+}
+
+// endRun runs the afterrun hooks, if the run edited any files, with the name of a file listing them, removes the
+// hooks' temporary directory, and releases the run's lock.
+func endRun() {
+	defer func() {
+		if hookTmpDir != "" {
+			os.RemoveAll(hookTmpDir)
+		}
+		unlockRun()
+	}()
+	if len(editedFiles) == 0 || len(afterRunFlags) == 0 {
+		return
+	}
+	list, err := listEdited()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't list the files edited for the after run hooks (%s)\n", err)
+		return
+	}
+	for _, hook := range afterRunFlags {
+		if err := runRunHook(hook, list); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: after run hook %q failed (%s)\n", hook, err)
+		}
+	}
+}
+
+// listEdited writes the names of editedFiles, one per line, to a file in the hooks' directory, returning its name.
+func listEdited() (string, error) {
+	dir, err := hookDir()
+	if err != nil {
+		return "", err
+	}
+	list := filepath.Join(dir, "files")
+	return list, ioutil.WriteFile(list, []byte(strings.Join(editedFiles, "\n")+"\n"), 0666)
+}
+
+// runRunHook runs the run hook flag, replacing "{files}" with the name of the file listing those edited, if this is
+// after the run, and "{tmpdir}" as for the edit hooks. Its environment has EG_FILES and EG_RUN_ID.
+func runRunHook(flag, files string) error {
+	vars := []string{"{files}", files, "{tmpdir}", ""}
+	if strings.Contains(flag, "{tmpdir}") {
+		dir, err := hookDir()
+		if err != nil {
+			return err
+		}
+		vars[3] = dir
+	}
+	cmd, err := hookCommand(flag, vars)
+	if cmd == nil || err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), "EG_FILES="+files, "EG_RUN_ID="+runID)
+	cmd.Stdout = os.Stderr // the rewritten files may be printed to standard output
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// splitCommand splits the command line into words at spaces outside quotes, removing the quotes. Backslashes aren't
+// escapes, since they separate the elements of Windows paths.
+func splitCommand(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	var inWord bool
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("edit hook %q has an unterminated %c quote", line, quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// shellQuote quotes the path for the platform's shell: in double quotes for cmd, which paths can't contain, and in
+// single quotes for sh, with those it contains escaped.
+func shellQuote(path string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + path + `"`
+	}
+	return "'" + strings.Replace(path, "'", `'\''`, -1) + "'"
+}
+
+// hookPath returns the path of filename to pass to the edit hooks: on Windows, an absolute path longer than MAX_PATH
+// has the \\?\ prefix, for the programs which support long paths only with it.
+func hookPath(filename string) string {
+	const maxPath = 260
+	if runtime.GOOS != "windows" || len(filename) < maxPath || !filepath.IsAbs(filename) ||
+		strings.HasPrefix(filename, `\\`) {
+		return filename
+	}
+	return `\\?\` + filepath.Clean(filename)
+}
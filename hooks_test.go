@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestHooks runs the edit and run hooks, checking that their output is kept out of what eg prints to standard output,
+// such as -json's report, the placeholders and environment variables they're given, what a failing beforeedit hook
+// does under each -hook-policy, and the JSON of each match, with its bindings, given to -onmatch, which may refuse it.
+func TestHooks(t *testing.T) {
+	runMainCases(t, "hooks")
+}
+
+// TestMatchHookPackage checks that the -onmatch hook is given the import path of the package of each match, as the
+// report's package and as $EG_PACKAGE.
+func TestMatchHookPackage(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the hook with")
+	}
+	dir, err := ioutil.TempDir("", "eg-onmatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "log")
+
+	saved := *onMatchFlag
+	defer func() { *onMatchFlag = saved }()
+	*onMatchFlag = "sh -c 'cat >> " + log + ` && echo " $EG_PACKAGE" >> ` + log + "'"
+	runParallel(t, 1, []string{"templates/empty/empty.go"}, false)
+
+	data, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 48 {
+		t.Fatalf("the hook ran %d times, want 48:\n%s", len(lines), data)
+	}
+	for _, line := range lines {
+		i := strings.LastIndex(line, " ")
+		var m matchReport
+		if err := json.Unmarshal([]byte(line[:i]), &m); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		want := "example.com/parallel/" + filepath.Base(filepath.Dir(m.File))
+		if m.Package != want || line[i+1:] != want {
+			t.Errorf("%s:%d: got the package %q and $EG_PACKAGE %q, want %q", m.File, m.Line, m.Package, line[i+1:],
+				want)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		want []string
+		err  string
+	}{
+		{line: "gofmt -l", want: []string{"gofmt", "-l"}},
+		{line: "  gofmt \t -l  ", want: []string{"gofmt", "-l"}},
+		{line: "", want: nil},
+		{line: `C:\tools\fmt.exe -w`, want: []string{`C:\tools\fmt.exe`, "-w"}}, // backslashes aren't escapes
+		{line: `"C:\Program Files\fmt.exe" -w`, want: []string{`C:\Program Files\fmt.exe`, "-w"}},
+		{line: `sh -c 'echo "$1"' hook`, want: []string{"sh", "-c", `echo "$1"`, "hook"}},
+		{line: `a""b ''`, want: []string{"ab", ""}},
+		{line: `sh -c 'echo`, err: "unterminated ' quote"},
+	} {
+		got, err := splitCommand(test.line)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("splitCommand(%q) = %q, %v, want an error containing %q", test.line, got, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("splitCommand(%q) failed: %v", test.line, err)
+		case !reflect.DeepEqual(got, test.want):
+			t.Errorf("splitCommand(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		if got := shellQuote(`C:\a b\c.go`); got != `"C:\a b\c.go"` {
+			t.Errorf("got %s", got)
+		}
+		return
+	}
+	for path, want := range map[string]string{
+		"a.go":          "'a.go'",
+		"dir with/a.go": "'dir with/a.go'",
+		"it's.go":       `'it'\''s.go'`,
+	} {
+		if got := shellQuote(path); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
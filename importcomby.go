@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const importCombyUsage = `Usage: eg import-comby [-o .eg.yaml] comby.toml
+       eg import-comby [-o .eg.yaml] -match template -rewrite template
+
+Converts comby's structural match and rewrite templates, those of the tables
+of a comby config file or those given by -match and -rewrite, to the rewrite
+rules of a config, printing it. Each hole, :[x], becomes a wildcard of the
+rule, matching an expression, and each table's name the rule's.
+
+Only templates which are expressions with holes standing for expressions of
+their own map cleanly; the rest, such as those with rules (where clauses),
+holes of other kinds (:[[x]], :[x.], :[x\n], :[x~regexp]), ellipses, holes
+within literals or identifiers, or statements, are reported with the reason.
+`
+
+func importCombyMain(args []string) error {
+	fs := flag.NewFlagSet("import-comby", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, importCombyUsage) }
+	out := fs.String("o", "", "the file to write the config to, rather than standard output")
+	match := fs.String("match", "", "the match template to convert, rather than those of a config file")
+	rewrite := fs.String("rewrite", "", "the rewrite template of -match")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var tables []combyTable
+	var source string
+	switch {
+	case *match != "" || *rewrite != "":
+		if fs.NArg() != 0 || *match == "" || *rewrite == "" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		tables = []combyTable{{name: "comby", keys: map[string]string{"match": *match, "rewrite": *rewrite}}}
+		source = "a comby template by eg import-comby"
+	case fs.NArg() == 1:
+		data, err := ioutil.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if tables, err = parseCombyConfig(data); err != nil {
+			return fmt.Errorf("%s: %v", fs.Arg(0), err)
+		}
+		source = fmt.Sprintf("the comby templates of %s by eg import-comby", fs.Arg(0))
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var conf repoConfig
+	names := make(map[string]bool)
+	for _, t := range tables {
+		r, err := t.convert()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "eg: template %s not converted: %s\n", t.name, err)
+			continue
+		}
+		r.Name = uniqueName(ruleName(t.name), names)
+		conf.Rules = append(conf.Rules, r)
+	}
+	fmt.Fprintf(os.Stderr, "eg: converted %d of the %d comby templates\n", len(conf.Rules), len(tables))
+	if len(conf.Rules) == 0 {
+		return errors.New("no templates converted")
+	}
+	return writeImported(*out, source, &conf)
+}
+
+// combyHole matches a hole of a comby template which matches an expression, :[x], with its name as its group.
+var combyHole = regexp.MustCompile(`:\[([A-Za-z_][A-Za-z0-9_]*)\]`)
+
+// A combyTable is a table of a comby config file, a named template: its keys, such as match, rewrite and rule.
+type combyTable struct {
+	name string
+	keys map[string]string
+}
+
+// convert returns the rewrite rule, without its name, equivalent to t, or an error giving why there's none.
+func (t *combyTable) convert() (templateRule, error) {
+	for key, value := range t.keys {
+		switch key {
+		case "match", "rewrite":
+		case "rule":
+			if strings.TrimSpace(value) != "" {
+				return templateRule{}, errors.New("rules (where clauses) aren't supported")
+			}
+		default:
+			return templateRule{}, fmt.Errorf("%s isn't supported", key)
+		}
+	}
+	match, rewrite := t.keys["match"], t.keys["rewrite"]
+	if match == "" {
+		return templateRule{}, errors.New("no match")
+	}
+	if rewrite == "" {
+		return templateRule{}, errors.New("no rewrite, so nothing to rewrite")
+	}
+	for _, s := range []string{match, rewrite} {
+		if strings.Contains(combyHole.ReplaceAllString(s, ""), ":[") {
+			return templateRule{}, errors.New("holes other than :[x], matching an expression, aren't supported")
+		}
+	}
+	r, err := metavarRewrite(match, rewrite, combyHole)
+	if err != nil {
+		return templateRule{}, err
+	}
+	return templateRule{Rewrite: r}, nil
+}
+
+// parseCombyConfig parses the tables of a comby config file, in order. It's the subset of TOML such files use:
+// tables whose keys are strings, quoted with single or double quotes, or tripled, for multiline strings.
+func parseCombyConfig(data []byte) ([]combyTable, error) {
+	var tables []combyTable
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	var lineNum int
+	next := func() (string, bool) {
+		if !sc.Scan() {
+			return "", false
+		}
+		lineNum++
+		return sc.Text(), true
+	}
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			end := strings.LastIndex(line, "]")
+			if end < 0 || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table %s", lineNum, line)
+			}
+			name := strings.TrimSpace(line[1:end])
+			if uq, err := strconv.Unquote(name); err == nil {
+				name = uq
+			}
+			tables = append(tables, combyTable{name: name, keys: make(map[string]string)})
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 || len(tables) == 0 {
+			return nil, fmt.Errorf("line %d: want a table, or a key = value of one", lineNum)
+		}
+		key, value := strings.TrimSpace(line[:eq]), strings.TrimSpace(line[eq+1:])
+		start := lineNum
+		var s string
+		switch {
+		case strings.HasPrefix(value, `'''`), strings.HasPrefix(value, `"""`):
+			delim := value[:3]
+			value = value[3:]
+			var lines []string
+			for {
+				if i := strings.Index(value, delim); i >= 0 {
+					lines = append(lines, value[:i])
+					break
+				}
+				lines = append(lines, value)
+				if value, ok = next(); !ok {
+					return nil, fmt.Errorf("line %d: unterminated %s string", start, delim)
+				}
+			}
+			// a newline just after the opening delimiter is trimmed, as TOML does
+			if lines[0] == "" {
+				lines = lines[1:]
+			}
+			s = strings.Join(lines, "\n")
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", lineNum)
+			}
+			s = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			end := 1
+			for end < len(value) && value[end] != '"' {
+				if value[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			var err error
+			if end >= len(value) {
+				err = errors.New("unterminated")
+			} else {
+				s, err = strconv.Unquote(value[:end+1])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string", lineNum)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: the value of %s isn't a string", lineNum, key)
+		}
+		tables[len(tables)-1].keys[key] = s
+	}
+	return tables, sc.Err()
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestImportComby(t *testing.T) {
+	runCommandCases(t, "import-comby")
+}
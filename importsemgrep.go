@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const importSemgrepUsage = `Usage: eg import-semgrep [-o .eg.yaml] rules.yaml
+
+Converts the Semgrep rules for Go in rules.yaml which rewrite an expression,
+with a pattern and a fix, to the rewrite rules of a config, printing it. Each
+metavariable becomes a wildcard of the rule, which is matched by syntax alone,
+as Semgrep's are, and the rule's message its doc. A pattern-either of patterns
+becomes a rule for each.
+
+The rules which can't be converted are reported with the reason, such as the
+operators combining patterns (patterns, pattern-not, pattern-inside, ...),
+ellipses, statement patterns, or fixes by regexp.
+`
+
+func importSemgrepMain(args []string) error {
+	fs := flag.NewFlagSet("import-semgrep", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, importSemgrepUsage) }
+	out := fs.String("o", "", "the file to write the config to, rather than standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Rules []semgrepRule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+
+	var conf repoConfig
+	names := make(map[string]bool)
+	var converted int
+	for _, sr := range file.Rules {
+		rules, err := sr.convert()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: rule %s not converted: %s\n", fs.Arg(0), sr.ID, err)
+			continue
+		}
+		for i := range rules {
+			rules[i].Name = uniqueName(ruleName(sr.ID), names)
+		}
+		conf.Rules = append(conf.Rules, rules...)
+		converted++
+	}
+	fmt.Fprintf(os.Stderr, "eg: converted %d of the %d rules of %s\n", converted, len(file.Rules), fs.Arg(0))
+	if len(conf.Rules) == 0 {
+		return errors.New("no rules converted")
+	}
+
+	return writeImported(*out, fmt.Sprintf("the Semgrep rules of %s by eg import-semgrep", fs.Arg(0)), &conf)
+}
+
+// writeImported writes conf, converted from source, to the file out, or standard output if it's "".
+func writeImported(out, source string, conf *repoConfig) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# converted from %s\n", source)
+	enc, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	buf.Write(enc)
+	if out != "" {
+		return ioutil.WriteFile(out, buf.Bytes(), 0666)
+	}
+	_, err = os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// A semgrepRule is a rule of a Semgrep rules file, with the keys eg can convert, and the rest.
+type semgrepRule struct {
+	ID        string                   `yaml:"id"`
+	Message   string                   `yaml:"message"`
+	Languages []string                 `yaml:"languages"`
+	Pattern   string                   `yaml:"pattern"`
+	Either    []map[string]interface{} `yaml:"pattern-either"`
+	Fix       string                   `yaml:"fix"`
+
+	Rest map[string]interface{} `yaml:",inline"`
+}
+
+// semgrepIgnored are the keys of a Semgrep rule which don't change what it matches or how it's fixed.
+var semgrepIgnored = map[string]bool{
+	"severity": true, "metadata": true, "paths": true, "options": true, "min-version": true, "max-version": true,
+}
+
+// convert returns the rewrite rules, without their names, equivalent to r, or an error giving why there are none.
+func (r *semgrepRule) convert() ([]templateRule, error) {
+	var golang bool
+	for _, l := range r.Languages {
+		golang = golang || l == "go" || l == "golang"
+	}
+	if !golang {
+		return nil, errors.New("not a rule for Go")
+	}
+	for key := range r.Rest {
+		if !semgrepIgnored[key] {
+			return nil, fmt.Errorf("%s isn't supported", key)
+		}
+	}
+	if r.Fix == "" {
+		return nil, errors.New("no fix, so nothing to rewrite")
+	}
+	patterns := []string{r.Pattern}
+	if r.Pattern == "" && len(r.Either) == 0 {
+		return nil, errors.New("no pattern")
+	}
+	if len(r.Either) > 0 {
+		if r.Pattern != "" {
+			return nil, errors.New("both pattern and pattern-either given")
+		}
+		patterns = nil
+		for _, alt := range r.Either {
+			p, ok := alt["pattern"].(string)
+			if len(alt) != 1 || !ok {
+				return nil, errors.New("pattern-either has operators other than pattern")
+			}
+			patterns = append(patterns, p)
+		}
+	}
+
+	var rules []templateRule
+	for _, pattern := range patterns {
+		rewrite, err := metavarRewrite(pattern, r.Fix, semgrepMetavar)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, templateRule{Doc: strings.TrimSpace(r.Message), Rewrite: rewrite})
+	}
+	return rules, nil
+}
+
+// semgrepMetavar matches a metavariable of Semgrep.
+var semgrepMetavar = regexp.MustCompile(`\$([A-Z_][A-Z0-9_]*)`)
+
+// metavarRewrite returns the rule of gofmt -r rewriting pattern as fix, where metavar matches their metavariables,
+// as those of Semgrep, ruleguard or comby, with their names as its first group, which are renamed as the rule's
+// wildcards, single lowercase letters. The metavariable _ is anonymous.
+func metavarRewrite(pattern, fix string, metavar *regexp.Regexp) (string, error) {
+	orig := [2]string{pattern, fix}
+	for _, s := range []string{pattern, fix} {
+		if strings.Contains(s, "...") {
+			return "", errors.New("ellipses aren't supported")
+		}
+	}
+
+	// the metavariables are replaced by identifiers, for the expressions to parse, and then by the wildcards
+	const prefix = "eg_metavar_"
+	var metavars []string
+	seen := make(map[string]bool)
+	var anon int
+	var uses [2]int
+	pattern = metavar.ReplaceAllStringFunc(pattern, func(m string) string {
+		name := metavar.FindStringSubmatch(m)[1]
+		if name == "_" { // each is a metavariable of its own
+			anon++
+			name = fmt.Sprintf("_%d", anon)
+		}
+		if !seen[name] {
+			seen[name] = true
+			metavars = append(metavars, name)
+		}
+		uses[0]++
+		return prefix + name
+	})
+	var unbound []string
+	fix = metavar.ReplaceAllStringFunc(fix, func(m string) string {
+		name := metavar.FindStringSubmatch(m)[1]
+		if !seen[name] {
+			unbound = append(unbound, m)
+		}
+		uses[1]++
+		return prefix + name
+	})
+	if len(unbound) > 0 {
+		return "", fmt.Errorf("the fix has metavariables the pattern doesn't bind: %s", strings.Join(unbound, ", "))
+	}
+
+	var exprs [2]ast.Expr
+	for i, s := range []string{pattern, fix} {
+		e, err := parser.ParseExpr(strings.TrimSpace(s))
+		if err != nil {
+			return "", fmt.Errorf("%q isn't an expression", strings.TrimSpace(orig[i]))
+		}
+		var literal string
+		var found int
+		ast.Inspect(e, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if isRuleWildcard(id.Name) {
+					literal = id.Name
+				}
+				if strings.HasPrefix(id.Name, prefix) && seen[strings.TrimPrefix(id.Name, prefix)] {
+					found++
+				}
+			}
+			return true
+		})
+		if literal != "" {
+			return "", fmt.Errorf("it refers to %s, which a rewrite rule takes as a wildcard", literal)
+		}
+		if found != uses[i] {
+			return "", fmt.Errorf("%q has metavariables which aren't expressions of their own, e.g. within a literal or an identifier", strings.TrimSpace(orig[i]))
+		}
+		exprs[i] = e
+	}
+
+	if len(metavars) > 26 {
+		return "", errors.New("too many metavariables")
+	}
+	wildcards := make(map[string]string)
+	for i, m := range metavars {
+		wildcards[prefix+m] = string(rune('a' + i))
+	}
+	for _, e := range exprs {
+		ast.Inspect(e, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && wildcards[id.Name] != "" {
+				id.Name = wildcards[id.Name]
+			}
+			return true
+		})
+	}
+	fSet := token.NewFileSet()
+	return nodeString(fSet, exprs[0]) + " -> " + nodeString(fSet, exprs[1]), nil
+}
+
+// ruleName returns the Semgrep rule id as the name of a config rule, an identifier, e.g. useStringsContains for
+// use-strings-contains.
+func ruleName(id string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range id {
+		switch {
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) && b.Len() > 0:
+			if upper {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+			upper = false
+		default:
+			upper = b.Len() > 0
+		}
+	}
+	if b.Len() == 0 {
+		return "rule"
+	}
+	return b.String()
+}
+
+// uniqueName returns name, or it numbered if it's among names already, and adds it.
+func uniqueName(name string, names map[string]bool) string {
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	names[unique] = true
+	return unique
+}
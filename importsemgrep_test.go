@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestImportSemgrep(t *testing.T) {
+	runCommandCases(t, "import-semgrep")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	goimports "golang.org/x/tools/imports"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inlineTemplate returns the source of the template written inline as "[params:] before -> after", e.g.
+//
+//	s, sub string: strings.Index(s, sub) != -1 -> strings.Contains(s, sub)
+//
+// where params declare the wildcards, as a function's parameters would.
+func inlineTemplate(snippet string) ([]byte, error) {
+	params, before, after, err := splitInline(snippet)
+	if err != nil {
+		return nil, err
+	}
+	src, err := exprTemplate(params, before, after, nil)
+	if err != nil {
+		return nil, fmt.Errorf("-e %q: %v", snippet, err)
+	}
+	return src, nil
+}
+
+// exprTemplate returns the source of the template rewriting the expression before as after, with the wildcards
+// params, importing the packages imports and any others they refer to, as goimports would.
+func exprTemplate(params, before, after string, imports []string) ([]byte, error) {
+	if _, err := parser.ParseExpr("func(" + params + ")"); err != nil {
+		return nil, fmt.Errorf("the params %q don't parse: %v", params, err)
+	}
+	for _, x := range []string{before, after} {
+		if _, err := parser.ParseExpr(x); err != nil {
+			return nil, fmt.Errorf("%q doesn't parse: %v", x, err)
+		}
+	}
+
+	// calls are rewritten as statements, so that those of functions with no results can be, and other expressions
+	// are returned, as values of any type
+	var buf bytes.Buffer
+	buf.WriteString("package template\n\n")
+	for _, path := range imports {
+		fmt.Fprintf(&buf, "import %q\n", path)
+	}
+	for _, fn := range []struct{ name, x string }{{"before", before}, {"after", after}} {
+		if isCallStmt(before) && isCallStmt(after) {
+			fmt.Fprintf(&buf, "func %s(%s) {\n\t%s\n}\n\n", fn.name, params, fn.x)
+		} else {
+			fmt.Fprintf(&buf, "func %s(%s) interface{} {\n\treturn %s\n}\n\n", fn.name, params, fn.x)
+		}
+	}
+	return goimports.Process("template.go", buf.Bytes(), nil)
+}
+
+// splitInline splits the inline template snippet at its tokens "->", and at ":" outside brackets, which can't
+// otherwise appear there.
+func splitInline(snippet string) (params, before, after string, err error) {
+	var s scanner.Scanner
+	fSet := token.NewFileSet()
+	file := fSet.AddFile("", -1, len(snippet))
+	s.Init(file, []byte(snippet), nil, 0)
+	colon, arrow := -1, -1
+	var depth int
+	var last token.Token
+	var lastOff int
+	for {
+		pos, tok, _ := s.Scan()
+		off := file.Offset(pos)
+		switch tok {
+		case token.EOF:
+			if arrow < 0 {
+				return "", "", "", fmt.Errorf("-e %q: want [params:] before -> after", snippet)
+			}
+			if colon >= 0 {
+				params = snippet[:colon]
+			}
+			before = strings.TrimSpace(snippet[colon+1 : arrow])
+			after = strings.TrimSpace(snippet[arrow+2:])
+			return strings.TrimSpace(params), before, after, nil
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.COLON:
+			if depth == 0 && colon < 0 && arrow < 0 {
+				colon = off
+			}
+		case token.GTR:
+			if last == token.SUB && lastOff == off-1 && arrow < 0 {
+				arrow = lastOff
+			}
+		}
+		last, lastOff = tok, off
+	}
+}
+
+// isCallStmt reports whether the expression x, which parses, is a call which may be used as a statement, as far as
+// can be told from its syntax: that of a function, rather than a conversion or a builtin with results.
+func isCallStmt(x string) bool {
+	e, _ := parser.ParseExpr(x)
+	for {
+		paren, ok := e.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		e = paren.X
+	}
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		switch types.Universe.Lookup(fun.Name).(type) {
+		case *types.TypeName:
+			return false
+		case *types.Builtin:
+			return stmtBuiltins[fun.Name]
+		}
+	case *ast.ArrayType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.MapType, *ast.StarExpr,
+		*ast.StructType:
+		return false
+	}
+	return true
+}
+
+// stmtBuiltins are the builtins which may be called as statements.
+var stmtBuiltins = map[string]bool{
+	"clear": true, "close": true, "copy": true, "delete": true, "panic": true, "print": true, "println": true,
+	"recover": true,
+}
+
+// writeTempTemplate writes the template source src to a file named name in a new temporary directory, returning its
+// path and a function removing it.
+func writeTempTemplate(name string, src []byte) (string, func(), error) {
+	dir, err := ioutil.TempDir("", "eg")
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, src, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return path, func() { os.RemoveAll(dir) }, nil
+}
+
+// stdinTemplate reads the template source from standard input.
+func stdinTemplate() ([]byte, error) {
+	src, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(src)) == 0 {
+		return nil, errors.New("-t -: the template read from standard input is empty")
+	}
+	return src, nil
+}
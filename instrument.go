@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+)
+
+const instrumentUsage = `Usage: eg instrument -t instrument.go -func path.F [-w] <packages>...
+
+Inserts statements before or after each call of a function or method in the
+loaded packages, e.g. to count them. The template declares either or both of
+
+	func before(key string, v []byte) {
+		metrics.Inc(funcName)
+	}
+	func after(key string, v []byte) { ... }
+
+taking the function's parameters, preceded by the receiver for a method, along
+with the placeholder
+
+	const funcName = "" // the called function's name, as pkg.F or T.M
+
+Their bodies are inserted around the statement containing the call, with
+references to their parameters replaced by the call's arguments, which must be
+free of side effects, as they're evaluated again. Calls which are deferred, or
+only conditionally evaluated, aren't instrumented, nor are calls already
+preceded or followed by the statements.
+`
+
+func instrumentMain(args []string) error {
+	fs := flag.NewFlagSet("instrument", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, instrumentUsage) }
+	tmplFlag := fs.String("t", "", "the instrumentation template")
+	funcFlag := fs.String("func", "", "the function whose calls to instrument, as path.F or path.T.M")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplFlag == "" {
+		return errors.New("no -t template given")
+	}
+	if *funcFlag == "" {
+		return errors.New("-func is required")
+	}
+
+	m, tmpl, tmplFile, err := loadWithTemplate(*tmplFlag, fs.Args())
+	if err != nil {
+		return err
+	}
+	// the function needn't be among the loaded packages, as its declaration isn't changed
+	obj, err := m.lookupWith(*funcFlag, true)
+	if err != nil {
+		return err
+	}
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return fmt.Errorf("%s isn't a function or method", *funcFlag)
+	}
+	in, err := newInstrumenter(m, tmpl, tmplFile, fn)
+	if err != nil {
+		return err
+	}
+
+	// the statements for the calls within a statement are inserted around it together
+	type group struct {
+		u             use
+		before, after []ast.Stmt
+	}
+	var groups []*group
+	byStmt := make(map[ast.Stmt]*group)
+	var failed bool
+	for _, u := range m.uses(fn) {
+		before, after, err := in.stmtsFor(u)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: can't instrument this call: %v\n", m.position(u.id), err)
+			failed = true
+			continue
+		}
+		list, j := enclosingStmt(u.path)
+		g := byStmt[(*list)[j]]
+		if g == nil {
+			g = &group{u: u}
+			byStmt[(*list)[j]] = g
+			groups = append(groups, g)
+		}
+		g.before = append(g.before, before...)
+		g.after = append(g.after, after...)
+	}
+	for _, g := range groups {
+		in.insert(g.u, g.before, g.after)
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some calls couldn't be instrumented")
+	}
+	return nil
+}
+
+// An instrumenter inserts a template's statements around the calls of a function.
+type instrumenter struct {
+	m    *migration
+	tmpl *packages.Package
+	fn   *types.Func
+
+	before, after *ast.FuncDecl
+	recv          bool // whether the template's first parameter is the receiver
+
+	funcName types.Object // the placeholder for the called function's name
+}
+
+// newInstrumenter validates the instrumentation template tmplFile for calls of fn.
+func newInstrumenter(m *migration, tmpl *packages.Package, tmplFile *ast.File, fn *types.Func) (*instrumenter, error) {
+	in := &instrumenter{m: m, tmpl: tmpl, fn: fn}
+	for _, decl := range tmplFile.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil {
+				continue
+			}
+			switch decl.Name.Name {
+			case "before":
+				in.before = decl
+			case "after":
+				in.after = decl
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if vs, ok := spec.(*ast.ValueSpec); ok && decl.Tok == token.CONST {
+					for _, id := range vs.Names {
+						if id.Name == "funcName" {
+							in.funcName = tmpl.TypesInfo.Defs[id]
+						}
+					}
+				}
+			}
+		}
+	}
+	if in.before == nil && in.after == nil {
+		return nil, errors.New("template declares neither before nor after")
+	}
+
+	sig := fn.Type().(*types.Signature)
+	for _, decl := range []*ast.FuncDecl{in.before, in.after} {
+		if decl == nil {
+			continue
+		}
+		tmplSig := tmpl.TypesInfo.Defs[decl.Name].Type().(*types.Signature)
+		params := tmplSig.Params()
+		in.recv = sig.Recv() != nil && params.Len() == sig.Params().Len()+1
+		var offset int
+		if in.recv {
+			if !types.Identical(params.At(0).Type(), sig.Recv().Type()) {
+				return nil, fmt.Errorf("%s: %s's receiver is a %s, not a %s", m.position(decl), fn.Name(),
+					sig.Recv().Type(), params.At(0).Type())
+			}
+			offset = 1
+		}
+		if params.Len()-offset != sig.Params().Len() || tmplSig.Variadic() != sig.Variadic() ||
+			tmplSig.Results().Len() > 0 {
+			return nil, fmt.Errorf("%s: %s must take the parameters of %s, and return nothing", m.position(decl),
+				decl.Name.Name, fn.Name())
+		}
+		for i := 0; i < sig.Params().Len(); i++ {
+			if p := params.At(i + offset); !types.Identical(p.Type(), sig.Params().At(i).Type()) {
+				return nil, fmt.Errorf("%s: parameter %s of %s is a %s, not a %s", m.position(decl), p.Name(),
+					decl.Name.Name, p.Type(), sig.Params().At(i).Type())
+			}
+		}
+		// the statements are inserted into the caller's block, so mustn't declare names there
+		scope := tmpl.TypesInfo.Scopes[decl.Type]
+		for id, obj := range tmpl.TypesInfo.Defs {
+			if obj != nil && obj.Parent() == scope && within(id, decl.Body) {
+				return nil, fmt.Errorf("%s: %s declares %s; enclose its statements in a block", m.position(id),
+					decl.Name.Name, id.Name)
+			}
+		}
+		if err := m.checkTemplateRefs(tmpl, decl.Body, in.funcName); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// stmtsFor returns the template's statements to insert before and after the statement containing the call at u.
+func (in *instrumenter) stmtsFor(u use) (before, after []ast.Stmt, err error) {
+	call := u.call()
+	if call == nil {
+		return nil, nil, fmt.Errorf("%s is used as a value", u.id.Name)
+	}
+	if _, reason := evaluatingStmt(u, call, true); reason != "" {
+		return nil, nil, errors.New(reason)
+	}
+	list, j := enclosingStmt(u.path)
+	stmt := (*list)[j]
+	switch stmt := stmt.(type) {
+	case *ast.DeferStmt:
+		if stmt.Call == call {
+			return nil, nil, errors.New("it's deferred")
+		}
+	case *ast.GoStmt:
+		if stmt.Call == call {
+			return nil, nil, errors.New("it's called in a new goroutine")
+		}
+	}
+
+	pos := stmt.Pos()
+	subst, err := in.bindings(u, call, pos)
+	if err != nil {
+		return nil, nil, err
+	}
+	if in.before != nil {
+		if before, err = in.stmts(in.before, u, pos, subst); err != nil {
+			return nil, nil, err
+		}
+	}
+	if in.after != nil {
+		switch stmt.(type) {
+		case *ast.ReturnStmt, *ast.BranchStmt, *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt,
+			*ast.TypeSwitchStmt, *ast.SelectStmt, *ast.BlockStmt, *ast.LabeledStmt:
+			return nil, nil, errors.New("nothing can follow the statement containing it")
+		}
+		if assign, ok := stmt.(*ast.AssignStmt); ok {
+			for _, lhs := range assign.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Name != "_" && in.bindsName(subst, id.Name) {
+					return nil, nil, fmt.Errorf("its arguments refer to %s, which is assigned by the statement", id.Name)
+				}
+			}
+		}
+		if after, err = in.stmts(in.after, u, stmt.End(), subst); err != nil {
+			return nil, nil, err
+		}
+	}
+	return before, after, nil
+}
+
+// insert inserts before and after around the statement containing the call at u, unless they're already there.
+func (in *instrumenter) insert(u use, before, after []ast.Stmt) {
+	list, j := enclosingStmt(u.path)
+	stmt := (*list)[j]
+	if len(before) > 0 && j >= len(before) && sameStmts(in.m.fSet, (*list)[j-len(before):j], before) {
+		before = nil
+	}
+	if len(after) > 0 && j+1+len(after) <= len(*list) && sameStmts(in.m.fSet, (*list)[j+1:j+1+len(after)], after) {
+		after = nil
+	}
+	if len(before) == 0 && len(after) == 0 {
+		return
+	}
+	spliceStmt(u.path, func(stmt ast.Stmt) []ast.Stmt {
+		return append(append(before, stmt), after...)
+	})
+	in.m.touch(u.pkg, u.file)
+	fmt.Fprintf(os.Stderr, "%s: instrumented calls of %s\n", in.m.position(stmt), in.fn.Name())
+}
+
+// bindings returns the arguments of call, and its receiver, by the names of the template's parameters which are
+// referred to.
+func (in *instrumenter) bindings(u use, call *ast.CallExpr, pos token.Pos) (map[string]ast.Expr, error) {
+	subst := make(map[string]ast.Expr)
+	if in.funcName != nil {
+		subst[in.funcName.Name()] = funcNameLit(in.fn, pos)
+	}
+	decl := in.before
+	if decl == nil {
+		decl = in.after
+	}
+	params := in.tmpl.TypesInfo.Defs[decl.Name].Type().(*types.Signature).Params()
+	args := call.Args
+	if in.recv {
+		sel, ok := u.path[1].(*ast.SelectorExpr)
+		if !ok || u.pkg.TypesInfo.Selections[sel] == nil || u.pkg.TypesInfo.Selections[sel].Kind() != types.MethodVal {
+			return nil, errors.New("it's not a call of a method value")
+		}
+		_, ptrRecv := in.fn.Type().(*types.Signature).Recv().Type().(*types.Pointer)
+		args = append([]ast.Expr{implicitSelections(u.pkg.TypesInfo, sel, ptrRecv)}, args...)
+	}
+	for i := 0; i < params.Len(); i++ {
+		p := params.At(i)
+		if !in.refersTo(p) {
+			continue
+		}
+		variadic := i == params.Len()-1 && call.Args != nil && in.fn.Type().(*types.Signature).Variadic()
+		switch {
+		case len(args) == 1 && params.Len() > 1:
+			return nil, errors.New("its argument is multi-valued")
+		case variadic && !call.Ellipsis.IsValid():
+			return nil, fmt.Errorf("its variadic arguments can't be bound to %s", p.Name())
+		case i >= len(args):
+			return nil, fmt.Errorf("it has no argument for %s", p.Name())
+		case !sideEffectFree(u.pkg.TypesInfo, args[i]):
+			return nil, fmt.Errorf("argument %s may have side effects", nodeString(in.m.fSet, args[i]))
+		}
+		subst[p.Name()] = args[i]
+	}
+	return subst, nil
+}
+
+// refersTo reports whether the bodies of the template's functions refer to their parameter p, or those
+// corresponding to it.
+func (in *instrumenter) refersTo(p *types.Var) bool {
+	var used bool
+	for _, decl := range []*ast.FuncDecl{in.before, in.after} {
+		if decl == nil {
+			continue
+		}
+		ast.Inspect(decl.Body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Name == p.Name() {
+				if v, ok := in.tmpl.TypesInfo.Uses[id].(*types.Var); ok && v.Parent() == in.tmpl.TypesInfo.Scopes[decl.Type] {
+					used = true
+				}
+			}
+			return !used
+		})
+	}
+	return used
+}
+
+// bindsName reports whether any of the expressions bound in subst refers to name.
+func (in *instrumenter) bindsName(subst map[string]ast.Expr, name string) bool {
+	var found bool
+	for _, e := range subst {
+		ast.Inspect(e, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Name == name {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// stmts instantiates the body of decl for the call at u, with copies of the bindings in subst.
+func (in *instrumenter) stmts(decl *ast.FuncDecl, u use, pos token.Pos, subst map[string]ast.Expr) ([]ast.Stmt, error) {
+	copies := make(map[string]ast.Expr)
+	for name, e := range subst {
+		c, err := parser.ParseExpr(nodeString(in.m.fSet, e))
+		if err != nil {
+			return nil, err
+		}
+		setPos(c, pos)
+		copies[name] = c
+	}
+	subst = copies
+	var stmts []ast.Stmt
+	for _, stmt := range decl.Body.List {
+		n, err := in.m.instantiate(in.tmpl, stmt, u.pkg, u.file, pos, subst)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, n.(ast.Stmt))
+	}
+	return stmts, nil
+}
+
+// sameStmts reports whether the statements of a and b print the same.
+func sameStmts(fSet *token.FileSet, a, b []ast.Stmt) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if compactString(fSet, a[i]) != compactString(fSet, b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestInstrument(t *testing.T) {
+	runCommandCases(t, "instrument")
+}
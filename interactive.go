@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// answers reads the answers to the prompts of -interactive.
+var answers = bufio.NewReader(os.Stdin)
+
+const promptHelp = `y - rewrite this match, or all the identical ones shown
+n - don't rewrite this match, or any of the identical ones
+a - rewrite this match and all the template's later ones
+q - quit: don't rewrite this match or any later one, but write those accepted
+e - edit the replacement, and rewrite the match, or all the identical ones, with the edit
+s - split the identical matches, to decide each in turn
+? - print help
+`
+
+// promptDecide asks, for each of props in turn, whether to rewrite it, as git add -p does for hunks, showing its
+// diff, in color if standard output is a terminal. Identical rewrites are asked about once, for all of them.
+func promptDecide(tmplPath string, props []*proposal) error {
+	color := isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+	for _, p := range props {
+		p.Accepted = false
+	}
+	clusters := clusterProposals(props)
+	for i := 0; i < len(clusters); i++ {
+		c := clusters[i]
+		printProposal(os.Stdout, c[0], color)
+		what, options := "this match", "y,n,a,q,e,?"
+		if len(c) > 1 {
+			fmt.Fprintf(os.Stdout, "... and %d more identical rewrites, at %s\n", len(c)-1, proposalLines(c[1:]))
+			what, options = fmt.Sprintf("these %d identical matches", len(c)), "y,n,a,q,e,s,?"
+		}
+		for {
+			fmt.Fprintf(os.Stdout, "(%d/%d) Rewrite %s [%s]? ", i+1, len(clusters), what, options)
+			answer, err := answers.ReadString('\n')
+			if err != nil && answer == "" {
+				if err == io.EOF {
+					fmt.Fprintln(os.Stdout)
+					return errStopReview
+				}
+				return err
+			}
+			switch strings.TrimSpace(answer) {
+			case "y":
+				accept(c, "")
+			case "n":
+			case "a":
+				for _, c := range clusters[i:] {
+					accept(c, "")
+				}
+				return nil
+			case "q":
+				return errStopReview
+			case "e":
+				if !editProposal(c[0]) {
+					continue
+				}
+				accept(c, c[0].Edited)
+			case "s":
+				if len(c) == 1 {
+					fmt.Fprint(os.Stdout, promptHelp)
+					continue
+				}
+				split := make([][]*proposal, 0, len(clusters)+len(c)-1)
+				split = append(split, clusters[:i]...)
+				for _, p := range c {
+					split = append(split, []*proposal{p})
+				}
+				clusters = append(split, clusters[i+1:]...)
+				i--
+			default:
+				fmt.Fprint(os.Stdout, promptHelp)
+				continue
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// accept accepts each of props, with the edited replacement, if any.
+func accept(props []*proposal, edited string) {
+	for _, p := range props {
+		p.Accepted = true
+		if edited != "" {
+			p.Edited = edited
+		}
+	}
+}
+
+// proposalLines returns the file:line of each of props, the file elided where it's that of the previous one, and
+// the list cut short if it's long.
+func proposalLines(props []*proposal) string {
+	const max = 10
+	var b strings.Builder
+	for i, p := range props {
+		if i == max {
+			fmt.Fprintf(&b, ", ... (%d more)", len(props)-max)
+			break
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if i == 0 || props[i-1].File != p.File {
+			fmt.Fprintf(&b, "%s:", p.File)
+		}
+		fmt.Fprintf(&b, "%d", p.Line)
+	}
+	return b.String()
+}
+
+// printProposal prints the file and line of p and its diff, amid the lines around it, to w, colored with ANSI escapes
+// if color.
+func printProposal(w io.Writer, p *proposal, color bool) {
+	header, del, add, reset := "", "", "", ""
+	if color {
+		header, del, add, reset = "\x1b[1m", "\x1b[31m", "\x1b[32m", "\x1b[0m"
+	}
+	fmt.Fprintf(w, "%s%s:%d%s\n", header, p.File, p.Line, reset)
+	if p.Above != "" {
+		io.WriteString(w, prefixLines(" ", strings.TrimSuffix(p.Above, "\n")))
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(p.Diff, "\n"), "\n") {
+		if strings.HasPrefix(line, "+") {
+			fmt.Fprintf(w, "%s%s%s\n", add, line, reset)
+		} else {
+			fmt.Fprintf(w, "%s%s%s\n", del, line, reset)
+		}
+	}
+	if below := strings.TrimSuffix(strings.TrimPrefix(p.Below, "\n"), "\n"); below != "" {
+		io.WriteString(w, prefixLines(" ", below))
+	}
+}
+
+// editProposal has the user edit the replacement of p with $EDITOR, or vi, recording the edit in p, and reports
+// whether they did: the edit must parse as an expression, and an empty one abandons it.
+func editProposal(p *proposal) bool {
+	f, err := ioutil.TempFile("", "eg-edit-*.go")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+		return false
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "%s\n", p.After)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+		return false
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	args, err := splitCommand(editor) // e.g. "code --wait"
+	if err != nil || len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "eg: invalid $EDITOR %q\n", editor)
+		return false
+	}
+	cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "eg: the editor failed: %s\n", err)
+		return false
+	}
+	src, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+		return false
+	}
+	edited := strings.TrimSpace(string(src))
+	if edited == "" {
+		return false
+	}
+	if _, err := parser.ParseExpr(edited); err != nil {
+		fmt.Fprintf(os.Stderr, "eg: the edit doesn't parse as an expression: %s\n", err)
+		return false
+	}
+	p.Edited = edited
+	return true
+}
+
+// isTerminal reports whether f is a terminal, as far as can be told without a system call: whether it's a
+// character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,10 @@
+package main
+
+import "testing"
+
+// TestPrompt answers the prompts of -i and -interactive from the stdin file of each case. Edits, with e, are made by
+// an $EDITOR rewriting Contains as ContainsAny.
+func TestPrompt(t *testing.T) {
+	t.Setenv("EDITOR", "sed -i s/Contains(s,/ContainsAny(s,/")
+	runMainCases(t, "prompt")
+}
@@ -0,0 +1,199 @@
+// Package diff computes the line diffs of eg's patches and source maps.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change in a unified diff.
+const context = 3
+
+// An Op is a single line of an edit script: an unchanged (' '), deleted ('-') or inserted ('+') line. A and B are
+// the indexes of the next line of each side at the point the op applies.
+type Op struct {
+	Kind byte
+	A, B int
+	Line string
+}
+
+// Unified returns a unified diff transforming a into b, with headers naming them aName and bName, or nil if
+// they're identical.
+func Unified(aName, bName string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	ops := Lines(SplitLines(a), SplitLines(b))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+	for i := 0; i < len(ops); {
+		// find the next change and the extent of its hunk, which absorbs any changes separated by few enough
+		// unchanged lines that their contexts would overlap.
+		for i < len(ops) && ops[i].Kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start, end := i-context, i
+		if start < 0 {
+			start = 0
+		}
+		for unchanged := 0; end < len(ops) && unchanged <= 2*context; end++ {
+			if ops[end].Kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > i && ops[end-1].Kind == ' ' {
+			end--
+		}
+		if end += context; end > len(ops) {
+			end = len(ops)
+		}
+
+		writeHunk(&buf, ops[start:end])
+		i = end
+	}
+	return buf.Bytes()
+}
+
+func writeHunk(buf *bytes.Buffer, ops []Op) {
+	var aCount, bCount int
+	for _, op := range ops {
+		if op.Kind != '+' {
+			aCount++
+		}
+		if op.Kind != '-' {
+			bCount++
+		}
+	}
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(ops[0].A, aCount), hunkRange(ops[0].B, bCount))
+	for _, op := range ops {
+		buf.WriteByte(op.Kind)
+		buf.WriteString(op.Line)
+		if !strings.HasSuffix(op.Line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the range of a hunk header, where start is zero-based.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// SplitLines splits b into lines, each retaining its terminating newline.
+func SplitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines computes a minimal edit script from a to b using Myers' algorithm.
+func Lines(a, b []string) []Op {
+	// trim the common prefix and suffix, which keeps the quadratic parts of the algorithm small for local edits.
+	var pre, suf int
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var ops []Op
+	for i := 0; i < pre; i++ {
+		ops = append(ops, Op{' ', i, i, a[i]})
+	}
+	for _, op := range myers(a[pre:len(a)-suf], b[pre:len(b)-suf]) {
+		op.A += pre
+		op.B += pre
+		ops = append(ops, op)
+	}
+	for i := suf; i > 0; i-- {
+		ops = append(ops, Op{' ', len(a) - i, len(b) - i, a[len(a)-i]})
+	}
+	return ops
+}
+
+func myers(a, b []string) []Op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[max+k] is the furthest x reached on diagonal k; trace[d] is v as it was before step d.
+	v := make([]int, 2*max+2)
+	var trace [][]int
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1] // down: insertion
+			} else {
+				x = v[max+k-1] + 1 // right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var ops []Op
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[max+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, Op{' ', x, y, a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, Op{'+', x, y, b[y]})
+		} else {
+			x--
+			ops = append(ops, Op{'-', x, y, a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, Op{' ', x, y, a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
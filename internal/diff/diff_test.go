@@ -0,0 +1,147 @@
+package diff_test
+
+import (
+	"fmt"
+	"github.com/jwilner/eg/internal/diff"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lines returns the lines numbered from to to, each holding its number, with a replaced line for each of the
+// numbers in replace.
+func lines(from, to int, replace map[int]string) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		if r, ok := replace[i]; ok {
+			b.WriteString(r)
+			continue
+		}
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnified(t *testing.T) {
+	for _, test := range []struct {
+		name, a, b, want string
+	}{
+		{"identical", "x\ny\n", "x\ny\n", ""},
+		{"both empty", "", "", ""},
+		{"from empty", "", "x\ny\n", `--- a
++++ b
+@@ -0,0 +1,2 @@
++x
++y
+`},
+		{"to empty", "x\ny\n", "", `--- a
++++ b
+@@ -1,2 +0,0 @@
+-x
+-y
+`},
+		{"no trailing newline", "x\ny", "x\nz", `--- a
++++ b
+@@ -1,2 +1,2 @@
+ x
+-y
+\ No newline at end of file
++z
+\ No newline at end of file
+`},
+		{"trailing newline added", "x", "x\n", `--- a
++++ b
+@@ -1 +1 @@
+-x
+\ No newline at end of file
++x
+`},
+		{"insertion", lines(1, 10, nil), lines(1, 10, map[int]string{5: "5\nnew\n"}), `--- a
++++ b
+@@ -3,6 +3,7 @@
+ 3
+ 4
+ 5
++new
+ 6
+ 7
+ 8
+`},
+		{"deletion", lines(1, 10, nil), lines(1, 10, map[int]string{5: ""}), `--- a
++++ b
+@@ -2,7 +2,6 @@
+ 2
+ 3
+ 4
+-5
+ 6
+ 7
+ 8
+`},
+		// changes separated by up to twice the context are in one hunk, since their contexts would overlap
+		{"adjacent hunks merged", lines(1, 20, nil), lines(1, 20, map[int]string{5: "five\n", 12: "twelve\n"}), `--- a
++++ b
+@@ -2,14 +2,14 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+ 9
+ 10
+ 11
+-12
++twelve
+ 13
+ 14
+ 15
+`},
+		{"hunks apart", lines(1, 20, nil), lines(1, 20, map[int]string{5: "five\n", 13: "thirteen\n"}), `--- a
++++ b
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+@@ -10,7 +10,7 @@
+ 10
+ 11
+ 12
+-13
++thirteen
+ 14
+ 15
+ 16
+`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(diff.Unified("a", "b", []byte(test.a), []byte(test.b))); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		want []string
+	}{
+		{"", nil},
+		{"\n", []string{"\n"}},
+		{"x", []string{"x"}},
+		{"x\ny\n", []string{"x\n", "y\n"}},
+		{"x\n\ny", []string{"x\n", "\n", "y"}},
+	} {
+		if got := diff.SplitLines([]byte(test.src)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitLines(%q) = %q, want %q", test.src, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package eg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"regexp"
+)
+
+// A Mismatch describes the comparison that caused the pattern to fail to
+// match an input expression.
+type Mismatch struct {
+	Pattern ast.Node // the pattern node being compared; nil for a wildcard
+	Input   ast.Node // the input node it was compared with
+	Reason  string
+
+	format string // the format of Reason
+}
+
+// A Candidate is the outcome of attempting to match the pattern against a
+// single input expression.
+type Candidate struct {
+	Expr     ast.Expr
+	Bindings map[string]ast.Expr // wildcard bindings of a successful match
+	Mismatch *Mismatch           // why the match failed; nil if it succeeded
+
+	// TypeChange, of a successful match, is the change of type its replacement makes, as Transform type checks it,
+	// if it makes one, or doesn't type check there; Transform refuses the rewrite if its Refused is set.
+	TypeChange *TypeChange
+}
+
+// Diagnose attempts to match the pattern against each of exprs, whose
+// package and type information are supplied in pkg and info, and reports
+// the outcome of each attempt in the same order.
+//
+// Unlike Transform, it does not modify the input.
+func (tr *Transformer) Diagnose(info *types.Info, pkg *types.Package, exprs []ast.Expr) []Candidate {
+	tr.prepare(info, pkg)
+	tr.diagnose = true
+	savedRefused, savedChanges := tr.refused, tr.typeChanges
+	defer func() {
+		tr.diagnose, tr.mismatch, tr.currentPkg = false, nil, nil
+		tr.refused, tr.typeChanges = savedRefused, savedChanges
+	}()
+
+	cands := make([]Candidate, 0, len(exprs))
+	for _, e := range exprs {
+		tr.env = make(map[string]ast.Expr)
+		tr.mismatch = nil
+
+		c := Candidate{Expr: e}
+		if tr.matchExpr(tr.before, e) {
+			c.Bindings = tr.env
+			tr.refused, tr.typeChanges = make(map[ast.Expr]bool), nil
+			tr.checkType(e, rvToExpr(tr.subst(tr.env, reflect.ValueOf(tr.after), reflect.ValueOf(e.Pos()))))
+			if len(tr.typeChanges) > 0 {
+				c.TypeChange = &tr.typeChanges[0]
+			}
+		} else if c.Mismatch = tr.mismatch; c.Mismatch == nil {
+			c.Mismatch = &Mismatch{Pattern: tr.before, Input: e, Reason: "no match"}
+		}
+		cands = append(cands, c)
+	}
+	tr.env = nil
+	return cands
+}
+
+// A Rejection records an input expression which resembles the pattern,
+// agreeing with it at its root, e.g. calling the same function, but
+// which doesn't match it, for a difference within, such as the type of
+// an argument.
+type Rejection struct {
+	Pos    token.Pos
+	Reason string // why it doesn't match, as for Mismatch
+	Kind   string // Reason with its particulars elided, the same for rejections of a sort
+}
+
+// Rejections returns the rejections of the most recent call to
+// Transform, in the order they were encountered.
+func (tr *Transformer) Rejections() []Rejection {
+	return tr.rejections
+}
+
+// reject records a Rejection for e, which failed to match the pattern,
+// if it resembles it, matching it again with diagnostics to find why.
+func (tr *Transformer) reject(e ast.Expr) {
+	if tr.diagnose || tr.refused[e] || !tr.resembles(e) {
+		return
+	}
+	tr.refused[e] = true // visited once via each path to it
+	tr.env = make(map[string]ast.Expr)
+	tr.diagnose, tr.mismatch = true, nil
+	if !tr.matchExpr(tr.before, e) && tr.mismatch != nil {
+		if tr.Verbosity >= VerboseCandidates {
+			tr.logf("%s: %s doesn't match %s: %s\n", tr.fset.Position(e.Pos()),
+				astString(tr.fset, e), astString(tr.fset, tr.before), tr.mismatch.Reason)
+		}
+		tr.rejections = append(tr.rejections, Rejection{
+			Pos:    e.Pos(),
+			Reason: tr.mismatch.Reason,
+			Kind:   verb.ReplaceAllString(tr.mismatch.format, "..."),
+		})
+	}
+	tr.diagnose, tr.mismatch = false, nil
+}
+
+// whyNot returns why e doesn't match the pattern, matching it again with
+// diagnostics.
+func (tr *Transformer) whyNot(e ast.Expr) string {
+	saved := tr.env
+	tr.env = make(map[string]ast.Expr)
+	tr.diagnose, tr.mismatch = true, nil
+	defer func() { tr.env, tr.diagnose, tr.mismatch = saved, false, nil }()
+	if tr.matchExpr(tr.before, e) || tr.mismatch == nil {
+		return "no match"
+	}
+	return tr.mismatch.Reason
+}
+
+// tracef prints a decision of the matcher about e, which is traced.
+func (tr *Transformer) tracef(e ast.Expr, format string, args ...interface{}) {
+	tr.logf("%s: trace: %s %s\n", tr.fset.Position(e.Pos()), astString(tr.fset, e), fmt.Sprintf(format, args...))
+}
+
+// verb matches the verbs of a format.
+var verb = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z]`)
+
+// resembles reports whether e agrees with the pattern at its root: it's
+// the same kind of expression, with the same operator, callee or
+// selected name, as applicable. Wildcards, references and literals
+// resemble nothing, since every expression of their kind would do.
+func (tr *Transformer) resembles(e ast.Expr) bool {
+	x, y := unparen(tr.before), unparen(e)
+	if _, ok := tr.wildcardObj(x); ok || isRef(x, &tr.info) != nil || isRef(y, &tr.info) != nil {
+		return false
+	}
+	if reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return false
+	}
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		return false
+	case *ast.CallExpr:
+		tr.env = make(map[string]ast.Expr)
+		if tv, _ := tr.info.typeAndValue(x.Fun); tv.IsType() {
+			return tr.matchType(x.Fun, y.(*ast.CallExpr).Fun)
+		}
+		return tr.matchExpr(x.Fun, y.(*ast.CallExpr).Fun)
+	case *ast.SelectorExpr:
+		return x.Sel.Name == y.(*ast.SelectorExpr).Sel.Name
+	case *ast.UnaryExpr:
+		return x.Op == y.(*ast.UnaryExpr).Op
+	case *ast.BinaryExpr:
+		return x.Op == y.(*ast.BinaryExpr).Op
+	}
+	return true
+}
+
+// mismatchf records why pattern x failed to match input y, if diagnostics
+// are enabled and no failure (which would be more deeply nested) has been
+// recorded yet for the current attempt. It always returns false.
+func (tr *Transformer) mismatchf(x, y ast.Node, format string, args ...interface{}) bool {
+	if tr.diagnose && tr.mismatch == nil {
+		tr.mismatch = &Mismatch{Pattern: x, Input: y, Reason: fmt.Sprintf(format, args...), format: format}
+	}
+	return false
+}
+
+// -- utilities --------------------------------------------------------
+
+// objString returns the (possibly package-qualified) name of obj.
+func objString(obj types.Object) string {
+	if obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope() {
+		return obj.Pkg().Name() + "." + obj.Name()
+	}
+	return obj.Name()
+}
+
+// refString describes what an input identifier refers to, if anything.
+func refString(obj types.Object) string {
+	if obj == nil {
+		return "is not a reference"
+	}
+	return "refers to " + objString(obj)
+}
+
+// nodeKind returns the syntactic kind of n, for use in messages.
+func nodeKind(n ast.Node) string {
+	return fmt.Sprintf("%T", n)
+}
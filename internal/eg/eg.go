@@ -0,0 +1,593 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package eg implements the example-based refactoring tool whose
+// command-line is defined in github.com/jwilner/eg.
+//
+// It is a fork of golang.org/x/tools/refactor/eg, extended with the
+// matcher diagnostics and capabilities the command needs.
+package eg
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+)
+
+const Help = `
+This tool implements example-based refactoring of expressions.
+
+The transformation is specified as a Go file defining two functions,
+'before' and 'after', of identical types.  Each function body consists
+of a single statement: either a return statement with a single
+(possibly multi-valued) expression, or an expression statement.  The
+'before' expression specifies a pattern and the 'after' expression its
+replacement.
+
+	package P
+ 	import ( "errors"; "fmt" )
+ 	func before(s string) error { return fmt.Errorf("%s", s) }
+ 	func after(s string)  error { return errors.New(s) }
+
+The expression statement form is useful when the expression has no
+result, for example:
+
+ 	func before(msg string) { log.Fatalf("%s", msg) }
+ 	func after(msg string)  { log.Fatal(msg) }
+
+A 'before' function whose body is anything else, e.g. several
+statements, or an if statement, is a statement template: its body is a
+pattern matching sequences of statements of a block, each matched as a
+whole, which are replaced by the statements of the body of 'after'
+(none, to delete them). A final call of panic, which a function with
+results needs if its body doesn't otherwise end in a terminating
+statement, is part of neither:
+
+ 	func before(err error) error { if err != nil { return err }; panic(0) }
+ 	func after(err error) error  { if err != nil { return fmt.Errorf("op: %w", err) }; panic(0) }
+
+The variables the pattern declares, e.g. by :=, match any variable of
+the input, declared or used in the same way, of an assignable type, and
+stand for it in the replacement. A template whose statements return
+matches only in functions whose results are of the template's types.
+
+The parameters of both functions are wildcards that may match any
+expression assignable to that type.  If the pattern contains multiple
+occurrences of the same parameter, each must match the same expression
+in the input for the pattern to match.  If the replacement contains
+multiple occurrences of the same parameter, the expression will be
+duplicated, possibly changing the side-effects.
+
+The tool analyses all Go code in the packages specified by the
+arguments, replacing all occurrences of the pattern with the
+substitution.
+
+So, the transform above would change this input:
+	err := fmt.Errorf("%s", "error: " + msg)
+to this output:
+	err := errors.New("error: " + msg)
+
+Identifiers, including qualified identifiers (p.X) are considered to
+match only if they denote the same object.  This allows correct
+matching even in the presence of dot imports, named imports and
+locally shadowed package names in the input program.
+
+Matching of type syntax is semantic, not syntactic: type syntax in the
+pattern matches type syntax in the input if the types are identical.
+Thus, func(x int) matches func(y int).
+
+This tool was inspired by other example-based refactoring tools,
+'gofmt -r' for Go and Refaster for Java.
+
+
+LIMITATIONS
+===========
+
+EXPRESSIVENESS
+
+Only refactorings that replace one expression with another, regardless
+of the expression's context, or a sequence of statements with another,
+may be expressed.  A statement pattern matches the statements
+themselves, not sequences of them interleaved with others, and may not
+contain declarations, labels, switch or select statements.
+
+A pattern that contains a function literal (and hence statements)
+never matches.
+
+There is no way to generalize over related types, e.g. to express that
+a wildcard may have any integer type, for example.
+
+It is not possible to replace an expression by one of a different
+type, even in contexts where this is legal, such as x in fmt.Print(x).
+
+The struct literals T{x} and T{K: x} cannot both be matched by a single
+template.
+
+
+SAFETY
+
+Verifying that a transformation does not introduce type errors is very
+complex in the general case.  An innocuous-looking replacement of one
+constant by another (e.g. 1 to 2) may cause type errors relating to
+array types and indices, for example.  The tool performs only very
+superficial checks of type preservation.
+
+
+IMPORTS
+
+Although the matching algorithm is fully aware of scoping rules, the
+replacement algorithm is not, so the replacement code may contain
+incorrect identifier syntax for imported objects if there are dot
+imports, named imports or locally shadowed package names in the input
+program.
+
+Imports are added as needed, but they are not removed as needed.
+Run 'goimports' on the modified file for now.
+
+Dot imports are forbidden in the template.
+
+
+TIPS
+====
+
+Sometimes a little creativity is required to implement the desired
+migration.  This section lists a few tips and tricks.
+
+To remove the final parameter from a function, temporarily change the
+function signature so that the final parameter is variadic, as this
+allows legal calls both with and without the argument.  Then use eg to
+remove the final argument from all callers, and remove the variadic
+parameter by hand.  The reverse process can be used to add a final
+parameter.
+
+To add or remove parameters other than the final one, you must do it in
+stages: (1) declare a variant function f' with a different name and the
+desired parameters; (2) use eg to transform calls to f into calls to f',
+changing the arguments as needed; (3) change the declaration of f to
+match f'; (4) use eg to rename f' to f in all calls; (5) delete f'.
+`
+
+// TODO(adonovan): expand upon the above documentation as an HTML page.
+
+// The levels of Transformer.Verbosity, each of which prints the
+// diagnostics of those below it too.
+const (
+	Quiet             = iota
+	VerboseMatches    // print each match
+	VerboseCandidates // and why each candidate resembling the pattern doesn't match
+	VerboseBindings   // and the wildcard bindings tried, with the template
+)
+
+// A Transformer represents a single example-based transformation.
+type Transformer struct {
+	fset           *token.FileSet
+	info           typeInfo                           // type info for template/input/output ASTs
+	wildcards      map[*types.Var]bool                // set of parameters in func before()
+	params         []string                           // names of the parameters of before(), in order
+	env            map[string]ast.Expr                // maps parameter name to wildcard binding
+	importedObjs   map[types.Object]*ast.SelectorExpr // objects imported by after().
+	before, after  ast.Expr
+	afterStmts     []ast.Stmt
+	beforeStmts    []ast.Stmt            // the pattern of a statement template, for which before and after are nil
+	locals         map[types.Object]bool // the variables the statement pattern declares
+	results        *types.Tuple          // the results of a statement template's functions, if it returns
+	sigs           []*types.Signature    // of the functions enclosing the node being transformed
+	allowWildcards bool
+	syntactic      bool // whether before can be matched without the input's type information
+
+	// StrictTypes causes rewrites whose replacement doesn't have exactly
+	// the type of the original expression to be refused; by default only
+	// those whose type isn't even assignable to it are.
+	StrictTypes bool
+
+	// OnMatch, if set, is called with each match and its replacement,
+	// once it's type checked, and refuses the rewrite if it returns false.
+	OnMatch func(orig, repl ast.Node) bool
+
+	// Revise, if set, is called with each match OnMatch accepts and its
+	// replacement, and returns the replacement to use instead, e.g. as
+	// edited by a user. The revision isn't type checked, and the
+	// imports it needs aren't added.
+	//
+	// The matches of a statement template, and their replacements, are
+	// passed to both as Stmts.
+	Revise func(orig, repl ast.Node) ast.Node
+
+	// Verbosity is the level of the diagnostics printed to stderr, which
+	// may be changed between calls to Transform, e.g. to scope them to a
+	// file.
+	Verbosity int
+
+	// Trace, if set, are expressions of the file being transformed for
+	// which every decision of the matcher is printed to stderr, whatever
+	// the Verbosity.
+	Trace map[ast.Expr]bool
+
+	// Log, if set, is where the diagnostics of Verbosity and Trace are
+	// printed, rather than stderr.
+	Log io.Writer
+
+	// Working state of Transform():
+	syntaxOnly  bool           // whether the input has no type information, as for TransformSyntax
+	nsubsts     int            // number of substitutions made
+	currentPkg  *types.Package // package of current call
+	typeChanges []TypeChange   // rewrites which changed the type of an expression
+	refused     map[ast.Expr]bool
+	replaced    map[ast.Expr]bool // the replacements made, which aren't matched again
+	refusals    []Refusal
+	rejections  []Rejection
+	gaps        []Gap
+	directives  []token.Pos       // the //line directives of the file
+	toTrace     map[ast.Expr]bool // the expressions of Trace not yet traced
+	traced      bool              // whether the expression being matched is among Trace
+
+	// Working state of Diagnose():
+	diagnose bool      // whether to record mismatches
+	mismatch *Mismatch // the first mismatch of the current attempt
+}
+
+// NewTransformer returns a transformer based on the specified template,
+// a single-file package containing "before" and "after" functions as
+// described in the package documentation.
+// tmplInfo is the type information for tmplFile. If verbose, Verbosity is
+// VerboseBindings.
+func NewTransformer(fset *token.FileSet, tmplPkg *types.Package, tmplFile *ast.File, tmplInfo *types.Info, verbose bool) (*Transformer, error) {
+	// Check the template.
+	beforeSig := funcSig(tmplPkg, "before")
+	if beforeSig == nil {
+		return nil, fmt.Errorf("no 'before' func found in template")
+	}
+	afterSig := funcSig(tmplPkg, "after")
+	if afterSig == nil {
+		return nil, fmt.Errorf("no 'after' func found in template")
+	}
+
+	// TODO(adonovan): should we also check the names of the params match?
+	if !types.Identical(afterSig, beforeSig) {
+		return nil, fmt.Errorf("before %s and after %s functions have different signatures",
+			beforeSig, afterSig)
+	}
+
+	for _, imp := range tmplFile.Imports {
+		if imp.Name != nil && imp.Name.Name == "." {
+			// Dot imports are currently forbidden.  We
+			// make the simplifying assumption that all
+			// imports are regular, without local renames.
+			return nil, fmt.Errorf("dot-import (of %s) in template", imp.Path.Value)
+		}
+	}
+	var beforeDecl, afterDecl *ast.FuncDecl
+	for _, decl := range tmplFile.Decls {
+		if decl, ok := decl.(*ast.FuncDecl); ok {
+			switch decl.Name.Name {
+			case "before":
+				beforeDecl = decl
+			case "after":
+				afterDecl = decl
+			}
+		}
+	}
+
+	wildcards := make(map[*types.Var]bool)
+	for i := 0; i < beforeSig.Params().Len(); i++ {
+		wildcards[beforeSig.Params().At(i)] = true
+	}
+	params := make([]string, beforeSig.Params().Len())
+	for i := range params {
+		params[i] = beforeSig.Params().At(i).Name()
+	}
+
+	var before, after ast.Expr
+	var beforeStmts, afterStmts []ast.Stmt
+	var err error
+	if beforeDecl.Body != nil && IsStatementTemplate(beforeDecl) {
+		if beforeStmts, afterStmts, err = stmtPatterns(tmplInfo, beforeDecl, afterDecl); err != nil {
+			return nil, err
+		}
+	} else {
+		if before, err = soleExpr(beforeDecl); err != nil {
+			return nil, fmt.Errorf("before: %s", err)
+		}
+		if afterStmts, after, err = stmtAndExpr(afterDecl); err != nil {
+			return nil, fmt.Errorf("after: %s", err)
+		}
+		if err := checkExprTypes(tmplInfo.TypeOf(before), tmplInfo.TypeOf(after)); err != nil {
+			return nil, err
+		}
+	}
+
+	tr := &Transformer{
+		fset:           fset,
+		wildcards:      wildcards,
+		params:         params,
+		allowWildcards: true,
+		importedObjs:   make(map[types.Object]*ast.SelectorExpr),
+		before:         before,
+		after:          after,
+		afterStmts:     afterStmts,
+		beforeStmts:    beforeStmts,
+	}
+	if beforeStmts != nil {
+		tr.locals = declaredVars(tmplInfo, beforeStmts)
+		if returns(beforeStmts) || returns(afterStmts) {
+			tr.results = beforeSig.Results()
+		}
+	}
+
+	// Look up type info in the template, the input package, and the
+	// synthesized ASTs alike.  This saves us having to book-keep
+	// where each ast.Node originated as we construct the resulting
+	// hybrid AST.
+	tr.info = typeInfo{tmpl: tmplInfo, subst: newSubstInfo()}
+
+	// Compute set of imported objects required by after(), or by
+	// the statements of a statement template's.
+	// TODO(adonovan): reject dot-imports in pattern
+	imported := func(n ast.Node) bool {
+		if n, ok := n.(*ast.SelectorExpr); ok {
+			if _, ok := tmplInfo.Selections[n]; !ok {
+				// qualified ident
+				obj := tmplInfo.Uses[n.Sel]
+				tr.importedObjs[obj] = n
+				return false // prune
+			}
+		}
+		return true // recur
+	}
+	if beforeStmts != nil {
+		for _, s := range afterStmts {
+			ast.Inspect(s, imported)
+		}
+	} else {
+		ast.Inspect(after, imported)
+	}
+	tr.syntactic = tr.isSyntactic()
+
+	if verbose {
+		tr.Verbosity = VerboseBindings
+	}
+	return tr, nil
+}
+
+// Clone returns a copy of tr with working state of its own, so that it
+// may transform files concurrently with tr. OnMatch and Revise are
+// copied, so they should be set anew if they refer to the state of the
+// goroutine using tr.
+func (tr *Transformer) Clone() *Transformer {
+	c := *tr
+	// the template's and the packages' type info is only read, so it's shared
+	c.info = typeInfo{tmpl: tr.info.tmpl, input: tr.info.input, subst: newSubstInfo()}
+	c.sigs = nil
+	c.env = nil
+	c.refused, c.replaced = nil, nil
+	c.refusals, c.rejections, c.typeChanges, c.gaps = nil, nil, nil, nil
+	return &c
+}
+
+// A Binding is the input expression a match binds a wildcard to.
+type Binding struct {
+	Name string // of the parameter of before()
+	Expr ast.Expr
+}
+
+// Bindings returns the wildcard bindings of the match being rewritten, in
+// the order of before()'s parameters, for OnMatch or Revise to consult.
+func (tr *Transformer) Bindings() []Binding {
+	var bindings []Binding
+	for _, name := range tr.params {
+		if e, ok := tr.env[name]; ok {
+			bindings = append(bindings, Binding{name, e})
+		}
+	}
+	return bindings
+}
+
+// WriteAST is a convenience function that writes AST f to the specified file.
+func WriteAST(fset *token.FileSet, filename string, f *ast.File) (err error) {
+	fh, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err2 := fh.Close(); err != nil {
+			err = err2 // prefer earlier error
+		}
+	}()
+	return format.Node(fh, fset, f)
+}
+
+// -- utilities --------------------------------------------------------
+
+// funcSig returns the signature of the specified package-level function.
+func funcSig(pkg *types.Package, name string) *types.Signature {
+	if f, ok := pkg.Scope().Lookup(name).(*types.Func); ok {
+		return f.Type().(*types.Signature)
+	}
+	return nil
+}
+
+// soleExpr returns the sole expression in the before/after template function.
+func soleExpr(fn *ast.FuncDecl) (ast.Expr, error) {
+	if fn.Body == nil {
+		return nil, fmt.Errorf("no body")
+	}
+	if len(fn.Body.List) != 1 {
+		return nil, fmt.Errorf("must contain a single statement")
+	}
+	switch stmt := fn.Body.List[0].(type) {
+	case *ast.ReturnStmt:
+		if len(stmt.Results) != 1 {
+			return nil, fmt.Errorf("return statement must have a single operand")
+		}
+		return stmt.Results[0], nil
+
+	case *ast.ExprStmt:
+		return stmt.X, nil
+	}
+
+	return nil, fmt.Errorf("must contain a single return or expression statement")
+}
+
+// stmtAndExpr returns the expression in the last return statement as well as the preceding lines.
+func stmtAndExpr(fn *ast.FuncDecl) ([]ast.Stmt, ast.Expr, error) {
+	if fn.Body == nil {
+		return nil, nil, fmt.Errorf("no body")
+	}
+
+	n := len(fn.Body.List)
+	if n == 0 {
+		return nil, nil, fmt.Errorf("must contain at least one statement")
+	}
+
+	stmts, last := fn.Body.List[:n-1], fn.Body.List[n-1]
+
+	switch last := last.(type) {
+	case *ast.ReturnStmt:
+		if len(last.Results) != 1 {
+			return nil, nil, fmt.Errorf("return statement must have a single operand")
+		}
+		return stmts, last.Results[0], nil
+
+	case *ast.ExprStmt:
+		return stmts, last.X, nil
+	}
+
+	return nil, nil, fmt.Errorf("must end with a single return or expression statement")
+}
+
+// checkExprTypes returns an error if Tb (type of before()) is not
+// safe to replace with Ta (type of after()).
+//
+// Only superficial checks are performed, and they may result in both
+// false positives and negatives.
+//
+// Ideally, we would only require that the replacement be assignable
+// to the context of a specific pattern occurrence, but the type
+// checker doesn't record that information and it's complex to deduce.
+// A Go type cannot capture all the constraints of a given expression
+// context, which may include the size, constness, signedness,
+// namedness or constructor of its type, and even the specific value
+// of the replacement.  (Consider the rule that array literal keys
+// must be unique.)  So we cannot hope to prove the safety of a
+// transformation in general.
+func checkExprTypes(Tb, Ta types.Type) error {
+	if types.AssignableTo(Tb, Ta) {
+		// safe: replacement is assignable to pattern.
+	} else if tuple, ok := Tb.(*types.Tuple); ok && tuple.Len() == 0 {
+		// safe: pattern has void type (must appear in an ExprStmt).
+	} else {
+		return fmt.Errorf("%s is not a safe replacement for %s", Ta, Tb)
+	}
+	return nil
+}
+
+// A typeInfo is the type info the transformer consults: that of the
+// ASTs it synthesizes, which is its own, over that of the package being
+// transformed and that of the template, which it only reads, so that its
+// clones share them.
+type typeInfo struct {
+	subst *types.Info // of the replacements, recorded by updateTypeInfo
+	input *types.Info // of the package being transformed, if any
+	tmpl  *types.Info
+}
+
+// newSubstInfo returns the empty type info of a transformer's replacements.
+func newSubstInfo() *types.Info {
+	return &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+}
+
+// layers returns the type infos of ti, in the order they're consulted.
+func (ti *typeInfo) layers() [3]*types.Info {
+	return [3]*types.Info{ti.subst, ti.input, ti.tmpl}
+}
+
+// typeAndValue returns the type and value of e, and whether it's known.
+func (ti *typeInfo) typeAndValue(e ast.Expr) (types.TypeAndValue, bool) {
+	for _, info := range ti.layers() {
+		if info != nil {
+			if tv, ok := info.Types[e]; ok {
+				return tv, true
+			}
+		}
+	}
+	return types.TypeAndValue{}, false
+}
+
+// def returns the object id declares, or nil.
+func (ti *typeInfo) def(id *ast.Ident) types.Object {
+	for _, info := range ti.layers() {
+		if info != nil {
+			if obj := info.Defs[id]; obj != nil {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+// use returns the object id refers to, or nil.
+func (ti *typeInfo) use(id *ast.Ident) types.Object {
+	for _, info := range ti.layers() {
+		if info != nil {
+			if obj := info.Uses[id]; obj != nil {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+// selection returns the selection of sel, or nil if it's a qualified
+// identifier.
+func (ti *typeInfo) selection(sel *ast.SelectorExpr) *types.Selection {
+	for _, info := range ti.layers() {
+		if info != nil {
+			if s := info.Selections[sel]; s != nil {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// typeOf returns the type of e, or nil, as types.Info.TypeOf does.
+func (ti *typeInfo) typeOf(e ast.Expr) types.Type {
+	if tv, ok := ti.typeAndValue(e); ok {
+		return tv.Type
+	}
+	if id, _ := e.(*ast.Ident); id != nil {
+		if obj := identObj(ti, id); obj != nil {
+			return obj.Type()
+		}
+	}
+	return nil
+}
+
+// logf prints a diagnostic of the matcher to tr.Log, or stderr.
+func (tr *Transformer) logf(format string, args ...interface{}) {
+	w := tr.Log
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// (debugging only)
+func astString(fset *token.FileSet, n ast.Node) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, n)
+	return buf.String()
+}
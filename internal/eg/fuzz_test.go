@@ -0,0 +1,145 @@
+package eg
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+// The templates fuzzed refer only to the universe, so the programs import nothing.
+const (
+	exprTemplate = `package template
+
+func before(s string) bool { return s == "" }
+func after(s string) bool  { return len(s) == 0 }
+`
+	stmtTemplate = `package template
+
+func before(x int) {
+	println(x)
+	println(x)
+}
+
+func after(x int) {
+	println(x, x)
+}
+`
+)
+
+// FuzzTransform applies an expression template to the body of a function.
+func FuzzTransform(f *testing.F) {
+	for _, body := range []string{
+		`_ = s == ""`,
+		`if s == "" { println(x) }`,
+		`_ = func() bool { return s+"x" == "" }()`,
+		`for s == "" { s = "x" }`,
+		`var t string; _ = t == "" && s == "" || x == 0`,
+		`_ = (s) == "" // comment`,
+	} {
+		f.Add(body)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		fuzzTransform(t, exprTemplate, body)
+	})
+}
+
+// FuzzTransformStmts applies a statement template to the body of a function.
+func FuzzTransformStmts(f *testing.F) {
+	for _, body := range []string{
+		"println(x)\nprintln(x)",
+		"println(x)\n// comment\nprintln(x)",
+		"println(x); println(x); println(x)",
+		"if x > 0 {\n\tprintln(x)\n\n\tprintln(x)\n}\nprintln(x)",
+		"println(s)\nprintln(x)\nprintln(x)\nprintln(s)",
+		"switch {\ncase x > 0:\n\tprintln(x)\n\tprintln(x)\n}",
+	} {
+		f.Add(body)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		fuzzTransform(t, stmtTemplate, body)
+	})
+}
+
+// fuzzTransform applies the template tmpl to a function of body, if it's well typed, checking that the output is
+// too, and that applying the template again to it finds no matches.
+func fuzzTransform(t *testing.T, tmpl, body string) {
+	fset := token.NewFileSet()
+	src := "package p\n\nfunc f(s string, x int) {\n" + body + "\n}\n"
+	file, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		return
+	}
+	pkg, info, err := checkTest(fset, file)
+	if err != nil {
+		return
+	}
+	tr := newTestTransformer(t, fset, tmpl)
+	if tr.Transform(info, pkg, file) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, fset, file, tr.Gaps()); err != nil {
+		t.Fatalf("formatting output: %v", err)
+	}
+	out, err := parser.ParseFile(fset, "p.go", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		t.Fatalf("output doesn't parse: %v\n%s", err, buf.Bytes())
+	}
+	pkg, info, err = checkTest(fset, out)
+	if err != nil {
+		t.Fatalf("output doesn't type check: %v\n%s", err, buf.Bytes())
+	}
+	if n := newTestTransformer(t, fset, tmpl).Transform(info, pkg, out); n != 0 {
+		t.Fatalf("found %d matches in the output:\n%s", n, buf.Bytes())
+	}
+}
+
+// newTestTransformer returns the transformer of the template src.
+func newTestTransformer(t *testing.T, fset *token.FileSet, src string) *Transformer {
+	t.Helper()
+	file, err := parser.ParseFile(fset, "template.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, info, err := checkTest(fset, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTransformer(fset, pkg, file, info, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// testImporter is shared by the templates and the programs, so that they refer to the same packages.
+var testImporter = importer.Default()
+
+// checkTest type checks file, importing the standard library from export data, tolerating the soft errors the
+// transformer may leave, such as unused variables.
+func checkTest(fset *token.FileSet, file *ast.File) (*types.Package, *types.Info, error) {
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	var hard error
+	conf := types.Config{
+		Importer: testImporter,
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); (!ok || !terr.Soft) && hard == nil {
+				hard = err
+			}
+		},
+	}
+	pkg, _ := conf.Check(file.Name.Name, fset, []*ast.File{file}, info)
+	return pkg, info, hard
+}
@@ -0,0 +1,323 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"log"
+	"reflect"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// matchExpr reports whether pattern x matches y.
+//
+// If tr.allowWildcards, Idents in x that refer to parameters are
+// treated as wildcards, and match any y that is assignable to the
+// parameter type; matchExpr records this correspondence in tr.env.
+// Otherwise, matchExpr simply reports whether the two trees are
+// equivalent.
+//
+// A wildcard appearing more than once in the pattern must
+// consistently match the same tree.
+func (tr *Transformer) matchExpr(x, y ast.Expr) bool {
+	if x == nil && y == nil {
+		return true
+	}
+	if x == nil || y == nil {
+		return tr.mismatchf(x, y, "one of pattern and input is missing")
+	}
+	x = unparen(x)
+	y = unparen(y)
+
+	// Is x a wildcard?  (a reference to a 'before' parameter)
+	if xobj, ok := tr.wildcardObj(x); ok {
+		return tr.matchWildcard(xobj, y)
+	}
+
+	// Is x a variable a statement pattern declares, or the blank identifier?
+	if xid, ok := x.(*ast.Ident); ok && tr.locals != nil {
+		if xid.Name == "_" {
+			if yid, ok := y.(*ast.Ident); !ok || yid.Name != "_" {
+				return tr.mismatchf(x, y, "pattern is _ but input is %s", nodeKind(y))
+			}
+			return true
+		}
+		if xobj := identObj(&tr.info, xid); tr.locals[xobj] {
+			return tr.matchLocal(xid, xobj, y)
+		}
+	}
+
+	// Object identifiers (including pkg-qualified ones)
+	// are handled semantically, not syntactically.
+	xobj := isRef(x, &tr.info)
+	yobj := isRef(y, &tr.info)
+	if xobj != nil {
+		if xobj != yobj {
+			return tr.mismatchf(x, y, "pattern refers to %s but input %s", objString(xobj), refString(yobj))
+		}
+		return true
+	}
+	if yobj != nil {
+		return tr.mismatchf(x, y, "pattern is %s but input refers to %s", nodeKind(x), objString(yobj))
+	}
+
+	// TODO(adonovan): audit: we cannot assume these ast.Exprs
+	// contain non-nil pointers.  e.g. ImportSpec.Name may be a
+	// nil *ast.Ident.
+
+	if reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return tr.mismatchf(x, y, "pattern is %s but input is %s", nodeKind(x), nodeKind(y))
+	}
+	switch x := x.(type) {
+	case *ast.Ident:
+		log.Fatalf("unexpected Ident: %s", astString(tr.fset, x))
+
+	case *ast.BasicLit:
+		y := y.(*ast.BasicLit)
+		xval := constant.MakeFromLiteral(x.Value, x.Kind, 0)
+		yval := constant.MakeFromLiteral(y.Value, y.Kind, 0)
+		if !constant.Compare(xval, token.EQL, yval) {
+			return tr.mismatchf(x, y, "different literal values %s and %s", x.Value, y.Value)
+		}
+		return true
+
+	case *ast.FuncLit:
+		// func literals (and thus statement syntax) never match.
+		return tr.mismatchf(x, y, "function literals never match")
+
+	case *ast.CompositeLit:
+		y := y.(*ast.CompositeLit)
+		if (x.Type == nil) != (y.Type == nil) {
+			return tr.mismatchf(x, y, "only one of the composite literals has an explicit type")
+		}
+		if x.Type != nil && !tr.matchType(x.Type, y.Type) {
+			return false
+		}
+		if len(x.Elts) != len(y.Elts) {
+			return tr.mismatchf(x, y, "arity mismatch: pattern has %d elements but input has %d", len(x.Elts), len(y.Elts))
+		}
+		return tr.matchExprs(x.Elts, y.Elts)
+
+	case *ast.SelectorExpr:
+		y := y.(*ast.SelectorExpr)
+		if !tr.matchSelectorExpr(x, y) {
+			return false
+		}
+		xs, ys := tr.info.selection(x), tr.info.selection(y)
+		if xs == nil || ys == nil {
+			// without type information, as for TransformSyntax
+			if xs != ys || x.Sel.Name != y.Sel.Name {
+				return tr.mismatchf(x, y, "pattern selects %s but input selects %s", x.Sel.Name, y.Sel.Name)
+			}
+			return true
+		}
+		if xsel, ysel := xs.Obj(), ys.Obj(); xsel != ysel {
+			return tr.mismatchf(x, y, "pattern selects %s but input selects %s", objString(xsel), objString(ysel))
+		}
+		return true
+
+	case *ast.IndexExpr:
+		y := y.(*ast.IndexExpr)
+		return tr.matchExpr(x.X, y.X) &&
+			tr.matchExpr(x.Index, y.Index)
+
+	case *ast.SliceExpr:
+		y := y.(*ast.SliceExpr)
+		if x.Slice3 != y.Slice3 {
+			return tr.mismatchf(x, y, "only one of the slice expressions has three indices")
+		}
+		return tr.matchExpr(x.X, y.X) &&
+			tr.matchExpr(x.Low, y.Low) &&
+			tr.matchExpr(x.High, y.High) &&
+			tr.matchExpr(x.Max, y.Max)
+
+	case *ast.TypeAssertExpr:
+		y := y.(*ast.TypeAssertExpr)
+		return tr.matchExpr(x.X, y.X) &&
+			tr.matchType(x.Type, y.Type)
+
+	case *ast.CallExpr:
+		y := y.(*ast.CallExpr)
+		match := tr.matchExpr // function call
+		if tv, _ := tr.info.typeAndValue(x.Fun); tv.IsType() {
+			match = tr.matchType // type conversion
+		}
+		if x.Ellipsis.IsValid() != y.Ellipsis.IsValid() {
+			return tr.mismatchf(x, y, "only one of the calls spreads its final argument with ...")
+		}
+		if !match(x.Fun, y.Fun) {
+			tr.mismatch = nil // report the callee rather than its parts
+			return tr.mismatchf(x, y, "different callee: pattern calls %s but input calls %s",
+				astString(tr.fset, x.Fun), astString(tr.fset, y.Fun))
+		}
+		if len(x.Args) != len(y.Args) {
+			return tr.mismatchf(x, y, "arity mismatch: pattern passes %d arguments but input passes %d", len(x.Args), len(y.Args))
+		}
+		return tr.matchExprs(x.Args, y.Args)
+
+	case *ast.StarExpr:
+		y := y.(*ast.StarExpr)
+		return tr.matchExpr(x.X, y.X)
+
+	case *ast.UnaryExpr:
+		y := y.(*ast.UnaryExpr)
+		if x.Op != y.Op {
+			return tr.mismatchf(x, y, "different operators %s and %s", x.Op, y.Op)
+		}
+		return tr.matchExpr(x.X, y.X)
+
+	case *ast.BinaryExpr:
+		y := y.(*ast.BinaryExpr)
+		if x.Op != y.Op {
+			return tr.mismatchf(x, y, "different operators %s and %s", x.Op, y.Op)
+		}
+		return tr.matchExpr(x.X, y.X) &&
+			tr.matchExpr(x.Y, y.Y)
+
+	case *ast.KeyValueExpr:
+		y := y.(*ast.KeyValueExpr)
+		return tr.matchExpr(x.Key, y.Key) &&
+			tr.matchExpr(x.Value, y.Value)
+	}
+
+	panic(fmt.Sprintf("unhandled AST node type: %T", x))
+}
+
+func (tr *Transformer) matchExprs(xx, yy []ast.Expr) bool {
+	if len(xx) != len(yy) {
+		return false
+	}
+	for i := range xx {
+		if !tr.matchExpr(xx[i], yy[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchType reports whether the two type ASTs denote identical types.
+func (tr *Transformer) matchType(x, y ast.Expr) bool {
+	tvx, _ := tr.info.typeAndValue(x)
+	tvy, _ := tr.info.typeAndValue(y)
+	tx, ty := tvx.Type, tvy.Type
+	if !types.Identical(tx, ty) {
+		return tr.mismatchf(x, y, "wrong type: pattern has type %s but input has type %s", tx, ty)
+	}
+	return true
+}
+
+func (tr *Transformer) wildcardObj(x ast.Expr) (*types.Var, bool) {
+	if x, ok := x.(*ast.Ident); ok && x != nil && tr.allowWildcards {
+		if xobj, ok := tr.info.use(x).(*types.Var); ok && tr.wildcards[xobj] {
+			return xobj, true
+		}
+	}
+	return nil, false
+}
+
+func (tr *Transformer) matchSelectorExpr(x, y *ast.SelectorExpr) bool {
+	if xobj, ok := tr.wildcardObj(x.X); ok {
+		field := x.Sel.Name
+		yt := tr.info.typeOf(y.X)
+		o, _, _ := types.LookupFieldOrMethod(yt, true, tr.currentPkg, field)
+		if o != nil {
+			tr.env[xobj.Name()] = y.X // record binding
+			return true
+		}
+	}
+	return tr.matchExpr(x.X, y.X)
+}
+
+func (tr *Transformer) matchWildcard(xobj *types.Var, y ast.Expr) bool {
+	name := xobj.Name()
+
+	if tr.tracing() {
+		tr.logf("%s: wildcard %s -> %s?: ",
+			tr.fset.Position(y.Pos()), name, astString(tr.fset, y))
+	}
+
+	// Check that y is assignable to the declared type of the param.
+	yt := tr.info.typeOf(y)
+	if yt == nil && tr.syntaxOnly {
+		// The wildcard is an empty interface, which any expression is
+		// assignable to, though a pseudo-expression still can't match.
+		switch y.(type) {
+		case *ast.KeyValueExpr, *ast.Ellipsis:
+			return tr.mismatchf(nil, y, "hole %s cannot bind %s, which isn't an expression", name, nodeKind(y))
+		}
+	} else if yt == nil {
+		// y has no type.
+		// Perhaps it is an *ast.Ellipsis in [...]T{}, or
+		// an *ast.KeyValueExpr in T{k: v}.
+		// Clearly these pseudo-expressions cannot match a
+		// wildcard, but it would nice if we had a way to ignore
+		// the difference between T{v} and T{k:v} for structs.
+		return tr.mismatchf(nil, y, "hole %s cannot bind %s, which has no type", name, nodeKind(y))
+	} else if !types.AssignableTo(yt, xobj.Type()) {
+		if tr.tracing() {
+			tr.logf("%s not assignable to %s\n", yt, xobj.Type())
+		}
+		return tr.mismatchf(nil, y, "wrong type: hole %s has type %s but input has type %s, which is not assignable", name, xobj.Type(), yt)
+	}
+
+	// A wildcard matches any expression.
+	// If it appears multiple times in the pattern, it must match
+	// the same expression each time.
+	if old, ok := tr.env[name]; ok {
+		// found existing binding
+		tr.allowWildcards = false
+		r := tr.matchExpr(old, y)
+		if tr.tracing() {
+			tr.logf("%t secondary match, primary was %s\n",
+				r, astString(tr.fset, old))
+		}
+		tr.allowWildcards = true
+		if !r {
+			tr.mismatch = nil // report the inconsistent binding rather than its parts
+			return tr.mismatchf(nil, y, "hole %s is already bound to %s, which differs from %s",
+				name, astString(tr.fset, old), astString(tr.fset, y))
+		}
+		return r
+	}
+
+	if tr.tracing() {
+		tr.logf("primary match\n")
+	}
+
+	tr.env[name] = y // record binding
+	return true
+}
+
+// tracing reports whether the bindings of wildcards are to be traced:
+// under VerboseBindings, or for an expression among Trace, except when
+// matching again for diagnostics.
+func (tr *Transformer) tracing() bool {
+	return (tr.Verbosity >= VerboseBindings || tr.traced) && !tr.diagnose
+}
+
+// -- utilities --------------------------------------------------------
+
+func unparen(e ast.Expr) ast.Expr { return astutil.Unparen(e) }
+
+// isRef returns the object referred to by this (possibly qualified)
+// identifier, or nil if the node is not a referring identifier.
+func isRef(n ast.Node, info *typeInfo) types.Object {
+	switch n := n.(type) {
+	case *ast.Ident:
+		return info.use(n)
+
+	case *ast.SelectorExpr:
+		if info.selection(n) == nil {
+			// qualified ident
+			return info.use(n.Sel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package eg
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+// TestRejectionsSkipReplacements checks that the replacements of the matches aren't rejected as candidates which
+// resemble the pattern, as the walk visits them again, but that the candidates of the input still are.
+func TestRejectionsSkipReplacements(t *testing.T) {
+	fset := token.NewFileSet()
+	src := "package p\n\nfunc f(s string, x int) {\n\t_ = s == \"\"\n\t_ = x == 0\n}\n"
+	file, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, info, err := checkTest(fset, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTransformer(t, fset, exprTemplate)
+	if n := tr.Transform(info, pkg, file); n != 1 {
+		t.Fatalf("got %d matches, want 1", n)
+	}
+	rejs := tr.Rejections()
+	if len(rejs) != 1 || fset.Position(rejs[0].Pos).Line != 5 {
+		t.Errorf("got the rejections %+v, want one of x == 0, on line 5", rejs)
+	}
+}
+
+// TestCloneSharesTypeInfo checks that a clone shares the type info of the template and of the input, which it only
+// reads, but records that of its replacements apart, and still rewrites as the original does.
+func TestCloneSharesTypeInfo(t *testing.T) {
+	fset := token.NewFileSet()
+	src := "package p\n\nfunc f(s string) {\n\t_ = s == \"\"\n}\n"
+	var files [2]*ast.File
+	for i := range files {
+		file, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = file
+	}
+	tr := newTestTransformer(t, fset, exprTemplate)
+	pkg, info, err := checkTest(fset, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := tr.Transform(info, pkg, files[0]); n != 1 {
+		t.Fatalf("got %d matches, want 1", n)
+	}
+	substs := len(tr.info.subst.Types)
+
+	c := tr.Clone()
+	if c.info.tmpl != tr.info.tmpl || c.info.input != tr.info.input {
+		t.Error("the clone copied the type info of the template or the input")
+	}
+	if c.info.subst == tr.info.subst || len(c.info.subst.Types) != 0 {
+		t.Error("the clone shares the type info of the original's replacements")
+	}
+	pkg, info, err = checkTest(fset, files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Transform(info, pkg, files[1]); n != 1 {
+		t.Fatalf("the clone made %d matches, want 1", n)
+	}
+	if len(tr.info.subst.Types) != substs || len(c.info.subst.Types) == 0 {
+		t.Errorf("the clone's replacements' type info was recorded in the original's")
+	}
+}
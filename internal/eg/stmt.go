@@ -0,0 +1,573 @@
+package eg
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// Stmts is a sequence of statements, the match of a statement template
+// or its replacement, as passed to OnMatch and Revise.
+type Stmts []ast.Stmt
+
+// Pos returns the position of the first statement.
+func (s Stmts) Pos() token.Pos {
+	if len(s) == 0 {
+		return token.NoPos
+	}
+	return s[0].Pos()
+}
+
+// End returns the end of the last statement.
+func (s Stmts) End() token.Pos {
+	if len(s) == 0 {
+		return token.NoPos
+	}
+	return s[len(s)-1].End()
+}
+
+// Statements reports whether the template is a statement template,
+// matching sequences of statements rather than expressions.
+func (tr *Transformer) Statements() bool {
+	return tr.beforeStmts != nil
+}
+
+// IsStatementTemplate reports whether before, the before function of a
+// template, makes it a statement template: its body is other than a
+// single return statement of one expression, or expression statement.
+func IsStatementTemplate(before *ast.FuncDecl) bool {
+	if before.Body == nil || len(before.Body.List) != 1 {
+		return true
+	}
+	switch stmt := before.Body.List[0].(type) {
+	case *ast.ReturnStmt:
+		return len(stmt.Results) != 1
+	case *ast.ExprStmt:
+		return false
+	}
+	return true
+}
+
+// TemplateStmts returns the statements of fn, a before or after
+// function of a statement template, which info describes: those of its
+// body, except a final call of panic.
+func TemplateStmts(info *types.Info, fn *ast.FuncDecl) []ast.Stmt {
+	stmts := fn.Body.List
+	if n := len(stmts); n > 0 {
+		if es, ok := stmts[n-1].(*ast.ExprStmt); ok {
+			if call, ok := unparen(es.X).(*ast.CallExpr); ok {
+				if id, ok := unparen(call.Fun).(*ast.Ident); ok {
+					if b, ok := info.Uses[id].(*types.Builtin); ok && b.Name() == "panic" {
+						stmts = stmts[:n-1]
+					}
+				}
+			}
+		}
+	}
+	return stmts
+}
+
+// stmtPatterns returns the pattern and replacement of a statement
+// template, of the before and after functions.
+func stmtPatterns(info *types.Info, before, after *ast.FuncDecl) ([]ast.Stmt, []ast.Stmt, error) {
+	if after.Body == nil {
+		return nil, nil, fmt.Errorf("after: no body")
+	}
+	beforeStmts, afterStmts := TemplateStmts(info, before), TemplateStmts(info, after)
+	if len(beforeStmts) == 0 {
+		return nil, nil, fmt.Errorf("before: must contain a statement to match")
+	}
+	var err error
+	for _, s := range beforeStmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch n.(type) {
+			case *ast.DeclStmt, *ast.LabeledStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+				if err == nil {
+					err = fmt.Errorf("before: %s statements can't be matched", nodeKind(n))
+				}
+			case *ast.BranchStmt:
+				if n.(*ast.BranchStmt).Label != nil && err == nil {
+					err = fmt.Errorf("before: labels can't be matched")
+				}
+			}
+			return err == nil
+		})
+	}
+	return beforeStmts, afterStmts, err
+}
+
+// declaredVars returns the variables declared by stmts.
+func declaredVars(info *types.Info, stmts []ast.Stmt) map[types.Object]bool {
+	vars := make(map[types.Object]bool)
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if v, ok := info.Defs[id].(*types.Var); ok {
+					vars[v] = true
+				}
+			}
+			return true
+		})
+	}
+	return vars
+}
+
+// returns reports whether stmts contain a return statement.
+func returns(stmts []ast.Stmt) bool {
+	var found bool
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			_, ok := n.(*ast.ReturnStmt)
+			found = found || ok
+			return !found
+		})
+	}
+	return found
+}
+
+// enterFunc records the signature of rv, if it's a function declaration
+// or literal, as that of the statements within it, returning a function
+// undoing it.
+func (tr *Transformer) enterFunc(rv reflect.Value) func() {
+	if tr.beforeStmts == nil || !rv.CanInterface() {
+		return func() {}
+	}
+	var sig *types.Signature
+	switch n := rv.Interface().(type) {
+	case *ast.FuncDecl:
+		if fn, ok := tr.info.def(n.Name).(*types.Func); ok {
+			sig, _ = fn.Type().(*types.Signature)
+		}
+	case *ast.FuncLit:
+		sig, _ = tr.info.typeOf(n).(*types.Signature)
+	default:
+		return func() {}
+	}
+	tr.sigs = append(tr.sigs, sig)
+	return func() { tr.sigs = tr.sigs[:len(tr.sigs)-1] }
+}
+
+// rewriteStmts returns stmts, a statement list, with each run of them
+// matching the statement pattern, from the first on, replaced.
+func (tr *Transformer) rewriteStmts(stmts []ast.Stmt) []ast.Stmt {
+	n := len(tr.beforeStmts)
+	var out []ast.Stmt
+	for i := 0; i < len(stmts); {
+		if i+n <= len(stmts) {
+			if repl, ok := tr.rewriteRun(stmts[i:i+n], stmts[i+n:]); ok {
+				out = append(out, repl...)
+				i += n
+				continue
+			}
+		}
+		out = append(out, stmts[i])
+		i++
+	}
+	return out
+}
+
+// rewriteRun returns the replacement of run, if it matches the statement
+// pattern and its rewrite is accepted. rest are the statements following
+// it in its list.
+func (tr *Transformer) rewriteRun(run, rest []ast.Stmt) ([]ast.Stmt, bool) {
+	savedEnv := tr.env
+	tr.env = make(map[string]ast.Expr)
+	defer func() { tr.env = savedEnv }()
+	if !tr.matchStmts(tr.beforeStmts, run) {
+		return nil, false
+	}
+	orig := Stmts(run)
+	if tr.results != nil {
+		var sig *types.Signature
+		if len(tr.sigs) > 0 {
+			sig = tr.sigs[len(tr.sigs)-1]
+		}
+		if sig == nil || !types.Identical(sig.Results(), tr.results) {
+			tr.refusals = append(tr.refusals, Refusal{orig.Pos(), "the template returns, but the enclosing function's results aren't of its types"})
+			return nil, false
+		}
+	}
+	if reason := tr.droppedVar(run, rest); reason != "" {
+		tr.refusals = append(tr.refusals, Refusal{orig.Pos(), reason})
+		return nil, false
+	}
+	if tr.Verbosity >= VerboseMatches {
+		tr.logf("%s: statement pattern matches %s\n", tr.fset.Position(orig.Pos()), astString(tr.fset, run[0]))
+	}
+
+	var repl Stmts
+	for _, s := range tr.afterStmts {
+		s := tr.subst(tr.env, reflect.ValueOf(s), reflect.Value{}).Interface().(ast.Stmt)
+		repl = append(repl, s)
+	}
+	tr.place(repl, run)
+	if tr.OnMatch != nil && !tr.OnMatch(orig, repl) {
+		return nil, false
+	}
+	if tr.Revise != nil {
+		if revised, ok := tr.Revise(orig, repl).(Stmts); ok {
+			repl = revised
+		}
+	}
+	tr.nsubsts++
+	tr.gap(repl, run)
+	return repl, true
+}
+
+// A Gap is the lines a replacement of statements leaves blank, being
+// shorter than the statements it replaced: Lines of them, following the
+// line of Pos.
+type Gap struct {
+	Pos   token.Pos
+	Lines int
+}
+
+// Gaps returns the gaps the statement replacements of the most recent
+// call to Transform left, for Format to close.
+func (tr *Transformer) Gaps() []Gap {
+	return tr.gaps
+}
+
+// gap records the lines left blank by repl, placed on the lines of the
+// statements run, it replaced.
+func (tr *Transformer) gap(repl, run []ast.Stmt) {
+	in := tr.fset.File(run[0].Pos())
+	line, last := in.Line(run[0].Pos())-1, in.Line(run[len(run)-1].End())
+	if len(repl) > 0 {
+		end := repl[len(repl)-1].End()
+		if !end.IsValid() || tr.fset.File(end) != in {
+			return
+		}
+		line = in.Line(end)
+	}
+	if line > 0 && line < last {
+		tr.gaps = append(tr.gaps, Gap{in.LineStart(line), last - line})
+	}
+}
+
+// Format prints node, a file, or a node of one, rewritten by transformers
+// whose Gaps are gaps, as format.Node does, but without the blank lines
+// left by the gaps on which nothing of node, not even a comment, remains.
+// The file set is unchanged once it returns.
+func Format(w io.Writer, fset *token.FileSet, node ast.Node, gaps []Gap) error {
+	if len(gaps) == 0 {
+		return format.Node(w, fset, node)
+	}
+	tf := fset.File(node.Pos())
+	if tf == nil {
+		return format.Node(w, fset, node)
+	}
+	used := make(map[int]bool) // the lines of the file on which nodes, or comments, remain
+	use := func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		for _, pos := range []token.Pos{n.Pos(), n.End()} {
+			if pos.IsValid() && fset.File(pos) == tf {
+				used[tf.Line(pos)] = true
+			}
+		}
+		return true
+	}
+	ast.Inspect(node, use)
+	if f, ok := node.(*ast.File); ok {
+		for _, cg := range f.Comments {
+			ast.Inspect(cg, use)
+		}
+	}
+	remove := make(map[int]bool)
+	for _, g := range gaps {
+		if !g.Pos.IsValid() || fset.File(g.Pos) != tf {
+			continue
+		}
+		line := tf.Line(g.Pos)
+		for l := line + 1; l <= line+g.Lines && l <= tf.LineCount(); l++ {
+			if !used[l] {
+				remove[l] = true
+			}
+		}
+	}
+	if len(remove) == 0 {
+		return format.Node(w, fset, node)
+	}
+
+	lines := make([]int, tf.LineCount())
+	for i := range lines {
+		lines[i] = tf.Offset(tf.LineStart(i + 1))
+	}
+	defer tf.SetLines(lines)
+	var removed []int
+	for l := range remove {
+		removed = append(removed, l)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(removed))) // from the last, so the lines before keep their numbers
+	for _, l := range removed {
+		tf.MergeLine(l - 1)
+	}
+	return format.Node(w, fset, node)
+}
+
+// droppedVar returns why the replacement of run can't leave out a
+// variable of the pattern, which it doesn't refer to: the variable of the
+// input it's bound to is declared outside run, or used by the statements
+// of rest. It returns "" if there's no such variable.
+func (tr *Transformer) droppedVar(run, rest []ast.Stmt) string {
+	kept := make(map[string]bool)
+	for _, s := range tr.afterStmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				kept[id.Name] = true
+			}
+			return true
+		})
+	}
+	var locals []types.Object // in the order they're declared, for the refusal to be deterministic
+	for v := range tr.locals {
+		locals = append(locals, v)
+	}
+	sort.Slice(locals, func(i, j int) bool { return locals[i].Pos() < locals[j].Pos() })
+	for _, v := range locals {
+		e, ok := tr.env[v.Name()]
+		if !ok || kept[v.Name()] {
+			continue
+		}
+		obj := identObj(&tr.info, e.(*ast.Ident))
+		if obj.Pos() < run[0].Pos() || obj.Pos() >= run[len(run)-1].End() {
+			return fmt.Sprintf("the replacement drops the assignment of %s, which is declared outside the match", obj.Name())
+		}
+		if tr.usedBefore(obj, rest) {
+			return fmt.Sprintf("the replacement drops the declaration of %s, which the input goes on to use", obj.Name())
+		}
+	}
+	return ""
+}
+
+// usedBefore reports whether obj is used by stmts before they redeclare
+// it with :=, which declares it anew once its declaration is dropped.
+func (tr *Transformer) usedBefore(obj types.Object, stmts []ast.Stmt) bool {
+	uses := func(n ast.Node) bool {
+		var used bool
+		ast.Inspect(n, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			used = used || ok && tr.info.use(id) == obj
+			return !used
+		})
+		return used
+	}
+	for _, s := range stmts {
+		if assign, ok := s.(*ast.AssignStmt); ok && assign.Tok == token.DEFINE {
+			for _, e := range assign.Lhs {
+				if id, ok := e.(*ast.Ident); ok && tr.info.use(id) == obj {
+					for _, e := range assign.Rhs {
+						if uses(e) {
+							return true
+						}
+					}
+					return false
+				}
+			}
+		}
+		if uses(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tr *Transformer) matchStmts(xx, yy []ast.Stmt) bool {
+	if len(xx) != len(yy) {
+		return tr.mismatchf(nil, nil, "pattern has %d statements but input has %d", len(xx), len(yy))
+	}
+	for i := range xx {
+		if !tr.matchStmt(xx[i], yy[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchStmt reports whether the statement pattern x matches y, as
+// matchExpr does for expressions.
+func (tr *Transformer) matchStmt(x, y ast.Stmt) bool {
+	if x == nil && y == nil {
+		return true
+	}
+	if x == nil || y == nil {
+		return tr.mismatchf(x, y, "one of pattern and input is missing")
+	}
+	if reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return tr.mismatchf(x, y, "pattern is %s but input is %s", nodeKind(x), nodeKind(y))
+	}
+	switch x := x.(type) {
+	case *ast.ExprStmt:
+		return tr.matchExpr(x.X, y.(*ast.ExprStmt).X)
+
+	case *ast.AssignStmt:
+		y := y.(*ast.AssignStmt)
+		if x.Tok != y.Tok {
+			return tr.mismatchf(x, y, "different assignments %s and %s", x.Tok, y.Tok)
+		}
+		if len(x.Lhs) != len(y.Lhs) || len(x.Rhs) != len(y.Rhs) {
+			return tr.mismatchf(x, y, "arity mismatch: pattern assigns %d values but input assigns %d", len(x.Lhs), len(y.Lhs))
+		}
+		return tr.matchExprs(x.Rhs, y.Rhs) && tr.matchExprs(x.Lhs, y.Lhs)
+
+	case *ast.IncDecStmt:
+		y := y.(*ast.IncDecStmt)
+		if x.Tok != y.Tok {
+			return tr.mismatchf(x, y, "different operators %s and %s", x.Tok, y.Tok)
+		}
+		return tr.matchExpr(x.X, y.X)
+
+	case *ast.SendStmt:
+		y := y.(*ast.SendStmt)
+		return tr.matchExpr(x.Chan, y.Chan) && tr.matchExpr(x.Value, y.Value)
+
+	case *ast.ReturnStmt:
+		y := y.(*ast.ReturnStmt)
+		if len(x.Results) != len(y.Results) {
+			return tr.mismatchf(x, y, "arity mismatch: pattern returns %d values but input returns %d", len(x.Results), len(y.Results))
+		}
+		return tr.matchExprs(x.Results, y.Results)
+
+	case *ast.BranchStmt:
+		y := y.(*ast.BranchStmt)
+		if x.Tok != y.Tok || y.Label != nil {
+			return tr.mismatchf(x, y, "pattern is %s but input is %s", astString(tr.fset, x), astString(tr.fset, y))
+		}
+		return true
+
+	case *ast.DeferStmt:
+		return tr.matchExpr(x.Call, y.(*ast.DeferStmt).Call)
+
+	case *ast.GoStmt:
+		return tr.matchExpr(x.Call, y.(*ast.GoStmt).Call)
+
+	case *ast.BlockStmt:
+		return tr.matchStmts(x.List, y.(*ast.BlockStmt).List)
+
+	case *ast.IfStmt:
+		y := y.(*ast.IfStmt)
+		return tr.matchStmt(x.Init, y.Init) &&
+			tr.matchExpr(x.Cond, y.Cond) &&
+			tr.matchStmt(x.Body, y.Body) &&
+			tr.matchStmt(x.Else, y.Else)
+
+	case *ast.ForStmt:
+		y := y.(*ast.ForStmt)
+		return tr.matchStmt(x.Init, y.Init) &&
+			tr.matchExpr(x.Cond, y.Cond) &&
+			tr.matchStmt(x.Post, y.Post) &&
+			tr.matchStmt(x.Body, y.Body)
+
+	case *ast.RangeStmt:
+		y := y.(*ast.RangeStmt)
+		if x.Tok != y.Tok {
+			return tr.mismatchf(x, y, "different assignments %s and %s", x.Tok, y.Tok)
+		}
+		return tr.matchExpr(x.X, y.X) &&
+			tr.matchExpr(x.Key, y.Key) &&
+			tr.matchExpr(x.Value, y.Value) &&
+			tr.matchStmt(x.Body, y.Body)
+
+	case *ast.EmptyStmt:
+		return true
+	}
+	return tr.mismatchf(x, y, "%s statements can't be matched", nodeKind(x))
+}
+
+// matchLocal reports whether y matches x, an identifier of a variable
+// the statement pattern declares: a variable of an assignable type, and
+// the same one as each other occurrence of x binds.
+func (tr *Transformer) matchLocal(x *ast.Ident, xobj types.Object, y ast.Expr) bool {
+	yid, ok := y.(*ast.Ident)
+	if !ok {
+		return tr.mismatchf(x, y, "pattern declares the variable %s but input is %s", x.Name, nodeKind(y))
+	}
+	yobj, ok := identObj(&tr.info, yid).(*types.Var)
+	if !ok {
+		return tr.mismatchf(x, y, "pattern declares the variable %s but input %s", x.Name, refString(identObj(&tr.info, yid)))
+	}
+	if old, ok := tr.env[x.Name]; ok {
+		if identObj(&tr.info, old.(*ast.Ident)) != yobj {
+			return tr.mismatchf(x, y, "variable %s is already bound to %s, which differs from %s", x.Name, astString(tr.fset, old), yid.Name)
+		}
+		return true
+	}
+	if !types.AssignableTo(yobj.Type(), xobj.Type()) {
+		return tr.mismatchf(x, y, "wrong type: variable %s has type %s but input has type %s, which is not assignable", x.Name, xobj.Type(), yobj.Type())
+	}
+	tr.env[x.Name] = yid // record binding
+	return true
+}
+
+// identObj returns the object id declares or refers to.
+func identObj(info *typeInfo, id *ast.Ident) types.Object {
+	if obj := info.def(id); obj != nil {
+		return obj
+	}
+	return info.use(id)
+}
+
+// place moves the positions of repl, the replacement of the statements
+// run, from the lines of the template to those of the match: the i'th
+// line of the replacement to the i'th of the match, or to its last, so
+// that the printer lays the replacement out as the template does, rather
+// than inserting blank lines. A binding is placed wholly on the line of
+// the wildcard it replaced.
+func (tr *Transformer) place(repl, run []ast.Stmt) {
+	if len(repl) == 0 {
+		return
+	}
+	tmpl, in := tr.fset.File(tr.afterStmts[0].Pos()), tr.fset.File(run[0].Pos())
+	base := tmpl.Line(tr.afterStmts[0].Pos())
+	first, last := in.Line(run[0].Pos()), in.Line(run[len(run)-1].End())
+	at := func(pos token.Pos) token.Pos {
+		line := first + tmpl.Line(pos) - base
+		if line <= first {
+			return run[0].Pos()
+		}
+		if line > last {
+			line = last
+		}
+		return in.LineStart(line)
+	}
+	inTmpl := func(pos token.Pos) bool {
+		return pos.IsValid() && tr.fset.File(pos) == tmpl
+	}
+
+	var placed []token.Pos // of the nodes enclosing the one visited
+	for _, s := range repl {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if n == nil {
+				placed = placed[:len(placed)-1]
+				return false
+			}
+			pos := run[0].Pos()
+			if inTmpl(n.Pos()) {
+				pos = at(n.Pos())
+			} else if len(placed) > 0 {
+				pos = placed[len(placed)-1] // within a binding
+			}
+			v := reflect.ValueOf(n).Elem()
+			for i := 0; i < v.NumField(); i++ {
+				f := v.Field(i)
+				if f.Type() != positionType || !f.CanSet() || !f.Interface().(token.Pos).IsValid() {
+					continue
+				}
+				if p := f.Interface().(token.Pos); inTmpl(p) {
+					f.Set(reflect.ValueOf(at(p)))
+				} else {
+					f.Set(reflect.ValueOf(pos))
+				}
+			}
+			placed = append(placed, pos)
+			return true
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package eg
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+// isSyntactic reports whether the pattern can be matched against input
+// without type information: its wildcards are empty interfaces, which
+// any expression is assignable to, and it refers only to package-level
+// objects and builtins, rather than selecting fields or methods,
+// converting, asserting or constructing values of types.
+func (tr *Transformer) isSyntactic() bool {
+	if tr.beforeStmts != nil {
+		return false
+	}
+	for w := range tr.wildcards {
+		if iface, ok := w.Type().Underlying().(*types.Interface); !ok || iface.NumMethods() > 0 {
+			return false
+		}
+	}
+	syntactic := true
+	ast.Inspect(tr.before, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			if tr.info.selection(n) != nil {
+				syntactic = false
+			}
+		case *ast.CompositeLit:
+			syntactic = syntactic && n.Type == nil
+		case *ast.TypeAssertExpr:
+			syntactic = false
+		case *ast.CallExpr:
+			tv, _ := tr.info.typeAndValue(n.Fun)
+			syntactic = syntactic && !tv.IsType()
+		}
+		return syntactic
+	})
+	return syntactic
+}
+
+// Syntactic reports whether the pattern can be matched by
+// TransformSyntax, without the input's type information.
+func (tr *Transformer) Syntactic() bool {
+	return tr.syntactic
+}
+
+// TransformSyntax is Transform for a file of pkg which couldn't be type
+// checked, matching the pattern against its syntax alone: qualified
+// identifiers are resolved using the file's imports, and other
+// identifiers by name. It must only be called if Syntactic reports true.
+func (tr *Transformer) TransformSyntax(pkg *types.Package, file *ast.File) int {
+	tr.syntaxOnly = true
+	defer func() { tr.syntaxOnly = false }()
+	return tr.Transform(tr.syntaxInfo(pkg, file), pkg, file)
+}
+
+// syntaxInfo returns the type information for file that can be deduced
+// from its syntax: the objects referred to by identifiers qualified by the
+// names of packages the template refers to, and by the unqualified names
+// of builtins and of pkg's members, unless declared in file. Other
+// identifiers refer to objects synthesized for each declaration, or for
+// each unresolved name, so that repeated wildcards still match only the
+// same variable.
+func (tr *Transformer) syntaxInfo(pkg *types.Package, file *ast.File) *types.Info {
+	byPath := make(map[string]*types.Package)
+	for _, obj := range tr.info.tmpl.Uses {
+		if pn, ok := obj.(*types.PkgName); ok {
+			byPath[pn.Imported().Path()] = pn.Imported()
+		}
+	}
+	imported := make(map[string]*types.Package) // by the name file refers to them by
+	for _, imp := range file.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if p, ok := byPath[path]; ok {
+			name := p.Name()
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			imported[name] = p
+		}
+	}
+
+	info := &types.Info{Uses: make(map[*ast.Ident]types.Object)}
+	declared := make(map[*ast.Object]types.Object)
+	unresolved := make(map[string]types.Object)
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			x, ok := n.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if p, ok := imported[x.Name]; ok && x.Obj == nil {
+				if obj := p.Scope().Lookup(n.Sel.Name); obj != nil {
+					info.Uses[n.Sel] = obj
+				}
+				return false
+			}
+			// the selected name is a field or method, which can't be resolved
+			ast.Inspect(n.X, func(n ast.Node) bool { return resolve(info, n, pkg, declared, unresolved) })
+			return false
+		case *ast.Ident:
+			resolve(info, n, pkg, declared, unresolved)
+		}
+		return true
+	})
+	return info
+}
+
+// resolve records the object the identifier n, if it is one, refers to in info, for syntaxInfo, and returns true.
+func resolve(info *types.Info, n ast.Node, pkg *types.Package, declared map[*ast.Object]types.Object,
+	unresolved map[string]types.Object) bool {
+	id, ok := n.(*ast.Ident)
+	if !ok || id.Name == "_" {
+		return true
+	}
+	// the parser resolves the names declared in file, including those at package level
+	if obj := pkg.Scope().Lookup(id.Name); obj != nil && (id.Obj == nil || id.Obj.Pos() == obj.Pos()) {
+		info.Uses[id] = obj
+		return true
+	}
+	if id.Obj != nil {
+		obj, ok := declared[id.Obj]
+		if !ok {
+			obj = types.NewVar(id.Obj.Pos(), pkg, id.Name, types.Typ[types.Invalid])
+			declared[id.Obj] = obj
+		}
+		info.Uses[id] = obj
+		return true
+	}
+	obj := types.Universe.Lookup(id.Name)
+	if obj == nil {
+		if obj = unresolved[id.Name]; obj == nil {
+			obj = types.NewVar(token.NoPos, pkg, id.Name, types.Typ[types.Invalid])
+			unresolved[id.Name] = obj
+		}
+	}
+	info.Uses[id] = obj
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build ignore
+// +build ignore
+
+package E1
+
+import "log"
+
+func example() {
+	log.Fatal("oops") // match
+}
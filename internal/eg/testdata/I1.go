@@ -0,0 +1,10 @@
+//go:build ignore
+// +build ignore
+
+package I1
+
+import "fmt"
+
+func example() {
+	_ = fmt.Errorf("%s", "foo")
+}
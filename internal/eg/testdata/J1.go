@@ -0,0 +1,11 @@
+//go:build ignore
+// +build ignore
+
+package I1
+
+import "fmt"
+
+func example() {
+	temp := 5
+	fmt.Print(temp + temp + temp)
+}
@@ -0,0 +1,39 @@
+//go:build ignore
+// +build ignore
+
+package K1
+
+import (
+	"fmt"
+	"strconv"
+)
+
+func parse(text string) (int, error) {
+	count, e := strconv.Atoi(text)
+	if e != nil {
+		return 0, e
+	}
+	return count * 2, nil
+}
+
+// The statements match only in functions returning an int and an error.
+func parse64(text string) (int64, error) {
+	count, e := strconv.Atoi(text)
+	if e != nil {
+		return 0, e
+	}
+	return int64(count), nil
+}
+
+func parseBoth(a, b string) (int, error) {
+	x, err := strconv.Atoi(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := strconv.Atoi(b)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Println(x, y)
+	return x + y, nil
+}
@@ -0,0 +1,26 @@
+//go:build ignore
+// +build ignore
+
+package L1
+
+import "fmt"
+
+func printed(a int) {
+	d, t := a*2, a*3
+	fmt.Println(d, t)
+	fmt.Println(a)
+}
+
+// The declaration of d can't be dropped, since it's used after.
+func usedAfter(a int) int {
+	d, t := a*2, a*3
+	fmt.Println(d, t)
+	return d
+}
+
+// Nor can the assignment of t, which is declared before the statements.
+func declaredBefore(a int) {
+	t := 0
+	d, t := a*2, a*3
+	fmt.Println(d, t)
+}
@@ -0,0 +1,146 @@
+package eg
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// A TypeChange records a match whose replacement, type checked in the
+// context of the match, doesn't have the type of the original expression,
+// or doesn't type check at all.
+type TypeChange struct {
+	Pos           token.Pos
+	Before, After types.Type
+	Assignable    bool  // whether After is assignable to Before
+	Refused       bool  // whether the rewrite was refused
+	Err           error // why the replacement doesn't type check, if it doesn't, in which case After is nil
+}
+
+// TypeChanges returns the type changes of the most recent call to
+// Transform, in the order they were encountered.
+func (tr *Transformer) TypeChanges() []TypeChange {
+	return tr.typeChanges
+}
+
+// checkType type checks repl, the substituted replacement for the input
+// expression orig, in the scope of orig and reports whether the rewrite
+// should proceed, recording a TypeChange if the types differ, or if the
+// replacement doesn't type check there, e.g. for calling a method the
+// wildcard's type lacks, or referring to an unexported name of another
+// package, in which case the rewrite is refused.
+func (tr *Transformer) checkType(orig, repl ast.Expr) bool {
+	if tr.refused[orig] {
+		return false // already considered via another path to the same node
+	}
+	before := tr.info.typeOf(orig)
+	if t, ok := before.(*types.Tuple); before == nil || ok && t.Len() == 0 {
+		return true // untyped, or an expression statement
+	}
+	// an untyped value, e.g. the comparison of an if condition, is compared by the type it defaults to, as is the
+	// replacement, type checked out of context
+	before = types.Default(before)
+
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	if err := types.CheckExpr(tr.fset, tr.checkPackage(orig.Pos()), orig.Pos(), repl, info); err != nil {
+		tr.typeChanges = append(tr.typeChanges, TypeChange{Pos: orig.Pos(), Before: before, Refused: true, Err: err})
+		tr.refused[orig] = true
+		return false
+	}
+	after := info.TypeOf(repl)
+	if after != nil {
+		after = types.Default(after)
+	}
+	if after == nil || types.Identical(before, after) {
+		return true
+	}
+
+	c := TypeChange{Pos: orig.Pos(), Before: before, After: after, Assignable: types.AssignableTo(after, before)}
+	c.Refused = !c.Assignable || tr.StrictTypes
+	tr.typeChanges = append(tr.typeChanges, c)
+	if c.Refused {
+		tr.refused[orig] = true
+	}
+	return !c.Refused
+}
+
+// accept reports whether OnMatch, if set, accepts the rewrite of orig
+// as repl, which is otherwise refused.
+func (tr *Transformer) accept(orig, repl ast.Expr) bool {
+	if tr.OnMatch == nil || tr.OnMatch(orig, repl) {
+		return true
+	}
+	tr.refused[orig] = true
+	return false
+}
+
+// checkPackage returns the package to type check a replacement at pos in:
+// the current package, or, if the replacement uses package names that the
+// file enclosing pos doesn't declare, a copy of it whose file scope declares
+// them, so that replacements can be type checked before Transform adds the
+// corresponding imports, and whose scope at pos declares the variables the
+// statements inserted before the match declare. The package's own scopes are
+// left as they are, since later checks, and other templates, share them.
+func (tr *Transformer) checkPackage(pos token.Pos) *types.Package {
+	var chain []*types.Scope // from the innermost scope at pos out to the file scope
+	for scope := tr.currentPkg.Scope().Innermost(pos); scope != nil && scope != tr.currentPkg.Scope(); scope = scope.Parent() {
+		chain = append(chain, scope)
+	}
+	if len(chain) == 0 {
+		return tr.currentPkg
+	}
+	file := chain[len(chain)-1]
+	missing := make(map[string]*types.Package)
+	for obj, sel := range tr.importedObjs {
+		id, ok := sel.X.(*ast.Ident)
+		if !ok || obj.Pkg() == tr.currentPkg {
+			continue
+		}
+		if _, found := file.LookupParent(id.Name, pos); found == nil {
+			missing[id.Name] = obj.Pkg()
+		}
+	}
+	var locals map[types.Object]bool
+	if tr.beforeStmts == nil {
+		locals = declaredVars(tr.info.tmpl, tr.afterStmts)
+	}
+	if len(missing) == 0 && len(locals) == 0 {
+		return tr.currentPkg
+	}
+
+	// the copy shares the objects, and so the types, of the package, and its
+	// path, so that its unexported names are accessible from the copy as from
+	// the package
+	pkg := types.NewPackage(tr.currentPkg.Path(), tr.currentPkg.Name())
+	copyScope(pkg, pkg.Scope(), tr.currentPkg.Scope())
+	parent := pkg.Scope()
+	for i := len(chain) - 1; i >= 0; i-- {
+		parent = types.NewScope(parent, chain[i].Pos(), chain[i].End(), "")
+		copyScope(pkg, parent, chain[i])
+		if i == len(chain)-1 {
+			for name, imported := range missing {
+				parent.Insert(types.NewPkgName(token.NoPos, pkg, name, imported))
+			}
+		}
+	}
+	if len(locals) > 0 {
+		parent = types.NewScope(parent, pos, pos+1, "")
+		for obj := range locals {
+			parent.Insert(types.NewVar(pos, pkg, obj.Name(), obj.Type()))
+		}
+	}
+	return pkg
+}
+
+// copyScope declares the objects of from in to, of pkg, leaving them in the
+// scopes they're declared in. The package names, which the type checker
+// requires to be of the package it checks, are declared anew.
+func copyScope(pkg *types.Package, to, from *types.Scope) {
+	for _, name := range from.Names() {
+		obj := from.Lookup(name)
+		if pn, ok := obj.(*types.PkgName); ok {
+			obj = types.NewPkgName(pn.Pos(), pkg, pn.Name(), pn.Imported())
+		}
+		to.Insert(obj)
+	}
+}
@@ -0,0 +1,132 @@
+package eg
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const containsTemplate = `package template
+
+import "strings"
+
+func before(s, sub string) bool { return strings.Index(s, sub) != -1 }
+func after(s, sub string) bool  { return strings.Contains(s, sub) }
+`
+
+// TestCheckType checks which rewrites the replacements' types let through, in each context of the match.
+func TestCheckType(t *testing.T) {
+	for _, test := range []struct {
+		name, tmpl, body string
+		want             string // the body rewritten, or "" if the rewrite is refused
+	}{
+		{
+			name: "if condition", // of untyped bool
+			tmpl: containsTemplate,
+			body: `if strings.Index(s, "x") != -1 { println() }`,
+			want: `if strings.Contains(s, "x") { println() }`,
+		},
+		{
+			name: "for condition",
+			tmpl: containsTemplate,
+			body: `for strings.Index(s, "x") != -1 { s = s[1:] }`,
+			want: `for strings.Contains(s, "x") { s = s[1:] }`,
+		},
+		{
+			name: "assignment",
+			tmpl: containsTemplate,
+			body: `b := strings.Index(s, "x") != -1; println(b)`,
+			want: `b := strings.Contains(s, "x"); println(b)`,
+		},
+		{
+			name: "operand",
+			tmpl: containsTemplate,
+			body: `println(x == 0 && strings.Index(s, "x") != -1)`,
+			want: `println(x == 0 && strings.Contains(s, "x"))`,
+		},
+		{
+			name: "untyped replacement",
+			tmpl: exprTemplate,
+			body: `if s == "" { println() }`,
+			want: `if len(s) == 0 { println() }`,
+		},
+		{
+			name: "typed to untyped",
+			tmpl: exprTemplate,
+			body: `var b bool = s == ""; println(b)`,
+			want: `var b bool = len(s) == 0; println(b)`,
+		},
+		{
+			name: "declaring a variable", // which the replacement refers to
+			tmpl: `package template
+
+func before(x int) int { return x + x + x }
+func after(x int) int {
+	temp := x + x
+	return temp + x
+}
+`,
+			body: `y := x + x + x; println(y)`,
+			want: "\ntemp := x + x; y := temp + x; println(y)", // the blank line is the inserted statement's
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, changes := transformBody(t, test.tmpl, test.body)
+			if want := formatBody(t, test.want); got != want && (test.want != "" || got != formatBody(t, test.body)) {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+			for _, c := range changes {
+				if test.want != "" {
+					t.Errorf("got a type change from %v to %v", c.Before, c.After)
+				}
+			}
+		})
+	}
+}
+
+// transformBody applies the template tmpl to a function of body, returning it formatted, and the type changes.
+func transformBody(t *testing.T, tmpl, body string) (string, []TypeChange) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", testSource(body), parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, info, err := checkTest(fset, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTransformer(t, fset, tmpl)
+	tr.Transform(info, pkg, file)
+	var buf bytes.Buffer
+	if err := Format(&buf, fset, file, tr.Gaps()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), tr.TypeChanges()
+}
+
+// formatBody returns the function of body formatted, as transformBody returns it.
+func formatBody(t *testing.T, body string) string {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", testSource(body), parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Format(&buf, fset, file, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// testSource returns a file of a function of body, importing strings if it refers to it.
+func testSource(body string) string {
+	var imports string
+	if strings.Contains(body, "strings.") {
+		imports = "import \"strings\"\n\n"
+	}
+	return "package p\n\n" + imports + "func f(s string, x int) {\n" + body + "\n}\n"
+}
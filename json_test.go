@@ -0,0 +1,8 @@
+package main
+
+import "testing"
+
+// TestJSON checks the -json report of the matches, the files and the failures of a run, with and without -w.
+func TestJSON(t *testing.T) {
+	runMainCases(t, "json")
+}
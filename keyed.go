@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+)
+
+const keyedUsage = `Usage: eg keyed [-type path.T]... [-w] <packages>...
+
+Converts the unkeyed composite literals of struct types to keyed ones, naming
+the field of each value, e.g. rewriting
+
+	r := image.Rectangle{image.Point{0, 0}, image.Point{w, h}}
+
+as image.Rectangle{Min: image.Point{X: 0, Y: 0}, Max: ...}, so that they keep
+compiling when the types gain fields. Without -type, the literals of every
+struct type defined in a package other than the literal's own are converted.
+`
+
+func keyedMain(args []string) error {
+	fs := flag.NewFlagSet("keyed", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, keyedUsage) }
+	var typeFlags arrayFlags
+	fs.Var(&typeFlags, "type", "a struct type whose literals to convert, as path.T (repeatable)")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	var named []types.Type
+	for _, spec := range typeFlags {
+		obj, err := m.lookupWith(spec, true)
+		if err != nil {
+			return err
+		}
+		tn, ok := obj.(*types.TypeName)
+		if !ok {
+			return fmt.Errorf("%s isn't a type", spec)
+		}
+		if _, ok := tn.Type().Underlying().(*types.Struct); !ok {
+			return fmt.Errorf("%s isn't a struct type", spec)
+		}
+		named = append(named, tn.Type())
+	}
+
+	for _, pkg := range m.pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				if lit, ok := n.(*ast.CompositeLit); ok && keyedConverts(pkg, lit, named) {
+					addKeys(pkg.TypesInfo, lit)
+					m.touch(pkg, file)
+				}
+				return true
+			})
+		}
+	}
+	return m.emit(false)
+}
+
+// keyedConverts reports whether lit is an unkeyed literal of one of the types named, or if none are, of a struct
+// type defined outside pkg.
+func keyedConverts(pkg *packages.Package, lit *ast.CompositeLit, named []types.Type) bool {
+	if len(lit.Elts) == 0 {
+		return false
+	}
+	if _, ok := lit.Elts[0].(*ast.KeyValueExpr); ok {
+		return false
+	}
+	t := pkg.TypesInfo.TypeOf(lit)
+	if p, ok := t.(*types.Pointer); ok && lit.Type == nil {
+		t = p.Elem() // elided within a slice or map of pointers
+	}
+	if _, ok := t.Underlying().(*types.Struct); !ok {
+		return false
+	}
+	if len(named) == 0 {
+		n, ok := t.(*types.Named)
+		return ok && n.Obj().Pkg() != nil && n.Obj().Pkg() != pkg.Types
+	}
+	for _, typ := range named {
+		if types.Identical(t, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// addKeys names the field of each value of the unkeyed struct literal lit.
+func addKeys(info *types.Info, lit *ast.CompositeLit) {
+	t := info.TypeOf(lit)
+	if p, ok := t.(*types.Pointer); ok && lit.Type == nil {
+		t = p.Elem()
+	}
+	st := t.Underlying().(*types.Struct)
+	for i, elt := range lit.Elts {
+		key := &ast.Ident{NamePos: elt.Pos(), Name: st.Field(i).Name()}
+		lit.Elts[i] = &ast.KeyValueExpr{Key: key, Colon: elt.Pos(), Value: elt}
+	}
+}
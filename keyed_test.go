@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestKeyed(t *testing.T) {
+	runCommandCases(t, "keyed")
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jwilner/eg/egrun"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dropTestDuplicates returns pkgs, loaded with their tests, without the packages generated to run the tests, and
+// without each package whose test variant, which has its files and its tests', is among them.
+func dropTestDuplicates(pkgs []*packages.Package) []*packages.Package {
+	variants := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if egrun.TestOf(pkg) == pkg.PkgPath {
+			variants[pkg.PkgPath] = true
+		}
+	}
+	var kept []*packages.Package
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test") || egrun.TestOf(pkg) == "" && variants[pkg.PkgPath] {
+			continue
+		}
+		kept = append(kept, pkg)
+	}
+	return kept
+}
+
+// dropBroken returns pkgs, loaded for patterns, without the packages and files with errors if -tolerate-errors was
+// given, reporting each one skipped, and returning the files skipped by name: a package with only type errors has
+// just the files containing them skipped, since the rest are type checked, while one which couldn't be listed or
+// parsed is skipped whole. Without the flag, as for the packages of templates, named by "file=" patterns, the errors
+// are printed, and fail the load.
+func dropBroken(fSet *token.FileSet, pkgs []*packages.Package, patterns []string) ([]*packages.Package, map[string]skippedFile, error) {
+	if !*tolerateFlag {
+		if packages.PrintErrors(pkgs) > 0 {
+			return nil, nil, errors.New("error loading packages")
+		}
+		return pkgs, nil, nil
+	}
+
+	var kept []*packages.Package
+	skipped := make(map[string]skippedFile)
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) == 0 {
+			kept = append(kept, pkg)
+			continue
+		}
+		for _, pattern := range patterns {
+			if strings.HasPrefix(pattern, "file=") && findFile(fSet, pkg, strings.TrimPrefix(pattern, "file=")) != nil {
+				packages.PrintErrors([]*packages.Package{pkg})
+				return nil, nil, errors.New("error loading template")
+			}
+		}
+
+		broken := make(map[string]packages.Error) // the first error in each file
+		whole := pkg.Types == nil || pkg.TypesInfo == nil
+		for _, e := range loadErrors(pkg) {
+			filename := errorFile(e)
+			if e.Kind != packages.TypeError || filename == "" {
+				whole = true
+			} else if _, ok := broken[filename]; !ok {
+				broken[filename] = e
+			}
+		}
+		if whole {
+			fmt.Fprintf(os.Stderr, "skipping package %s: %v\n", pkg.ID, pkg.Errors[0])
+			for _, filename := range pkg.GoFiles {
+				skipped[filename] = skippedFile{pkg: pkg}
+			}
+			continue
+		}
+		var syntax []*ast.File
+		for _, file := range pkg.Syntax {
+			filename := fSet.File(file.Pos()).Name()
+			if e, ok := broken[filename]; ok {
+				fmt.Fprintf(os.Stderr, "skipping %s: %v\n", filename, e)
+				skipped[filename] = skippedFile{pkg, file}
+				continue
+			}
+			syntax = append(syntax, file)
+		}
+		pkg.Syntax = syntax
+		kept = append(kept, pkg)
+	}
+	return kept, skipped, nil
+}
+
+// loadErrors returns the errors of pkg, without the go command's output compiling it, a single error without a
+// position, if it has type errors, which the output repeats.
+func loadErrors(pkg *packages.Package) []packages.Error {
+	var typeErrors bool
+	for _, e := range pkg.Errors {
+		typeErrors = typeErrors || e.Kind == packages.TypeError
+	}
+	if !typeErrors {
+		return pkg.Errors
+	}
+	var errs []packages.Error
+	for _, e := range pkg.Errors {
+		if e.Kind != packages.ListError {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// A skippedFile is a file of pkg skipped for its errors. If they're only type errors, file is its syntax.
+type skippedFile struct {
+	pkg  *packages.Package
+	file *ast.File
+}
+
+// errorFile returns the name of the file in which e occurred, or "" if it has no position.
+func errorFile(e packages.Error) string {
+	// the position is of the form file:line:column, or file:line
+	pos := e.Pos
+	for i := 0; i < 2; i++ {
+		colon := strings.LastIndex(pos, ":")
+		if colon < 0 {
+			break
+		}
+		if _, err := strconv.Atoi(pos[colon+1:]); err != nil {
+			break
+		}
+		pos = pos[:colon]
+	}
+	if pos == e.Pos {
+		return ""
+	}
+	return pos
+}
+
+// dependencyOrder returns pkgs in reverse topological order of the import graph: each package comes after those of
+// pkgs it imports, directly or indirectly, so a package's API is rewritten before its consumers are. Packages which
+// don't depend on each other are ordered by ID.
+func dependencyOrder(pkgs []*packages.Package) []*packages.Package {
+	roots := append([]*packages.Package(nil), pkgs...)
+	sort.Slice(roots, func(i, j int) bool { return roots[i].ID < roots[j].ID })
+	isRoot := make(map[*packages.Package]bool)
+	for _, pkg := range roots {
+		isRoot[pkg] = true
+	}
+
+	ordered := make([]*packages.Package, 0, len(pkgs))
+	visited := make(map[*packages.Package]bool)
+	var visit func(pkg *packages.Package)
+	visit = func(pkg *packages.Package) {
+		if visited[pkg] {
+			return
+		}
+		visited[pkg] = true
+		paths := make([]string, 0, len(pkg.Imports))
+		for path := range pkg.Imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			visit(pkg.Imports[path])
+		}
+		if isRoot[pkg] {
+			ordered = append(ordered, pkg)
+		}
+	}
+	for _, pkg := range roots {
+		visit(pkg)
+	}
+	return ordered
+}
+
+// reportOrder prints the order in which pkgs are visited, if -v was given.
+func reportOrder(pkgs []*packages.Package) {
+	if *verboseFlag == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "visiting packages in dependency order:\n")
+	for i, pkg := range pkgs {
+		fmt.Fprintf(os.Stderr, "\t%d. %s\n", i+1, pkg.ID)
+	}
+}
+
+// conflicts skips the files with merge conflict markers in each load of the run, reporting each file once.
+var conflicts = &egrun.ConflictSkipper{Log: os.Stderr}
+
+// loadPackages is packages.Load, except that the templates named by the leading "file=" patterns may be outside
+// the module of the other packages, e.g. in a central repository of templates serving many. It's loaded on its own, in its own
+// module, to check that it compiles there, and then type checked again against the packages it imports as loaded
+// along with the rest, so that it refers to their objects, which the matcher compares by identity. The packages
+// the template imports which the module doesn't require are added to a copy of its go.mod, with which the packages
+// are loaded, and which is returned, for the caller to write along with the rewritten files.
+func loadPackages(cfg *packages.Config, patterns ...string) ([]*packages.Package, *requirements, error) {
+	if cfg.ParseFile == nil {
+		cfg.ParseFile = conflicts.ParseFile
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, nil, err
+		}
+		dir = wd
+	}
+	var tmplPaths []string
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "file=") {
+			break
+		}
+		tmplPaths = append(tmplPaths, strings.TrimPrefix(pattern, "file="))
+	}
+	var reqs *requirements
+	for _, tmplPath := range tmplPaths {
+		r, err := requireImports(cfg, dir, tmplPath)
+		if err != nil {
+			reqs.remove()
+			return nil, nil, err
+		}
+		if r != nil && reqs != nil {
+			r.remove()
+			reqs.remove()
+			return nil, nil, errors.New("several of the templates need modules added to go.mod; apply them in separate runs")
+		}
+		if r != nil {
+			reqs = r
+		}
+	}
+	for _, tmplPath := range tmplPaths {
+		if err := checkTemplate(cfg, dir, tmplPath); err != nil {
+			reqs.remove()
+			return nil, nil, err
+		}
+	}
+	pkgs, err := loadTemplatePackages(cfg, dir, patterns)
+	if err != nil {
+		reqs.remove()
+		return nil, nil, err
+	}
+	conflicts.DropErrors(pkgs, dir)
+	return pkgs, reqs, nil
+}
+
+// checkTemplate type checks the template at tmplPath against the packages it imports alone, as loaded from dir, so
+// that a broken template fails before the packages it rewrites are loaded, which may take far longer, with all its
+// errors rather than the first. Imports which can't be loaded are left for the full load to report.
+func checkTemplate(cfg *packages.Config, dir, tmplPath string) error {
+	fSet := token.NewFileSet()
+	f, err := parser.ParseFile(fSet, tmplPath, nil, 0)
+	if err != nil {
+		return fmt.Errorf("template %s doesn't parse: %v", tmplPath, err)
+	}
+	var imports []string
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		imports = append(imports, path)
+	}
+	byPath := make(map[string]*types.Package)
+	if len(imports) > 0 {
+		pkgs, err := packages.Load(&packages.Config{
+			Mode:       packages.NeedName | packages.NeedTypes,
+			Dir:        dir,
+			Env:        cfg.Env,
+			BuildFlags: cfg.BuildFlags,
+		}, imports...)
+		if err != nil {
+			return nil
+		}
+		for _, pkg := range pkgs {
+			if len(pkg.Errors) > 0 || pkg.Types == nil {
+				return nil
+			}
+			byPath[pkg.PkgPath] = pkg.Types
+		}
+	}
+
+	var errs []string
+	conf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if pkg, ok := byPath[path]; ok {
+				return pkg, nil
+			}
+			return nil, fmt.Errorf("package %q wasn't loaded", path)
+		}),
+		Error: func(err error) {
+			terr := err.(types.Error)
+			msg := fmt.Sprintf("%s: %s", terr.Fset.Position(terr.Pos), terr.Msg)
+			if fn := enclosingFunc(f, terr.Pos); fn != "" {
+				msg += " (in " + fn + ")"
+			}
+			errs = append(errs, msg)
+		},
+	}
+	conf.Check(f.Name.Name, fSet, []*ast.File{f}, nil)
+	if len(errs) > 0 {
+		return fmt.Errorf("template %s doesn't type check:\n\t%s", tmplPath, strings.Join(errs, "\n\t"))
+	}
+	return nil
+}
+
+// enclosingFunc returns the name of the function declared in f enclosing pos, if any.
+func enclosingFunc(f *ast.File, pos token.Pos) string {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Pos() <= pos && pos < fn.End() {
+			return fn.Name.Name
+		}
+	}
+	return ""
+}
+
+// loadTemplatePackages is loadPackages, given the directory of the go command, without adding requirements.
+func loadTemplatePackages(cfg *packages.Config, dir string, patterns []string) ([]*packages.Package, error) {
+	var tmplPaths, rest []string
+	for _, pattern := range patterns {
+		if path := strings.TrimPrefix(pattern, "file="); path != pattern && moduleRoot(filepath.Dir(path)) != moduleRoot(dir) {
+			tmplPaths = append(tmplPaths, path)
+		} else {
+			rest = append(rest, pattern)
+		}
+	}
+	if len(tmplPaths) == 0 {
+		return packages.Load(cfg, patterns...)
+	}
+
+	var files []*ast.File
+	imports := []string{}
+	for _, tmplPath := range tmplPaths {
+		// a template outside any module, such as one given inline, is only checked against the packages it rewrites
+		if moduleRoot(filepath.Dir(tmplPath)) != "" {
+			own, err := packages.Load(&packages.Config{Mode: egrun.LoadMode, Dir: filepath.Dir(tmplPath)}, "file="+tmplPath)
+			if err != nil {
+				return nil, fmt.Errorf("load: %v", err)
+			}
+			if packages.PrintErrors(own) > 0 {
+				return nil, fmt.Errorf("error loading template %s in its own module", tmplPath)
+			}
+		}
+		f, err := parser.ParseFile(cfg.Fset, tmplPath, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			imports = append(imports, path)
+		}
+	}
+
+	// the template's imports are loaded as patterns, to share the others' type universe, but only returned if matched
+	matched, err := packages.Load(&packages.Config{Mode: packages.NeedName, Dir: cfg.Dir, BuildFlags: cfg.BuildFlags,
+		Tests: cfg.Tests}, rest...)
+	if err != nil {
+		return nil, fmt.Errorf("load: %v", err)
+	}
+	isMatched := make(map[string]bool)
+	for _, pkg := range matched {
+		isMatched[pkg.ID] = true
+	}
+	named := *cfg
+	named.Mode |= packages.NeedName
+	all, err := packages.Load(&named, append(rest, imports...)...)
+	if err != nil {
+		return nil, fmt.Errorf("load: %v", err)
+	}
+	byPath := make(map[string]*types.Package)
+	packages.Visit(all, nil, func(pkg *packages.Package) {
+		byPath[pkg.PkgPath] = pkg.Types
+	})
+	var pkgs []*packages.Package
+	for _, pkg := range all {
+		if isMatched[pkg.ID] {
+			pkgs = append(pkgs, pkg)
+		} else if len(pkg.Errors) > 0 {
+			return nil, fmt.Errorf("template %s imports %s, which can't be loaded for the packages it rewrites: %v",
+				strings.Join(tmplPaths, " or "), pkg.PkgPath, pkg.Errors[0])
+		}
+	}
+
+	imp := importerFunc(func(path string) (*types.Package, error) {
+		if pkg, ok := byPath[path]; ok {
+			return pkg, nil
+		}
+		return nil, fmt.Errorf("package %q wasn't loaded", path)
+	})
+	for i, tmplPath := range tmplPaths {
+		f := files[i]
+		tpkg, info, err := checkFile(cfg.Fset, f, imp)
+		if err != nil {
+			return nil, fmt.Errorf("template %s doesn't type check against the packages it rewrites: %v", tmplPath, err)
+		}
+		pkgs = append(pkgs, &packages.Package{
+			ID:              "file=" + tmplPath,
+			Name:            f.Name.Name,
+			PkgPath:         tpkg.Path(),
+			GoFiles:         []string{tmplPath},
+			CompiledGoFiles: []string{tmplPath},
+			Syntax:          []*ast.File{f},
+			Types:           tpkg,
+			TypesInfo:       info,
+			Fset:            cfg.Fset,
+		})
+	}
+	return pkgs, nil
+}
+
+// requirements are the requirements added to a module's go.mod for the packages a template imports, in a copy of
+// it and its go.sum.
+type requirements struct {
+	root    string   // the module's root directory
+	paths   []string // the packages and module versions required
+	modFile string   // the edited copy of go.mod, with go.sum beside it
+}
+
+// requireImports adds the requirements of the packages the template at tmplPath imports which the module enclosing
+// dir doesn't provide, as go get would, to a copy of its go.mod, and makes cfg load packages with it. The module
+// versions its eg:require directives declare are required too, where the module requires older ones, if
+// -bump-requires is set, and are otherwise an error. It returns nil if there are none.
+func requireImports(cfg *packages.Config, dir, tmplPath string) (*requirements, error) {
+	root := moduleRoot(dir)
+	if root == "" {
+		return nil, nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), tmplPath, nil, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, nil // reported by the load
+	}
+	declared, err := templateRequires(f)
+	if err != nil {
+		return nil, err
+	}
+	var imports []string
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if elems := strings.SplitN(path, "/", 2); strings.Contains(elems[0], ".") {
+			imports = append(imports, path)
+		}
+	}
+	var missing []string
+	if len(imports) > 0 {
+		pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName, Dir: dir, BuildFlags: cfg.BuildFlags}, imports...)
+		if err != nil {
+			return nil, fmt.Errorf("load: %v", err)
+		}
+		for _, pkg := range pkgs {
+			if len(pkg.Errors) > 0 {
+				missing = append(missing, pkg.PkgPath)
+			}
+		}
+	}
+	for _, r := range declared {
+		current := requiredVersion(root, r.path)
+		if current != "" && !semverLess(current, r.version) {
+			continue
+		}
+		var imported bool
+		for i, path := range missing {
+			if path == r.path || strings.HasPrefix(path, r.path+"/") {
+				missing[i], imported = path+"@"+r.version, true
+			}
+		}
+		if current == "" && imported {
+			continue // it's added for the import, at the version
+		}
+		if !*bumpFlag {
+			if current == "" {
+				current = "none"
+			}
+			return nil, fmt.Errorf("template %s requires %s %s, but %s requires %s; -bump-requires updates it",
+				tmplPath, r.path, r.version, filepath.Join(root, "go.mod"), current)
+		}
+		missing = append(missing, r.path+"@"+r.version)
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	tmp, err := ioutil.TempDir("", "eg")
+	if err != nil {
+		return nil, err
+	}
+	reqs := &requirements{root: root, paths: missing, modFile: filepath.Join(tmp, "go.mod")}
+	for _, name := range []string{"go.mod", "go.sum"} {
+		src, err := ioutil.ReadFile(filepath.Join(root, name))
+		if os.IsNotExist(err) && name == "go.sum" {
+			continue
+		}
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(tmp, name), src, 0666)
+		}
+		if err != nil {
+			reqs.remove()
+			return nil, err
+		}
+	}
+	cmd := exec.Command("go", append([]string{"get", "-modfile=" + reqs.modFile}, missing...)...)
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		reqs.remove()
+		return nil, fmt.Errorf("template %s needs %s, which %s doesn't require, and go get failed: %v\n%s",
+			tmplPath, strings.Join(missing, ", "), filepath.Join(root, "go.mod"), err, out)
+	}
+	if err := requireDirectly(root, reqs.modFile, imports); err != nil {
+		reqs.remove()
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "eg: requiring %s, which template %s needs, in %s\n",
+		strings.Join(missing, ", "), tmplPath, filepath.Join(root, "go.mod"))
+	cfg.BuildFlags = append(cfg.BuildFlags, "-modfile="+reqs.modFile)
+	return reqs, nil
+}
+
+// requireDirectly marks the requirements of modFile, the go.mod of the module at root, providing the packages at
+// imports as direct, which go get marked // indirect, since the module didn't import them until it's rewritten.
+func requireDirectly(root, modFile string, imports []string) error {
+	cmd := exec.Command("go", "mod", "edit", "-json", "-modfile="+modFile)
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", modFile, err)
+	}
+	var mod struct {
+		Require []struct {
+			Path, Version string
+			Indirect      bool
+		}
+	}
+	if err := json.Unmarshal(out, &mod); err != nil {
+		return fmt.Errorf("reading %s: %v", modFile, err)
+	}
+	var edits []string
+	for _, r := range mod.Require {
+		for _, path := range imports {
+			if r.Indirect && (path == r.Path || strings.HasPrefix(path, r.Path+"/")) {
+				// the requirement is dropped and added anew, since -require alone keeps its comment
+				edits = append(edits, "-droprequire="+r.Path, "-require="+r.Path+"@"+r.Version)
+				break
+			}
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	cmd = exec.Command("go", append([]string{"mod", "edit", "-modfile=" + modFile}, edits...)...)
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("editing %s: %v\n%s", modFile, err, out)
+	}
+	return nil
+}
+
+// requiredVersion returns the version of the module path which the module at root requires, or "" if it requires
+// none.
+func requiredVersion(root, path string) string {
+	cmd := exec.Command("go", "list", "-m", "-f", "{{.Version}}", path)
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// staged returns the edited go.mod and go.sum, to be written over the module's own.
+func (r *requirements) staged() ([]stagedFile, error) {
+	var staged []stagedFile
+	for _, name := range []string{"go.mod", "go.sum"} {
+		src, err := ioutil.ReadFile(filepath.Join(filepath.Dir(r.modFile), name))
+		if os.IsNotExist(err) && name == "go.sum" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, stagedFile{name: filepath.Join(r.root, name), src: src})
+	}
+	return staged, nil
+}
+
+// remove removes the edited copies, if there are any.
+func (r *requirements) remove() {
+	if r != nil {
+		os.RemoveAll(filepath.Dir(r.modFile))
+	}
+}
+
+// loadTemplate loads and type checks the package containing the template at tmplPath on its own, returning the
+// package and the template's syntax tree.
+func loadTemplate(fSet *token.FileSet, tmplPath string) (*packages.Package, *ast.File, error) {
+	tmplPath, err := filepath.Abs(tmplPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to resolve template path %q: %v", tmplPath, err)
+	}
+
+	cfg := &packages.Config{Mode: egrun.LoadMode, Fset: fSet}
+	pkgs, err := packages.Load(cfg, "file="+tmplPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		return nil, nil, errors.New("error loading template")
+	}
+	for _, pkg := range pkgs {
+		if f := findFile(fSet, pkg, tmplPath); f != nil {
+			return pkg, f, nil
+		}
+	}
+	return nil, nil, templateNotFound(tmplPath)
+}
+
+// findFile returns the syntax tree in pkg whose file name is filename, or nil if there is none.
+func findFile(fSet *token.FileSet, pkg *packages.Package, filename string) *ast.File {
+	for _, f := range pkg.Syntax {
+		if filename == fSet.File(f.Pos()).Name() {
+			return f
+		}
+	}
+	return nil
+}
+
+// templateFuncs returns the before and after declarations of the template; either may be nil if not declared.
+func templateFuncs(tmplFile *ast.File) (before, after *ast.FuncDecl) {
+	for _, decl := range tmplFile.Decls {
+		if decl, ok := decl.(*ast.FuncDecl); ok && decl.Recv == nil {
+			switch decl.Name.Name {
+			case "before":
+				before = decl
+			case "after":
+				after = decl
+			}
+		}
+	}
+	return before, after
+}
+
+// loadTemplateWith loads the template at tmplPath together with the packages at paths, returning the template's
+// package and syntax tree and an importer of every package loaded, transitively. Code type checked using the
+// importer shares the template's type universe, which the matcher's object identity checks depend on.
+func loadTemplateWith(fSet *token.FileSet, tmplPath string, paths []string) (*packages.Package, *ast.File, types.Importer, error) {
+	cfg := &packages.Config{Mode: egrun.LoadMode, Fset: fSet}
+	pkgs, err := packages.Load(cfg, append([]string{"file=" + tmplPath}, paths...)...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("load: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		return nil, nil, nil, errors.New("error loading packages")
+	}
+
+	var tmplPkg *packages.Package
+	var tmplFile *ast.File
+	byPath := make(map[string]*types.Package)
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		byPath[pkg.PkgPath] = pkg.Types
+		if f := findFile(fSet, pkg, tmplPath); f != nil {
+			tmplPkg, tmplFile = pkg, f
+		}
+	})
+	if tmplPkg == nil {
+		return nil, nil, nil, templateNotFound(tmplPath)
+	}
+	imp := importerFunc(func(path string) (*types.Package, error) {
+		if pkg, ok := byPath[path]; ok {
+			return pkg, nil
+		}
+		return nil, fmt.Errorf("package %q wasn't loaded", path)
+	})
+	return tmplPkg, tmplFile, imp, nil
+}
+
+// checkFile type checks f as a single-file package, recording all the information a transformer needs.
+func checkFile(fSet *token.FileSet, f *ast.File, imp types.Importer) (*types.Package, *types.Info, error) {
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	conf := types.Config{Importer: imp}
+	pkg, err := conf.Check(f.Name.Name, fSet, []*ast.File{f}, info)
+	return pkg, info, err
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestConflicts(t *testing.T) {
+	runMainCases(t, "conflicts")
+}
+
+// TestDependencyOrder checks that the packages are visited, and reported with -v, in dependency order, rather than
+// in the order of their IDs.
+func TestDependencyOrder(t *testing.T) {
+	runMainCases(t, "dependency-order")
+}
+
+// TestTolerateErrors checks that -tolerate-errors skips the packages which don't parse, and the files which don't
+// type check, rewriting the rest, and those files too by their syntax alone, if the template needs no types, and
+// that without it, they fail the run.
+func TestTolerateErrors(t *testing.T) {
+	runMainCases(t, "tolerate-errors")
+}
+
+// TestExternalTemplate applies a template in a module of its own, which imports a package of the module it rewrites,
+// so must be type checked against that package as loaded for the rewrite.
+func TestExternalTemplate(t *testing.T) {
+	runMainCases(t, "external-template")
+}
+
+// TestRequire checks that the modules a template imports, which the module rewritten doesn't require, are added to
+// its go.mod, at the versions its eg:require directives declare, if any, and that those versions are required, with
+// -bump-requires, or else fail the run, where the module requires older ones. A patch, rather than the files written,
+// includes the changes to go.mod and go.sum.
+func TestRequire(t *testing.T) {
+	runMainCases(t, "require")
+}
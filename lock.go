@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runLock is the lock file the run holds, if any, which endRun removes, and lockSignals receives the signals which
+// release it while it's held.
+var (
+	runLock     string
+	lockSignals chan os.Signal
+)
+
+// lockRun takes the advisory lock of the module enclosing dir, or of dir if it isn't in one, when files are to be
+// written in place, so that concurrent runs don't interleave their writes, or write results computed from what
+// another is rewriting. The lock is a file, .eg/lock, created exclusively; if another run holds it, lockRun waits up
+// to -lock-wait for it to be released, and fails after. A lock recording a process of this host which has exited is
+// stale, left by a killed run, and is removed, unless it changed after it was read. The lock is released if the run is interrupted or terminated.
+func lockRun(dir string) error {
+	if !*writeFlag || runLock != "" {
+		return nil
+	}
+	root := moduleRoot(dir)
+	if root == "" {
+		root = dir
+	}
+	path := filepath.Join(root, ".eg", "lock")
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(*lockWaitFlag)
+	for waited := false; ; waited = true {
+		// the directory is made each time, since the run releasing the lock removes it
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			runLock = path
+			releaseOnSignal(path)
+			_, err = fmt.Fprintf(f, "pid %d on %s, run %s, since %s\n",
+				os.Getpid(), host, runID, time.Now().Format(time.RFC3339))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		holder, _ := ioutil.ReadFile(path)
+		if pid, on := lockHolder(string(holder)); pid != 0 && on == host && !processExists(pid) {
+			removed, err := removeStaleLock(path, holder, fi.ModTime())
+			if err != nil {
+				return err
+			}
+			if removed {
+				fmt.Fprintf(os.Stderr, "eg: removed the stale lock %s (%s), whose run has exited\n",
+					path, strings.TrimSpace(string(holder)))
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("another eg run holds %s (%s): wait for it, pass -lock-wait to queue behind it, "+
+				"or remove the file if no run is", path, strings.TrimSpace(string(holder)))
+		}
+		if !waited {
+			fmt.Fprintf(os.Stderr, "eg: waiting for the eg run holding %s\n", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// removeStaleLock removes the lock file path, which held holder, written at modTime, by a run which has exited,
+// unless it has changed since: another run waiting for the lock may have removed it, and taken the lock anew,
+// meanwhile. It reports whether it removed it.
+func removeStaleLock(path string, holder []byte, modTime time.Time) (bool, error) {
+	// checked right before the removal, as the holder, a process and the time it took the lock, is unique to the run
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	current, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !fi.ModTime().Equal(modTime) || !bytes.Equal(current, holder) {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// unlockRun releases the lock taken by lockRun, if any, removing the .eg directory too unless it holds more.
+func unlockRun() {
+	if runLock == "" {
+		return
+	}
+	signal.Stop(lockSignals)
+	close(lockSignals)
+	os.Remove(runLock)
+	os.Remove(filepath.Dir(runLock))
+	runLock = ""
+}
+
+// releaseOnSignal removes the lock file path, as unlockRun does, if the run is interrupted or terminated before
+// unlockRun is called, and exits as the signal would have had it.
+func releaseOnSignal(path string) {
+	lockSignals = make(chan os.Signal, 1)
+	signal.Notify(lockSignals, os.Interrupt, syscall.SIGTERM)
+	go func(c chan os.Signal) {
+		sig, ok := <-c
+		if !ok {
+			return
+		}
+		os.Remove(path)
+		os.Remove(filepath.Dir(path))
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		os.Exit(code)
+	}(lockSignals)
+}
+
+// lockHolder returns the pid and host recorded in the lock file's contents by lockRun, or 0 if they aren't there, as
+// in the lock of an older eg.
+func lockHolder(holder string) (pid int, host string) {
+	f := strings.Fields(holder)
+	if len(f) < 4 || f[0] != "pid" || f[2] != "on" {
+		return 0, ""
+	}
+	pid, err := strconv.Atoi(f[1])
+	if err != nil {
+		return 0, ""
+	}
+	return pid, strings.TrimSuffix(f[3], ",")
+}
+
+// processExists reports whether the process pid is running. On Windows, finding it opens it, which fails if it has
+// exited; elsewhere, it's sent the null signal, which fails with EPERM if it's running as another user.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
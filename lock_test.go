@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLockRun(t *testing.T) {
+	defer func(write bool, wait time.Duration) { *writeFlag, *lockWaitFlag = write, wait }(*writeFlag, *lockWaitFlag)
+	*writeFlag, *lockWaitFlag = true, 0
+
+	// the pid of a process which has exited
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name, holder string
+		stale        bool
+	}{
+		{"exited", fmt.Sprintf("pid %d on %s, run x, since y", exited.Process.Pid, host), true},
+		{"running", fmt.Sprintf("pid %d on %s, run x, since y", os.Getpid(), host), false},
+		{"other host", fmt.Sprintf("pid %d on %s.other, run x, since y", exited.Process.Pid, host), false},
+		{"no host", fmt.Sprintf("pid %d, run x, since y", exited.Process.Pid), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "eg-lock")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, ".eg", "lock")
+			if err := os.Mkdir(filepath.Dir(path), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(test.holder+"\n"), 0666); err != nil {
+				t.Fatal(err)
+			}
+			err = lockRun(dir)
+			defer unlockRun()
+			switch {
+			case test.stale && err != nil:
+				t.Fatalf("the stale lock wasn't taken over: %v", err)
+			case test.stale && runLock != path:
+				t.Fatalf("the lock held is %q, want %q", runLock, path)
+			case !test.stale && err == nil:
+				t.Fatal("the lock was taken from its holder")
+			case !test.stale && !strings.Contains(err.Error(), "another eg run holds"):
+				t.Fatalf("the lock failed with %q", err)
+			}
+		})
+	}
+}
+
+// TestRemoveStaleLockRetaken checks that a stale lock is left if another run has taken the lock anew since it was
+// read, as when that run removed the stale lock first.
+func TestRemoveStaleLockRetaken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eg-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+	stale := []byte("pid 1 on h, run x, since y\n")
+	if err := ioutil.WriteFile(path, stale, 0666); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := []byte("pid 2 on h, run z, since y\n")
+	if err := ioutil.WriteFile(path, fresh, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := removeStaleLock(path, stale, fi.ModTime()); err != nil || removed {
+		t.Fatalf("got %v, %v removing the retaken lock, want false, nil", removed, err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || string(got) != string(fresh) {
+		t.Fatalf("the retaken lock holds %q (%v), want %q", got, err, fresh)
+	}
+	if removed, err := removeStaleLock(path, fresh, fi.ModTime().Add(-time.Hour)); err != nil || removed {
+		t.Fatalf("got %v, %v removing the lock written since, want false, nil", removed, err)
+	}
+	fi, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := removeStaleLock(path, fresh, fi.ModTime()); err != nil || !removed {
+		t.Fatalf("got %v, %v removing the unchanged lock, want true, nil", removed, err)
+	}
+	if removed, err := removeStaleLock(path, fresh, fi.ModTime()); err != nil || removed {
+		t.Fatalf("got %v, %v removing the lock removed already, want false, nil", removed, err)
+	}
+}
+
+func TestLockReleasedOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM can't be sent on Windows")
+	}
+	dir, err := ioutil.TempDir("", "eg-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"go.mod", "p/p.go", "templates/contains/contains.go"} {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", "hooks", "json", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), src, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the run holds the lock while its beforerun hook sleeps
+	cmd := exec.Command(os.Args[0], "-w", "-beforerun", "sleep 5", "-t", "templates/contains/contains.go", "./p")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), mainEnv+"=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	lock := filepath.Join(dir, ".eg", "lock")
+	for deadline := time.Now().Add(time.Minute); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(lock); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			cmd.Wait()
+			t.Fatal("the run didn't take the lock")
+		}
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Fatal("the terminated run succeeded")
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("the terminated run left its lock: %v", err)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const loopsUsage = `Usage: eg loops [-w] <packages>...
+
+Rewrites hand-rolled loops as calls of the slices and maps packages:
+
+	found := false                     found := slices.Contains(s, x)
+	for _, v := range s {
+		if v == x {
+			found = true
+			break
+		}
+	}
+
+	i := -1                            i := slices.Index(s, x)
+	for j, v := range s {
+		if v == x {
+			i = j
+			break
+		}
+	}
+
+	var out []T                        out := slices.Clone(s)
+	for _, v := range s {
+		out = append(out, v)
+	}
+
+	keys := make([]K, 0, len(m))       keys := slices.Collect(maps.Keys(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+and the forms of the first two returning from the loop, of the last collecting
+the map's values, and of the last followed by sorting the keys, which become
+slices.Sorted(maps.Keys(m)). The rewrites match sequences of statements, the
+declaration followed by the loop, rather than single expressions, so can't be
+expressed as templates. An empty result may differ in whether it's nil.
+Packages in modules declaring a go version before 1.21, which added slices, or
+1.23 for maps.Keys and maps.Values, aren't rewritten.
+`
+
+func loopsMain(args []string) error {
+	fs := flag.NewFlagSet("loops", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, loopsUsage) }
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	versions := make(moduleVersions)
+	for _, pkg := range m.pkgs {
+		l := loopRewriter{m: m, pkg: pkg}
+		if len(pkg.GoFiles) > 0 {
+			dir := filepath.Dir(pkg.GoFiles[0])
+			version, gomod, err := versions.lookup(dir)
+			if err != nil {
+				return err
+			}
+			if version != "" && goVersionLess(version, "1.21") {
+				fmt.Fprintf(os.Stderr, "skipping %s: %s declares go %s, but slices needs go 1.21\n", dir, gomod, version)
+				continue
+			}
+			l.iterators = version == "" || !goVersionLess(version, "1.23")
+		}
+		for _, file := range pkg.Syntax {
+			l.file, l.removed, l.sorted = file, nil, false
+			ast.Inspect(file, func(n ast.Node) bool {
+				if list, ok := stmtList(n); ok {
+					l.rewriteList(list)
+				}
+				return true
+			})
+			joinLines(m.fSet.File(file.Pos()), l.removed)
+			if l.sorted {
+				removeUnusedImports(m.fSet, pkg.TypesInfo, file, map[string]bool{"sort": true})
+			}
+		}
+	}
+	return m.emit(false)
+}
+
+// A loopRewriter rewrites the loops of a file.
+type loopRewriter struct {
+	m         *migration
+	pkg       *packages.Package
+	file      *ast.File
+	iterators bool // whether maps.Keys and maps.Values are available
+
+	removed []posRange // the statements replaced in the file
+	sorted  bool       // whether calls of sort functions were removed from the file
+}
+
+// rewriteList rewrites the loops in the statement list, together with the statements around them they depend on.
+func (l *loopRewriter) rewriteList(list *[]ast.Stmt) {
+	for i := 0; i < len(*list); i++ {
+		rest := (*list)[i:]
+		for _, rewrite := range []func([]ast.Stmt) (func() ast.Stmt, int){l.search, l.searchReturn, l.collect} {
+			apply, n := rewrite(rest)
+			if apply == nil {
+				continue
+			}
+			if l.hasComments(rest[0].Pos(), rest[n-1].End()) {
+				fmt.Fprintf(os.Stderr, "%s: can't rewrite a loop containing comments; rewrite it manually\n",
+					l.m.position(rest[0]))
+				break
+			}
+			stmt := apply()
+			if stmt == nil {
+				break
+			}
+			setPos(stmt, rest[0].Pos())
+			l.removed = append(l.removed, posRange{rest[0].Pos(), rest[n-1].End()})
+			*list = append(append((*list)[:i:i], stmt), rest[n:]...)
+			l.m.touch(l.pkg, l.file)
+			break
+		}
+	}
+}
+
+// hasComments reports whether there are comments in the file between pos and end.
+func (l *loopRewriter) hasComments(pos, end token.Pos) bool {
+	for _, cg := range l.file.Comments {
+		if pos <= cg.Pos() && cg.End() <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// The rewrites of a statement list each return a function rewriting the statements they match at its start,
+// which returns the statement replacing them, or nil if it can't, and the number of statements matched.
+
+// search rewrites a variable declaration followed by a loop searching a slice for an element, which sets it to
+// true or the element's index, as a call of slices.Contains or slices.Index.
+func (l *loopRewriter) search(list []ast.Stmt) (func() ast.Stmt, int) {
+	if len(list) < 2 {
+		return nil, 0
+	}
+	v, init, setInit := l.initialized(list[0])
+	loop, key, val := l.rangeLoop(list[1])
+	if v == nil || loop == nil || val == nil {
+		return nil, 0
+	}
+	x, body := l.searchIf(loop, val, key)
+	if x == nil || len(body) != 2 {
+		return nil, 0
+	}
+	set, ok := body[0].(*ast.AssignStmt)
+	if br, isBranch := body[1].(*ast.BranchStmt); !isBranch || br.Tok != token.BREAK || br.Label != nil {
+		return nil, 0
+	}
+	if !ok || set.Tok != token.ASSIGN || len(set.Lhs) != 1 || len(set.Rhs) != 1 || !l.refersTo(set.Lhs[0], v) {
+		return nil, 0
+	}
+	var fn string
+	switch {
+	case (init == nil || l.isConst(init, constant.MakeBool(false))) && l.isConst(set.Rhs[0], constant.MakeBool(true)) &&
+		types.Identical(v.Type(), types.Typ[types.Bool]):
+		fn = "Contains"
+	case key != nil && l.isConst(init, constant.MakeInt64(-1)) && l.refersTo(set.Rhs[0], key) &&
+		types.Identical(v.Type(), types.Typ[types.Int]):
+		fn = "Index"
+	default:
+		return nil, 0
+	}
+	return func() ast.Stmt {
+		call := l.call(list[0].Pos(), "slices", fn, loop.X, x)
+		if call == nil {
+			return nil
+		}
+		return setInit(call)
+	}, 2
+}
+
+// searchReturn rewrites a loop searching a slice for an element, which returns true or its index, followed by a
+// return of false or -1, as the return of a call of slices.Contains or slices.Index.
+func (l *loopRewriter) searchReturn(list []ast.Stmt) (func() ast.Stmt, int) {
+	if len(list) < 2 {
+		return nil, 0
+	}
+	loop, key, val := l.rangeLoop(list[0])
+	ret, ok := list[1].(*ast.ReturnStmt)
+	if loop == nil || val == nil || !ok || len(ret.Results) != 1 {
+		return nil, 0
+	}
+	x, body := l.searchIf(loop, val, key)
+	if x == nil || len(body) != 1 {
+		return nil, 0
+	}
+	found, ok := body[0].(*ast.ReturnStmt)
+	if !ok || len(found.Results) != 1 {
+		return nil, 0
+	}
+	info := l.pkg.TypesInfo
+	var fn string
+	switch {
+	case l.isConst(ret.Results[0], constant.MakeBool(false)) && l.isConst(found.Results[0], constant.MakeBool(true)) &&
+		types.Identical(info.TypeOf(ret.Results[0]), types.Typ[types.Bool]):
+		fn = "Contains"
+	case key != nil && l.isConst(ret.Results[0], constant.MakeInt64(-1)) && l.refersTo(found.Results[0], key) &&
+		types.Identical(info.TypeOf(ret.Results[0]), types.Typ[types.Int]):
+		fn = "Index"
+	default:
+		return nil, 0
+	}
+	return func() ast.Stmt {
+		call := l.call(list[0].Pos(), "slices", fn, loop.X, x)
+		if call == nil {
+			return nil
+		}
+		return &ast.ReturnStmt{Return: list[0].Pos(), Results: []ast.Expr{call}}
+	}, 2
+}
+
+// collect rewrites an empty slice's declaration followed by a loop appending each element of a slice, or each
+// key or value of a map, to it as a call of slices.Clone, or of slices.Collect of maps.Keys or maps.Values, or
+// slices.Sorted if the loop is followed by sorting the slice.
+func (l *loopRewriter) collect(list []ast.Stmt) (func() ast.Stmt, int) {
+	if len(list) < 2 {
+		return nil, 0
+	}
+	v, init, setInit := l.initialized(list[0])
+	loop, key, val := l.rangeLoop(list[1])
+	if v == nil || loop == nil || len(loop.Body.List) != 1 || !l.isEmptySlice(init, v.Type()) {
+		return nil, 0
+	}
+	// v = append(v, e)
+	set, ok := loop.Body.List[0].(*ast.AssignStmt)
+	if !ok || set.Tok != token.ASSIGN || len(set.Lhs) != 1 || len(set.Rhs) != 1 || !l.refersTo(set.Lhs[0], v) {
+		return nil, 0
+	}
+	app, ok := set.Rhs[0].(*ast.CallExpr)
+	if !ok || len(app.Args) != 2 || app.Ellipsis.IsValid() || !l.isBuiltin(app.Fun, "append") || !l.refersTo(app.Args[0], v) {
+		return nil, 0
+	}
+	elem := app.Args[1]
+
+	info := l.pkg.TypesInfo
+	switch t := info.TypeOf(loop.X).Underlying().(type) {
+	case *types.Slice:
+		if key != nil || val == nil || !l.refersTo(elem, val) || !types.Identical(v.Type(), info.TypeOf(loop.X)) {
+			return nil, 0
+		}
+		return func() ast.Stmt {
+			call := l.call(list[0].Pos(), "slices", "Clone", loop.X)
+			if call == nil {
+				return nil
+			}
+			return setInit(call)
+		}, 2
+	case *types.Map:
+		var iter string
+		var iterType types.Type
+		switch {
+		case key != nil && val == nil && l.refersTo(elem, key):
+			iter, iterType = "Keys", t.Key()
+		case key == nil && val != nil && l.refersTo(elem, val):
+			iter, iterType = "Values", t.Elem()
+		default:
+			return nil, 0
+		}
+		if !l.iterators || !types.Identical(v.Type(), types.NewSlice(iterType)) {
+			return nil, 0
+		}
+		collect, n := "Collect", 2
+		sorted := len(list) > 2 && l.sorts(list[2], v)
+		if sorted {
+			collect, n = "Sorted", 3
+		}
+		return func() ast.Stmt {
+			inner := l.call(list[0].Pos(), "maps", iter, loop.X)
+			if inner == nil {
+				return nil
+			}
+			call := l.call(list[0].Pos(), "slices", collect, inner)
+			if call == nil {
+				return nil
+			}
+			l.sorted = l.sorted || sorted
+			return setInit(call)
+		}, n
+	}
+	return nil, 0
+}
+
+// initialized returns the variable declared or assigned by stmt, a declaration or assignment of a single
+// variable, with its initial value (nil if it has none) and a function returning the statement initializing it to
+// another value instead.
+func (l *loopRewriter) initialized(stmt ast.Stmt) (*types.Var, ast.Expr, func(ast.Expr) ast.Stmt) {
+	info := l.pkg.TypesInfo
+	var id *ast.Ident
+	var init ast.Expr
+	var set func(ast.Expr) ast.Stmt
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		if len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 || stmt.Tok != token.DEFINE && stmt.Tok != token.ASSIGN {
+			return nil, nil, nil
+		}
+		id, _ = stmt.Lhs[0].(*ast.Ident)
+		init = stmt.Rhs[0]
+		set = func(e ast.Expr) ast.Stmt {
+			stmt.Rhs[0] = e
+			return stmt
+		}
+	case *ast.DeclStmt:
+		gen := stmt.Decl.(*ast.GenDecl)
+		if gen.Tok != token.VAR || len(gen.Specs) != 1 {
+			return nil, nil, nil
+		}
+		spec := gen.Specs[0].(*ast.ValueSpec)
+		if len(spec.Names) != 1 || len(spec.Values) > 1 {
+			return nil, nil, nil
+		}
+		id = spec.Names[0]
+		if len(spec.Values) == 1 {
+			init = spec.Values[0]
+		}
+		set = func(e ast.Expr) ast.Stmt {
+			return &ast.AssignStmt{Lhs: []ast.Expr{id}, TokPos: id.End(), Tok: token.DEFINE, Rhs: []ast.Expr{e}}
+		}
+	}
+	if id == nil {
+		return nil, nil, nil
+	}
+	v, ok := info.ObjectOf(id).(*types.Var)
+	if !ok {
+		return nil, nil, nil
+	}
+	return v, init, set
+}
+
+// rangeLoop returns the range loop stmt, with the variables it declares for the key and value, unless they're
+// blank or omitted.
+func (l *loopRewriter) rangeLoop(stmt ast.Stmt) (*ast.RangeStmt, *types.Var, *types.Var) {
+	loop, ok := stmt.(*ast.RangeStmt)
+	if !ok || loop.Tok == token.ASSIGN {
+		return nil, nil, nil
+	}
+	variable := func(e ast.Expr) *types.Var {
+		if id, ok := e.(*ast.Ident); ok && id.Name != "_" {
+			v, _ := l.pkg.TypesInfo.Defs[id].(*types.Var)
+			return v
+		}
+		return nil
+	}
+	return loop, variable(loop.Key), variable(loop.Value)
+}
+
+// searchIf returns the operand x of the comparison val == x which is the condition of the if statement forming
+// the body of loop, with the if statement's body.
+func (l *loopRewriter) searchIf(loop *ast.RangeStmt, val, key *types.Var) (ast.Expr, []ast.Stmt) {
+	info := l.pkg.TypesInfo
+	if len(loop.Body.List) != 1 {
+		return nil, nil
+	}
+	if _, ok := info.TypeOf(loop.X).Underlying().(*types.Slice); !ok {
+		return nil, nil
+	}
+	ifStmt, ok := loop.Body.List[0].(*ast.IfStmt)
+	if !ok || ifStmt.Init != nil || ifStmt.Else != nil {
+		return nil, nil
+	}
+	eq, ok := ifStmt.Cond.(*ast.BinaryExpr)
+	if !ok || eq.Op != token.EQL {
+		return nil, nil
+	}
+	x := eq.Y
+	if !l.refersTo(eq.X, val) {
+		if !l.refersTo(eq.Y, val) {
+			return nil, nil
+		}
+		x = eq.X
+	}
+	// x is evaluated once, rather than on every iteration, and must be assignable to the element type
+	if !sideEffectFree(info, x) || usesObj(info, x, val) || key != nil && usesObj(info, x, key) ||
+		!types.AssignableTo(info.TypeOf(x), val.Type()) {
+		return nil, nil
+	}
+	return x, ifStmt.Body.List
+}
+
+// sorts reports whether stmt sorts the slice v in increasing order.
+func (l *loopRewriter) sorts(stmt ast.Stmt, v *types.Var) bool {
+	expr, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := expr.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 || !l.refersTo(call.Args[0], v) {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	fn, ok := l.pkg.TypesInfo.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return false
+	}
+	switch fn.Pkg().Path() + "." + fn.Name() {
+	case "sort.Strings", "sort.Ints", "sort.Float64s", "slices.Sort":
+		return true
+	}
+	return false
+}
+
+// isEmptySlice reports whether init, the initial value of a variable of type t, is an empty slice: absent, nil,
+// or made with a length of 0.
+func (l *loopRewriter) isEmptySlice(init ast.Expr, t types.Type) bool {
+	if _, ok := t.Underlying().(*types.Slice); !ok {
+		return false
+	}
+	if init == nil || l.pkg.TypesInfo.Types[init].IsNil() {
+		return true
+	}
+	mk, ok := init.(*ast.CallExpr)
+	return ok && l.isBuiltin(mk.Fun, "make") && len(mk.Args) >= 2 && l.isConst(mk.Args[1], constant.MakeInt64(0))
+}
+
+// isConst reports whether e is a constant of value c.
+func (l *loopRewriter) isConst(e ast.Expr, c constant.Value) bool {
+	if e == nil {
+		return false
+	}
+	tv, ok := l.pkg.TypesInfo.Types[e]
+	return ok && tv.Value != nil && tv.Value.Kind() == c.Kind() && constant.Compare(tv.Value, token.EQL, c)
+}
+
+// isBuiltin reports whether e refers to the builtin function name.
+func (l *loopRewriter) isBuiltin(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	b, ok := l.pkg.TypesInfo.Uses[id].(*types.Builtin)
+	return ok && b.Name() == name
+}
+
+// refersTo reports whether e is an identifier referring to v.
+func (l *loopRewriter) refersTo(e ast.Expr, v *types.Var) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && v != nil && l.pkg.TypesInfo.Uses[id] == v
+}
+
+// call returns a call of the function name of the package at path, or nil if it can't be referred to at pos.
+func (l *loopRewriter) call(pos token.Pos, path, name string, args ...ast.Expr) *ast.CallExpr {
+	call, err := l.m.stdCall(l.pkg, l.file, pos, path, name, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v; rewrite this loop manually\n", l.m.fSet.Position(pos), err)
+		return nil
+	}
+	return call
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestLoops(t *testing.T) {
+	runCommandCases(t, "loops")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// writeMatrix writes the run's matches by template and package to filename, for -matrix: as CSV, with a row for
+// each package and a column for each template, if its name ends in .csv, or else as JSON.
+func writeMatrix(filename string) error {
+	var pkgs []string
+	seen := make(map[string]bool)
+	for _, t := range metrics.Templates {
+		for pkg := range t.ByPackage {
+			if !seen[pkg] {
+				seen[pkg] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	sort.Strings(pkgs)
+
+	var out bytes.Buffer
+	if filepath.Ext(filename) == ".csv" {
+		w := csv.NewWriter(&out)
+		header := []string{"package"}
+		for _, t := range metrics.Templates {
+			header = append(header, t.Template)
+		}
+		w.Write(header)
+		for _, pkg := range pkgs {
+			row := []string{pkg}
+			for _, t := range metrics.Templates {
+				row = append(row, strconv.Itoa(t.ByPackage[pkg]))
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	} else {
+		matrix := struct {
+			RunID   string                    `json:"run_id"`
+			Start   time.Time                 `json:"start"`
+			Matches map[string]map[string]int `json:"matches"` // by template, then package
+		}{metrics.RunID, metrics.Start, make(map[string]map[string]int)}
+		for _, t := range metrics.Templates {
+			matrix.Matches[t.Template] = t.ByPackage
+			if t.ByPackage == nil {
+				matrix.Matches[t.Template] = map[string]int{}
+			}
+		}
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(&matrix); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(filename, out.Bytes(), 0666)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strings"
+)
+
+// writeMessage writes a description of the run's changes to filename, for the message of a commit or the body of a
+// pull request: the templates which matched, with the rationale of each, and their files and matches.
+func writeMessage(filename string) error {
+	var applied []*templateMetrics
+	var matches int
+	files := make(map[string]bool) // since templates may rewrite the same files
+	for _, t := range metrics.Templates {
+		if t.Matches > 0 {
+			applied = append(applied, t)
+			matches += t.Matches
+			for f := range t.rewritten {
+				files[f] = true
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	switch len(applied) {
+	case 0:
+		buf.WriteString("Apply eg: nothing to rewrite\n")
+	case 1:
+		fmt.Fprintf(&buf, "Apply eg template %s\n", messageLabel(applied[0]))
+	default:
+		fmt.Fprintf(&buf, "Apply %d eg templates\n", len(applied))
+	}
+	for _, t := range applied {
+		buf.WriteString("\n")
+		if len(applied) > 1 {
+			fmt.Fprintf(&buf, "%s:\n", messageLabel(t))
+		}
+		if doc := templateDoc(t.path); doc != "" {
+			fmt.Fprintf(&buf, "%s\n\n", doc)
+		}
+		fmt.Fprintf(&buf, "%s in %s.\n", plural(t.Matches, "match", "matches"), plural(t.FilesRewritten, "file", "files"))
+	}
+	if len(applied) > 1 {
+		fmt.Fprintf(&buf, "\nIn all, %s in %s.\n", plural(matches, "match", "matches"), plural(len(files), "file", "files"))
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+// messageLabel returns the name of the template of t in the message: its label, or its path, relative to the
+// working directory if it's within it.
+func messageLabel(t *templateMetrics) string {
+	if t.Template == t.path {
+		return patchPath(t.path)
+	}
+	return t.Template
+}
+
+// templateDoc returns the rationale of the template at tmplPath: its package doc comment, or else that of its before
+// function, if any.
+func templateDoc(tmplPath string) string {
+	f, err := parser.ParseFile(token.NewFileSet(), tmplPath, nil, parser.ParseComments)
+	if err != nil {
+		return ""
+	}
+	if f.Doc != nil {
+		return strings.TrimSpace(f.Doc.Text())
+	}
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == "before" && fn.Recv == nil && fn.Doc != nil {
+			return strings.TrimSpace(fn.Doc.Text())
+		}
+	}
+	return ""
+}
+
+// plural returns n followed by the singular or plural noun, as n requires.
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestEmitMessage(t *testing.T) {
+	runMainCases(t, "message")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// runMetrics are the counters of a run, written by -metrics, e.g. for platform teams following a migration across
+// many repositories.
+type runMetrics struct {
+	RunID           string             `json:"run_id"`
+	Start           time.Time          `json:"start"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	Failed          bool               `json:"failed"`
+	Error           string             `json:"error,omitempty"`
+	Templates       []*templateMetrics `json:"templates"`
+}
+
+// templateMetrics are the counters of applying a template, over every platform it's applied for.
+type templateMetrics struct {
+	Template        string         `json:"template"`
+	Packages        int            `json:"packages"`
+	Files           int            `json:"files"` // visited
+	FilesRewritten  int            `json:"files_rewritten"`
+	Matches         int            `json:"matches"`
+	Failures        int            `json:"failures"` // of files which couldn't be rewritten or written
+	DurationSeconds float64        `json:"duration_seconds"`
+	ByPackage       map[string]int `json:"matches_by_package,omitempty"`
+
+	path      string          // of the template
+	rewritten map[string]bool // the files rewritten, of which there are FilesRewritten
+}
+
+// metrics are the counters of the run.
+var metrics = runMetrics{RunID: runID, Start: time.Now()}
+
+// templateLabels name the templates written to temporary files, by path, in the metrics, where the paths would
+// differ from run to run: the -e snippet, "-" for standard input, or the name of a config's rule.
+var templateLabels = make(map[string]string)
+
+// template returns the counters of the template at tmplPath, adding them if need be.
+func (m *runMetrics) template(tmplPath string) *templateMetrics {
+	label := templateLabel(tmplPath)
+	for _, t := range m.Templates {
+		if t.Template == label {
+			return t
+		}
+	}
+	t := &templateMetrics{Template: label, path: tmplPath}
+	m.Templates = append(m.Templates, t)
+	return t
+}
+
+// addMatches counts the matches of the template in pkg, by its import path.
+func (t *templateMetrics) addMatches(pkg string, n int) {
+	t.Matches += n
+	if t.ByPackage == nil {
+		t.ByPackage = make(map[string]int)
+	}
+	t.ByPackage[pkg] += n
+}
+
+// addFile counts filename as rewritten by the template, once however often it's rewritten.
+func (t *templateMetrics) addFile(filename string) {
+	if t.rewritten == nil {
+		t.rewritten = make(map[string]bool)
+	}
+	if !t.rewritten[filename] {
+		t.rewritten[filename] = true
+		t.FilesRewritten++
+	}
+}
+
+// writeMetrics writes the run's metrics to the file of -metrics, and pushes them to the gateway of -metrics-push, and
+// the matrix of its matches to the file of -matrix, as given, once the run has ended with err.
+func writeMetrics(err error) {
+	if *matrixFlag != "" {
+		if err := writeMatrix(*matrixFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't write the matrix to %s (%s)\n", *matrixFlag, err)
+		}
+	}
+	if *metricsFlag == "" && *metricsPushFlag == "" {
+		return
+	}
+	metrics.DurationSeconds = time.Since(metrics.Start).Seconds()
+	if err != nil {
+		metrics.Failed, metrics.Error = true, err.Error()
+	}
+	if *metricsFlag != "" {
+		var out bytes.Buffer
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false) // for the arrows of -e snippets
+		enc.SetIndent("", "\t")
+		err := enc.Encode(&metrics)
+		if err == nil {
+			err = ioutil.WriteFile(*metricsFlag, out.Bytes(), 0666)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't write the metrics to %s (%s)\n", *metricsFlag, err)
+		}
+	}
+	if *metricsPushFlag != "" {
+		if err := pushMetrics(*metricsPushFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't push the metrics to %s (%s)\n", *metricsPushFlag, err)
+		}
+	}
+}
+
+// pushMetrics pushes the run's metrics to the Prometheus pushgateway group at url, e.g.
+// http://pushgateway:9091/metrics/job/eg, replacing those of the group's previous run.
+func pushMetrics(url string) error {
+	var buf bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	gauge("eg_run_duration_seconds", "How long the run took.")
+	fmt.Fprintf(&buf, "eg_run_duration_seconds %g\n", metrics.DurationSeconds)
+	gauge("eg_run_failed", "Whether the run failed.")
+	failed := 0
+	if metrics.Failed {
+		failed = 1
+	}
+	fmt.Fprintf(&buf, "eg_run_failed %d\n", failed)
+
+	templates := append([]*templateMetrics(nil), metrics.Templates...)
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Template < templates[j].Template })
+	for _, c := range []struct {
+		name, help string
+		value      func(*templateMetrics) float64
+	}{
+		{"eg_packages", "The packages the template was applied to.", func(t *templateMetrics) float64 { return float64(t.Packages) }},
+		{"eg_files", "The files the template was applied to.", func(t *templateMetrics) float64 { return float64(t.Files) }},
+		{"eg_files_rewritten", "The files the template matched.", func(t *templateMetrics) float64 { return float64(t.FilesRewritten) }},
+		{"eg_matches", "The matches of the template.", func(t *templateMetrics) float64 { return float64(t.Matches) }},
+		{"eg_failures", "The files the template's rewrites failed for.", func(t *templateMetrics) float64 { return float64(t.Failures) }},
+		{"eg_duration_seconds", "How long applying the template took.", func(t *templateMetrics) float64 { return t.DurationSeconds }},
+	} {
+		gauge(c.name, c.help)
+		for _, t := range templates {
+			fmt.Fprintf(&buf, "%s{template=%s} %g\n", c.name, strconv.Quote(t.Template), c.value(t))
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMatchesByPackage checks that the metrics count a template's matches by the import paths of the packages.
+func TestMatchesByPackage(t *testing.T) {
+	savedTemplates := metrics.Templates
+	defer func() { metrics.Templates = savedTemplates }()
+	for _, workers := range []int{1, 8} {
+		metrics.Templates = nil
+		runParallel(t, workers, []string{"templates/empty/empty.go"}, false)
+		if len(metrics.Templates) != 1 || !strings.HasSuffix(metrics.Templates[0].Template, "empty.go") {
+			t.Fatalf("-parallel %d: got the metrics of %d templates, want empty.go's", workers, len(metrics.Templates))
+		}
+		want := make(map[string]int)
+		for _, pkg := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			want["example.com/parallel/"+pkg] = 6
+		}
+		if got := metrics.Templates[0].ByPackage; !reflect.DeepEqual(got, want) {
+			t.Errorf("-parallel %d: got the matches by package %v, want %v", workers, got, want)
+		}
+	}
+}
+
+// TestFilesRewritten checks that a file rewritten by a template more than once is counted once.
+func TestFilesRewritten(t *testing.T) {
+	var m templateMetrics
+	for _, name := range []string{"a.go", "b.go", "a.go"} {
+		m.addFile(name)
+	}
+	if m.FilesRewritten != 2 || len(m.rewritten) != 2 {
+		t.Errorf("got %d files rewritten, of %v, want 2", m.FilesRewritten, m.rewritten)
+	}
+}
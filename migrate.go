@@ -0,0 +1,1093 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/egrun"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// outputFlags registers the flags controlling how rewritten files are output on fs, bound to the same variables
+// as the flags of the main command.
+func outputFlags(fs *flag.FlagSet) {
+	fs.BoolVar(writeFlag, "w", false, "rewrite input files in place (by default, the results are printed to standard output)")
+	fs.BoolVar(diffFlag, "d", false, "print a unified diff of each file a rewrite changes, and exit 1 if there are any")
+	fs.StringVar(formatFlag, "format", "", "output format when not rewriting in place: source (the default) or list")
+	fs.Var(&beforeEditFlags, "beforeedit", "a command to exec before each file is edited; '{}' is replaced by the file name")
+	fs.Var(&afterEditFlags, "afteredit", "a command to exec after each file is edited; '{}' is replaced by the file name")
+	fs.Var(&beforeRunFlags, "beforerun", "a command to exec once, before the first file is edited")
+	fs.Var(&afterRunFlags, "afterrun", "a command to exec once, after the run, if any file was edited; '{files}' is replaced by the name of a file listing them")
+	fs.StringVar(policyFlag, "hook-policy", "", "what a failing beforeedit hook does: warn (the default), or abort the migration")
+	fs.DurationVar(lockWaitFlag, "lock-wait", 0, "how long to wait for another run writing the module to finish, rather than failing")
+	fs.BoolVar(shellFlag, "hook-shell", false, "run the edit hooks with the platform's shell: sh -c, or cmd /c on Windows")
+	fs.BoolVar(tolerateFlag, "tolerate-errors", false, "skip the packages and files with errors, rather than failing")
+	fs.Var(verboseFlag, "v", "report the order in which packages are visited")
+	fs.BoolVar(&checkFlag, "check", true, "after a migration, reload the packages of the modules rewritten and report references left broken")
+}
+
+// checkFlag is the -check flag of the subcommands.
+var checkFlag bool
+
+// A migration is a coordinated rewrite of a declaration and its uses across all the loaded packages, which
+// templates can't express. Files are modified in place and emitted together once the rewrite is complete.
+type migration struct {
+	fSet    *token.FileSet
+	pkgs    []*packages.Package
+	configs *configs
+	skipped map[string]skippedFile // the files with errors skipped by -tolerate-errors
+	reqs    *requirements          // those added for the template's imports, if any
+
+	modified map[*ast.File]*packages.Package
+	decls    map[*types.Func]funcDecl         // computed lazily by funcDecls
+	declared map[*types.Scope]map[string]bool // the names the migration has declared in each scope
+	appended map[*ast.File][]byte             // source appended to the output of files, e.g. declarations moved
+	inserted map[*ast.File][]insertion        // source inserted between the declarations of files
+}
+
+// An insertion is the source of declarations inserted after a declaration of a file.
+type insertion struct {
+	after ast.Decl
+	src   []byte
+}
+
+// loadMigration loads the packages matched by patterns, which must type check, for a migration.
+func loadMigration(patterns []string) (*migration, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no packages specified")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	configs, err := newConfigs(wd)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := configs.forDir(wd)
+	if err != nil {
+		return nil, err
+	}
+	if *policyFlag == "skip-file" {
+		return nil, errors.New("-hook-policy skip-file would leave a migration half done, so it can't be used; " +
+			"a failing beforeedit hook aborts the migration under any policy but warn")
+	}
+	if err := applyConfig(conf); err != nil {
+		return nil, err
+	}
+	if err := lockRun(wd); err != nil {
+		return nil, err
+	}
+
+	fSet := token.NewFileSet()
+	cfg := &packages.Config{Mode: egrun.LoadMode, Fset: fSet}
+	pkgs, reqs, err := loadPackages(cfg, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("load: %v", err)
+	}
+	pkgs, skipped, err := dropBroken(fSet, pkgs, patterns)
+	if err != nil {
+		reqs.remove()
+		return nil, err
+	}
+	pkgs = dependencyOrder(pkgs)
+	reportOrder(pkgs)
+	m := &migration{fSet: fSet, pkgs: pkgs, configs: configs, skipped: skipped, reqs: reqs}
+	m.modified = make(map[*ast.File]*packages.Package)
+	return m, nil
+}
+
+// lookup resolves a package-level object, or a method or field of a named type, named by spec in one of the
+// forms path.Name or path.Type.Member. The package must be among those loaded.
+func (m *migration) lookup(spec string) (types.Object, error) {
+	return m.lookupWith(spec, false)
+}
+
+// lookupWith resolves spec as for lookup, in the packages the loaded ones import too if imported is set.
+func (m *migration) lookupWith(spec string, imported bool) (types.Object, error) {
+	find := func(path string) *types.Package {
+		for _, p := range m.pkgs {
+			if p.PkgPath == path {
+				return p.Types
+			}
+		}
+		if imported {
+			return importedPackage(m.pkgs, path)
+		}
+		return nil
+	}
+	path, names, err := splitName(spec, func(path string) bool { return find(path) != nil })
+	if err != nil {
+		return nil, err
+	}
+	pkg := find(path)
+	if pkg == nil {
+		return nil, fmt.Errorf("package %s isn't among the loaded packages", path)
+	}
+	obj := pkg.Scope().Lookup(names[0])
+	if obj == nil && !pkg.Complete() {
+		// the export data of the packages importing an indirect import holds only the objects they refer to
+		if pkg, err = m.loadTypes(path); err != nil {
+			return nil, err
+		}
+		obj = pkg.Scope().Lookup(names[0])
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("%s has no member %s", path, names[0])
+	}
+	if len(names) == 1 {
+		return obj, nil
+	}
+	if _, ok := obj.(*types.TypeName); !ok {
+		return nil, fmt.Errorf("%s.%s isn't a type", path, names[0])
+	}
+	member, _, _ := types.LookupFieldOrMethod(obj.Type(), true, pkg, names[1])
+	if member == nil {
+		return nil, fmt.Errorf("%s.%s has no field or method %s", path, names[0], names[1])
+	}
+	return member, nil
+}
+
+// loadTypes loads the types of the package at path on their own, from its export data.
+func (m *migration) loadTypes(path string) (*types.Package, error) {
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName | packages.NeedTypes, Fset: m.fSet}, path)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %v", path, err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Types == nil || len(pkgs[0].Errors) > 0 {
+		return nil, fmt.Errorf("can't load the types of %s", path)
+	}
+	return pkgs[0].Types, nil
+}
+
+// loaded reports whether the package at path is among those loaded.
+func (m *migration) loaded(path string) bool {
+	for _, p := range m.pkgs {
+		if p.PkgPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+// importedPackage returns the package at path imported, directly or indirectly, by pkgs, or nil if there's none.
+func importedPackage(pkgs []*packages.Package, path string) *types.Package {
+	seen := make(map[*types.Package]bool)
+	var find func([]*types.Package) *types.Package
+	find = func(imports []*types.Package) *types.Package {
+		for _, p := range imports {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			if p.Path() == path {
+				return p
+			}
+			if found := find(p.Imports()); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	for _, p := range pkgs {
+		if found := find(p.Types.Imports()); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// lookupFunc resolves spec as for lookup, requiring it to be a function or method.
+func (m *migration) lookupFunc(spec string) (*types.Func, error) {
+	obj, err := m.lookup(spec)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a function or method", spec)
+	}
+	return fn, nil
+}
+
+// A funcDecl is the declaration of a function in the loaded packages, with the file and package containing it.
+type funcDecl struct {
+	decl *ast.FuncDecl
+	file *ast.File
+	pkg  *packages.Package
+}
+
+// decl returns the declaration of the function fn, with the package and file containing it, or nils if it's not
+// declared in the loaded packages.
+func (m *migration) decl(fn *types.Func) (*ast.FuncDecl, *ast.File, *packages.Package) {
+	d := m.funcDecls()[fn]
+	return d.decl, d.file, d.pkg
+}
+
+// funcDecls returns the declarations of all the functions and methods in the loaded packages.
+func (m *migration) funcDecls() map[*types.Func]funcDecl {
+	if m.decls != nil {
+		return m.decls
+	}
+	m.decls = make(map[*types.Func]funcDecl)
+	for _, pkg := range m.pkgs {
+		for _, file := range pkg.Syntax {
+			for _, d := range file.Decls {
+				if d, ok := d.(*ast.FuncDecl); ok {
+					if fn, ok := pkg.TypesInfo.Defs[d.Name].(*types.Func); ok {
+						m.decls[fn] = funcDecl{d, file, pkg}
+					}
+				}
+			}
+		}
+	}
+	return m.decls
+}
+
+// A use is an identifier referring to an object of interest, with its enclosing nodes, innermost first.
+type use struct {
+	pkg  *packages.Package
+	file *ast.File
+	id   *ast.Ident
+	path []ast.Node
+}
+
+// call returns the call of which the use is the callee, or nil if it's not called directly.
+func (u use) call() *ast.CallExpr {
+	var fun ast.Node = u.id
+	i := 1
+	if sel, ok := u.path[1].(*ast.SelectorExpr); ok && sel.Sel == u.id {
+		fun, i = sel, 2
+	}
+	for ; i < len(u.path); i++ {
+		if _, ok := u.path[i].(*ast.ParenExpr); !ok {
+			break
+		}
+		fun = u.path[i]
+	}
+	if call, ok := u.path[i].(*ast.CallExpr); ok && call.Fun == fun {
+		return call
+	}
+	return nil
+}
+
+// enclosingFunc returns the innermost function declaration or literal containing the use.
+func (u use) enclosingFunc() ast.Node {
+	for _, n := range u.path {
+		switch n.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			return n
+		}
+	}
+	return nil
+}
+
+// uses returns the uses of any of objs in the loaded packages, excluding their declarations, in file order.
+func (m *migration) uses(objs ...types.Object) []use {
+	want := make(map[types.Object]bool)
+	for _, obj := range objs {
+		want[obj] = true
+	}
+	var uses []use
+	for _, pkg := range m.pkgs {
+		for _, file := range pkg.Syntax {
+			var stack []ast.Node
+			ast.Inspect(file, func(n ast.Node) bool {
+				if n == nil {
+					stack = stack[:len(stack)-1]
+					return true
+				}
+				stack = append(stack, n)
+				if id, ok := n.(*ast.Ident); ok && want[pkg.TypesInfo.Uses[id]] {
+					path := make([]ast.Node, len(stack))
+					for i, n := range stack {
+						path[len(stack)-1-i] = n
+					}
+					uses = append(uses, use{pkg: pkg, file: file, id: id, path: path})
+				}
+				return true
+			})
+		}
+	}
+	return uses
+}
+
+// spliceStmt replaces the innermost statement of path which is in a statement list with the result of f on it,
+// and reports whether there was one.
+func spliceStmt(path []ast.Node, f func(stmt ast.Stmt) []ast.Stmt) bool {
+	list, j := enclosingStmt(path)
+	if list == nil {
+		return false
+	}
+	rest := append([]ast.Stmt(nil), (*list)[j+1:]...)
+	*list = append(append((*list)[:j], f((*list)[j])...), rest...)
+	return true
+}
+
+// enclosingStmt returns the statement list containing the innermost statement of path which is in one, with its
+// index, or nil if there's none.
+func enclosingStmt(path []ast.Node) (*[]ast.Stmt, int) {
+	for i, n := range path {
+		stmt, ok := n.(ast.Stmt)
+		if !ok || i+1 == len(path) {
+			continue
+		}
+		list, ok := stmtList(path[i+1])
+		if !ok {
+			continue
+		}
+		for j, s := range *list {
+			if s == stmt {
+				return list, j
+			}
+		}
+	}
+	return nil, 0
+}
+
+// inStmtList reports whether path's first node is a statement in the statement list of its second.
+func inStmtList(path []ast.Node) bool {
+	if len(path) < 2 {
+		return false
+	}
+	list, ok := stmtList(path[1])
+	if !ok {
+		return false
+	}
+	for _, s := range *list {
+		if s == path[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// stmtList returns the statement list of n, if it has one.
+func stmtList(n ast.Node) (*[]ast.Stmt, bool) {
+	switch n := n.(type) {
+	case *ast.BlockStmt:
+		return &n.List, true
+	case *ast.CaseClause:
+		return &n.Body, true
+	case *ast.CommClause:
+		return &n.Body, true
+	}
+	return nil, false
+}
+
+// freshName returns a name based on base which is neither in scope at pos nor declared later in the innermost
+// scope there, by the source or the migration, and records it as declared there.
+func (m *migration) freshName(pkg *packages.Package, pos token.Pos, base string) string {
+	scope := pkg.Types.Scope().Innermost(pos)
+	for i := 0; ; i++ {
+		name := base
+		if i > 0 {
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		if scope == nil {
+			return name
+		}
+		if _, obj := scope.LookupParent(name, pos); obj == nil && scope.Lookup(name) == nil && !m.declared[scope][name] {
+			m.declare(scope, name)
+			return name
+		}
+	}
+}
+
+// errName returns the name of an error variable to declare at pos: err, unless that would conflict with another
+// declaration in the same scope, where redeclare is whether it may redeclare an earlier err.
+func (m *migration) errName(pkg *packages.Package, pos token.Pos, redeclare bool) string {
+	scope := pkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		return "err"
+	}
+	obj := scope.Lookup("err")
+	switch {
+	case obj == nil && !m.declared[scope]["err"]:
+		m.declare(scope, "err")
+		return "err"
+	case redeclare && (obj == nil || obj.Pos() < pos && types.Identical(obj.Type(), errorType)):
+		return "err"
+	}
+	return m.freshName(pkg, pos, "err")
+}
+
+func (m *migration) declare(scope *types.Scope, name string) {
+	if m.declared == nil {
+		m.declared = make(map[*types.Scope]map[string]bool)
+	}
+	if m.declared[scope] == nil {
+		m.declared[scope] = make(map[string]bool)
+	}
+	m.declared[scope][name] = true
+}
+
+// touch records the file as modified.
+func (m *migration) touch(pkg *packages.Package, file *ast.File) {
+	m.modified[file] = pkg
+}
+
+// position returns the position of n, for reporting.
+func (m *migration) position(n ast.Node) token.Position {
+	return m.fSet.Position(n.Pos())
+}
+
+// parseExpr parses src for insertion into file at pos. Package names in src which aren't in scope there are either
+// the last element of one of the import paths given, or are themselves import paths; imports of those packages are
+// added to file.
+func (m *migration) parseExpr(pkg *packages.Package, file *ast.File, pos token.Pos, src string, imports []string) (ast.Expr, error) {
+	e, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", src, err)
+	}
+	scope := pkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		scope = pkg.Types.Scope()
+	}
+	ast.Inspect(e, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if _, obj := scope.LookupParent(id.Name, pos); obj != nil {
+			return true // already imported, or a variable, e.g. r.Context()
+		}
+		path := id.Name
+		for _, imp := range imports {
+			if imp[strings.LastIndex(imp, "/")+1:] == id.Name {
+				path = imp
+			}
+		}
+		m.addImport(pkg, file, path)
+		return true
+	})
+	setPos(e, pos)
+	return e, nil
+}
+
+// parseStmts parses the statements src for insertion at pos. Identifiers named in subst are replaced by the
+// corresponding expressions, which are inserted as they are.
+func parseStmts(src string, pos token.Pos, subst map[string]ast.Expr) ([]ast.Stmt, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p\nfunc _() {\n"+src+"\n}", 0)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", src, err)
+	}
+	body := f.Decls[0].(*ast.FuncDecl).Body
+	setPos(body, pos)
+	astutil.Apply(body, func(c *astutil.Cursor) bool {
+		if id, ok := c.Node().(*ast.Ident); ok {
+			if e, ok := subst[id.Name]; ok {
+				c.Replace(e)
+			}
+		}
+		return true
+	}, nil)
+	return body.List, nil
+}
+
+// qualifier returns a qualifier naming packages as file imports them, adding imports as needed.
+func (m *migration) qualifier(pkg *packages.Package, file *ast.File) types.Qualifier {
+	return func(p *types.Package) string {
+		if p == pkg.Types {
+			return ""
+		}
+		for _, imp := range file.Imports {
+			if path, _ := strconv.Unquote(imp.Path.Value); path == p.Path() {
+				if imp.Name != nil {
+					return imp.Name.Name
+				}
+				return p.Name()
+			}
+		}
+		m.addImport(pkg, file, p.Path())
+		return p.Name()
+	}
+}
+
+// setPos sets every position within n to pos. Syntax parsed separately carries positions meaningless in the file
+// it's inserted into, which confuse the printer; giving it those of the insertion point keeps it on its line.
+func setPos(n ast.Node, pos token.Pos) {
+	posType := reflect.TypeOf(token.NoPos)
+	ast.Inspect(n, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			// positions which are absent are left so, since some, e.g. CallExpr.Ellipsis, mark a token's presence
+			if f := v.Field(i); f.Type() == posType && f.CanSet() && f.Int() != int64(token.NoPos) {
+				f.Set(reflect.ValueOf(pos))
+			}
+		}
+		return true
+	})
+}
+
+// A posRange is the range of positions of some syntax.
+type posRange struct{ pos, end token.Pos }
+
+// joinLines joins the lines of each range in tf, so the printer doesn't leave blank the lines of syntax replaced
+// by syntax occupying only the first.
+func joinLines(tf *token.File, ranges []posRange) {
+	for _, r := range ranges {
+		for first := tf.Line(r.pos); first < tf.Line(r.end); {
+			tf.MergeLine(first)
+		}
+	}
+}
+
+// addImport adds an import of path to file, if it's not already imported, and records file as modified.
+func (m *migration) addImport(pkg *packages.Package, file *ast.File, path string) {
+	if path == pkg.PkgPath {
+		return
+	}
+	for _, imp := range file.Imports {
+		if p, _ := strconv.Unquote(imp.Path.Value); p == path {
+			return
+		}
+	}
+	astutil.AddImport(m.fSet, file, path)
+	m.touch(pkg, file)
+}
+
+// stdCall returns a call of the function name of the standard library package at path, which has no other
+// elements, with args, for insertion into file at pos, adding an import of the package if need be. It's an error if
+// the package's name refers to something else there.
+func (m *migration) stdCall(pkg *packages.Package, file *ast.File, pos token.Pos, path, name string, args ...ast.Expr) (*ast.CallExpr, error) {
+	fun, err := m.stdName(pkg, file, pos, path, name)
+	if err != nil {
+		return nil, err
+	}
+	end := pos
+	if len(args) > 0 {
+		end = args[len(args)-1].End()
+	}
+	return &ast.CallExpr{Fun: fun, Lparen: pos, Args: args, Rparen: end}, nil
+}
+
+// stdName returns a reference to the member name of the standard library package at path, as for stdCall.
+func (m *migration) stdName(pkg *packages.Package, file *ast.File, pos token.Pos, path, name string) (*ast.SelectorExpr, error) {
+	scope := pkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		scope = pkg.Types.Scope()
+	}
+	if _, obj := scope.LookupParent(path, pos); obj != nil {
+		if pn, ok := obj.(*types.PkgName); !ok || pn.Imported().Path() != path {
+			return nil, fmt.Errorf("%s refers to the %s declared at %s", path, obj.Name(), m.fSet.Position(obj.Pos()))
+		}
+	} else {
+		m.addImport(pkg, file, path)
+	}
+	return &ast.SelectorExpr{X: &ast.Ident{NamePos: pos, Name: path}, Sel: &ast.Ident{NamePos: pos, Name: name}}, nil
+}
+
+// emit outputs every modified file, in file name order. The rewrite touches declarations and their uses across
+// files, so every file is formatted before any is output, and with -w, they're written together by writeStaged, or
+// if failed, since some part of the rewrite couldn't be done, not at all, so the module isn't left half migrated.
+// For the same reason, it's an error, before any is output, for the config to exclude any of them.
+func (m *migration) emit(failed bool) error {
+	byName := make(map[string]*ast.File)
+	var names []string
+	for file := range m.modified {
+		name := m.fSet.File(file.Pos()).Name()
+		byName[name] = file
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var excluded []string
+	for _, name := range names {
+		conf, err := m.configs.forDir(filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		if conf.excluded(name) {
+			excluded = append(excluded, name)
+		}
+	}
+	if len(excluded) > 0 {
+		return fmt.Errorf("the rewrite must change files the config excludes, which would be left broken: %s; no files were written",
+			strings.Join(excluded, ", "))
+	}
+
+	var staged []stagedFile
+	for _, name := range names {
+		fSet, file := m.fSet, byName[name]
+		if m.appended[file] != nil || m.inserted[file] != nil {
+			var err error
+			if fSet, file, err = m.withAdded(file); err != nil {
+				return fmt.Errorf("%s: %v; no files were written", name, err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := format.Node(&buf, fSet, file); err != nil {
+			return fmt.Errorf("%s: %v; no files were written", name, err)
+		}
+		staged = append(staged, stagedFile{name, buf.Bytes(), editInfo{pkg: m.modified[byName[name]].PkgPath}})
+	}
+	// the module only needs the template's imports if it was applied
+	defer m.reqs.remove()
+	if m.reqs != nil && len(staged) > 0 {
+		mod, err := m.reqs.staged()
+		if err != nil {
+			return err
+		}
+		staged = append(staged, mod...)
+	}
+
+	if *writeFlag {
+		if failed {
+			fmt.Fprintln(os.Stderr, "eg: some of the rewrite couldn't be done, so no files were written")
+			return nil
+		}
+		if err := writeStaged(staged); err != nil {
+			return err
+		}
+	} else {
+		var hadErrors bool
+		for _, f := range staged {
+			fmt.Fprintf(os.Stderr, "=== %s\n", f.name)
+			if err := emitSource(f.name, f.src, f.info); err != nil {
+				fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+				hadErrors = true
+			}
+		}
+		if hadErrors {
+			return errors.New("some files couldn't be written")
+		}
+	}
+	if checkFlag && !failed && len(staged) > 0 {
+		return m.checkStaged(staged)
+	}
+	return nil
+}
+
+// checkStaged reloads the packages of the modules containing the files staged, with their rewritten source whether
+// or not it was written, and reports their errors: references the rewrite left broken, such as callers of a
+// function whose signature changed in packages that weren't loaded. The files skipped for errors aren't checked.
+// Each error is reported once, by its position: the go command's output compiling a package with type errors, which
+// repeats them, is left out, as are those of its test variant.
+func (m *migration) checkStaged(staged []stagedFile) error {
+	overlay := make(map[string][]byte)
+	isRoot := make(map[string]bool)
+	var roots []string
+	var buildFlags []string
+	for _, f := range staged {
+		// the go command reads go.mod and go.sum from disk, rather than the overlay
+		if base := filepath.Base(f.name); base == "go.mod" || base == "go.sum" {
+			buildFlags = []string{"-modfile=" + m.reqs.modFile}
+			continue
+		}
+		overlay[f.name] = f.src
+		if root := moduleRoot(filepath.Dir(f.name)); root != "" && !isRoot[root] {
+			isRoot[root] = true
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+
+	reported := make(map[string]bool)
+	for _, root := range roots {
+		cfg := &packages.Config{Mode: egrun.LoadMode, Dir: root, Overlay: overlay, Tests: true,
+			BuildFlags: buildFlags, ParseFile: conflicts.ParseFile}
+		pkgs, err := packages.Load(cfg, "./...")
+		if err != nil {
+			return fmt.Errorf("checking %s: %v", root, err)
+		}
+		conflicts.DropErrors(pkgs, root)
+		for _, pkg := range pkgs {
+			for _, e := range loadErrors(pkg) {
+				key := e.Pos
+				if key == "" {
+					key = e.Error()
+				}
+				if reported[key] || m.skipped[errorFile(e)].pkg != nil {
+					continue
+				}
+				if e.Pos == "" {
+					fmt.Fprintf(os.Stderr, "eg: %s: left broken: %s\n", pkg.PkgPath, e.Msg)
+				} else {
+					fmt.Fprintf(os.Stderr, "%s: left broken: %s\n", e.Pos, e.Msg)
+				}
+				reported[key] = true
+			}
+		}
+	}
+	if len(reported) > 0 {
+		return fmt.Errorf("the rewrite left %d errors in its modules, which need updating by hand", len(reported))
+	}
+	return nil
+}
+
+// A stagedFile is the rewritten source of a file, yet to be written.
+type stagedFile struct {
+	name string
+	src  []byte
+	info editInfo
+}
+
+// writeStaged writes the files staged in place, running the edit hooks. Each is written to a temporary file beside
+// it first, and only once all of them have been are they renamed over the originals, so an error writing one
+// leaves every file as it was, as does a failing beforeedit hook, unless -hook-policy is warn: a migration is
+// written whole or not at all. Should renaming one fail, the files renamed before it are restored.
+func writeStaged(staged []stagedFile) error {
+	for _, f := range staged {
+		fmt.Fprintf(os.Stderr, "=== %s\n", f.name)
+		if err := runBeforeHooks(f.name, f.info); err != nil {
+			return fmt.Errorf("%v; no files were written", err)
+		}
+	}
+
+	tmps := make([]string, 0, len(staged))
+	origs := make([][]byte, 0, len(staged))
+	removeTmps := func() {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}
+	for _, f := range staged {
+		orig, err := ioutil.ReadFile(f.name)
+		if err != nil {
+			removeTmps()
+			return fmt.Errorf("%s: %v; no files were written", f.name, err)
+		}
+		tmp, err := writeTemp(f)
+		if err != nil {
+			removeTmps()
+			return fmt.Errorf("%s: %v; no files were written", f.name, err)
+		}
+		tmps, origs = append(tmps, tmp), append(origs, orig)
+	}
+	for i, f := range staged {
+		if err := os.Rename(tmps[i], f.name); err != nil {
+			tmps = tmps[i:]
+			removeTmps()
+			if rerr := restoreStaged(staged[:i], origs); rerr != nil {
+				return fmt.Errorf("%s: %v; the files before it were written, and restoring them failed: %v", f.name, err, rerr)
+			}
+			return fmt.Errorf("%s: %v; no files were written", f.name, err)
+		}
+	}
+	for _, f := range staged {
+		editedFiles = append(editedFiles, f.name)
+	}
+
+	for _, f := range staged {
+		for _, hook := range afterEditFlags {
+			if err := runCmdOnFile(hook, f.name, f.info); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: after edit hook %q failed (%s)\n", hook, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreStaged writes the original sources, origs, of the files staged back over them, as writeStaged writes
+// them, returning the first failure.
+func restoreStaged(staged []stagedFile, origs [][]byte) error {
+	var first error
+	for i, f := range staged {
+		tmp, err := writeTemp(stagedFile{name: f.name, src: origs[i]})
+		if err == nil {
+			if err = os.Rename(tmp, f.name); err != nil {
+				os.Remove(tmp)
+			}
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("%s: %v", f.name, err)
+		}
+	}
+	return first
+}
+
+// writeTemp writes the source of f to a temporary file in its directory, with its mode, returning its name.
+func writeTemp(f stagedFile) (string, error) {
+	info, err := os.Stat(f.name)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.name), "."+filepath.Base(f.name)+".eg")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(f.src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// appendDecls appends the source of declarations to the output of file.
+func (m *migration) appendDecls(pkg *packages.Package, file *ast.File, src []byte) {
+	if m.appended == nil {
+		m.appended = make(map[*ast.File][]byte)
+	}
+	m.appended[file] = append(append(m.appended[file], '\n'), src...)
+	m.touch(pkg, file)
+}
+
+// insertDecls inserts the source of declarations after the declaration after in the output of file.
+func (m *migration) insertDecls(pkg *packages.Package, file *ast.File, after ast.Decl, src []byte) {
+	if m.inserted == nil {
+		m.inserted = make(map[*ast.File][]insertion)
+	}
+	m.inserted[file] = append(m.inserted[file], insertion{after, src})
+	m.touch(pkg, file)
+}
+
+// withAdded returns file with the source inserted into and appended to it, reparsed in a file set of its own.
+func (m *migration) withAdded(file *ast.File) (*token.FileSet, *ast.File, error) {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, m.fSet, file); err != nil {
+		return nil, nil, err
+	}
+	name := m.fSet.File(file.Pos()).Name()
+	out := buf.Bytes()
+	if ins := m.inserted[file]; len(ins) > 0 {
+		// the declarations are printed in order, so the ith of the reparsed output is the ith of file
+		fSet := token.NewFileSet()
+		f, err := parser.ParseFile(fSet, name, out, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		ends := make(map[ast.Decl]int) // the offset of the end of the line each declaration ends on
+		for i, d := range file.Decls {
+			end := fSet.Position(f.Decls[i].End()).Offset
+			if nl := bytes.IndexByte(out[end:], '\n'); nl >= 0 {
+				end += nl
+			}
+			ends[d] = end
+		}
+		// the later insertions are made first, so the offsets of earlier ones stay valid
+		sort.SliceStable(ins, func(i, j int) bool { return ends[ins[i].after] > ends[ins[j].after] })
+		for i := 0; i < len(ins); {
+			j, end := i, ends[ins[i].after]
+			var src []byte
+			for ; j < len(ins) && ends[ins[j].after] == end; j++ {
+				src = append(append(src, "\n\n"...), bytes.TrimSpace(ins[j].src)...)
+			}
+			out = append(append(append([]byte(nil), out[:end]...), src...), out[end:]...)
+			i = j
+		}
+	}
+	out = append(out, m.appended[file]...)
+	fSet := token.NewFileSet()
+	f, err := parser.ParseFile(fSet, name, out, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fSet, f, nil
+}
+
+// A sigDecl is the syntax declaring the signature of a function or method: its declaration, or, for an interface
+// method, its entry in the interface type, in which case decl is nil.
+type sigDecl struct {
+	fn   *types.Func
+	typ  *ast.FuncType
+	decl *ast.FuncDecl
+	file *ast.File
+	pkg  *packages.Package
+}
+
+// sigDecls returns the declarations of the signature of fn and, if it's an interface method, of the methods
+// implementing it in the loaded packages, whose signatures must change with it. The named types outside the loaded
+// packages which implement the interface are returned too.
+func (m *migration) sigDecls(fn *types.Func) ([]sigDecl, []*types.TypeName, error) {
+	if decl, file, pkg := m.decl(fn); decl != nil {
+		return []sigDecl{{fn, decl.Type, decl, file, pkg}}, nil, nil
+	}
+	iface, ok := fn.Type().(*types.Signature).Recv().Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil, nil, fmt.Errorf("the declaration of %s isn't among the loaded packages", fn.FullName())
+	}
+	var sigs []sigDecl
+	for _, pkg := range m.pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				if f, ok := n.(*ast.Field); ok && len(f.Names) == 1 && pkg.TypesInfo.Defs[f.Names[0]] == fn {
+					sigs = append(sigs, sigDecl{fn, f.Type.(*ast.FuncType), nil, file, pkg})
+				}
+				return true
+			})
+		}
+	}
+	if len(sigs) == 0 {
+		return nil, nil, fmt.Errorf("the declaration of %s isn't among the loaded packages", fn.FullName())
+	}
+	impls, outside := m.implementations(iface, fn)
+	for _, impl := range impls {
+		decl, file, pkg := m.decl(impl)
+		sigs = append(sigs, sigDecl{impl, decl.Type, decl, file, pkg})
+	}
+	return sigs, outside, nil
+}
+
+// implementations returns the methods named like fn of the named types which implement iface: those declared in
+// the loaded packages, and the types whose methods aren't. Types declared within functions aren't found.
+func (m *migration) implementations(iface *types.Interface, fn *types.Func) ([]*types.Func, []*types.TypeName) {
+	var impls []*types.Func
+	var outside []*types.TypeName
+	seen := make(map[*types.Func]bool)
+	packages.Visit(m.pkgs, nil, func(pkg *packages.Package) {
+		if pkg.Types == nil {
+			return
+		}
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || tn.IsAlias() || types.IsInterface(tn.Type()) {
+				continue
+			}
+			ptr := types.NewPointer(tn.Type())
+			if !types.Implements(ptr, iface) {
+				continue
+			}
+			method, ok := methodOf(ptr, fn)
+			if !ok || method == fn || seen[method] {
+				continue // promoted from the interface itself, embedded
+			}
+			seen[method] = true
+			if decl, _, _ := m.decl(method); decl != nil {
+				impls = append(impls, method)
+			} else {
+				outside = append(outside, tn)
+			}
+		}
+	})
+	sort.Slice(impls, func(i, j int) bool { return impls[i].Pos() < impls[j].Pos() })
+	return impls, outside
+}
+
+// methodOf returns the method of t with the name of fn.
+func methodOf(t types.Type, fn *types.Func) (*types.Func, bool) {
+	obj, _, _ := types.LookupFieldOrMethod(t, false, fn.Pkg(), fn.Name())
+	method, ok := obj.(*types.Func)
+	return method, ok
+}
+
+// reportOutside reports the types outside the loaded packages which implement the interface method fn, and so
+// won't once it's changed, and returns whether there were any.
+func (m *migration) reportOutside(outside []*types.TypeName, fn *types.Func) bool {
+	for _, tn := range outside {
+		fmt.Fprintf(os.Stderr, "%s: %s implements %s outside the loaded packages; update it manually\n",
+			m.fSet.Position(tn.Pos()), tn.Type(), fn.FullName())
+	}
+	return len(outside) > 0
+}
+
+// loadWithTemplate loads the template file at tmplPath along with the packages matched by patterns, as for
+// loadMigration, returning the template's package separately from the migration's.
+func loadWithTemplate(tmplPath string, patterns []string) (*migration, *packages.Package, *ast.File, error) {
+	if len(patterns) == 0 {
+		return nil, nil, nil, errors.New("no packages specified")
+	}
+	tmplPath, err := filepath.Abs(tmplPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	m, err := loadMigration(append([]string{"file=" + tmplPath}, patterns...))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i, pkg := range m.pkgs {
+		if tmplFile := findFile(m.fSet, pkg, tmplPath); tmplFile != nil {
+			m.pkgs = append(m.pkgs[:i], m.pkgs[i+1:]...)
+			return m, pkg, tmplFile, nil
+		}
+	}
+	return nil, nil, nil, templateNotFound(tmplPath)
+}
+
+// instantiate returns a copy of the template code n, from the template package tmpl, for insertion into file at
+// pos: identifiers named in subst are replaced by the corresponding expressions, and package names qualified as
+// file imports them, adding imports as needed. n must be an expression or a statement.
+func (m *migration) instantiate(tmpl *packages.Package, n ast.Node, pkg *packages.Package, file *ast.File, pos token.Pos,
+	subst map[string]ast.Expr) (ast.Node, error) {
+	// the template's package names, renamed to those of file, become placeholders in subst
+	env := make(map[string]ast.Expr)
+	for name, e := range subst {
+		env[name] = e
+	}
+	// those of pkg itself are dropped, leaving the names they qualify unqualified
+	local := make(map[string]bool)
+	qual := m.qualifier(pkg, file)
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if pn, ok := tmpl.TypesInfo.Uses[id].(*types.PkgName); ok {
+				if name := qual(pn.Imported()); name != "" {
+					env[id.Name] = &ast.Ident{NamePos: pos, Name: name}
+				} else {
+					local[id.Name] = true
+				}
+			}
+		}
+		return true
+	})
+
+	src := nodeString(m.fSet, n)
+	var copied ast.Node
+	if _, ok := n.(ast.Expr); ok {
+		e, err := parser.ParseExpr(src)
+		if err != nil {
+			return nil, err
+		}
+		setPos(e, pos)
+		copied = e
+	} else {
+		stmts, err := parseStmts(src, pos, nil)
+		if err != nil {
+			return nil, err
+		}
+		copied = stmts[0]
+	}
+	// selected names and keys of composite literals needn't be identifiers in scope, so aren't replaced
+	return astutil.Apply(copied, func(c *astutil.Cursor) bool {
+		if sel, ok := c.Node().(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && local[x.Name] {
+				c.Replace(sel.Sel)
+				return false
+			}
+		}
+		switch parent := c.Parent().(type) {
+		case *ast.SelectorExpr:
+			if c.Name() == "Sel" {
+				return false
+			}
+		case *ast.KeyValueExpr:
+			if _, ok := parent.Key.(*ast.Ident); ok && c.Name() == "Key" {
+				return false
+			}
+		}
+		if id, ok := c.Node().(*ast.Ident); ok {
+			if e, ok := env[id.Name]; ok {
+				c.Replace(e)
+			}
+		}
+		return true
+	}, nil), nil
+}
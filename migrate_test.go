@@ -0,0 +1,382 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateFlag = flag.Bool("update", false, "rewrite the golden files of the subcommands' cases with their output")
+
+// runCommandCases runs the subcommand cmd on each case of testdata/cmd: a directory holding a module, with the file
+// args holding the subcommand's arguments, and files name.golden holding what the run leaves in name; the other files
+// must be left unchanged. The files stdout.golden and stderr hold what the run must print to standard output, and the
+// lines it must print, among others, to standard error, and error, the error it must fail with; without one, it must
+// succeed. Where the output is too long to hold in full, stdout holds the lines it must print among others instead of
+// stdout.golden. The paths printed are relative to the module, whose root is printed as ".". The file env, if any,
+// holds lines KEY=value of the environment to run in, with $WORK the copy of the case, and dir the directory of the
+// case to run in, rather than its root.
+func runCommandCases(t *testing.T, cmd string) {
+	runTestdataCases(t, cmd, func(args []string, stdin []byte) (string, string, error) {
+		return captureOutput(t, func() error {
+			defer endRun()
+			return subcommands[cmd](args)
+		})
+	})
+}
+
+// runMainCases runs the eg command itself, rather than a subcommand, on each case of testdata/name, as
+// runCommandCases does, in a process of its own, since its flags and its run's state are global. The file stdin, if
+// any, is its standard input; the error it fails with is what it prints to standard error.
+func runMainCases(t *testing.T, name string) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestdataCases(t, name, func(args []string, stdin []byte) (string, string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(exe, args...)
+		cmd.Env = append(os.Environ(), mainEnv+"=1")
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(stdin), &stdout, &stderr
+		err := cmd.Run()
+		if _, ok := err.(*exec.ExitError); ok {
+			err = errors.New(strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), stderr.String(), err
+	})
+}
+
+// mainEnv is set in the environment of the test binary run by runMainCases to have it run as the eg command.
+const mainEnv = "EG_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(runWithProxy(m))
+}
+
+// runWithProxy runs the tests with the go command fetching modules from the module proxy of testdata/proxy, into a
+// module cache of their own, so that the cases may require modules without the network, and with its default flags.
+func runWithProxy(m *testing.M) int {
+	tmp, err := ioutil.TempDir("", "eg-proxy")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(tmp)
+	proxy := filepath.Join(tmp, "proxy")
+	if err := writeProxy(proxy, filepath.Join("testdata", "proxy")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for name, val := range map[string]string{
+		"GOPROXY":    "file://" + filepath.ToSlash(proxy),
+		"GOSUMDB":    "off",
+		"GOMODCACHE": filepath.Join(tmp, "mod"),
+		"GOFLAGS":    "-modcacherw", // so the cache can be removed, and without a -mod=mod adding requirements itself
+	} {
+		os.Setenv(name, val)
+	}
+	return m.Run()
+}
+
+// writeProxy writes the modules of src, each a directory named path@version holding the module's files, to dir, as
+// a module proxy serves them.
+func writeProxy(dir, src string) error {
+	mods, err := filepath.Glob(filepath.Join(src, "*", "*@*"))
+	if err != nil {
+		return err
+	}
+	for _, mod := range mods {
+		rel, err := filepath.Rel(src, mod)
+		if err != nil {
+			return err
+		}
+		at := strings.LastIndex(rel, "@")
+		path, version := filepath.ToSlash(rel[:at]), rel[at+1:]
+		vdir := filepath.Join(dir, filepath.FromSlash(path), "@v")
+		if err := os.MkdirAll(vdir, 0777); err != nil {
+			return err
+		}
+
+		var zipped bytes.Buffer
+		zw := zip.NewWriter(&zipped)
+		err = filepath.Walk(mod, func(name string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(mod, name)
+			if err != nil {
+				return err
+			}
+			src, err := ioutil.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			w, err := zw.Create(path + "@" + version + "/" + filepath.ToSlash(rel))
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(src)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		gomod, err := ioutil.ReadFile(filepath.Join(mod, "go.mod"))
+		if err != nil {
+			return err
+		}
+		info := fmt.Sprintf(`{"Version":%q,"Time":"2020-01-01T00:00:00Z"}`, version)
+		for ext, data := range map[string][]byte{".info": []byte(info), ".mod": gomod, ".zip": zipped.Bytes()} {
+			if err := ioutil.WriteFile(filepath.Join(vdir, version+ext), data, 0666); err != nil {
+				return err
+			}
+		}
+		list, err := os.OpenFile(filepath.Join(vdir, "list"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(list, version)
+		if err := list.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runTestdataCases(t *testing.T, name string, run func(args []string, stdin []byte) (string, string, error)) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", name, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatalf("no cases in testdata/%s", name)
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			runTestdataCase(t, name, dir, run)
+		})
+	}
+}
+
+// caseFiles are the files of a case which aren't its module's.
+var caseFiles = map[string]bool{"args": true, "dir": true, "env": true, "error": true, "stderr": true, "stdin": true,
+	"stdout": true, "stdout.golden": true}
+
+func runTestdataCase(t *testing.T, name, dir string, run func(args []string, stdin []byte) (string, string, error)) {
+	argsSrc, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := splitCommand(strings.TrimSpace(string(argsSrc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := ioutil.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "eg-"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := make(map[string]string)
+	golden := make(map[string]string)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || caseFiles[rel] {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(rel, ".golden") {
+			golden[strings.TrimSuffix(rel, ".golden")] = string(src)
+			return nil
+		}
+		input[rel] = string(src)
+		if err := os.MkdirAll(filepath.Join(tmp, filepath.Dir(rel)), 0777); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(tmp, rel), src, 0666)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := ioutil.ReadFile(filepath.Join(dir, "env"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(env)), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			t.Setenv(k, strings.Replace(v, "$WORK", tmp, -1))
+		}
+	}
+	runDir, err := ioutil.ReadFile(filepath.Join(dir, "dir"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(tmp, filepath.FromSlash(strings.TrimSpace(string(runDir))))); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, runErr := run(args, stdin)
+	if err := os.Chdir(wd); err != nil {
+		t.Fatal(err)
+	}
+	// the module's root itself is printed as .
+	for _, out := range []*string{&stdout, &stderr} {
+		*out = strings.Replace(*out, tmp+string(filepath.Separator), "", -1)
+		*out = strings.Replace(*out, tmp, ".", -1)
+	}
+
+	wantErr, err := ioutil.ReadFile(filepath.Join(dir, "error"))
+	switch {
+	case err == nil && runErr == nil:
+		t.Errorf("the run succeeded, but should fail with %q", strings.TrimSpace(string(wantErr)))
+	case err == nil:
+		msg := strings.Replace(runErr.Error(), tmp+string(filepath.Separator), "", -1)
+		if !strings.Contains(msg, strings.TrimSpace(string(wantErr))) {
+			t.Errorf("the run failed with %q, want %q", msg, strings.TrimSpace(string(wantErr)))
+		}
+	case os.IsNotExist(err) && runErr != nil:
+		t.Errorf("the run failed: %v\n%s", runErr, stderr)
+	}
+	checkLines(t, filepath.Join(dir, "stderr"), "standard error", stderr)
+	if _, err := os.Stat(filepath.Join(dir, "stdout")); err == nil {
+		checkLines(t, filepath.Join(dir, "stdout"), "standard output", stdout)
+	} else {
+		checkGolden(t, filepath.Join(dir, "stdout.golden"), stdout, true)
+	}
+
+	var names []string
+	for name := range input {
+		names = append(names, name)
+	}
+	for name := range golden {
+		if _, ok := input[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		got, err := ioutil.ReadFile(filepath.Join(tmp, name))
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if _, ok := golden[name]; ok {
+			if os.IsNotExist(err) {
+				t.Errorf("%s wasn't written", name)
+			} else {
+				checkGolden(t, filepath.Join(dir, name+".golden"), string(got), false)
+			}
+		} else if string(got) != input[name] {
+			if *updateFlag {
+				checkGolden(t, filepath.Join(dir, name+".golden"), string(got), false)
+				continue
+			}
+			t.Errorf("%s was changed:\n%s", name, got)
+		}
+	}
+}
+
+// checkLines checks that each line of the file want, if it exists, is among those printed to the output named.
+func checkLines(t *testing.T, want, output, printed string) {
+	t.Helper()
+	lines, err := ioutil.ReadFile(want)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(lines), "\n"), "\n") {
+		if !strings.Contains(printed, line) {
+			t.Errorf("the run didn't print %q to %s, but\n%s", line, output, printed)
+		}
+	}
+}
+
+// checkGolden checks got against the golden file, rewriting it with -update. If optional, an absent golden file
+// wants nothing.
+func checkGolden(t *testing.T, golden, got string, optional bool) {
+	t.Helper()
+	want, err := ioutil.ReadFile(golden)
+	if os.IsNotExist(err) && optional && !*updateFlag {
+		if got != "" {
+			t.Errorf("printed, without %s,\n%s", filepath.Base(golden), got)
+		}
+		return
+	}
+	if err != nil && !(os.IsNotExist(err) && *updateFlag) {
+		t.Fatal(err)
+	}
+	if got == string(want) {
+		return
+	}
+	if *updateFlag && (got != "" || !optional) {
+		if err := ioutil.WriteFile(golden, []byte(got), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	t.Errorf("%s: got\n%s\nwant\n%s", filepath.Base(golden), got, want)
+}
+
+// captureOutput returns what fn prints to standard output and standard error, and its error.
+func captureOutput(t *testing.T, fn func() error) (string, string, error) {
+	t.Helper()
+	var files [2]*os.File
+	for i := range files {
+		f, err := ioutil.TempFile("", "eg-output")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		files[i] = f
+	}
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = files[0], files[1]
+	err := fn()
+	os.Stdout, os.Stderr = savedStdout, savedStderr
+
+	var out [2]string
+	for i, f := range files {
+		data, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = string(data)
+	}
+	return out[0], out[1], err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// moduleDir is the directory the packages are loaded from: the root of the module being rewritten, when the
+// arguments span several, or "" for the working directory.
+var moduleDir string
+
+// A moduleGroup is the arguments naming packages of a module, which are loaded together.
+type moduleGroup struct {
+	root string // of the module, or "" for that of the working directory
+	args []string
+}
+
+// moduleGroups groups args by the modules enclosing the directories they name, in the order of their first
+// arguments, since a single load can't span modules without a workspace. Those which aren't directories, such as
+// import paths, are of the working directory's module. Arguments within it alone are its group, unchanged; others
+// are made absolute, to be loaded from their module's root.
+func moduleGroups(wd string, args []string) []moduleGroup {
+	wdRoot := moduleRoot(wd)
+	var groups []moduleGroup
+	index := make(map[string]int)
+	for _, arg := range args {
+		root, abs := wdRoot, arg
+		if isDirPattern(arg) {
+			dir := strings.TrimSuffix(strings.TrimSuffix(filepath.ToSlash(arg), "..."), "/")
+			if dir == "" && !strings.HasPrefix(arg, "/") {
+				dir = "."
+			}
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(wd, dir)
+			}
+			if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+				dir = filepath.Dir(dir) // a .go file
+			}
+			if r := moduleRoot(dir); r != "" {
+				root = r
+			}
+			abs = filepath.Join(wd, arg)
+			if filepath.IsAbs(arg) {
+				abs = arg
+			}
+		}
+		i, ok := index[root]
+		if !ok {
+			i = len(groups)
+			index[root] = i
+			groups = append(groups, moduleGroup{root: root})
+		}
+		groups[i].args = append(groups[i].args, abs)
+	}
+	if len(groups) == 1 && groups[0].root == wdRoot {
+		return []moduleGroup{{args: args}}
+	}
+	return groups
+}
+
+// isDirPattern reports whether the package pattern arg names a directory, or a file, rather than import paths.
+func isDirPattern(arg string) bool {
+	return arg == "." || arg == ".." || filepath.IsAbs(arg) ||
+		strings.HasPrefix(arg, "./") || strings.HasPrefix(arg, "../") ||
+		strings.HasPrefix(arg, "."+string(filepath.Separator)) || strings.HasPrefix(arg, ".."+string(filepath.Separator))
+}
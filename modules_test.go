@@ -0,0 +1,8 @@
+package main
+
+import "testing"
+
+// TestModules rewrites directory arguments in several modules, each loaded from its root under its own config.
+func TestModules(t *testing.T) {
+	runMainCases(t, "modules")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParallel checks that the files are transformed by several workers just as by one: printed in the same order,
+// and written the same.
+func TestParallel(t *testing.T) {
+	_, orig := runParallel(t, 1, nil, false)
+	for _, templates := range [][]string{
+		{"templates/empty/empty.go"},
+		{"templates/empty/empty.go", "templates/print/print.go"},
+	} {
+		out1, written1 := runParallel(t, 1, templates, false)
+		out8, written8 := runParallel(t, 8, templates, false)
+		if out1 == "" {
+			t.Fatalf("%v: nothing was printed", templates)
+		}
+		if out1 != out8 {
+			t.Errorf("%v: -parallel 8 printed\n%s\nbut -parallel 1 printed\n%s", templates, out8, out1)
+		}
+		if !equalFiles(written1, orig) || !equalFiles(written8, orig) {
+			t.Errorf("%v: the files were changed, though nothing was to be written", templates)
+		}
+
+		_, written1 = runParallel(t, 1, templates, true)
+		_, written8 = runParallel(t, 8, templates, true)
+		if !equalFiles(written1, written8) {
+			t.Errorf("%v: -parallel 8 wrote\n%v\nbut -parallel 1 wrote\n%v", templates, written8, written1)
+		}
+		if equalFiles(written1, orig) {
+			t.Errorf("%v: nothing was written", templates)
+		}
+	}
+}
+
+// runParallel applies the templates, if any, to a copy of testdata/parallel with workers workers, writing the files
+// if write, and returns what's printed and the files afterwards, by their paths in the copy.
+func runParallel(t *testing.T, workers int, templates []string, write bool) (string, map[string]string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "eg-parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := copyDir(dir, filepath.Join("testdata", "parallel")); err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) > 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chdir(wd)
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		savedWorkers, savedWrite, savedStdout := *parallelFlag, *writeFlag, os.Stdout
+		defer func() { *parallelFlag, *writeFlag, os.Stdout = savedWorkers, savedWrite, savedStdout }()
+		*parallelFlag, *writeFlag = workers, write
+		out, err := os.Create(filepath.Join(dir, "stdout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = out
+		defer out.Close()
+
+		configs, err := newConfigs(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tmplPaths []string
+		for _, tmpl := range templates {
+			tmplPaths = append(tmplPaths, filepath.Join(dir, tmpl))
+		}
+		if err := applyTemplates(tmplPaths, []string{"./..."}, configs, platform{}, make(map[string]bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := make(map[string]string)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files[rel] = string(src)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := files["stdout"]
+	delete(files, "stdout")
+	return out, files
+}
+
+// copyDir copies the files of the directory from, recursively, to to.
+func copyDir(to, from string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(to, rel), 0777)
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(to, rel), src, 0666)
+	})
+}
+
+func equalFiles(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, src := range a {
+		if b[name] != src {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// splitUnits are the valid values of -split-by.
+var splitUnits = []string{"pkg", "template", "dir"}
+
+func validSplit(unit string) bool {
+	for _, u := range splitUnits {
+		if u == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// patchRoot is the directory the paths of the patches are relative to, as for patch -p1 or git apply run there.
+var patchRoot string
+
+// patchPath returns the path of filename in a patch: relative to the patchRoot, if it's within it, with slashes.
+func patchPath(filename string) string {
+	if r, err := filepath.Rel(patchRoot, filename); err == nil && !strings.HasPrefix(r, "..") {
+		filename = r
+	}
+	return filepath.ToSlash(filename)
+}
+
+// A patchUnit is the patch of the files of a unit of -split-by: a package, a template or a directory.
+type patchUnit struct {
+	Patch   string   `json:"patch"` // the name of the patch file
+	Unit    string   `json:"unit"`
+	Files   []string `json:"files"`
+	Matches int      `json:"matches"`
+
+	buf bytes.Buffer
+}
+
+// patchUnits are the patches of the run's units, by unit, when -split-by is given.
+var patchUnits = make(map[string]*patchUnit)
+
+// addPatch adds the patch of filename, rewritten for info, to that of its unit of -split-by. It fails for a Go file
+// whose package isn't known, rather than splitting it by dir.
+func addPatch(filename string, patch []byte, info editInfo) error {
+	var unit string
+	switch *splitByFlag {
+	case "pkg":
+		unit = info.pkg
+		if unit == "" && strings.HasSuffix(filename, ".go") {
+			return fmt.Errorf("%s: -split-by pkg: the file's package isn't known", filename)
+		}
+	case "template":
+		// relative to the working directory, as the files are, unless it's labeled, e.g. for an -e snippet
+		if unit = templateLabel(info.template); unit == info.template {
+			unit = patchPath(unit)
+		}
+	}
+	if unit == "" { // by dir, or for a file outside any package, e.g. go.mod
+		unit = path.Dir(patchPath(filename))
+	}
+	u := patchUnits[unit]
+	if u == nil {
+		u = &patchUnit{Unit: unit}
+		patchUnits[unit] = u
+	}
+	u.Files = append(u.Files, patchPath(filename))
+	u.Matches += info.matches
+	u.buf.Write(patch)
+	return nil
+}
+
+// writePatches writes the patch of each unit of -split-by to a file in dir, numbered in the order of the units'
+// names, with a manifest.json listing them, their files, and their matches.
+func writePatches(dir string) error {
+	if len(patchUnits) == 0 {
+		fmt.Fprintln(os.Stderr, "eg: nothing to patch")
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	units := make([]*patchUnit, 0, len(patchUnits))
+	for _, u := range patchUnits {
+		units = append(units, u)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].Unit < units[j].Unit })
+	for i, u := range units {
+		u.Patch = fmt.Sprintf("%04d-%s.patch", i+1, patchName(u.Unit))
+		if err := ioutil.WriteFile(filepath.Join(dir, u.Patch), u.buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(units); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "manifest.json"), out.Bytes(), 0666); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "eg: wrote %d patches, by %s, to %s\n", len(units), *splitByFlag, dir)
+	return nil
+}
+
+// patchName returns unit as it's named in the name of its patch file: its runs of characters other than letters,
+// digits and dots replaced by "-", and cut short if it's long, as an -e snippet may be.
+func patchName(unit string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range unit {
+		if r < 0x80 && (r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.Trim(b.String(), "-.")
+	if len(name) > 52 {
+		name = strings.Trim(name[:52], "-.")
+	}
+	if name == "" {
+		name = "root"
+	}
+	return name
+}
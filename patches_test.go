@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestPatch(t *testing.T) {
+	runMainCases(t, "patch")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A platform is the GOOS and GOARCH packages are loaded for; the zero platform is that of the environment.
+type platform struct {
+	goos, goarch string
+}
+
+func (p platform) String() string {
+	if p == (platform{}) {
+		return "the host platform"
+	}
+	return p.goos + "/" + p.goarch
+}
+
+// env returns the environment of the go command loading packages for p, or nil for that of eg.
+func (p platform) env() []string {
+	if p == (platform{}) {
+		return nil
+	}
+	return append(os.Environ(), "GOOS="+p.goos, "GOARCH="+p.goarch)
+}
+
+// parsePlatforms parses the comma-separated GOOS/GOARCH pairs of -platforms, returning the zero platform alone if
+// there are none.
+func parsePlatforms(list string) ([]platform, error) {
+	if strings.TrimSpace(list) == "" {
+		return []platform{{}}, nil
+	}
+	var platforms []platform
+	seen := make(map[platform]bool)
+	for _, s := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(s), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid -platforms entry %q: want GOOS/GOARCH, e.g. linux/amd64", s)
+		}
+		p := platform{goos: parts[0], goarch: parts[1]}
+		if !seen[p] {
+			seen[p] = true
+			platforms = append(platforms, p)
+		}
+	}
+	return platforms, nil
+}
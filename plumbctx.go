@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"io"
+	"os"
+	"sort"
+)
+
+const plumbCtxUsage = `Usage: eg plumb-ctx -entry path.Func... [-name ctx] [-w] <packages>...
+
+Threads a context.Context parameter from the entry points down through every
+function and method they call, transitively, which is declared in the loaded
+packages. Functions without a context parameter get one, as their first; calls
+to them pass the caller's context, or context.TODO() from callers which have
+none. Calls of context.TODO() within the functions are replaced by their
+context. Calls through interfaces and function values aren't followed.
+`
+
+func plumbCtxMain(args []string) error {
+	fs := flag.NewFlagSet("plumb-ctx", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, plumbCtxUsage) }
+	var entries arrayFlags
+	fs.Var(&entries, "entry", "an entry point, as path.Func or path.Type.Method (repeatable)")
+	nameFlag := fs.String("name", "ctx", "the name of the parameters added")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errors.New("no -entry given")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	var roots []*types.Func
+	for _, entry := range entries {
+		fn, err := m.lookupFunc(entry)
+		if err != nil {
+			return err
+		}
+		if decl, _, _ := m.decl(fn); decl == nil {
+			return fmt.Errorf("the declaration of %s isn't among the loaded packages", entry)
+		}
+		roots = append(roots, fn)
+	}
+
+	// find the functions reachable from the entries, and the name of each one's context parameter
+	decls := m.funcDecls()
+	ctxNames := make(map[*types.Func]string)
+	var added []*types.Func
+	queue := roots
+	for len(queue) > 0 {
+		fn := queue[0]
+		queue = queue[1:]
+		if _, ok := ctxNames[fn]; ok {
+			continue
+		}
+		d := decls[fn]
+		name, ok := contextParam(d.pkg.TypesInfo, d.decl)
+		if !ok {
+			if params := d.decl.Type.Params.List; len(params) > 0 && len(params[0].Names) == 0 {
+				fmt.Fprintf(os.Stderr, "%s: warning: %s has unnamed parameters, so its context is unnamed too\n",
+					m.position(d.decl), fn.Name())
+			} else if err := checkNewName(d.pkg.TypesInfo, d.decl, *nameFlag); err != nil {
+				return fmt.Errorf("%s: %v", m.position(d.decl), err)
+			} else {
+				name = *nameFlag
+			}
+			added = append(added, fn)
+		}
+		ctxNames[fn] = name
+		if d.decl.Body == nil {
+			continue
+		}
+		ast.Inspect(d.decl.Body, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if callee := calleeFunc(d.pkg.TypesInfo, call); callee != nil && decls[callee].decl != nil {
+					queue = append(queue, callee)
+				}
+			}
+			return true
+		})
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Pos() < added[j].Pos() })
+
+	// replace the context.TODO()s of the functions which have a usable context
+	for fn, name := range ctxNames {
+		d := decls[fn]
+		if name == "" || d.decl.Body == nil {
+			continue
+		}
+		astutil.Apply(d.decl.Body, func(c *astutil.Cursor) bool {
+			if call, ok := c.Node().(*ast.CallExpr); ok && isContextTODO(d.pkg.TypesInfo, call) {
+				c.Replace(&ast.Ident{NamePos: call.Pos(), Name: name})
+				m.touch(d.pkg, d.file)
+			}
+			return true
+		}, nil)
+	}
+
+	var failed bool
+	var objs []types.Object
+	for _, fn := range added {
+		objs = append(objs, fn)
+		d := decls[fn]
+		pos := d.decl.Type.Params.Closing
+		if len(d.decl.Type.Params.List) > 0 {
+			pos = d.decl.Type.Params.List[0].Pos()
+		}
+		typ, err := m.parseExpr(d.pkg, d.file, pos, "context.Context", nil)
+		if err != nil {
+			return err
+		}
+		field := &ast.Field{Type: typ}
+		if ctxNames[fn] != "" {
+			field.Names = []*ast.Ident{{NamePos: pos, Name: *nameFlag}}
+		}
+		insertField(d.decl.Type.Params, 0, field)
+		m.touch(d.pkg, d.file)
+		fmt.Fprintf(os.Stderr, "%s: added %s to %s\n", m.position(d.decl), *nameFlag, fn.FullName())
+	}
+
+	for _, u := range m.uses(objs...) {
+		call := u.call()
+		if call == nil {
+			fmt.Fprintf(os.Stderr, "%s: %s is used as a value; update it manually\n", m.position(u.id), u.id.Name)
+			failed = true
+			continue
+		}
+		if len(call.Args) == 1 && u.pkg.TypesInfo.Uses[u.id].Type().(*types.Signature).Params().Len() > 1 {
+			fmt.Fprintf(os.Stderr, "%s: can't add an argument to a call with a multi-valued argument\n", m.position(call))
+			failed = true
+			continue
+		}
+		pos := call.Rparen
+		if len(call.Args) > 0 {
+			pos = call.Args[0].Pos()
+		}
+		var arg ast.Expr
+		if name := ctxNames[enclosingFuncObj(u)]; name != "" {
+			arg = &ast.Ident{NamePos: pos, Name: name}
+		} else if arg, err = m.parseExpr(u.pkg, u.file, pos, "context.TODO()", nil); err != nil {
+			return err
+		}
+		call.Args = append([]ast.Expr{arg}, call.Args...)
+		m.touch(u.pkg, u.file)
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some uses couldn't be rewritten")
+	}
+	return nil
+}
+
+// contextParam returns the name of the context.Context parameter of decl, if it has one; the name is "" if the
+// parameter is unnamed or blank.
+func contextParam(info *types.Info, decl *ast.FuncDecl) (string, bool) {
+	for _, field := range decl.Type.Params.List {
+		if !isContext(info.TypeOf(field.Type)) {
+			continue
+		}
+		if len(field.Names) == 0 || field.Names[0].Name == "_" {
+			return "", true
+		}
+		return field.Names[0].Name, true
+	}
+	return "", false
+}
+
+func isContext(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "context" && named.Obj().Name() == "Context"
+}
+
+func isContextTODO(info *types.Info, call *ast.CallExpr) bool {
+	fn := calleeFunc(info, call)
+	return fn != nil && fn.Pkg() != nil && fn.Pkg().Path() == "context" && fn.Name() == "TODO"
+}
+
+// calleeFunc returns the function or method statically called by call, or nil if it's not such a call.
+func calleeFunc(info *types.Info, call *ast.CallExpr) *types.Func {
+	var id *ast.Ident
+	switch fun := astutil.Unparen(call.Fun).(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	default:
+		return nil
+	}
+	fn, _ := info.Uses[id].(*types.Func)
+	return fn
+}
+
+// enclosingFuncObj returns the function declared by the declaration enclosing the use, or nil if it's not in one.
+func enclosingFuncObj(u use) *types.Func {
+	for _, n := range u.path {
+		if decl, ok := n.(*ast.FuncDecl); ok {
+			fn, _ := u.pkg.TypesInfo.Defs[decl.Name].(*types.Func)
+			return fn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestPlumbCtx(t *testing.T) {
+	runCommandCases(t, "plumb-ctx")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strings"
+)
+
+// matchRevise, if set, revises the replacement of each match matchFilter accepts, as a reviewer edited it.
+var matchRevise func(fSet *token.FileSet, orig, repl ast.Node) ast.Node
+
+// reviewOverlay, if set, holds the sources of the files as the templates reviewed so far rewrote them, when they're
+// not written in place, which are loaded, and reviewed, instead of those on disk.
+var reviewOverlay map[string][]byte
+
+// matchFilter, if set, decides each match which the -onmatch hook, if any, accepts, as for a review of them, which
+// is rewritten only if it returns true.
+var matchFilter func(fSet *token.FileSet, tmplPath string, orig, repl ast.Node) bool
+
+// A proposal is a rewrite which a template proposes, for review before it's written.
+type proposal struct {
+	ID       int
+	Template string // the path of the template
+	File     string
+	Line     int
+	Offset   int // of the match in the file, identifying it between the runs collecting and applying proposals
+	Before   string
+	After    string
+	Diff     string // the lines of the match, prefixed by "-", and as rewritten, prefixed by "+"
+	Above    string // the lines of the file just before those of the match, shown around the diff at the prompt
+	Below    string // and just after
+	Accepted bool
+	Edited   string // the source of the replacement as the reviewer edited it, if they did
+}
+
+// A proposalKey identifies a proposal by its match.
+type proposalKey struct {
+	file   string
+	offset int
+}
+
+// reviewRun applies the templates at tmplPaths to the packages matched by args in turn, collecting the matches of
+// each as proposals, which decide accepts or not, and then rewriting those it accepts, in place if write, or else
+// printing each file rewritten, once all the templates are, in the output format. The templates are reviewed one at
+// a time, since rewriting the matches of one may move those of the next, which are found anew in the files as it
+// left them: written, or else held in memory.
+func reviewRun(tmplPaths, args []string, configs *configs, decide func(tmplPath string, props []*proposal) error,
+	write bool) error {
+	defer func() { matchFilter, matchRevise = nil, nil }()
+	if write {
+		return reviewTemplates(tmplPaths, args, configs, decide, write)
+	}
+
+	var names []string // the files rewritten, in the order they first were
+	infos := make(map[string]editInfo)
+	reviewOverlay = make(map[string][]byte)
+	emitCapture = func(filename string, src []byte, info editInfo) {
+		prev, ok := infos[filename]
+		if !ok {
+			names = append(names, filename)
+		} else {
+			info.template = prev.template + ", " + info.template
+			info.matches += prev.matches
+			info.found = append(prev.found, info.found...)
+		}
+		info.gaps = nil // src is printed
+		infos[filename] = info
+		reviewOverlay[filename] = src
+	}
+	err := reviewTemplates(tmplPaths, args, configs, decide, write)
+	overlay := reviewOverlay
+	emitCapture, reviewOverlay = nil, nil
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := emitSource(name, overlay[name], infos[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reviewTemplates reviews the templates at tmplPaths in turn, for reviewRun.
+func reviewTemplates(tmplPaths, args []string, configs *configs, decide func(tmplPath string, props []*proposal) error,
+	write bool) error {
+	for _, tmplPath := range tmplPaths {
+		props, err := collectProposals(tmplPath, args, configs)
+		if err != nil {
+			return err
+		}
+		if len(props) == 0 {
+			continue
+		}
+		stop := decide(tmplPath, props)
+		if stop != nil && stop != errStopReview {
+			return stop
+		}
+		accepted := make(map[proposalKey]*proposal)
+		for _, p := range props {
+			if p.Accepted {
+				accepted[proposalKey{p.File, p.Offset}] = p
+			}
+		}
+		if len(accepted) > 0 {
+			matchFilter = func(fSet *token.FileSet, _ string, orig, _ ast.Node) bool {
+				pos := fSet.PositionFor(orig.Pos(), false)
+				return accepted[proposalKey{pos.Filename, pos.Offset}] != nil
+			}
+			matchRevise = func(fSet *token.FileSet, orig, repl ast.Node) ast.Node {
+				pos := fSet.PositionFor(orig.Pos(), false)
+				if p := accepted[proposalKey{pos.Filename, pos.Offset}]; p != nil && p.Edited != "" {
+					// parsed anew for each match, since identical ones may share the edit
+					return parseEdit(p.Edited, orig, repl)
+				}
+				return repl
+			}
+			saved := *writeFlag
+			*writeFlag = write
+			err = applyTemplate(tmplPath, args, configs, platform{}, make(map[string]bool))
+			*writeFlag = saved
+			matchFilter, matchRevise = nil, nil
+			if err != nil {
+				return err
+			}
+		}
+		if stop != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseEdit returns the replacement of orig as a reviewer edited it, src: an expression, or the statements of the
+// match of a statement template. It returns repl, the template's replacement, if src doesn't parse.
+func parseEdit(src string, orig, repl ast.Node) ast.Node {
+	if _, ok := orig.(eg.Stmts); ok {
+		if stmts, err := parseStmts(src, orig.Pos(), nil); err == nil {
+			return eg.Stmts(stmts)
+		}
+		return repl
+	}
+	if e, err := parser.ParseExpr(src); err == nil {
+		setPos(e, orig.Pos())
+		return e
+	}
+	return repl
+}
+
+// errStopReview is returned by a review's decide function to have the proposals accepted written, and the rest of
+// the templates skipped.
+var errStopReview = errors.New("review stopped")
+
+// lockReview takes the run's lock, as lockRun does for -w, since the rewrites reviewed are written in place.
+func lockReview(dir string) error {
+	saved := *writeFlag
+	*writeFlag = true
+	defer func() { *writeFlag = saved }()
+	return lockRun(dir)
+}
+
+// collectProposals applies the template at tmplPath to the packages matched by args without rewriting them,
+// returning its matches as proposals, in the order they're found.
+func collectProposals(tmplPath string, args []string, configs *configs) ([]*proposal, error) {
+	var props []*proposal
+	sources := make(map[string][]byte)
+	matchFilter = func(fSet *token.FileSet, tmplPath string, orig, repl ast.Node) bool {
+		if p := newProposal(fSet, tmplPath, orig, repl, sources); p != nil {
+			p.ID = len(props)
+			props = append(props, p)
+		}
+		return false // the files are left as they are until the proposals are decided
+	}
+	err := applyTemplate(tmplPath, args, configs, platform{}, make(map[string]bool))
+	matchFilter = nil
+	return props, err
+}
+
+// contextLines is the number of lines of the file shown above and below a proposal's diff at the prompt.
+const contextLines = 3
+
+// newProposal returns the proposal to rewrite orig as repl, with the source of its file, read into sources, or nil
+// if the file can't be read.
+func newProposal(fSet *token.FileSet, tmplPath string, orig, repl ast.Node, sources map[string][]byte) *proposal {
+	start, end := fSet.PositionFor(orig.Pos(), false), fSet.PositionFor(orig.End(), false)
+	src, ok := reviewOverlay[start.Filename]
+	if !ok {
+		src, ok = sources[start.Filename]
+	}
+	if !ok {
+		var err error
+		if src, err = ioutil.ReadFile(start.Filename); err != nil {
+			return nil
+		}
+		sources[start.Filename] = src
+	}
+	if end.Offset > len(src) || start.Offset > end.Offset {
+		return nil
+	}
+	after := nodeString(fSet, repl)
+	lineStart := bytes.LastIndexByte(src[:start.Offset], '\n') + 1
+	lineEnd := len(src)
+	if i := bytes.IndexByte(src[end.Offset:], '\n'); i >= 0 {
+		lineEnd = end.Offset + i
+	}
+	above, below := lineStart, lineEnd
+	for i := 0; i < contextLines && above > 0; i++ {
+		above = bytes.LastIndexByte(src[:above-1], '\n') + 1
+	}
+	for i := 0; i < contextLines && below < len(src); i++ {
+		if j := bytes.IndexByte(src[below+1:], '\n'); j >= 0 {
+			below += 1 + j
+		} else {
+			below = len(src)
+		}
+	}
+	old := string(src[lineStart:lineEnd])
+	rewritten := string(src[lineStart:start.Offset]) + after + string(src[end.Offset:lineEnd])
+	return &proposal{
+		Template: tmplPath,
+		File:     start.Filename,
+		Line:     start.Line,
+		Offset:   start.Offset,
+		Before:   nodeString(fSet, orig),
+		After:    after,
+		Diff:     prefixLines("-", old) + prefixLines("+", rewritten),
+		Above:    string(src[above:lineStart]),
+		Below:    string(src[lineEnd:below]),
+		Accepted: true,
+	}
+}
+
+// clusterProposals groups props into clusters of identical rewrites, those with the same text before and after, in
+// the order their first is found, so that a mechanical change can be decided as one.
+func clusterProposals(props []*proposal) [][]*proposal {
+	var clusters [][]*proposal
+	index := make(map[[2]string]int)
+	for _, p := range props {
+		key := [2]string{p.Before, p.After}
+		i, ok := index[key]
+		if !ok {
+			i = len(clusters)
+			index[key] = i
+			clusters = append(clusters, nil)
+		}
+		clusters[i] = append(clusters[i], p)
+	}
+	return clusters
+}
+
+// prefixLines returns the lines of s, each prefixed by prefix and ended by a newline.
+func prefixLines(prefix, s string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(s, "\n") {
+		b.WriteString(prefix + line + "\n")
+	}
+	return b.String()
+}
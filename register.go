@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+)
+
+const registerUsage = `Usage: eg register -t register.go [-import path]... [-w] <packages>...
+
+Registers the named types in the loaded packages which implement an interface,
+as add-method does, by adding a blank import and an init function to the files
+declaring them, with neither needing an expression to match. The template is
+of the form
+
+	// the types implementing matches, or whose pointers do, are registered
+	type matches interface {
+		Open(name string) (driver.Conn, error)
+	}
+
+	// T stands for each of them
+	type T struct{ matches }
+
+	func init() {
+		sql.Register(typeName, &T{})
+	}
+
+	const typeName = ""
+
+where the init function's statements, which are optional, are added in an
+init function of their own after the type's methods, unless an init function
+of its package has them already. Each package declaring such a type imports
+the -import packages for their side effects, unless it imports them already.
+`
+
+func registerMain(args []string) error {
+	fs := flag.NewFlagSet("register", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, registerUsage) }
+	tmplFlag := fs.String("t", "", "the registration template")
+	var importFlags arrayFlags
+	fs.Var(&importFlags, "import", "the path of a package to import for its side effects")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplFlag == "" {
+		return errors.New("no -t template given")
+	}
+
+	m, tmpl, tmplFile, err := loadWithTemplate(*tmplFlag, fs.Args())
+	if err != nil {
+		return err
+	}
+	r, err := newRegisterer(m, tmpl, tmplFile, importFlags)
+	if err != nil {
+		return err
+	}
+	var failed bool
+	for _, pkg := range m.pkgs {
+		if err := r.register(pkg); err != nil {
+			fmt.Fprintf(os.Stderr, "eg: can't register the types of %s: %v\n", pkg.PkgPath, err)
+			failed = true
+		}
+	}
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some types couldn't be registered")
+	}
+	return nil
+}
+
+// A registerer applies a registration template.
+type registerer struct {
+	*typeTemplate
+	init    []ast.Stmt // the statements of the template's init function
+	imports []string
+}
+
+// newRegisterer validates the registration template tmplFile.
+func newRegisterer(m *migration, tmpl *packages.Package, tmplFile *ast.File, imports []string) (*registerer, error) {
+	tt, err := newTypeTemplate(m, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	r := &registerer{typeTemplate: tt, imports: imports}
+	for _, decl := range tmplFile.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "init" {
+			if err := m.checkTemplateRefs(tmpl, fn.Body, r.t, r.typeName); err != nil {
+				return nil, err
+			}
+			r.init = append(r.init, fn.Body.List...)
+		}
+	}
+	if len(r.init) == 0 && len(imports) == 0 {
+		return nil, errors.New("template declares no init function, and no -import was given")
+	}
+	return r, nil
+}
+
+// register adds the imports and init functions to pkg for each type it declares implementing matches.
+func (r *registerer) register(pkg *packages.Package) error {
+	existing := r.initStmts(pkg)
+	var imported bool
+	for _, tn := range namedTypes(pkg) {
+		if ok, _ := r.implemented(tn); !ok {
+			continue
+		}
+		if tn.Type().(*types.Named).TypeParams().Len() > 0 {
+			return fmt.Errorf("%s: %s has type parameters", r.m.fSet.Position(tn.Pos()), tn.Name())
+		}
+		after, file := lastDecl(r.m.fSet, pkg, tn)
+		if !imported {
+			r.addImports(pkg, file)
+			imported = true
+		}
+		if len(r.init) == 0 {
+			continue
+		}
+
+		pos := after.End()
+		var stmts []ast.Stmt
+		for _, stmt := range r.init {
+			s, err := r.m.instantiate(r.tmpl, stmt, pkg, file, pos, r.subst(tn, pos))
+			if err != nil {
+				return err
+			}
+			stmts = append(stmts, s.(ast.Stmt))
+		}
+		if existing[nodeString(r.m.fSet, &ast.BlockStmt{List: stmts})] {
+			continue
+		}
+		var buf bytes.Buffer
+		buf.WriteString("func init() {\n")
+		for _, stmt := range stmts {
+			fmt.Fprintf(&buf, "%s\n", nodeString(r.m.fSet, stmt))
+		}
+		buf.WriteString("}\n\n")
+		r.m.insertDecls(pkg, file, after, buf.Bytes())
+		fmt.Fprintf(os.Stderr, "%s: registered %s\n", r.m.fSet.Position(tn.Pos()), tn.Name())
+	}
+	return nil
+}
+
+// initStmts returns the source of the runs of statements of the init functions of pkg, as blocks, for comparison
+// with those to be added.
+func (r *registerer) initStmts(pkg *packages.Package) map[string]bool {
+	existing := make(map[string]bool)
+	n := len(r.init)
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Name.Name != "init" || fn.Body == nil {
+				continue
+			}
+			for i := 0; i+n <= len(fn.Body.List); i++ {
+				existing[nodeString(r.m.fSet, &ast.BlockStmt{List: fn.Body.List[i : i+n]})] = true
+			}
+		}
+	}
+	return existing
+}
+
+// addImports adds blank imports of the -import packages which pkg doesn't import to file.
+func (r *registerer) addImports(pkg *packages.Package, file *ast.File) {
+	for _, path := range r.imports {
+		if _, ok := pkg.Imports[path]; ok || path == pkg.PkgPath {
+			continue
+		}
+		astutil.AddNamedImport(r.m.fSet, file, "_", path)
+		r.m.touch(pkg, file)
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestRegister(t *testing.T) {
+	runCommandCases(t, "register")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/token"
+	"os"
+	"sort"
+)
+
+// maxRejectionReasons is the number of reasons the rejection summary lists, most frequent first.
+const maxRejectionReasons = 10
+
+// rejections counts the candidates which resembled a template but weren't rewritten, by kind of reason, so that a run with
+// no matches can be told from one whose template has the wrong types.
+type rejections struct {
+	reasons  []string // in the order first seen
+	byReason map[string]*rejected
+}
+
+// rejected counts the rejections of a kind, with the position and reason of the first.
+type rejected struct {
+	count  int
+	sample string
+}
+
+// add records a rejection at pos for reason, of the sort kind.
+func (r *rejections) add(fSet *token.FileSet, pos token.Pos, kind, reason string) {
+	if r.byReason == nil {
+		r.byReason = make(map[string]*rejected)
+	}
+	rej, ok := r.byReason[kind]
+	if !ok {
+		rej = &rejected{sample: reportPos(fSet, pos)}
+		if reason != kind {
+			rej.sample += ": " + reason
+		}
+		r.byReason[kind] = rej
+		r.reasons = append(r.reasons, kind)
+	}
+	rej.count++
+}
+
+// addFile records the rejections, type changes and refusals of a file's transformation: the candidates it didn't
+// match, and the matches it refused to rewrite.
+func (r *rejections) addFile(fSet *token.FileSet, rejs []eg.Rejection, changes []eg.TypeChange, refusals []eg.Refusal) {
+	for _, rej := range rejs {
+		r.add(fSet, rej.Pos, rej.Kind, rej.Reason)
+	}
+	for _, c := range changes {
+		switch {
+		case c.Err != nil:
+			r.add(fSet, c.Pos, errKind, errKind)
+		case !c.Assignable:
+			r.add(fSet, c.Pos, unassignableKind, unassignableKind)
+		case c.Refused:
+			r.add(fSet, c.Pos, strictKind, strictKind)
+		}
+	}
+	for _, ref := range refusals {
+		r.add(fSet, ref.Pos, "matched, but "+ref.Reason, "matched, but "+ref.Reason)
+	}
+}
+
+// The kinds of the rejections of matches for their types.
+const (
+	errKind          = "matched, but the replacement doesn't type check there"
+	unassignableKind = "matched, but the replacement's type isn't assignable to the original's"
+	strictKind       = "matched, but the replacement changes the type, which -strict-types refuses"
+)
+
+// report prints the counts of the rejections of the template at tmplPath, if any.
+func (r *rejections) report(tmplPath string) {
+	var total int
+	for _, rej := range r.byReason {
+		total += rej.count
+	}
+	if total == 0 {
+		return
+	}
+	reasons := append([]string(nil), r.reasons...)
+	sort.SliceStable(reasons, func(i, j int) bool { return r.byReason[reasons[i]].count > r.byReason[reasons[j]].count })
+	fmt.Fprintf(os.Stderr, "%s: %d candidates resembling the pattern weren't rewritten:\n", tmplPath, total)
+	for i, reason := range reasons {
+		if i == maxRejectionReasons {
+			fmt.Fprintf(os.Stderr, "\t... and %d more reasons\n", len(reasons)-i)
+			break
+		}
+		rej := r.byReason[reason]
+		fmt.Fprintf(os.Stderr, "\t%d: %s, e.g. at %s\n", rej.count, reason, rej.sample)
+	}
+}
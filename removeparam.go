@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"io"
+	"os"
+)
+
+const removeParamUsage = `Usage: eg remove-param -func path.Func (-name name | -at index) [-hoist] [-w] <packages>...
+
+Removes a parameter, which the body mustn't use, from a function or method
+(path.Type.Method), deleting the corresponding argument at every call site in
+the loaded packages. Removing one from an interface method removes it from the
+methods implementing it in the loaded packages too. Arguments which may have
+side effects aren't deleted unless -hoist is given, in which case they're
+evaluated in a statement of their own before the one containing the call, if
+that statement is in a block and evaluates them unconditionally, once, and
+before anything else with side effects.
+`
+
+func removeParamMain(args []string) error {
+	fs := flag.NewFlagSet("remove-param", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, removeParamUsage) }
+	funcFlag := fs.String("func", "", "the function, as path.Func or path.Type.Method")
+	nameFlag := fs.String("name", "", "the name of the parameter")
+	atFlag := fs.Int("at", -1, "the index of the parameter, if it's unnamed")
+	hoistFlag := fs.Bool("hoist", false, "hoist arguments which may have side effects out of the call, rather than refusing")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *funcFlag == "" || (*nameFlag == "") == (*atFlag < 0) {
+		return errors.New("-func and one of -name or -at are required")
+	}
+
+	m, err := loadMigration(fs.Args())
+	if err != nil {
+		return err
+	}
+	fn, err := m.lookupFunc(*funcFlag)
+	if err != nil {
+		return err
+	}
+	sigs, outside, err := m.sigDecls(fn)
+	if err != nil {
+		return err
+	}
+
+	sig := fn.Type().(*types.Signature)
+	params := sig.Params()
+	at := *atFlag
+	if *nameFlag != "" {
+		for i := 0; i < params.Len(); i++ {
+			if params.At(i).Name() == *nameFlag {
+				at = i
+			}
+		}
+		if at < 0 {
+			return fmt.Errorf("%s has no parameter named %s", *funcFlag, *nameFlag)
+		}
+	}
+	if at >= params.Len() {
+		return fmt.Errorf("-at %d is out of range for %s, which has %d parameters", at, *funcFlag, params.Len())
+	}
+	variadic := sig.Variadic() && at == params.Len()-1
+	var objs []types.Object
+	for _, sd := range sigs {
+		objs = append(objs, sd.fn)
+		if sd.decl == nil {
+			continue
+		}
+		param := sd.fn.Type().(*types.Signature).Params().At(at)
+		if param.Name() == "" || param.Name() == "_" {
+			continue
+		}
+		for id, obj := range sd.pkg.TypesInfo.Uses {
+			if obj == param {
+				return fmt.Errorf("%s: %s is used in the body of %s", m.position(id), param.Name(), sd.fn.FullName())
+			}
+		}
+	}
+
+	failed := m.reportOutside(outside, fn)
+	type edit struct {
+		u    use
+		call *ast.CallExpr
+	}
+	var edits []edit
+	for _, u := range m.uses(objs...) {
+		call := u.call()
+		if call == nil {
+			fmt.Fprintf(os.Stderr, "%s: %s is used as a value; update it manually\n", m.position(u.id), u.id.Name)
+			failed = true
+			continue
+		}
+		if len(call.Args) == 1 && params.Len() > 1 {
+			fmt.Fprintf(os.Stderr, "%s: can't remove an argument from a call with a multi-valued argument\n", m.position(call))
+			failed = true
+			continue
+		}
+		edits = append(edits, edit{u, call})
+	}
+	// check every call before changing any, so a refusal leaves the declaration consistent with its calls
+	for _, e := range edits {
+		removed := removedArgs(e.call, at, variadic)
+		for i, arg := range removed {
+			if sideEffectFree(e.u.pkg.TypesInfo, arg) {
+				continue
+			}
+			if !*hoistFlag {
+				return fmt.Errorf("%s: argument %s may have side effects; use -hoist to keep its evaluation",
+					m.position(arg), nodeString(m.fSet, arg))
+			}
+			if reason := hoistable(e.u, arg, removed[:i]...); reason != "" {
+				return fmt.Errorf("%s: can't hoist argument %s: %s", m.position(arg), nodeString(m.fSet, arg), reason)
+			}
+		}
+	}
+
+	for _, sd := range sigs {
+		removeField(sd.typ.Params, at)
+		m.touch(sd.pkg, sd.file)
+	}
+	for _, e := range edits {
+		for _, arg := range removedArgs(e.call, at, variadic) {
+			if !sideEffectFree(e.u.pkg.TypesInfo, arg) {
+				hoist(e.u, arg)
+			}
+		}
+		if variadic {
+			e.call.Args = e.call.Args[:at]
+			e.call.Ellipsis = token.NoPos
+		} else {
+			e.call.Args = append(e.call.Args[:at], e.call.Args[at+1:]...)
+		}
+		m.touch(e.u.pkg, e.u.file)
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some uses couldn't be rewritten")
+	}
+	return nil
+}
+
+// removedArgs returns the arguments of call for the parameter at index at; all the trailing ones if it's variadic.
+func removedArgs(call *ast.CallExpr, at int, variadic bool) []ast.Expr {
+	switch {
+	case at >= len(call.Args):
+		return nil
+	case variadic:
+		return call.Args[at:]
+	default:
+		return call.Args[at : at+1]
+	}
+}
+
+// pureBuiltins are the builtin functions whose calls have no side effects.
+var pureBuiltins = map[string]bool{
+	"cap": true, "complex": true, "imag": true, "len": true, "real": true,
+}
+
+// sideEffectFree reports whether evaluating e, which info describes, can't have side effects, and so can be
+// deleted. Panics, e.g. of out of range indexes, are disregarded.
+func sideEffectFree(info *types.Info, e ast.Expr) bool {
+	pure := true
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false // creating a closure doesn't run it
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				pure = false
+			}
+		case *ast.CallExpr:
+			if tv, ok := info.Types[n.Fun]; ok && tv.IsType() {
+				break // a conversion
+			}
+			if id, ok := astutil.Unparen(n.Fun).(*ast.Ident); ok {
+				if b, ok := info.Uses[id].(*types.Builtin); ok && pureBuiltins[b.Name()] {
+					break
+				}
+			}
+			pure = false
+		}
+		return pure
+	})
+	return pure
+}
+
+// hoistable returns why e, within the statement containing the use u, can't be evaluated in a statement of its own
+// before that one, or "" if it can: the statement must be in a block, and evaluate e unconditionally, once, and
+// before anything else which may have side effects, but for hoisted, which is hoisted ahead of e.
+func hoistable(u use, e ast.Expr, hoisted ...ast.Expr) string {
+	stmt, reason := evaluatingStmt(u, e, false)
+	if reason != "" {
+		return reason
+	}
+	switch s := stmt.(type) {
+	case *ast.IfStmt:
+		if s.Init != nil {
+			return "it's evaluated after the if statement's init"
+		}
+	case *ast.SwitchStmt:
+		if s.Init != nil {
+			return "it's evaluated after the switch statement's init"
+		}
+	}
+	skip := make(map[ast.Node]bool)
+	for _, h := range hoisted {
+		skip[h] = true
+	}
+	var earlier ast.Expr
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		switch {
+		case n == nil || earlier != nil || n == e || skip[n] || n.Pos() >= e.End():
+			return false
+		case within(e, n):
+			return true
+		}
+		if x, ok := n.(ast.Expr); ok && n.End() <= e.Pos() && !sideEffectFree(u.pkg.TypesInfo, x) {
+			earlier = x
+		}
+		return false
+	})
+	if earlier != nil {
+		return fmt.Sprintf("it would be evaluated before %s, which precedes it", nodeString(u.pkg.Fset, earlier))
+	}
+	return ""
+}
+
+// evaluatingStmt returns the statement in a block containing e, within the use u, if it evaluates e
+// unconditionally and once, or why not. With inits, e may be in the init statement of an if or switch statement.
+func evaluatingStmt(u use, e ast.Expr, inits bool) (ast.Stmt, string) {
+	var stmt ast.Stmt
+	for i := 0; stmt == nil; i++ {
+		if i == len(u.path) {
+			return nil, "it's not in a block"
+		}
+		switch n := u.path[i].(type) {
+		case *ast.BinaryExpr:
+			if (n.Op == token.LAND || n.Op == token.LOR) && within(e, n.Y) {
+				return nil, "it's only conditionally evaluated"
+			}
+		case *ast.CaseClause, *ast.CommClause:
+			return nil, "it's only conditionally evaluated"
+		case ast.Stmt:
+			if i+1 == len(u.path) {
+				return nil, "it's not in a block"
+			}
+			if _, ok := stmtList(u.path[i+1]); ok {
+				stmt = n
+				break
+			}
+			switch p := u.path[i+1].(type) {
+			case *ast.IfStmt:
+				if n == p.Else {
+					return nil, "it's only conditionally evaluated"
+				}
+				if inits && n == p.Init {
+					continue
+				}
+			case *ast.SwitchStmt:
+				if inits && n == p.Init {
+					continue
+				}
+			case *ast.TypeSwitchStmt:
+				if inits && n == p.Init {
+					continue
+				}
+			case *ast.ForStmt:
+				if n == p.Post {
+					return nil, "it's evaluated on every iteration of a loop"
+				}
+			}
+			return nil, "it's not in a statement of its own in a block"
+		}
+	}
+
+	switch s := stmt.(type) {
+	case *ast.ForStmt:
+		return nil, "it's evaluated on every iteration of a loop"
+	case *ast.RangeStmt:
+		if !within(e, s.X) {
+			return nil, "it's evaluated on every iteration of a loop"
+		}
+	}
+	return stmt, ""
+}
+
+// hoist inserts a statement evaluating arg, and discarding its results, before the statement containing it, on its
+// line.
+func hoist(u use, arg ast.Expr) {
+	spliceStmt(u.path, func(stmt ast.Stmt) []ast.Stmt {
+		setPos(arg, stmt.Pos())
+		lhs := []ast.Expr{&ast.Ident{NamePos: stmt.Pos(), Name: "_"}}
+		return []ast.Stmt{&ast.AssignStmt{Lhs: lhs, TokPos: stmt.Pos(), Tok: token.ASSIGN, Rhs: []ast.Expr{arg}}, stmt}
+	})
+}
+
+// within reports whether n lies within outer, which may be nil.
+func within(n, outer ast.Node) bool {
+	return outer != nil && outer.Pos() <= n.Pos() && n.End() <= outer.End()
+}
+
+// removeField removes the at'th entry from fields, splitting a group of names declared together if necessary.
+func removeField(fields *ast.FieldList, at int) {
+	var list []*ast.Field
+	var i int
+	for _, f := range fields.List {
+		count := len(f.Names)
+		if count == 0 {
+			count = 1
+		}
+		switch {
+		case at < i || at >= i+count:
+			list = append(list, f)
+		case count > 1:
+			names := append(append([]*ast.Ident(nil), f.Names[:at-i]...), f.Names[at-i+1:]...)
+			list = append(list, &ast.Field{Doc: f.Doc, Names: names, Type: f.Type, Comment: f.Comment})
+		}
+		i += count
+	}
+	fields.List = list
+}
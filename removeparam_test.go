@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestRemoveParam(t *testing.T) {
+	runCommandCases(t, "remove-param")
+}
@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const renameUsage = `Usage: eg rename [-w] path.Name newpath.NewName <packages>...
+       eg rename [-w] [-tags] path.Type.Member path.Type.NewMember <packages>...
+
+Renames a package-level function, type, variable or constant, or a field or
+method of a named type, updating every reference in the loaded packages. If
+newpath differs from path, the function or type, with its methods, is moved to
+that package, which must be among those loaded, and imports are updated to
+match. A moved declaration mustn't refer to others it would leave behind.
+
+Renaming a field updates selectors and keyed composite literals of its type
+only, not those of other types' fields of the same name. With -tags, the
+values of its struct tags which match its name, e.g. json:"userID" or
+db:"user_id" for UserID, are renamed in the same style.
+`
+
+func renameMain(args []string) error {
+	fs := flag.NewFlagSet("rename", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, renameUsage) }
+	tagsFlag := fs.Bool("tags", false, "when renaming a field, rename the values of its struct tags matching its name too")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 3 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	from, to := fs.Arg(0), fs.Arg(1)
+
+	m, err := loadMigration(fs.Args()[2:])
+	if err != nil {
+		return err
+	}
+	obj, err := m.lookup(from)
+	if err != nil {
+		return err
+	}
+	fromPath, fromNames, _ := splitName(from, m.loaded)
+	path, names, err := splitName(to, m.loaded)
+	if err != nil {
+		return err
+	}
+	r := &renamer{m: m, obj: obj, newName: names[len(names)-1], tags: *tagsFlag}
+	if !token.IsIdentifier(r.newName) || r.newName == "_" {
+		return fmt.Errorf("invalid name %s", r.newName)
+	}
+
+	switch {
+	case len(fromNames) == 2:
+		if path != fromPath || len(names) != 2 || names[0] != fromNames[0] {
+			return fmt.Errorf("%s can only be renamed within %s.%s", from, fromPath, fromNames[0])
+		}
+		err = r.renameMember()
+	case len(names) != 1:
+		return fmt.Errorf("invalid name %q: want path.Name", to)
+	case path == fromPath:
+		err = r.renamePackageLevel()
+	default:
+		err = r.move(path)
+	}
+	if err != nil {
+		return err
+	}
+	return m.emit(false)
+}
+
+// splitName splits spec, of the form path.Name or path.Type.Member, into the path and the names. Since the last
+// element of a path may hold dots, e.g. gopkg.in/yaml.v2, it's split at the last dot after which the path is one known
+// reports, or else, if none is, at the first dot after the last slash.
+func splitName(spec string, known func(path string) bool) (string, []string, error) {
+	slash := strings.LastIndex(spec, "/")
+	first := strings.Index(spec[slash+1:], ".")
+	if first < 0 {
+		return "", nil, fmt.Errorf("invalid name %q: want path.Name or path.Type.Member", spec)
+	}
+	dot := slash + 1 + first
+	for i := len(spec) - 1; i > dot; i-- {
+		if spec[i] == '.' && known(spec[:i]) {
+			dot = i
+			break
+		}
+	}
+	names := strings.Split(spec[dot+1:], ".")
+	if len(names) > 2 {
+		return "", nil, fmt.Errorf("invalid name %q: want path.Name or path.Type.Member", spec)
+	}
+	return spec[:dot], names, nil
+}
+
+// A renamer renames obj, and perhaps moves it to another package.
+type renamer struct {
+	m       *migration
+	obj     types.Object
+	newName string
+	tags    bool           // whether to rename a field's matching struct tag values
+	impls   []types.Object // the methods implementing obj, an interface method, which are renamed with it
+}
+
+// renameMember renames a field or method.
+func (r *renamer) renameMember() error {
+	if v, ok := r.obj.(*types.Var); ok && v.Anonymous() {
+		return fmt.Errorf("%s is an embedded field, whose name is that of its type", v.Name())
+	}
+	recv := r.receiver()
+	if recv == nil {
+		return fmt.Errorf("the declaration of %s isn't among the loaded packages", r.obj.Name())
+	}
+	if other, _, _ := types.LookupFieldOrMethod(recv, true, r.obj.Pkg(), r.newName); other != nil {
+		return fmt.Errorf("%s: %s already has a field or method named %s", r.m.fSet.Position(other.Pos()), recv, r.newName)
+	}
+	fn, isFunc := r.obj.(*types.Func)
+	if iface, ok := recv.Underlying().(*types.Interface); ok && isFunc {
+		if err := r.addImpls(iface, fn); err != nil {
+			return err
+		}
+	} else if isFunc {
+		fmt.Fprintf(os.Stderr, "warning: types may no longer implement interfaces with a method %s\n", r.obj.Name())
+	}
+	if err := r.checkExported(r.obj.Pkg().Path()); err != nil {
+		return err
+	}
+	if !isFunc {
+		r.checkConversions(recv)
+		if r.tags {
+			r.renameTags()
+		}
+	}
+	r.renameIdents()
+	return nil
+}
+
+// addImpls adds the methods implementing fn, a method of iface, to those renamed, checking that their types can
+// have methods of the new name. Implementations outside the loaded packages are reported.
+func (r *renamer) addImpls(iface *types.Interface, fn *types.Func) error {
+	impls, outside := r.m.implementations(iface, fn)
+	for _, impl := range impls {
+		recv := impl.Type().(*types.Signature).Recv().Type()
+		if other, _, _ := types.LookupFieldOrMethod(recv, true, impl.Pkg(), r.newName); other != nil {
+			return fmt.Errorf("%s: %s, which implements %s, already has a field or method named %s",
+				r.m.fSet.Position(other.Pos()), recv, fn.Name(), r.newName)
+		}
+		if impl.Pkg() != fn.Pkg() && !ast.IsExported(r.newName) {
+			return fmt.Errorf("%s: %s implements %s outside %s, so %s must be exported", r.m.fSet.Position(impl.Pos()),
+				recv, fn.Name(), fn.Pkg().Path(), r.newName)
+		}
+		r.impls = append(r.impls, impl)
+	}
+	for _, tn := range outside {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s implements %s outside the loaded packages, and won't once it's renamed\n",
+			r.m.fSet.Position(tn.Pos()), tn.Type(), fn.Name())
+	}
+	return nil
+}
+
+// receiver returns the named type of which the field or method being renamed is a member, or nil if it can't be
+// found.
+func (r *renamer) receiver() types.Type {
+	if fn, ok := r.obj.(*types.Func); ok {
+		return fn.Type().(*types.Signature).Recv().Type()
+	}
+	scope := r.obj.Pkg().Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if st, ok := tn.Type().Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				if st.Field(i) == r.obj {
+					return tn.Type()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renamePackageLevel renames a package-level object within its package.
+func (r *renamer) renamePackageLevel() error {
+	pkg := r.pkgOf(r.obj.Pkg().Path())
+	if err := r.checkFree(pkg); err != nil {
+		return err
+	}
+	if err := r.checkExported(pkg.PkgPath); err != nil {
+		return err
+	}
+	if err := r.checkEmbedded(); err != nil {
+		return err
+	}
+	for _, u := range r.m.uses(r.obj) {
+		if u.pkg == pkg {
+			if err := r.checkShadow(u); err != nil {
+				return err
+			}
+		}
+	}
+	r.renameIdents()
+	return nil
+}
+
+// pkgOf returns the loaded package with the path given, or nil if there's none.
+func (r *renamer) pkgOf(path string) *packages.Package {
+	for _, pkg := range r.m.pkgs {
+		if pkg.PkgPath == path {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// checkFree reports an error if the new name is already declared in pkg, or imported by one of its files.
+func (r *renamer) checkFree(pkg *packages.Package) error {
+	if other := pkg.Types.Scope().Lookup(r.newName); other != nil {
+		return fmt.Errorf("%s: %s already declares %s", r.m.fSet.Position(other.Pos()), pkg.PkgPath, r.newName)
+	}
+	for _, file := range pkg.Syntax {
+		if scope := pkg.TypesInfo.Scopes[file]; scope != nil && scope.Lookup(r.newName) != nil {
+			return fmt.Errorf("%s: a package named %s is imported", r.m.position(file), r.newName)
+		}
+	}
+	return nil
+}
+
+// checkExported reports an error if the new name is unexported but obj, or a method implementing it, is used
+// outside the package at path.
+func (r *renamer) checkExported(path string) error {
+	if ast.IsExported(r.newName) {
+		return nil
+	}
+	for _, u := range r.m.uses(append([]types.Object{r.obj}, r.impls...)...) {
+		if u.pkg.PkgPath != path && !r.moving(u) {
+			return fmt.Errorf("%s: %s is used outside %s, so %s must be exported", r.m.position(u.id), r.obj.Name(),
+				path, r.newName)
+		}
+	}
+	return nil
+}
+
+// moving reports whether the use is within a declaration that a move of obj would move: that of obj itself, or of
+// one of its methods.
+func (r *renamer) moving(u use) bool {
+	return u.pkg.Types == r.obj.Pkg() && enclosingDeclObj(u) == r.obj
+}
+
+// checkEmbedded reports an error if obj is a type embedded in a struct, since renaming it would rename the field.
+func (r *renamer) checkEmbedded() error {
+	if _, ok := r.obj.(*types.TypeName); !ok {
+		return nil
+	}
+	for _, u := range r.m.uses(r.obj) {
+		if embedded(u) {
+			return fmt.Errorf("%s: %s is embedded, so renaming it would rename a field", r.m.position(u.id), r.obj.Name())
+		}
+	}
+	return nil
+}
+
+// embedded reports whether the use is the type of an embedded field.
+func embedded(u use) bool {
+	for i := 1; i+2 < len(u.path); i++ {
+		switch n := u.path[i].(type) {
+		case *ast.SelectorExpr, *ast.StarExpr:
+			continue
+		case *ast.Field:
+			_, ok := u.path[i+2].(*ast.StructType)
+			return ok && len(n.Names) == 0
+		}
+		break
+	}
+	return false
+}
+
+// checkShadow reports an error if an unqualified reference to the new name at the use would refer to an object of
+// an enclosing scope, short of the package's own, rather than obj.
+func (r *renamer) checkShadow(u use) error {
+	scope := u.pkg.Types.Scope().Innermost(u.id.Pos())
+	if scope == nil {
+		return nil
+	}
+	_, other := scope.LookupParent(r.newName, u.id.Pos())
+	if other != nil && other != r.obj && other.Parent() != u.pkg.Types.Scope() && other.Parent() != types.Universe {
+		return fmt.Errorf("%s: %s would refer to the %s declared at %s", r.m.position(u.id), r.newName, other.Name(),
+			r.m.fSet.Position(other.Pos()))
+	}
+	return nil
+}
+
+// renameIdents renames the declaration of obj and every reference to it.
+func (r *renamer) renameIdents() {
+	objs := append([]types.Object{r.obj}, r.impls...)
+	renamed := make(map[types.Object]bool)
+	for _, obj := range objs {
+		renamed[obj] = true
+	}
+	for _, pkg := range r.m.pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && renamed[pkg.TypesInfo.Defs[id]] {
+					id.Name = r.newName
+					r.m.touch(pkg, file)
+				}
+				return true
+			})
+		}
+	}
+	for _, u := range r.m.uses(objs...) {
+		u.id.Name = r.newName
+		r.m.touch(u.pkg, u.file)
+	}
+}
+
+// A movedDecl is a declaration being moved, with the file containing it.
+type movedDecl struct {
+	decl ast.Decl
+	file *ast.File
+}
+
+// move moves a function, or a type with its methods, to the package at path, renaming it.
+func (r *renamer) move(path string) error {
+	dest := r.pkgOf(path)
+	if dest == nil {
+		return fmt.Errorf("package %s isn't among the loaded packages", path)
+	}
+	src := r.pkgOf(r.obj.Pkg().Path())
+	decls, err := r.movedDecls(src)
+	if err != nil {
+		return err
+	}
+	if err := r.checkFree(dest); err != nil {
+		return err
+	}
+	if err := r.checkExported(path); err != nil {
+		return err
+	}
+	if r.obj.Name() != r.newName {
+		if err := r.checkEmbedded(); err != nil {
+			return err
+		}
+	}
+
+	// the moved declarations mustn't depend on what they leave behind, nor create an import cycle
+	var deps []string
+	used := make(map[*types.PkgName]bool)
+	for _, d := range decls {
+		ast.Inspect(d.decl, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			switch obj := src.TypesInfo.Uses[id].(type) {
+			case nil:
+			case *types.PkgName:
+				used[obj] = true
+				if obj.Imported() != dest.Types && imports(r.pkgOf(obj.Imported().Path()), path) {
+					deps = append(deps, fmt.Sprintf("%s, which imports %s (%s)", obj.Imported().Path(), path, r.m.position(id)))
+				}
+			default:
+				if obj != r.obj && obj.Pkg() == src.Types && obj.Parent() == src.Types.Scope() {
+					deps = append(deps, fmt.Sprintf("%s (%s)", obj.Name(), r.m.position(id)))
+				}
+			}
+			return true
+		})
+	}
+	if len(deps) > 0 {
+		return fmt.Errorf("can't move %s: it refers to %s", r.obj.Name(), strings.Join(deps, "; "))
+	}
+	if err := r.checkMembers(dest); err != nil {
+		return err
+	}
+	uses := r.m.uses(r.obj)
+	for _, u := range uses {
+		if u.pkg == src && !r.moving(u) && imports(dest, src.PkgPath) {
+			return fmt.Errorf("can't move %s: %s imports %s, which would still use it", r.obj.Name(), path, src.PkgPath)
+		}
+		if u.pkg == dest || r.moving(u) {
+			if err := r.checkShadow(u); err != nil {
+				return err
+			}
+		}
+	}
+
+	// references become qualified or unqualified as their packages require
+	for _, u := range uses {
+		sel, qualified := u.path[1].(*ast.SelectorExpr)
+		qualified = qualified && sel.Sel == u.id
+		switch {
+		case u.pkg == dest && qualified:
+			replaceNode(u.path[2], sel, u.id)
+		case u.pkg == dest || r.moving(u):
+		case qualified:
+			sel.X = &ast.Ident{NamePos: sel.X.Pos(), Name: r.m.qualifier(u.pkg, u.file)(dest.Types)}
+		default:
+			replaceNode(u.path[1], u.id, &ast.SelectorExpr{
+				X:   &ast.Ident{NamePos: u.id.Pos(), Name: r.m.qualifier(u.pkg, u.file)(dest.Types)},
+				Sel: u.id,
+			})
+		}
+		r.m.touch(u.pkg, u.file)
+	}
+	r.renameIdents()
+
+	// as do references to the destination within the moved declarations
+	for _, d := range decls {
+		astutil.Apply(d.decl, func(c *astutil.Cursor) bool {
+			if sel, ok := c.Node().(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok {
+					if pn, ok := src.TypesInfo.Uses[id].(*types.PkgName); ok && pn.Imported() == dest.Types {
+						c.Replace(sel.Sel)
+					}
+				}
+			}
+			return true
+		}, nil)
+	}
+
+	destFile := destinationFile(r.m.fSet, dest, r.m.fSet.File(decls[0].file.Pos()).Name())
+	for pn := range used {
+		if pn.Imported() == dest.Types {
+			continue
+		}
+		if err := r.importInto(dest, destFile, pn); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, d := range decls {
+		if err := r.cut(src, d, &buf); err != nil {
+			return err
+		}
+	}
+	r.m.appendDecls(dest, destFile, buf.Bytes())
+
+	paths := map[string]bool{src.PkgPath: true, path: true}
+	for pn := range used {
+		paths[pn.Imported().Path()] = true
+	}
+	for file, pkg := range r.m.modified {
+		if file == destFile {
+			// the moved declarations, which aren't in the file yet, may use its imports
+			removeUnusedImports(r.m.fSet, pkg.TypesInfo, file, map[string]bool{src.PkgPath: true})
+		} else {
+			removeUnusedImports(r.m.fSet, pkg.TypesInfo, file, paths)
+		}
+	}
+	return nil
+}
+
+// checkMembers reports an error if unexported fields or methods of obj, a type being moved to dest, are used
+// where they'd no longer be accessible.
+func (r *renamer) checkMembers(dest *packages.Package) error {
+	tn, ok := r.obj.(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	var members []types.Object
+	if st, ok := tn.Type().Underlying().(*types.Struct); ok {
+		for i := 0; i < st.NumFields(); i++ {
+			members = append(members, st.Field(i))
+		}
+	}
+	if named, ok := tn.Type().(*types.Named); ok {
+		for i := 0; i < named.NumMethods(); i++ {
+			members = append(members, named.Method(i))
+		}
+	}
+	var unexported []types.Object
+	for _, obj := range members {
+		if !obj.Exported() {
+			unexported = append(unexported, obj)
+		}
+	}
+	for _, u := range r.m.uses(unexported...) {
+		if u.pkg != dest && !r.moving(u) {
+			return fmt.Errorf("%s: %s would be inaccessible once %s is moved", r.m.position(u.id), u.id.Name, tn.Name())
+		}
+	}
+	return nil
+}
+
+// movedDecls returns the declarations to move: those of obj, a function or type, and of a type's methods, the
+// type's first.
+func (r *renamer) movedDecls(src *packages.Package) ([]movedDecl, error) {
+	switch r.obj.(type) {
+	case *types.Func, *types.TypeName:
+	default:
+		return nil, fmt.Errorf("only functions and types can be moved, and %s is neither", r.obj.Name())
+	}
+	var decls []movedDecl
+	for _, file := range src.Syntax {
+		for _, d := range file.Decls {
+			switch d := d.(type) {
+			case *ast.FuncDecl:
+				if src.TypesInfo.Defs[d.Name] == r.obj || recvTypeName(src.TypesInfo, d) == r.obj {
+					decls = append(decls, movedDecl{d, file})
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok && src.TypesInfo.Defs[ts.Name] == r.obj {
+						if len(d.Specs) > 1 {
+							return nil, fmt.Errorf("%s: can't move %s out of a declaration group", r.m.position(d), r.obj.Name())
+						}
+						decls = append(decls, movedDecl{d, file})
+					}
+				}
+			}
+		}
+	}
+	if len(decls) == 0 {
+		return nil, fmt.Errorf("the declaration of %s isn't among the loaded packages", r.obj.Name())
+	}
+	sort.SliceStable(decls, func(i, j int) bool {
+		_, iType := decls[i].decl.(*ast.GenDecl)
+		_, jType := decls[j].decl.(*ast.GenDecl)
+		return iType && !jType
+	})
+	return decls, nil
+}
+
+// importInto adds the import named by pn, which a moved declaration uses, to file.
+func (r *renamer) importInto(pkg *packages.Package, file *ast.File, pn *types.PkgName) error {
+	path := pn.Imported().Path()
+	for _, imp := range file.Imports {
+		if p, _ := strconv.Unquote(imp.Path.Value); p != path {
+			continue
+		}
+		name := pn.Imported().Name()
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name != pn.Name() {
+			return fmt.Errorf("%s: %s is imported as %s, but %s refers to it as %s", r.m.position(imp), path, name,
+				r.obj.Name(), pn.Name())
+		}
+		return nil
+	}
+	if pn.Name() != pn.Imported().Name() {
+		astutil.AddNamedImport(r.m.fSet, file, pn.Name(), path)
+		r.m.touch(pkg, file)
+	} else {
+		r.m.addImport(pkg, file, path)
+	}
+	return nil
+}
+
+// cut removes the declaration d from its file, writing its source, with its comments, to w.
+func (r *renamer) cut(src *packages.Package, d movedDecl, w *bytes.Buffer) error {
+	start := d.decl.Pos()
+	switch decl := d.decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Doc != nil {
+			start = decl.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if decl.Doc != nil {
+			start = decl.Doc.Pos()
+		}
+	}
+	var comments, kept []*ast.CommentGroup
+	for _, c := range d.file.Comments {
+		if start <= c.Pos() && c.End() <= d.decl.End() {
+			comments = append(comments, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if err := format.Node(w, r.m.fSet, &printer.CommentedNode{Node: d.decl, Comments: comments}); err != nil {
+		return err
+	}
+	w.WriteString("\n\n")
+
+	d.file.Comments = kept
+	for i, decl := range d.file.Decls {
+		if decl == d.decl {
+			d.file.Decls = append(d.file.Decls[:i], d.file.Decls[i+1:]...)
+			break
+		}
+	}
+	r.m.touch(src, d.file)
+	return nil
+}
+
+// enclosingDeclObj returns the function or type declared by the top-level declaration enclosing the use; for a
+// method, its receiver's type.
+func enclosingDeclObj(u use) types.Object {
+	for _, n := range u.path {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if n.Recv != nil {
+				return recvTypeName(u.pkg.TypesInfo, n)
+			}
+			return u.pkg.TypesInfo.Defs[n.Name]
+		case *ast.TypeSpec:
+			return u.pkg.TypesInfo.Defs[n.Name]
+		}
+	}
+	return nil
+}
+
+// recvTypeName returns the name of the receiver type of decl, or nil if it's not a method.
+func recvTypeName(info *types.Info, decl *ast.FuncDecl) types.Object {
+	fn, ok := info.Defs[decl.Name].(*types.Func)
+	if !ok || decl.Recv == nil {
+		return nil
+	}
+	t := fn.Type().(*types.Signature).Recv().Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj()
+	}
+	return nil
+}
+
+// imports reports whether pkg, which may be nil, imports the package at path, directly or indirectly.
+func imports(pkg *packages.Package, path string) bool {
+	if pkg == nil {
+		return false
+	}
+	var found bool
+	packages.Visit([]*packages.Package{pkg}, func(p *packages.Package) bool {
+		found = found || p != pkg && p.PkgPath == path
+		return !found
+	}, nil)
+	return found
+}
+
+// destinationFile returns the file of dest to which declarations moved from the file named srcName are appended:
+// the one with the same base name, if any, or else the first.
+func destinationFile(fSet *token.FileSet, dest *packages.Package, srcName string) *ast.File {
+	files := append([]*ast.File(nil), dest.Syntax...)
+	sort.Slice(files, func(i, j int) bool { return fSet.File(files[i].Pos()).Name() < fSet.File(files[j].Pos()).Name() })
+	for _, f := range files {
+		if filepath.Base(fSet.File(f.Pos()).Name()) == filepath.Base(srcName) {
+			return f
+		}
+	}
+	return files[0]
+}
+
+// replaceNode replaces old, a child of parent, with new.
+func replaceNode(parent, old, new ast.Node) {
+	astutil.Apply(parent, func(c *astutil.Cursor) bool {
+		if c.Node() == old {
+			c.Replace(new)
+			return false
+		}
+		return true
+	}, nil)
+}
+
+// removeUnusedImports removes the imports of file of the paths given which nothing in it refers to any longer.
+// Imports added by the migration are left.
+func removeUnusedImports(fSet *token.FileSet, info *types.Info, file *ast.File, paths map[string]bool) {
+	for _, imp := range append([]*ast.ImportSpec(nil), file.Imports...) {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if !paths[path] || imp.Name != nil && (imp.Name.Name == "_" || imp.Name.Name == ".") {
+			continue
+		}
+		var pn types.Object
+		var name string
+		if imp.Name != nil {
+			pn, name = info.Defs[imp.Name], imp.Name.Name
+		} else {
+			pn = info.Implicits[imp]
+		}
+		if pn == nil {
+			continue
+		}
+		used := false
+		ast.Inspect(file, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok {
+					// qualifiers the migration inserted aren't known to info
+					obj, known := info.Uses[id]
+					used = obj == pn || !known && id.Name == pn.Name()
+				}
+			}
+			return !used
+		})
+		if !used {
+			astutil.DeleteNamedImport(fSet, file, name, path)
+		}
+	}
+}
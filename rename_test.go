@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestRename(t *testing.T) {
+	runCommandCases(t, "rename")
+}
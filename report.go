@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+)
+
+// A jsonMatch is a rewrite of the -json report.
+type jsonMatch struct {
+	File     string  `json:"file"`
+	Package  string  `json:"package"`
+	Template string  `json:"template"` // or the rule
+	Start    jsonPos `json:"start"`
+	End      jsonPos `json:"end"`
+	Before   string  `json:"before"`
+	After    string  `json:"after"`
+}
+
+// A jsonPos is a position in a file of the -json report, as is, ignoring //line directives.
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// A jsonFile is a file the run rewrote, with its number of matches, which is 0 for a file such as a go.mod.
+type jsonFile struct {
+	File    string `json:"file"`
+	Package string `json:"package,omitempty"`
+	Matches int    `json:"matches"`
+}
+
+// A jsonFailure is a file the run couldn't rewrite, and why.
+type jsonFailure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// jsonReport is the report -json prints at the end of the run.
+var jsonReport = struct {
+	Matches  []jsonMatch   `json:"matches"`
+	Files    []jsonFile    `json:"files"`
+	Failures []jsonFailure `json:"failures"`
+	Total    int           `json:"total"` // of the matches
+}{Matches: []jsonMatch{}, Files: []jsonFile{}, Failures: []jsonFailure{}}
+
+// newJSONMatch returns the rewrite of orig, of the package pkg, as repl by tmpl for the -json report.
+func newJSONMatch(fSet *token.FileSet, pkg, tmpl string, orig, repl ast.Node) jsonMatch {
+	start, end := fSet.PositionFor(orig.Pos(), false), fSet.PositionFor(orig.End(), false)
+	return jsonMatch{
+		File:     start.Filename,
+		Package:  pkg,
+		Template: tmpl,
+		Start:    jsonPos{start.Line, start.Column},
+		End:      jsonPos{end.Line, end.Column},
+		Before:   nodeString(fSet, orig),
+		After:    nodeString(fSet, repl),
+	}
+}
+
+// reportFile adds filename, emitted with info, and its matches to the -json report.
+func reportFile(filename string, info editInfo) {
+	jsonReport.Files = append(jsonReport.Files, jsonFile{File: filename, Package: info.pkg, Matches: len(info.found)})
+	jsonReport.Matches = append(jsonReport.Matches, info.found...)
+	jsonReport.Total += len(info.found)
+}
+
+// reportFailure adds filename, which couldn't be rewritten for err, to the -json report.
+func reportFailure(filename string, err error) {
+	jsonReport.Failures = append(jsonReport.Failures, jsonFailure{File: filename, Error: err.Error()})
+}
+
+// writeJSONReport prints the -json report to standard output.
+func writeJSONReport() error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(jsonReport)
+}
+
+// progressf prints a line of the run's progress, such as each file rewritten, to stderr, unless -json was given,
+// whose report replaces them.
+func progressf(format string, a ...interface{}) {
+	if !*jsonFlag {
+		fmt.Fprintf(os.Stderr, format, a...)
+	}
+}
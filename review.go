@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const reviewUsage = `Usage: eg review [-t template.go | -e template] [-addr host:port] <args>...
+
+Finds the matches of the template, or of those of the config, in the packages
+matched by args, and serves a page at addr for reviewing them: each proposed
+rewrite is shown as a diff, to be accepted or rejected, and only those accepted
+are written, in place, once the page is submitted. The templates of a config are
+reviewed one after another. The page's URL has a token generated for the run,
+without which requests are refused, so that other pages open in the browser
+can't submit the review.
+`
+
+func reviewMain(args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, reviewUsage) }
+	fs.Var(&templateFlags, "t", "template.go file specifying the refactoring, or a directory of them, or - to read it from standard input; repeatable")
+	fs.StringVar(exprFlag, "e", "", "an inline template, of the form '[params:] before -> after'")
+	addr := fs.String("addr", "localhost:0", "the address to serve the review page at")
+	fs.BoolVar(tolerateFlag, "tolerate-errors", false, "skip the packages and files with errors, rather than failing")
+	fs.Var(&beforeEditFlags, "beforeedit", "a command to exec before each file is edited; '{}' is replaced by the file name")
+	fs.Var(&afterEditFlags, "afteredit", "a command to exec after each file is edited; '{}' is replaced by the file name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	wd, configs, tmplPaths, cleanup, err := startReview()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	token, err := reviewToken()
+	if err != nil {
+		return err
+	}
+	srv := &reviewServer{dir: wd, token: token}
+	go http.Serve(l, srv)
+	fmt.Fprintf(os.Stderr, "eg: review the proposed rewrites at http://%s/?token=%s\n", l.Addr(), token)
+	return reviewRun(tmplPaths, fs.Args(), configs, srv.decide, true)
+}
+
+// startReview readies a run reviewing the rewrites of the templates given, or of the config of the working
+// directory, which it returns, with the paths of the templates and a function removing those written to temporary
+// files. The run's lock is taken, since the accepted rewrites are written in place.
+func startReview() (wd string, c *configs, tmplPaths []string, cleanup func(), err error) {
+	if wd, err = os.Getwd(); err != nil {
+		return "", nil, nil, nil, err
+	}
+	if c, err = newConfigs(wd); err != nil {
+		return "", nil, nil, nil, err
+	}
+	conf, err := c.forDir(wd)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	if err := applyConfig(conf); err != nil {
+		return "", nil, nil, nil, err
+	}
+	if err := lockReview(wd); err != nil {
+		return "", nil, nil, nil, err
+	}
+	if rewrites, err := configRewrites(conf); err != nil {
+		return "", nil, nil, nil, err
+	} else if len(rewrites) > 0 {
+		return "", nil, nil, nil, errors.New("eg review can't review the rewrite rules of the config")
+	}
+	if tmplPaths, cleanup, err = resolveTemplates(conf); err != nil {
+		return "", nil, nil, nil, err
+	}
+	return wd, c, tmplPaths, cleanup, nil
+}
+
+// reviewToken returns a random token for the review server to require of requests, since any page open in the
+// reviewer's browser could otherwise post a decision to it.
+func reviewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// errReviewCancelled is returned when the reviewer cancels the review of a template.
+var errReviewCancelled = errors.New("review cancelled; the rewrites of the templates left weren't written")
+
+// A reviewServer serves the proposals of the template under review, one template at a time, until the reviewer
+// decides them.
+type reviewServer struct {
+	dir   string // the directory the files are shown relative to
+	token string // required of each request, in its query or form
+
+	mu       sync.Mutex
+	tmplPath string      // the template under review, if any
+	props    []*proposal // its proposals
+	decided  chan error  // receives nil once the proposals are decided, or errReviewCancelled
+}
+
+// decide serves the proposals of the template at tmplPath for review, returning once they're decided.
+func (s *reviewServer) decide(tmplPath string, props []*proposal) error {
+	decided := make(chan error, 1)
+	s.mu.Lock()
+	s.tmplPath, s.props, s.decided = tmplPath, props, decided
+	s.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "eg: %d rewrites of %s to review\n", len(props), templateLabel(tmplPath))
+	return <-decided
+}
+
+func (s *reviewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Form.Get("token")), []byte(s.token)) != 1 {
+		http.Error(w, "missing or wrong token: open the URL eg printed", http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/decide":
+		if s.decided == nil {
+			http.Redirect(w, r, "/?token="+s.token, http.StatusSeeOther)
+			return
+		}
+		accepted := make(map[int]bool)
+		for _, v := range r.PostForm["accept"] {
+			if id, err := strconv.Atoi(v); err == nil {
+				accepted[id] = true
+			}
+		}
+		var n int
+		for _, p := range s.props {
+			p.Accepted = accepted[p.ID]
+			if p.Accepted {
+				n++
+			}
+		}
+		result := error(nil)
+		msg := fmt.Sprintf("Writing the %d rewrites accepted.", n)
+		if r.PostForm.Get("action") == "cancel" {
+			result, msg = errReviewCancelled, "Review cancelled: nothing more is written."
+		}
+		s.decided <- result
+		s.tmplPath, s.props, s.decided = "", nil, nil
+		reviewDone.Execute(w, reviewDoneData{Msg: msg, Token: s.token})
+	case r.URL.Path == "/":
+		if s.decided == nil {
+			reviewWaiting.Execute(w, nil)
+			return
+		}
+		data := reviewData{Template: templateLabel(s.tmplPath), Token: s.token}
+		var single []*proposal
+		for _, c := range clusterProposals(s.props) {
+			if len(c) == 1 {
+				single = append(single, c[0])
+				continue
+			}
+			data.Clusters = append(data.Clusters, reviewCluster{ID: len(data.Clusters), Before: c[0].Before,
+				After: c[0].After, Files: groupByFile(s.dir, c)})
+		}
+		data.Files = groupByFile(s.dir, single)
+		reviewPage.Execute(w, data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// reviewData is the model of the review page.
+type reviewData struct {
+	Template string
+	Token    string
+	Clusters []reviewCluster
+	Files    []reviewFile // of the proposals in no cluster
+}
+
+// reviewCluster is a cluster of identical proposals, accepted or rejected together in the review page, unless the
+// reviewer decides them one by one.
+type reviewCluster struct {
+	ID            int
+	Before, After string
+	Files         []reviewFile
+}
+
+// Len returns the number of proposals in the cluster.
+func (c reviewCluster) Len() int {
+	var n int
+	for _, f := range c.Files {
+		n += len(f.Props)
+	}
+	return n
+}
+
+// reviewFile is the proposals of a file, in the review page.
+type reviewFile struct {
+	Name  string
+	Props []*proposal
+}
+
+// groupByFile groups props by their file, named relative to dir, in the order the files are first seen.
+func groupByFile(dir string, props []*proposal) []reviewFile {
+	var files []reviewFile
+	index := make(map[string]int)
+	for _, p := range props {
+		i, ok := index[p.File]
+		if !ok {
+			name := p.File
+			if strings.HasPrefix(name, dir+string(os.PathSeparator)) {
+				name = name[len(dir)+1:]
+			}
+			i = len(files)
+			index[p.File] = i
+			files = append(files, reviewFile{Name: name})
+		}
+		files[i].Props = append(files[i].Props, p)
+	}
+	return files
+}
+
+// templateLabel returns the name of the template at tmplPath to show the user: its label, if it's written to a
+// temporary file, or else its path.
+func templateLabel(tmplPath string) string {
+	if l, ok := templateLabels[tmplPath]; ok {
+		return l
+	}
+	return tmplPath
+}
+
+// diffClass returns the class of a line of a proposal's diff in the review page.
+func diffClass(line string) string {
+	if strings.HasPrefix(line, "+") {
+		return "add"
+	}
+	return "del"
+}
+
+var reviewFuncs = template.FuncMap{
+	"lines":     func(s string) []string { return strings.Split(strings.TrimSuffix(s, "\n"), "\n") },
+	"diffClass": diffClass,
+}
+
+const reviewStyle = `<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { margin: 0.3em 0 1em 1.5em; padding: 0.3em; background: #f6f8fa; }
+.add { color: #22863a; background: #f0fff4; display: block; }
+.del { color: #b31d28; background: #ffeef0; display: block; }
+details { margin: 0.3em 0 1em 1.5em; }
+label { font-family: monospace; }
+</style>`
+
+var reviewPage = template.Must(template.New("review").Funcs(reviewFuncs).Parse(`<!DOCTYPE html>
+<html><head><title>eg review: {{.Template}}</title>` + reviewStyle + `</head><body>
+<h1>Rewrites of {{.Template}}</h1>
+<form method="post" action="/decide">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>
+<button type="button" onclick="for (const c of document.querySelectorAll('input[type=checkbox]')) c.checked = true">Accept all</button>
+<button type="button" onclick="for (const c of document.querySelectorAll('input[type=checkbox]')) c.checked = false">Reject all</button>
+</p>
+{{if .Clusters}}<h2>Identical rewrites</h2>
+{{range .Clusters}}{{$cluster := .ID}}<label><input type="checkbox" checked onchange="for (const c of document.querySelectorAll('input[data-cluster=&quot;{{$cluster}}&quot;]')) c.checked = this.checked"> {{.Len}} matches: {{.Before}} &rarr; {{.After}}</label>
+<details><summary>Decide them one by one</summary>
+{{range .Files}}<h3>{{.Name}}</h3>
+{{range .Props}}<label><input type="checkbox" name="accept" value="{{.ID}}" data-cluster="{{$cluster}}"{{if .Accepted}} checked{{end}}> line {{.Line}}</label>
+<pre>{{range lines .Diff}}<span class="{{diffClass .}}">{{.}}</span>{{end}}</pre>
+{{end}}{{end}}</details>
+{{end}}{{end}}
+{{range .Files}}<h2>{{.Name}}</h2>
+{{range .Props}}<label><input type="checkbox" name="accept" value="{{.ID}}"{{if .Accepted}} checked{{end}}> line {{.Line}}: {{.Before}}</label>
+<pre>{{range lines .Diff}}<span class="{{diffClass .}}">{{.}}</span>{{end}}</pre>
+{{end}}{{end}}
+<p>
+<button type="submit" name="action" value="apply">Write the accepted rewrites</button>
+<button type="submit" name="action" value="cancel">Cancel</button>
+</p>
+</form>
+</body></html>
+`))
+
+var reviewWaiting = template.Must(template.New("waiting").Parse(`<!DOCTYPE html>
+<html><head><title>eg review</title><meta http-equiv="refresh" content="2">` + reviewStyle + `</head><body>
+<p>Finding the rewrites to review&hellip;</p>
+</body></html>
+`))
+
+// reviewDoneData is the model of the page shown once the proposals of a template are decided.
+type reviewDoneData struct {
+	Msg   string
+	Token string
+}
+
+var reviewDone = template.Must(template.New("done").Parse(`<!DOCTYPE html>
+<html><head><title>eg review</title><meta http-equiv="refresh" content="3; url=/?token={{.Token}}">` + reviewStyle + `</head><body>
+<p>{{.Msg}} The next template's rewrites, if any, are shown once they're found; eg reports its progress in the terminal.</p>
+</body></html>
+`))
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReviewServerToken(t *testing.T) {
+	srv := &reviewServer{dir: ".", token: "secret"}
+	decided := make(chan error, 1)
+	go func() { decided <- srv.decide("t.go", []*proposal{{ID: 0, File: "a.go", Line: 1, Diff: "-a\n+b\n"}}) }()
+	for ready := false; !ready; time.Sleep(time.Millisecond) {
+		srv.mu.Lock()
+		ready = srv.decided != nil
+		srv.mu.Unlock()
+	}
+
+	for _, test := range []struct {
+		name, method, target string
+		form                 url.Values
+		code                 int
+	}{
+		{"page without token", http.MethodGet, "/", nil, http.StatusForbidden},
+		{"page with wrong token", http.MethodGet, "/?token=guess", nil, http.StatusForbidden},
+		{"decision without token", http.MethodPost, "/decide", url.Values{"action": {"cancel"}}, http.StatusForbidden},
+		{"decision with wrong token", http.MethodPost, "/decide?token=guess",
+			url.Values{"action": {"cancel"}}, http.StatusForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveReview(srv, test.method, test.target, test.form)
+			if w.Code != test.code {
+				t.Errorf("got status %d, want %d", w.Code, test.code)
+			}
+		})
+	}
+	select {
+	case err := <-decided:
+		t.Fatalf("the review was decided without the token: %v", err)
+	default:
+	}
+
+	w := serveReview(srv, http.MethodGet, "/?token=secret", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="token" value="secret"`) {
+		t.Fatalf("the page with the token has status %d, and no token in its form:\n%s", w.Code, w.Body)
+	}
+	w = serveReview(srv, http.MethodPost, "/decide", url.Values{"token": {"secret"}, "action": {"cancel"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("the decision with the token has status %d", w.Code)
+	}
+	if err := <-decided; err != errReviewCancelled {
+		t.Errorf("the review returned %v, want %v", err, errReviewCancelled)
+	}
+}
+
+// serveReview serves the request to srv, with form as its body, if any.
+func serveReview(srv *reviewServer, method, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const rewriteImportUsage = `Usage: eg rewrite-import [-w] old.example.com/mod new.example.com/mod
+
+Rewrites imports of the package path old, and of the paths below it, to new
+throughout the module containing the working directory, and the module's
+require, replace and exclude directives (and module directive, if it's old).
+Where the package name implied by the last element of a path changes, e.g.
+from mod to mod2, the import is given the old name as an alias, so references
+to it needn't change. Run go mod tidy afterwards to update go.sum.
+`
+
+func rewriteImportMain(args []string) error {
+	fs := flag.NewFlagSet("rewrite-import", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, rewriteImportUsage) }
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	r := importRewriter{old: strings.TrimSuffix(fs.Arg(0), "/"), new: strings.TrimSuffix(fs.Arg(1), "/")}
+	if r.old == "" || r.new == "" || r.old == r.new {
+		return errors.New("the old and new paths must differ")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root := moduleRoot(wd)
+	if root == "" {
+		return fmt.Errorf("%s isn't in a module", wd)
+	}
+	configs, err := newConfigs(wd)
+	if err != nil {
+		return err
+	}
+	conf, err := configs.forDir(wd)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(conf); err != nil {
+		return err
+	}
+	if err := lockRun(wd); err != nil {
+		return err
+	}
+
+	var hadErrors bool
+	emit := func(filename string, f func() error) error {
+		conf, err := configs.forDir(filepath.Dir(filename))
+		if err != nil {
+			return err
+		}
+		if conf.excluded(filename) {
+			fmt.Fprintf(os.Stderr, "%s: excluded by config; not rewritten\n", filename)
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "=== %s\n", filename)
+		if err := f(); err != nil {
+			if _, ok := err.(abortError); ok {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+			hadErrors = true
+		}
+		return nil
+	}
+
+	err = filepath.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return skipDir(root, filename, info)
+		}
+		if !strings.HasSuffix(filename, ".go") {
+			return nil
+		}
+		fSet := token.NewFileSet()
+		file, err := parser.ParseFile(fSet, filename, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		if !r.rewriteFile(fSet, file) {
+			return nil
+		}
+		return emit(filename, func() error { return emitFile(fSet, filename, file, editInfo{}) })
+	})
+	if err != nil {
+		return err
+	}
+
+	gomod := filepath.Join(root, "go.mod")
+	src, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return err
+	}
+	if rewritten, changed := r.rewriteGoMod(src); changed {
+		if err := emit(gomod, func() error { return emitSource(gomod, rewritten, editInfo{}) }); err != nil {
+			return err
+		}
+	}
+	if hadErrors {
+		return errors.New("some files couldn't be written")
+	}
+	return nil
+}
+
+// skipDir returns filepath.SkipDir for the directories under root which aren't part of its module's source: those
+// the go command ignores, and nested modules.
+func skipDir(root, dir string, info os.FileInfo) error {
+	if dir == root {
+		return nil
+	}
+	name := info.Name()
+	if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+		return filepath.SkipDir
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// An importRewriter rewrites the path old, and the paths below it, to new.
+type importRewriter struct {
+	old, new string
+}
+
+// rewrite returns the rewritten form of p, and whether it's a path to rewrite.
+func (r importRewriter) rewrite(p string) (string, bool) {
+	if p == r.old || strings.HasPrefix(p, r.old+"/") {
+		return r.new + p[len(r.old):], true
+	}
+	return p, false
+}
+
+// rewriteFile rewrites the imports of file, and reports whether there were any to rewrite.
+func (r importRewriter) rewriteFile(fSet *token.FileSet, file *ast.File) bool {
+	var changed bool
+	for _, imp := range file.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		rewritten, ok := r.rewrite(p)
+		if !ok {
+			continue
+		}
+		if name := assumedPackageName(p); imp.Name == nil && assumedPackageName(rewritten) != name {
+			imp.Name = &ast.Ident{NamePos: imp.Path.Pos(), Name: name}
+		}
+		imp.Path.Value = strconv.Quote(rewritten)
+		changed = true
+	}
+	if changed {
+		ast.SortImports(fSet, file)
+	}
+	return changed
+}
+
+// assumedPackageName returns the name of the package at an import path, as assumed from the path when it's not
+// known: the last element, skipping a major version suffix, less any go- prefix or other non-identifier.
+func assumedPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil && path.Dir(importPath) != "." {
+			base = path.Base(path.Dir(importPath))
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// rewriteGoMod rewrites the module paths of the module, require, replace and exclude directives of a go.mod file,
+// and reports whether there were any to rewrite. Replacements by local directories are left.
+func (r importRewriter) rewriteGoMod(src []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	var changed bool
+	var block string // the directive of the block being read, if any
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for s.Scan() {
+		line := s.Text()
+		fields := strings.Fields(line)
+		if i := strings.Index(line, "//"); i >= 0 {
+			fields = strings.Fields(line[:i])
+		}
+		directive := block
+		switch {
+		case len(fields) == 0:
+		case block != "" && fields[0] == ")":
+			block, directive = "", ""
+		case block == "" && len(fields) == 2 && fields[1] == "(":
+			block, directive = fields[0], ""
+		case block == "":
+			directive, fields = fields[0], fields[1:]
+		}
+
+		var paths []string
+		switch directive {
+		case "module", "require", "exclude":
+			if len(fields) > 0 {
+				paths = fields[:1]
+			}
+		case "replace":
+			for i, f := range fields {
+				// the module replaced, and the replacement, unless it's a directory
+				if i == 0 || i > 0 && fields[i-1] == "=>" && !strings.HasPrefix(f, ".") && !strings.HasPrefix(f, "/") {
+					paths = append(paths, f)
+				}
+			}
+		}
+		for _, p := range paths {
+			unquoted, err := strconv.Unquote(p)
+			if err != nil {
+				unquoted = p
+			}
+			if rewritten, ok := r.rewrite(unquoted); ok {
+				if unquoted != p {
+					rewritten = strconv.Quote(rewritten)
+				}
+				line = replaceField(line, p, rewritten)
+				changed = true
+			}
+		}
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	return out.Bytes(), changed
+}
+
+// replaceField replaces the first whitespace-delimited occurrence of field in line with rewritten.
+func replaceField(line, field, rewritten string) string {
+	for i := 0; ; {
+		j := strings.Index(line[i:], field)
+		if j < 0 {
+			return line
+		}
+		start, end := i+j, i+j+len(field)
+		if (start == 0 || unicode.IsSpace(rune(line[start-1]))) && (end == len(line) || unicode.IsSpace(rune(line[end]))) {
+			return line[:start] + rewritten + line[end:]
+		}
+		i = end
+	}
+}
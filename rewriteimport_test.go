@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestRewriteImport(t *testing.T) {
+	runCommandCases(t, "rewrite-import")
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"golang.org/x/tools/go/packages"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A rewriteRule is a rule of gofmt -r, "pattern -> replacement", where each identifier of a single lowercase letter
+// is a wildcard, matching any expression. It's matched by syntax alone, without type checking the rule or the
+// packages it rewrites, so it needs no template, but can't tell apart expressions which look alike.
+type rewriteRule struct {
+	text             string
+	pattern, replace ast.Expr
+}
+
+// parseRule parses the rule of -r.
+func parseRule(rule string) (*rewriteRule, error) {
+	f := strings.Split(rule, "->")
+	if len(f) != 2 {
+		return nil, fmt.Errorf("invalid -r %q: want the form 'pattern -> replacement'", rule)
+	}
+	r := &rewriteRule{text: rule}
+	for i, s := range f {
+		e, err := parser.ParseExpr(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid -r %q: %s doesn't parse: %v", rule, strings.TrimSpace(s), err)
+		}
+		if i == 0 {
+			r.pattern = e
+		} else {
+			r.replace = e
+		}
+	}
+	return r, nil
+}
+
+// applyRule applies the rule of -r to the packages matched by args, as built for p, as applyTemplate does a
+// template. Only the packages' syntax is loaded.
+func applyRule(r *rewriteRule, args []string, configs *configs, p platform, done map[string]bool) error {
+	m := metrics.template(r.text)
+	start := time.Now()
+	defer func() { m.DurationSeconds += time.Since(start).Seconds() }()
+	fSet := token.NewFileSet()
+	cfg := &packages.Config{Mode: packages.NeedName | packages.NeedFiles, Env: p.env(), Dir: moduleDir}
+	var err error
+	if cfg.Tests, err = configs.tests(); err != nil {
+		return err
+	}
+	pkgs, err := packages.Load(cfg, args...)
+	if err != nil {
+		return fmt.Errorf("load: %v", err)
+	}
+	if cfg.Tests {
+		pkgs = dropTestDuplicates(pkgs)
+	}
+	if packages.PrintErrors(pkgs) > 0 && !*tolerateFlag {
+		return errors.New("error loading packages")
+	}
+	m.Packages += len(pkgs)
+	if p == (platform{}) {
+		progressf("visiting %v packages\n", len(pkgs))
+	} else {
+		progressf("visiting %v packages for %s\n", len(pkgs), p)
+	}
+
+	var hadErrors bool
+	emit := func(f matchedFile) error {
+		m.addMatches(f.pkg.PkgPath, f.matches)
+		m.addFile(f.filename)
+		progressf("=== %s (%d matches)\n", f.filename, f.matches)
+		info := editInfo{pkg: f.pkg.PkgPath, template: r.text, matches: f.matches, found: f.found}
+		if err := emitFile(fSet, f.filename, f.file, info); err != nil {
+			if _, ok := err.(abortError); ok {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "eg: %s\n", err)
+			reportFailure(f.filename, err)
+			hadErrors = true
+			m.Failures++
+		}
+		return nil
+	}
+	var sampled []matchedFile // the files matched, for -sample to choose among
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			fmt.Fprintf(os.Stderr, "skipping %s, since it has errors\n", pkg.PkgPath)
+			continue
+		}
+		// the files are parsed here, since go/packages only parses those it type checks
+		for _, filename := range pkg.GoFiles {
+			conf, err := configs.forDir(filepath.Dir(filename))
+			if err != nil {
+				return err
+			}
+			if conf.excluded(filename) || !conf.inScope(r.text, filename) || done[filename] || cgoProcessed(pkg, filename) {
+				continue
+			}
+			src, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			file, err := conflicts.ParseFile(fSet, filename, src)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "eg: %s: skipped, since it doesn't parse: %v\n", filename, err)
+				reportFailure(filename, err)
+				hadErrors = true
+				m.Failures++
+				continue
+			}
+			m.Files++
+			var found []jsonMatch
+			n := r.rewriteFile(fSet, file, func(pos token.Pos) bool {
+				if reason := conf.forbidden(fSet, file, pos); reason != "" {
+					reportForbidden(fSet, pos, reason)
+					return false
+				}
+				return budget.allows(filename)
+			}, func(orig, repl ast.Node) {
+				if *jsonFlag {
+					found = append(found, newJSONMatch(fSet, pkg.PkgPath, r.text, orig, repl))
+				}
+			})
+			if n == 0 {
+				continue
+			}
+			done[filename] = true
+			f := matchedFile{pkg: pkg, filename: filename, file: file, matches: n, found: found}
+			if sample.enabled() {
+				sampled = append(sampled, f)
+				continue
+			}
+			if err := emit(f); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range sample.choose(sampled) {
+		if err := emit(f); err != nil {
+			return err
+		}
+	}
+	if hadErrors {
+		return errRewrites
+	}
+	return nil
+}
+
+// rewriteFile rewrites each match of the rule's pattern in f, innermost first, as gofmt -r does, returning the
+// number of matches. A match is only rewritten if allow accepts its position, and rewritten is called with each
+// that is.
+func (r *rewriteRule) rewriteFile(fSet *token.FileSet, f *ast.File, allow func(pos token.Pos) bool,
+	rewritten func(orig, repl ast.Node)) int {
+	cmap := ast.NewCommentMap(fSet, f, f.Comments)
+	var n int
+	filename := fSet.File(f.Pos()).Name()
+	env := make(map[string]reflect.Value)
+	pattern, replace := reflect.ValueOf(r.pattern), reflect.ValueOf(r.replace)
+	var rewrite func(val reflect.Value) reflect.Value
+	rewrite = func(val reflect.Value) reflect.Value {
+		if !val.IsValid() {
+			return reflect.Value{}
+		}
+		val = ruleApply(rewrite, val)
+		for k := range env {
+			delete(env, k)
+		}
+		if ruleMatch(env, pattern, val) {
+			pos := val.Interface().(ast.Node).Pos()
+			if !allow(pos) {
+				return val
+			}
+			budget.spend(filename)
+			n++
+			repl := ruleSubst(env, replace, reflect.ValueOf(pos))
+			rewritten(val.Interface().(ast.Node), repl.Interface().(ast.Node))
+			val = repl
+		}
+		return val
+	}
+	ruleApply(rewrite, reflect.ValueOf(f))
+	f.Comments = cmap.Filter(f).Comments()
+	return n
+}
+
+var (
+	objectPtrNil = reflect.ValueOf((*ast.Object)(nil))
+	scopePtrNil  = reflect.ValueOf((*ast.Scope)(nil))
+
+	identType     = reflect.TypeOf((*ast.Ident)(nil))
+	objectPtrType = reflect.TypeOf((*ast.Object)(nil))
+	positionType  = reflect.TypeOf(token.NoPos)
+	callExprType  = reflect.TypeOf((*ast.CallExpr)(nil))
+	scopePtrType  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// ruleSet sets x to y, unless it can't be set, or y isn't assignable to it, when the rewrite is abandoned.
+func ruleSet(x, y reflect.Value) {
+	if !x.CanSet() || !y.IsValid() || !y.Type().AssignableTo(x.Type()) {
+		return
+	}
+	x.Set(y)
+}
+
+// ruleApply replaces each child of val with the result of f, returning val.
+func ruleApply(f func(reflect.Value) reflect.Value, val reflect.Value) reflect.Value {
+	if !val.IsValid() {
+		return reflect.Value{}
+	}
+	// objects and scopes, which introduce cycles and are wrong after a rewrite, are dropped
+	if val.Type() == objectPtrType {
+		return objectPtrNil
+	}
+	if val.Type() == scopePtrType {
+		return scopePtrNil
+	}
+	switch v := reflect.Indirect(val); v.Kind() {
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			e := v.Index(i)
+			ruleSet(e, f(e))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			e := v.Field(i)
+			ruleSet(e, f(e))
+		}
+	case reflect.Interface:
+		ruleSet(v, f(v.Elem()))
+	}
+	return val
+}
+
+// isRuleWildcard reports whether name is a wildcard of a rule: a single lowercase letter.
+func isRuleWildcard(name string) bool {
+	r, size := utf8.DecodeRuneInString(name)
+	return size == len(name) && unicode.IsLower(r)
+}
+
+// ruleMatch reports whether val matches pattern, binding the wildcards in env, if it isn't nil. A wildcard
+// appearing more than once must match the same expression each time.
+func ruleMatch(env map[string]reflect.Value, pattern, val reflect.Value) bool {
+	if env != nil && pattern.IsValid() && pattern.Type() == identType {
+		name := pattern.Interface().(*ast.Ident).Name
+		if isRuleWildcard(name) && val.IsValid() {
+			if _, ok := val.Interface().(ast.Expr); ok && !val.IsNil() {
+				if old, ok := env[name]; ok {
+					return ruleMatch(nil, old, val)
+				}
+				env[name] = val
+				return true
+			}
+		}
+	}
+
+	if !pattern.IsValid() || !val.IsValid() {
+		return !pattern.IsValid() && !val.IsValid()
+	}
+	if pattern.Type() != val.Type() {
+		return false
+	}
+	switch pattern.Type() {
+	case identType:
+		p, v := pattern.Interface().(*ast.Ident), val.Interface().(*ast.Ident)
+		return p == nil && v == nil || p != nil && v != nil && p.Name == v.Name
+	case objectPtrType, positionType:
+		return true
+	case callExprType:
+		// f(x) and f(x...) differ only by the position of the ellipsis
+		p, v := pattern.Interface().(*ast.CallExpr), val.Interface().(*ast.CallExpr)
+		if p.Ellipsis.IsValid() != v.Ellipsis.IsValid() {
+			return false
+		}
+	}
+
+	p, v := reflect.Indirect(pattern), reflect.Indirect(val)
+	if !p.IsValid() || !v.IsValid() {
+		return !p.IsValid() && !v.IsValid()
+	}
+	switch p.Kind() {
+	case reflect.Slice:
+		if p.Len() != v.Len() {
+			return false
+		}
+		for i := 0; i < p.Len(); i++ {
+			if !ruleMatch(env, p.Index(i), v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < p.NumField(); i++ {
+			if !ruleMatch(env, p.Field(i), v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Interface:
+		return ruleMatch(env, p.Elem(), v.Elem())
+	}
+	return p.Interface() == v.Interface()
+}
+
+// ruleSubst returns a copy of pattern with its wildcards replaced by their bindings in env, and its positions, if
+// pos is valid, by pos.
+func ruleSubst(env map[string]reflect.Value, pattern, pos reflect.Value) reflect.Value {
+	if !pattern.IsValid() {
+		return reflect.Value{}
+	}
+	if env != nil && pattern.Type() == identType {
+		if name := pattern.Interface().(*ast.Ident).Name; isRuleWildcard(name) {
+			if old, ok := env[name]; ok {
+				return ruleSubst(nil, old, reflect.Value{})
+			}
+		}
+	}
+	if pos.IsValid() && pattern.Type() == positionType {
+		// positions absent from the pattern, e.g. CallExpr.Ellipsis, stay so
+		if !pattern.Interface().(token.Pos).IsValid() {
+			return pattern
+		}
+		return pos
+	}
+
+	switch p := pattern; p.Kind() {
+	case reflect.Slice:
+		if p.IsNil() {
+			return reflect.Zero(p.Type())
+		}
+		v := reflect.MakeSlice(p.Type(), p.Len(), p.Len())
+		for i := 0; i < p.Len(); i++ {
+			v.Index(i).Set(ruleSubst(env, p.Index(i), pos))
+		}
+		return v
+	case reflect.Struct:
+		v := reflect.New(p.Type()).Elem()
+		for i := 0; i < p.NumField(); i++ {
+			v.Field(i).Set(ruleSubst(env, p.Field(i), pos))
+		}
+		return v
+	case reflect.Ptr:
+		v := reflect.New(p.Type().Elem())
+		if elem := p.Elem(); elem.IsValid() {
+			v.Elem().Set(ruleSubst(env, elem, pos))
+		}
+		return v
+	case reflect.Interface:
+		v := reflect.New(p.Type()).Elem()
+		if elem := p.Elem(); elem.IsValid() {
+			v.Set(ruleSubst(env, elem, pos))
+		}
+		return v
+	}
+	return pattern
+}
@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestRewriteRule(t *testing.T) {
+	runMainCases(t, "rule")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ruleguardMetavar matches a metavariable of a ruleguard pattern: $x, $_, or, matching any number of expressions,
+// $*x.
+var ruleguardMetavar = regexp.MustCompile(`\$(\*?[A-Za-z_][A-Za-z0-9_]*)`)
+
+// A ruleguardMatch is a call of Match of a ruleguard rule, with the calls chained to it.
+type ruleguardMatch struct {
+	patterns []string
+	types    map[string]string // of the metavariables, by name, as the Where clause requires with Type.Is
+	suggest  string
+	report   string
+}
+
+// loadRuleguard translates the rules of the ruleguard (go-ruleguard, as go-critic runs) rules file at filename to
+// those of a config: those whose metavariables all have their types given by Where become templates, those with
+// none rewrite rules. A rule needs a Suggest, which is its after. The rules which can't be translated are
+// reported, with the reason.
+func loadRuleguard(filename string) ([]templateRule, error) {
+	fSet := token.NewFileSet()
+	f, err := parser.ParseFile(fSet, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var rules []templateRule
+	var failed int
+	names := make(map[string]bool)
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil || len(fn.Type.Params.List) != 1 || len(fn.Type.Params.List[0].Names) != 1 {
+			continue
+		}
+		if sel, ok := fn.Type.Params.List[0].Type.(*ast.SelectorExpr); !ok || sel.Sel.Name != "Matcher" {
+			continue
+		}
+		m := fn.Type.Params.List[0].Names[0].Name
+		var imports []string
+		for _, stmt := range fn.Body.List {
+			call, ok := ruleguardRoot(stmt, m)
+			if !ok {
+				continue
+			}
+			if call.method == "Import" {
+				if path, err := stringArg(call.args, 0); err == nil {
+					imports = append(imports, path)
+				}
+				continue
+			}
+			match, err := parseRuleguardMatch(stmt.(*ast.ExprStmt).X, m)
+			if err == nil {
+				var translated []templateRule
+				if translated, err = match.translate(imports); err == nil {
+					for _, r := range translated {
+						r.Name = uniqueName(fn.Name.Name, names)
+						rules = append(rules, r)
+					}
+					continue
+				}
+			}
+			fmt.Fprintf(os.Stderr, "%s: rule %s not translated: %s\n", fSet.Position(stmt.Pos()), fn.Name.Name, err)
+			failed++
+		}
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules translated", filename)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "eg: translated %d rules of %s, and left %d\n", len(rules), filename, failed)
+	}
+	return rules, nil
+}
+
+// A ruleguardCall is a call of a method of a chain of them.
+type ruleguardCall struct {
+	method string
+	args   []ast.Expr
+}
+
+// ruleguardRoot returns the first call of the chain of stmt, if it's one of calls of methods of the matcher m.
+func ruleguardRoot(stmt ast.Stmt, m string) (ruleguardCall, bool) {
+	es, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return ruleguardCall{}, false
+	}
+	calls, ok := ruleguardChain(es.X, m)
+	if !ok || len(calls) == 0 {
+		return ruleguardCall{}, false
+	}
+	return calls[0], true
+}
+
+// ruleguardChain returns the calls of the chain e, m.A(...).B(...)..., in order, and whether it's one.
+func ruleguardChain(e ast.Expr, m string) ([]ruleguardCall, bool) {
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return nil, false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil, false
+	}
+	var calls []ruleguardCall
+	if id, ok := sel.X.(*ast.Ident); !ok || id.Name != m {
+		if calls, ok = ruleguardChain(sel.X, m); !ok {
+			return nil, false
+		}
+	}
+	return append(calls, ruleguardCall{sel.Sel.Name, call.Args}), true
+}
+
+// parseRuleguardMatch parses the rule e, a chain of calls of the matcher m starting with Match.
+func parseRuleguardMatch(e ast.Expr, m string) (*ruleguardMatch, error) {
+	calls, _ := ruleguardChain(e, m)
+	if calls[0].method != "Match" {
+		return nil, fmt.Errorf("%s isn't supported", calls[0].method)
+	}
+	match := &ruleguardMatch{types: make(map[string]string)}
+	for _, call := range calls {
+		switch call.method {
+		case "Match":
+			for i := range call.args {
+				p, err := stringArg(call.args, i)
+				if err != nil {
+					return nil, err
+				}
+				match.patterns = append(match.patterns, p)
+			}
+		case "Where":
+			if len(call.args) != 1 {
+				return nil, errors.New("a Where without one condition")
+			}
+			if err := match.where(call.args[0], m); err != nil {
+				return nil, err
+			}
+		case "Suggest":
+			s, err := stringArg(call.args, 0)
+			if err != nil {
+				return nil, err
+			}
+			match.suggest = s
+		case "Report":
+			match.report, _ = stringArg(call.args, 0)
+		case "At":
+			return nil, errors.New("At isn't supported, since a match is rewritten as a whole")
+		default:
+			return nil, fmt.Errorf("%s isn't supported", call.method)
+		}
+	}
+	if match.suggest == "" {
+		return nil, errors.New("no Suggest, so nothing to rewrite")
+	}
+	return match, nil
+}
+
+// where records the types the condition of a Where requires of the metavariables: conjunctions of
+// m["x"].Type.Is("T").
+func (match *ruleguardMatch) where(cond ast.Expr, m string) error {
+	switch cond := cond.(type) {
+	case *ast.ParenExpr:
+		return match.where(cond.X, m)
+	case *ast.BinaryExpr:
+		if cond.Op != token.LAND {
+			return fmt.Errorf("the condition %s isn't supported", cond.Op)
+		}
+		if err := match.where(cond.X, m); err != nil {
+			return err
+		}
+		return match.where(cond.Y, m)
+	case *ast.CallExpr:
+		// m["x"].Type.Is("T")
+		if is, ok := cond.Fun.(*ast.SelectorExpr); ok && is.Sel.Name == "Is" {
+			if typ, ok := is.X.(*ast.SelectorExpr); ok && typ.Sel.Name == "Type" {
+				if index, ok := typ.X.(*ast.IndexExpr); ok {
+					if id, ok := index.X.(*ast.Ident); ok && id.Name == m {
+						name, err := stringArg([]ast.Expr{index.Index}, 0)
+						if err != nil {
+							return err
+						}
+						t, err := stringArg(cond.Args, 0)
+						if err != nil {
+							return err
+						}
+						match.types[name] = t
+						return nil
+					}
+				}
+			}
+		}
+	}
+	return errors.New("only conditions of the form m[\"x\"].Type.Is(\"T\"), and conjunctions of them, are supported")
+}
+
+// translate returns the config rules equivalent to match, one for each of its patterns, with imports.
+func (match *ruleguardMatch) translate(imports []string) ([]templateRule, error) {
+	if strings.Contains(match.suggest, "$$") {
+		return nil, errors.New("a Suggest of $$, the match, isn't supported")
+	}
+	var rules []templateRule
+	for _, pattern := range match.patterns {
+		for _, s := range []string{pattern, match.suggest} {
+			if strings.Contains(s, "$*") {
+				return nil, errors.New("metavariables matching any number of expressions ($*) aren't supported")
+			}
+		}
+		r := templateRule{Doc: match.report}
+		var names []string
+		seen := make(map[string]bool)
+		for _, v := range ruleguardMetavar.FindAllString(pattern, -1) {
+			if !seen[v[1:]] {
+				seen[v[1:]] = true
+				names = append(names, v[1:])
+			}
+		}
+		var typed int
+		for _, name := range names {
+			if match.types[name] != "" {
+				typed++
+			}
+		}
+		switch {
+		case len(names) > 0 && typed == len(names) && !seen["_"]:
+			// a template, whose wildcards are the metavariables, of the types required
+			params := make([]string, len(names))
+			for i, name := range names {
+				params[i] = name + " " + match.types[name]
+			}
+			unmeta := func(s string) string {
+				return ruleguardMetavar.ReplaceAllStringFunc(s, func(v string) string { return v[1:] })
+			}
+			r.Params, r.Before, r.After, r.Imports = strings.Join(params, ", "), unmeta(pattern), unmeta(match.suggest), imports
+			if _, err := r.source(); err != nil {
+				return nil, err
+			}
+		case len(match.types) == 0:
+			rewrite, err := metavarRewrite(pattern, match.suggest, ruleguardMetavar)
+			if err != nil {
+				return nil, err
+			}
+			r.Rewrite = rewrite
+		default:
+			return nil, errors.New("the types of some metavariables, but not all, are required, which a template can't express")
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// stringArg returns the i'th of args, a string literal.
+func stringArg(args []ast.Expr, i int) (string, error) {
+	if i < len(args) {
+		if lit, ok := args[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			return strconv.Unquote(lit.Value)
+		}
+	}
+	return "", errors.New("an argument which isn't a string literal")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadRuleguard checks the config rules the rules of testdata/ruleguard/rules.go are translated to, against
+// rules.golden, and the rules reported as left.
+func TestLoadRuleguard(t *testing.T) {
+	var rules []templateRule
+	_, stderr, err := captureOutput(t, func() (err error) {
+		rules, err = loadRuleguard(filepath.Join("testdata", "ruleguard", "rules.go"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := yaml.Marshal(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, filepath.Join("testdata", "ruleguard", "rules.golden"), string(got), false)
+
+	for _, want := range []string{
+		"rules.go:21:2: rule partlyTyped not translated: the types of some metavariables, but not all",
+		"rules.go:27:2: rule noSuggest not translated: no Suggest, so nothing to rewrite",
+		"rules.go:31:2: rule at not translated: At isn't supported",
+		"eg: translated 3 rules of testdata/ruleguard/rules.go, and left 3",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("loadRuleguard didn't report %q, but\n%s", want, stderr)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"golang.org/x/tools/go/packages"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A fileSample is the subset of the files matched which -sample rewrites, chosen by a hash of their paths, so that
+// the same tree always yields the same sample.
+type fileSample struct {
+	percent float64 // of the files matched, for -sample 5%
+	files   int     // the number of files, for -sample 20files
+	chosen  map[string]bool
+	taken   int
+	rest    []string // the files matched but left out
+}
+
+var sample = fileSample{chosen: make(map[string]bool)}
+
+// parseSample parses the -sample flag, a percentage of the files matched, e.g. 5%, or a number of them, e.g. 20files.
+func parseSample(s string) error {
+	switch {
+	case s == "":
+		return nil
+	case strings.HasSuffix(s, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return fmt.Errorf("invalid -sample %q: want a percentage, greater than 0%%", s)
+		}
+		sample.percent = p
+	case strings.HasSuffix(s, "files"), strings.HasSuffix(s, "file"):
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(s, "s"), "file"))
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid -sample %q: want a number of files, greater than 0", s)
+		}
+		sample.files = n
+	default:
+		return fmt.Errorf("invalid -sample %q: want a percentage of the files matched, e.g. 5%%, or a number of them, e.g. 20files", s)
+	}
+	return nil
+}
+
+// enabled reports whether -sample was given, so that the files matched are chosen among before they're written.
+func (s *fileSample) enabled() bool {
+	return s.percent > 0 || s.files > 0
+}
+
+// A matchedFile is a file rewritten in memory, waiting for -sample to choose whether to write it.
+type matchedFile struct {
+	pkg       *packages.Package
+	filename  string
+	file      *ast.File
+	matches   int
+	syntactic bool
+	found     []jsonMatch // the matches, for -json
+	gaps      []eg.Gap    // for editInfo
+}
+
+// choose returns those of files which are in the sample, in order, recording the rest. A file's choice is made
+// once, so that each template of the run agrees: by its hash, for a percentage, or, for a number of files, by
+// the order of the hashes of those matched, until there are enough.
+func (s *fileSample) choose(files []matchedFile) []matchedFile {
+	byHash := append([]matchedFile(nil), files...)
+	sort.Slice(byHash, func(i, j int) bool {
+		hi, hj := sampleHash(byHash[i].filename), sampleHash(byHash[j].filename)
+		return hi < hj || hi == hj && byHash[i].filename < byHash[j].filename
+	})
+	for _, f := range byHash {
+		if _, ok := s.chosen[f.filename]; ok {
+			continue
+		}
+		var in bool
+		if s.percent > 0 {
+			in = float64(sampleHash(f.filename)%10000) < s.percent*100
+		} else {
+			in = s.taken < s.files
+		}
+		s.chosen[f.filename] = in
+		if in {
+			s.taken++
+		} else {
+			s.rest = append(s.rest, f.filename)
+		}
+	}
+	var chosen []matchedFile
+	for _, f := range files {
+		if s.chosen[f.filename] {
+			chosen = append(chosen, f)
+		}
+	}
+	return chosen
+}
+
+// sampleHash hashes the path of filename relative to its module's root, so that the sample is the same in any
+// checkout.
+func sampleHash(filename string) uint32 {
+	name := filename
+	if root := moduleRoot(filepath.Dir(filename)); root != "" {
+		if rel, err := filepath.Rel(root, filename); err == nil {
+			name = filepath.ToSlash(rel)
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+// report lists the files matched which the sample left, for the full rollout.
+func (s *fileSample) report() {
+	if len(s.rest) == 0 {
+		return
+	}
+	sort.Strings(s.rest)
+	fmt.Fprintf(os.Stderr, "eg: -sample rewrote %d of the files matched, leaving %d for the full rollout:\n", s.taken, len(s.rest))
+	for _, name := range s.rest {
+		fmt.Fprintf(os.Stderr, "\t%s\n", name)
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+// TestSample checks the files -sample chooses, which, since they're chosen by a hash of their paths within the
+// module, are the same in each case's temporary copy.
+func TestSample(t *testing.T) {
+	runMainCases(t, "sample")
+}
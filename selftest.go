@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/egrun"
+	"github.com/jwilner/eg/internal/diff"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const testUsage = `Usage: eg test [-update] [-v] <templates>...
+
+Runs the golden-file tests of each template. Arguments are template files or
+package patterns (e.g. ./templates/...) whose files are templates. For a
+template <dir>/<name>.go, every directory <dir>/testdata/<name>/ containing
+input.go and golden.go, or <dir>/testdata/<name>/<case>/ containing them, is a
+test case: the template is applied to input.go and the result compared with
+golden.go, printing a diff on failure.
+
+Templates may also carry their own examples as comments, which are run too:
+
+	// eg:test in: fmt.Errorf("%s", s) out: errors.New(s)
+
+or, spread over several lines (the final out line is the replacement and any
+lines before it are the statements the template inserts before it):
+
+	// eg:test
+	// in: fmt.Errorf("%s", s)
+	// out: errors.New(s)
+
+Each line beginning eg:test is a case of its own. The holes of the template
+may be referred to by name, and the packages it imports by their names.
+
+-update  rewrite the golden files with the actual output
+-v       show verbose matcher diagnostics
+`
+
+// A templateCase is a test case of a template: either a golden-file case or one synthesized from an inline eg:test
+// comment.
+type templateCase struct {
+	name          string
+	input, golden string // paths of the input and expected output files
+	src, want     []byte // the synthesized input and expected output of an inline case
+}
+
+// inline reports whether c was synthesized from an eg:test comment, in which case only the body of its function is
+// compared, since the template may add imports.
+func (c templateCase) inline() bool { return c.want != nil }
+
+func testMain(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, testUsage) }
+	update := fs.Bool("update", false, "rewrite the golden files with the actual output")
+	verbose := fs.Bool("v", false, "show verbose matcher diagnostics")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	tmplPaths, err := egrun.FindTemplates("", fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(tmplPaths) == 0 {
+		return errors.New("no templates found")
+	}
+
+	var failed bool
+	for _, tmplPath := range tmplPaths {
+		cases, err := findCases(tmplPath)
+		if err != nil {
+			return err
+		}
+		if len(cases) == 0 {
+			fmt.Printf("?    \t%s\t[no test cases]\n", tmplPath)
+			continue
+		}
+		ok, err := runCases(tmplPath, cases, *update, *verbose)
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL\t%s\t%v\n", tmplPath, err)
+			failed = true
+		case !ok:
+			fmt.Printf("FAIL\t%s\n", tmplPath)
+			failed = true
+		default:
+			fmt.Printf("ok   \t%s\t%d case(s)\n", tmplPath, len(cases))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// findCases returns the golden-file test cases of the template at tmplPath.
+func findCases(tmplPath string) ([]templateCase, error) {
+	name := strings.TrimSuffix(filepath.Base(tmplPath), ".go")
+	root := filepath.Join(filepath.Dir(tmplPath), "testdata", name)
+
+	var cases []templateCase
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return filepath.SkipDir
+		}
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		input, golden := filepath.Join(path, "input.go"), filepath.Join(path, "golden.go")
+		if _, err := os.Stat(input); err == nil {
+			caseName := name
+			if rel, _ := filepath.Rel(root, path); rel != "." {
+				caseName += "/" + filepath.ToSlash(rel)
+			}
+			cases = append(cases, templateCase{name: caseName, input: input, golden: golden})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inline, err := inlineCases(tmplPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(cases, inline...), nil
+}
+
+// inlineCases synthesizes test cases from the eg:test comments of the template at tmplPath.
+func inlineCases(tmplPath string) ([]templateCase, error) {
+	src, err := ioutil.ReadFile(tmplPath)
+	if err != nil {
+		return nil, err
+	}
+	fSet := token.NewFileSet()
+	f, err := parser.ParseFile(fSet, tmplPath, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	beforeDecl, _ := templateFuncs(f)
+	if beforeDecl == nil {
+		return nil, nil
+	}
+
+	// the holes are declared as parameters of the synthesized function, spelled as they are in the template.
+	params := string(src[fSet.Position(beforeDecl.Type.Params.Pos()).Offset:fSet.Position(beforeDecl.Type.Params.End()).Offset])
+	void := beforeDecl.Type.Results == nil || len(beforeDecl.Type.Results.List) == 0
+	name := strings.TrimSuffix(filepath.Base(tmplPath), ".go")
+
+	var cases []templateCase
+	for _, cg := range f.Comments {
+		for _, t := range inlineTests(fSet, cg) {
+			in, out := parseInlineTest(t.text)
+			if in == "" || out == "" {
+				return nil, fmt.Errorf("%s: eg:test needs both in: and out:", fSet.Position(t.pos))
+			}
+			line := fSet.Position(t.pos).Line
+			cases = append(cases, templateCase{
+				name:  fmt.Sprintf("%s/line%d", name, line),
+				input: fmt.Sprintf("%s:%d", tmplPath, line),
+				src:   inlineSource(f, params, in, void),
+				want:  inlineSource(f, params, out, void),
+			})
+		}
+	}
+	return cases, nil
+}
+
+// An inlineTest is the text of an eg:test comment, following the eg:test, and the position it starts at.
+type inlineTest struct {
+	pos  token.Pos
+	text string
+}
+
+// inlineTests returns the eg:test comments of the group cg. Each line beginning eg:test starts one: the rest of the
+// line, if there is any, is the whole of it, and otherwise it's the lines which follow, up to the next eg:test.
+func inlineTests(fSet *token.FileSet, cg *ast.CommentGroup) []inlineTest {
+	var tests []inlineTest
+	var cur *inlineTest
+	for _, c := range cg.List {
+		text := strings.TrimPrefix(c.Text, "//")
+		if strings.HasPrefix(c.Text, "/*") {
+			text = strings.TrimSuffix(strings.TrimPrefix(c.Text, "/*"), "*/")
+		}
+		for i, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "eg:test") {
+				if cur != nil {
+					cur.text += line + "\n"
+				}
+				continue
+			}
+			pos := c.Pos()
+			if i > 0 {
+				pos = fSet.File(pos).LineStart(fSet.Position(pos).Line + i)
+			}
+			tests = append(tests, inlineTest{pos: pos})
+			cur = &tests[len(tests)-1]
+			if rest := strings.TrimSpace(strings.TrimPrefix(line, "eg:test")); rest != "" {
+				cur.text, cur = rest, nil
+			}
+		}
+	}
+	return tests
+}
+
+// parseInlineTest extracts the in and out snippets of the text of an eg:test comment. The markers in: and out: are
+// only recognized outside the snippets' string and rune literals.
+func parseInlineTest(text string) (in, out string) {
+	var cur *[]string
+	var ins, outs []string
+	for _, line := range strings.Split(text, "\n") {
+		for {
+			line = strings.TrimSpace(line)
+			i, o := markerIndex(line, "in:"), markerIndex(line, "out:")
+			switch {
+			case i == 0:
+				cur, line = &ins, line[len("in:"):]
+				continue
+			case o == 0:
+				cur, line = &outs, line[len("out:"):]
+				continue
+			case o > 0 && cur != nil:
+				*cur = append(*cur, line[:o])
+				line = line[o:]
+				continue
+			}
+			break
+		}
+		if cur != nil && line != "" {
+			*cur = append(*cur, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(ins, "\n")), strings.TrimSpace(strings.Join(outs, "\n"))
+}
+
+// markerIndex returns the index of the first instance of marker in line which begins it or follows a space, and
+// isn't within a string or rune literal, or -1 if there's none.
+func markerIndex(line, marker string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\' && quote != '`':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case strings.HasPrefix(line[i:], marker) && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return i
+		}
+	}
+	return -1
+}
+
+// inlineSource synthesizes a single-file package whose only function takes the template's holes as parameters and
+// evaluates snippet, making available those of the template's imports the function refers to.
+func inlineSource(tmplFile *ast.File, params, snippet string, void bool) []byte {
+	lines := strings.Split(snippet, "\n")
+	stmts, expr := lines[:len(lines)-1], lines[len(lines)-1]
+	if !void {
+		expr = "_ = " + expr
+	}
+	body := strings.Join(append(stmts, expr), "\n\t")
+
+	var buf bytes.Buffer
+	buf.WriteString("package egtest\n\n")
+	for _, imp := range tmplFile.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if ref := name + "."; strings.Contains(params, ref) || strings.Contains(body, ref) {
+			fmt.Fprintf(&buf, "import %s %s\n", name, imp.Path.Value)
+		}
+	}
+	fmt.Fprintf(&buf, "\nfunc _%s {\n\t%s\n}\n", params, body)
+	return buf.Bytes()
+}
+
+// runCases applies the template at tmplPath to the input of each case, reporting whether every output matched its
+// golden file. If update is set, the golden files are rewritten instead.
+func runCases(tmplPath string, cases []templateCase, update, verbose bool) (bool, error) {
+	fSet := token.NewFileSet()
+
+	// parse the inputs first to learn which packages must be loaded alongside the template: they need to share
+	// its type universe for the matcher's object identity checks to succeed.
+	inputs := make([]*ast.File, len(cases))
+	failed := make([]bool, len(cases))
+	imports := make(map[string]bool)
+	for i, c := range cases {
+		var src interface{}
+		if c.src != nil {
+			src = c.src
+		}
+		f, err := parser.ParseFile(fSet, c.input, src, parser.ParseComments)
+		if err != nil && c.inline() {
+			// the error is at a position in the synthesized source, which means nothing to the template's author
+			if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+				err = errors.New(list[0].Msg)
+			}
+			fmt.Printf("--- FAIL: %s\n\t%s: in: %v\n", c.name, c.input, err)
+			failed[i] = true
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		inputs[i] = f
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			imports[path] = true
+		}
+	}
+	var paths []string
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	tmplPkg, tmplFile, imp, err := loadTemplateWith(fSet, tmplPath, paths)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	for i, c := range cases {
+		if failed[i] {
+			ok = false
+			continue
+		}
+		got, err := applyToFile(fSet, tmplPkg, tmplFile, inputs[i], imp, verbose)
+		if err != nil {
+			fmt.Printf("--- FAIL: %s\n\t%v\n", c.name, err)
+			ok = false
+			continue
+		}
+		if c.inline() {
+			want, err := inlineBody(c.want)
+			if err == nil {
+				got, err = inlineBody(got)
+			}
+			if err != nil {
+				fmt.Printf("--- FAIL: %s\n\t%v\n", c.name, err)
+				ok = false
+			} else if d := diff.Unified("out", "output", want, got); d != nil {
+				fmt.Printf("--- FAIL: %s\n%s", c.name, d)
+				ok = false
+			}
+			continue
+		}
+		if update {
+			if err := ioutil.WriteFile(c.golden, got, 0644); err != nil {
+				return false, err
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(c.golden)
+		if err != nil {
+			fmt.Printf("--- FAIL: %s\n\t%v\n", c.name, err)
+			ok = false
+			continue
+		}
+		if d := diff.Unified(c.golden, "output", want, got); d != nil {
+			fmt.Printf("--- FAIL: %s\n%s", c.name, d)
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// applyToFile type checks f as a single-file package and returns its source after applying the template.
+func applyToFile(fSet *token.FileSet, tmplPkg *packages.Package, tmplFile, f *ast.File, imp types.Importer, verbose bool) ([]byte, error) {
+	pkg, info, err := checkFile(fSet, f, imp)
+	if err != nil {
+		return nil, err
+	}
+
+	xform, err := eg.NewTransformer(fSet, tmplPkg.Types, tmplFile, tmplPkg.TypesInfo, verbose)
+	if err != nil {
+		return nil, err
+	}
+	xform.Transform(info, pkg, f)
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fSet, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inlineBody returns the formatted statements of the function synthesized for an inline case.
+func inlineBody(src []byte) ([]byte, error) {
+	fSet := token.NewFileSet()
+	f, err := parser.ParseFile(fSet, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			for _, stmt := range fn.Body.List {
+				if err := format.Node(&buf, fSet, stmt); err != nil {
+					return nil, err
+				}
+				buf.WriteByte('\n')
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestTest(t *testing.T) {
+	runCommandCases(t, "test")
+}
+
+func TestParseInlineTest(t *testing.T) {
+	for _, test := range []struct {
+		text, in, out string
+	}{
+		{`in: f(x) out: g(x)`, "f(x)", "g(x)"},
+		{"\nin: f(x)\nout: g(x)\n", "f(x)", "g(x)"},
+		{"\nin: f(x)\nout: y := h(x)\ng(y)\n", "f(x)", "y := h(x)\ng(y)"},
+		{`in: f("out: x") out: g("out: x")`, `f("out: x")`, `g("out: x")`},
+		{"in: f(`in: x`, 'o') out: g(\"\\\" out: x\")", "f(`in: x`, 'o')", "g(\"\\\" out: x\")"},
+		{`in: checkout: f(x)`, "checkout: f(x)", ""},
+	} {
+		if in, out := parseInlineTest(test.text); in != test.in || out != test.out {
+			t.Errorf("parseInlineTest(%q) = %q, %q, want %q, %q", test.text, in, out, test.in, test.out)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jwilner/eg/internal/diff"
+	"io/ioutil"
+	"os"
+)
+
+// A sourceEdit is a range of the bytes of a file as it was, [OldStart, OldEnd), replaced by the range [NewStart,
+// NewEnd) of those of its rewrite. The offsets outside any edit are moved by those of the edits preceding them.
+type sourceEdit struct {
+	OldStart int `json:"old_start"`
+	OldEnd   int `json:"old_end"`
+	NewStart int `json:"new_start"`
+	NewEnd   int `json:"new_end"`
+}
+
+// A sourceMap is the edits of a file's rewrite, in order.
+type sourceMap struct {
+	File  string       `json:"file"`
+	Edits []sourceEdit `json:"edits"`
+}
+
+var (
+	sourceMaps  []*sourceMap              // of the files rewritten, in the order of their first rewrite
+	sourceOrigs = make(map[string][]byte) // the source of each file as it was before the run
+)
+
+// recordSourceMap records the map of the rewrite of filename as src for -sourcemap. A file rewritten more than
+// once, e.g. for each of -platforms, is mapped from its source before the run to its last rewrite.
+func recordSourceMap(filename string, src []byte) error {
+	orig, ok := sourceOrigs[filename]
+	if !ok {
+		var err error
+		if orig, err = ioutil.ReadFile(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		sourceOrigs[filename] = orig
+		sourceMaps = append(sourceMaps, &sourceMap{File: filename})
+	}
+	for _, m := range sourceMaps {
+		if m.File == filename {
+			m.Edits = sourceEdits(orig, src)
+		}
+	}
+	return nil
+}
+
+// sourceEdits returns the edits rewriting a as b: the hunks of the diff of their lines, each narrowed to the bytes
+// which differ, and split by line if it rewrites as many lines as it replaces.
+func sourceEdits(a, b []byte) []sourceEdit {
+	edits := []sourceEdit{}
+	var aOff, bOff int
+	var removed, inserted []string // the lines of the hunk
+	flush := func() {
+		if len(removed) == 0 && len(inserted) == 0 {
+			return
+		}
+		aStart, bStart := aOff, bOff
+		for _, l := range removed {
+			aOff += len(l)
+		}
+		for _, l := range inserted {
+			bOff += len(l)
+		}
+		if len(removed) != len(inserted) {
+			edits = append(edits, narrowEdit(sourceEdit{aStart, aOff, bStart, bOff}, a, b))
+		} else {
+			for i := range removed {
+				if removed[i] != inserted[i] {
+					e := sourceEdit{aStart, aStart + len(removed[i]), bStart, bStart + len(inserted[i])}
+					edits = append(edits, narrowEdit(e, a, b))
+				}
+				aStart += len(removed[i])
+				bStart += len(inserted[i])
+			}
+		}
+		removed, inserted = nil, nil
+	}
+	for _, op := range diff.Lines(diff.SplitLines(a), diff.SplitLines(b)) {
+		switch op.Kind {
+		case ' ':
+			flush()
+			aOff += len(op.Line)
+			bOff += len(op.Line)
+		case '-':
+			removed = append(removed, op.Line)
+		case '+':
+			inserted = append(inserted, op.Line)
+		}
+	}
+	flush()
+	return edits
+}
+
+// narrowEdit returns e with the bytes its old and new ranges begin and end with in common left out.
+func narrowEdit(e sourceEdit, a, b []byte) sourceEdit {
+	for e.OldStart < e.OldEnd && e.NewStart < e.NewEnd && a[e.OldStart] == b[e.NewStart] {
+		e.OldStart++
+		e.NewStart++
+	}
+	for e.OldStart < e.OldEnd && e.NewStart < e.NewEnd && a[e.OldEnd-1] == b[e.NewEnd-1] {
+		e.OldEnd--
+		e.NewEnd--
+	}
+	return e
+}
+
+// writeSourceMap writes the maps of the files rewritten to filename, as JSON.
+func writeSourceMap(filename string) error {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	maps := sourceMaps
+	if maps == nil {
+		maps = []*sourceMap{}
+	}
+	if err := enc.Encode(maps); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, out.Bytes(), 0666)
+}
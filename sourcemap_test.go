@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSourceEdits(t *testing.T) {
+	for _, test := range []struct {
+		name, a, b string
+		want       []sourceEdit
+	}{
+		{
+			name: "unchanged",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: []sourceEdit{},
+		},
+		{
+			name: "narrowed", // to the bytes between those the lines begin and end with in common
+			a:    "x := 1\nif s == \"\" {\n",
+			b:    "x := 1\nif len(s) == 0 {\n",
+			want: []sourceEdit{{10, 17, 10, 21}},
+		},
+		{
+			name: "by line", // a hunk rewriting as many lines as it replaces
+			a:    "a1\nb\nc1\n",
+			b:    "a2\nb\nc2\n",
+			want: []sourceEdit{{1, 2, 1, 2}, {6, 7, 6, 7}},
+		},
+		{
+			name: "inserted",
+			a:    "a\nc\n",
+			b:    "a\nb\nc\n",
+			want: []sourceEdit{{2, 2, 2, 4}},
+		},
+		{
+			name: "removed",
+			a:    "a\nb\nc\n",
+			b:    "a\nc\n",
+			want: []sourceEdit{{2, 4, 2, 2}},
+		},
+		{
+			name: "replaced by more",
+			a:    "a\nb\nd\n",
+			b:    "a\nb1\nb2\nd\n",
+			want: []sourceEdit{{3, 3, 3, 7}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := sourceEdits([]byte(test.a), []byte(test.b))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			// the edits rewrite a as b
+			var out []byte
+			var off int
+			for _, e := range got {
+				if e.NewStart-len(out) != e.OldStart-off {
+					t.Errorf("the edit %+v doesn't follow the bytes before it", e)
+				}
+				out = append(append(out, test.a[off:e.OldStart]...), test.b[e.NewStart:e.NewEnd]...)
+				off = e.OldEnd
+			}
+			if out = append(out, test.a[off:]...); string(out) != test.b {
+				t.Errorf("the edits rewrite %q as %q, want %q", test.a, out, test.b)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// templateNotFound returns the error reporting that the template at tmplPath wasn't in any loaded package, explaining
+// why, as far as can be told, and how to fix it.
+func templateNotFound(tmplPath string) error {
+	why, fix := whyNotLoaded(tmplPath)
+	return fmt.Errorf("didn't find template %s in the loaded packages: %s\n\tsuggestion: %s", tmplPath, why, fix)
+}
+
+// whyNotLoaded returns the reason why the go command wouldn't load the file at path as part of a package, and a
+// suggested fix.
+func whyNotLoaded(path string) (why, fix string) {
+	base := filepath.Base(path)
+	dir := filepath.Dir(path)
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		return "it doesn't exist", "check the path given with -t"
+	case err != nil:
+		return err.Error(), "check the path given with -t"
+	case info.IsDir():
+		return "it's a directory", "name a template file with -t; directories of them may be listed in .eg.yaml"
+	case filepath.Ext(base) != ".go":
+		// e.g. a template.go.txt, kept from the go command
+		name := strings.TrimSuffix(base, filepath.Ext(base))
+		if filepath.Ext(name) != ".go" {
+			name += ".go"
+		}
+		return "its name doesn't end in .go, so the go command doesn't treat it as Go source", "rename it to " + name
+	case strings.HasSuffix(base, "_test.go"):
+		return "it's a test file, which isn't part of its package's build",
+			"rename it to " + strings.TrimSuffix(base, "_test.go") + ".go"
+	case strings.HasPrefix(base, "_") || strings.HasPrefix(base, "."):
+		return "the go command ignores files whose names start with _ or .",
+			"rename it to " + strings.TrimLeft(base, "_.")
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err.Error(), "check the file is readable"
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), path, src, parser.PackageClauseOnly); err != nil {
+		return "its package clause doesn't parse: " + err.Error(), "start it with a package clause, e.g. package template"
+	}
+	if ok, err := build.Default.MatchFile(dir, base); err == nil && !ok {
+		return "its build constraints, or a GOOS or GOARCH suffix of its name, exclude it from the build",
+			"remove the constraint, or pass the tags it needs with -tags"
+	}
+	if moduleRoot(dir) == "" && os.Getenv("GO111MODULE") != "off" {
+		return "it isn't within a module, since neither its directory nor any above it has a go.mod",
+			"move it into the module it rewrites, or run go mod init in its directory"
+	}
+	return "the go command didn't report it as part of any package",
+		fmt.Sprintf("check what go list -e -json file=%s reports", path)
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+// TestTemplateNotFound checks the explanations, and the fixes suggested, for templates which aren't part of any
+// package loaded.
+func TestTemplateNotFound(t *testing.T) {
+	runMainCases(t, "template-diagnostics")
+}
+
+// TestTemplateCheck checks that a template which doesn't type check fails with each of its errors, naming the
+// function they're in.
+func TestTemplateCheck(t *testing.T) {
+	runMainCases(t, "template-check")
+}
@@ -0,0 +1,6 @@
+package config
+
+// Timeout is the timeout, in seconds.
+var Timeout = 30
+
+func double() { Timeout *= 2 }
@@ -0,0 +1,10 @@
+package p
+
+import "example.com/m/config"
+
+func F() int {
+	config.Timeout = 3
+	config.Timeout += 2
+	config.Timeout++
+	return config.Timeout * 2
+}
@@ -0,0 +1,3 @@
+package config
+
+var Limits [2]int
@@ -0,0 +1,10 @@
+package p
+
+import "example.com/m/config"
+
+func F() [2]int {
+	config.Limits[0] = 1
+	p := &config.Limits
+	config.Limits = [2]int{}
+	return *p
+}
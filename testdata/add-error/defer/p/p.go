@@ -0,0 +1,10 @@
+package p
+
+func Load(n int) int { return n }
+
+func F() error {
+	defer Load(1)
+	go Load(2)
+	defer println(Load(3))
+	return nil
+}
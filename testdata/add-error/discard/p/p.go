@@ -0,0 +1,10 @@
+package p
+
+func Load(n int) int { return n }
+
+func F() error {
+	Load(1)
+	v := Load(2)
+	println(v, Load(3))
+	return nil
+}
@@ -0,0 +1,13 @@
+package p
+
+func Load(n int) int { return n }
+
+func F(ok bool) error {
+	if Load(1); ok {
+	}
+	if v := Load(2); v > 0 {
+	}
+	for i := 0; i < 3; Load(i) {
+	}
+	return nil
+}
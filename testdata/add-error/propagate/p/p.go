@@ -0,0 +1,25 @@
+package p
+
+func Load(n int) int { return n }
+
+func Stmt() error {
+	Load(1)
+	return nil
+}
+
+func Assign() error {
+	v := Load(2)
+	var w int
+	w = Load(3)
+	var x = Load(4)
+	println(v, w, x)
+	return nil
+}
+
+func Operand() (int, error) {
+	return Load(5) + 1, nil
+}
+
+func NoError() {
+	Load(6)
+}
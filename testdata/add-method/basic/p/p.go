@@ -0,0 +1,26 @@
+package p
+
+import "errors"
+
+type Name string
+
+func (n Name) Validate() error {
+	if n == "" {
+		return errors.New("empty")
+	}
+	return nil
+}
+
+type Config struct{ Port int }
+
+func (c *Config) Validate() error { return nil }
+
+func (c *Config) String() string { return "config" }
+
+type Done struct{}
+
+func (Done) Validate() error { return nil }
+
+func (Done) MustValidate() {}
+
+type Other struct{}
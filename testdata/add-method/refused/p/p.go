@@ -0,0 +1,9 @@
+package p
+
+type Config struct{ MustValidate func() }
+
+func (c Config) Validate() error { return nil }
+
+type List[E any] []E
+
+func (List[E]) Validate() error { return nil }
@@ -0,0 +1,16 @@
+package must
+
+type matches interface {
+	Validate() error
+}
+
+type T struct{ matches }
+
+// MustValidate panics if typeName, the type's name, isn't valid.
+func (t T) MustValidate() {
+	if err := t.Validate(); err != nil {
+		panic(typeName + ": " + err.Error())
+	}
+}
+
+const typeName = ""
@@ -0,0 +1,13 @@
+package q
+
+import (
+	"fmt"
+
+	"example.com/m/p"
+)
+
+func Print(names ...string) {
+	for _, name := range names {
+		fmt.Println(p.Load(name))
+	}
+}
@@ -0,0 +1,9 @@
+package p
+
+// Load loads the named thing, and its parent.
+func Load(name string) string {
+	if name == "" {
+		return ""
+	}
+	return name + Load(name[1:])
+}
@@ -0,0 +1,17 @@
+package p
+
+type Getter interface {
+	Get(key string) string
+}
+
+type M map[string]string
+
+func (m M) Get(key string) string { return m[key] }
+
+type Empty struct{}
+
+func (Empty) Get(string) string { return "" }
+
+func Both(g Getter, m M) string {
+	return g.Get("a") + m.Get("b")
+}
@@ -0,0 +1,5 @@
+package p
+
+var n = 1
+
+func F(x int) int { return x + n }
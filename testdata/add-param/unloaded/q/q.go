@@ -0,0 +1,9 @@
+package q
+
+import "example.com/m/p"
+
+// Both calls are left broken, since q isn't among the packages rewritten.
+func Print() {
+	println(p.Load("a"))
+	println(p.Load("b"))
+}
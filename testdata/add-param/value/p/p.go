@@ -0,0 +1,9 @@
+package p
+
+func F(x int) int { return x }
+
+func G() int {
+	f := F
+	_ = F
+	return f(1) + F(2)
+}
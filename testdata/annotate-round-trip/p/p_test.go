@@ -0,0 +1,12 @@
+package p
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHasX(t *testing.T) {
+	if hasX("y") != (strings.Index("y", "x") != -1) {
+		t.Fail()
+	}
+}
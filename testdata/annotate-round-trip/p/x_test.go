@@ -0,0 +1,12 @@
+package p_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContains(t *testing.T) {
+	if !(strings.Index("x", "x") != -1) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,23 @@
+package p
+
+import (
+	"fmt"
+
+	"example.com/m/q"
+)
+
+func show(t *q.T) string {
+	return fmt.Sprintf("%v", t)
+}
+
+func label(n int) string {
+	return fmt.Sprintf("%v", n+1)
+}
+
+func name() string {
+	return fmt.Sprintf("%v", "x")
+}
+
+func show2(u *q.T) string {
+	return fmt.Sprintf("%v", u)
+}
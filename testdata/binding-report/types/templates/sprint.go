@@ -0,0 +1,6 @@
+package templates
+
+import "fmt"
+
+func before(x interface{}) string { return fmt.Sprintf("%v", x) }
+func after(x interface{}) string  { return fmt.Sprint(x) }
@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasAB(s string) (bool, bool) {
+	return strings.Index(s, "a") != -1, strings.Index(s, "b") != -1
+}
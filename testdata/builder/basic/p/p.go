@@ -0,0 +1,39 @@
+package p
+
+import "fmt"
+
+func Join(words []string) string {
+	var s string
+	for _, w := range words {
+		s += w
+	}
+	return s
+}
+
+func Initial(words []string) string {
+	s := "> "
+	for i, w := range words {
+		s = s + fmt.Sprint(i) + w
+	}
+	return s
+}
+
+func Twice(words []string) (string, int) {
+	var s string
+	for _, w := range words {
+		s += w
+	}
+	fmt.Println(s)
+	return s, len(s)
+}
+
+func Read(words []string) string {
+	var s string
+	for _, w := range words {
+		if len(s) > 10 {
+			break
+		}
+		s += w
+	}
+	return s
+}
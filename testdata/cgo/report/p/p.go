@@ -0,0 +1,10 @@
+package p
+
+// int two(void) { return 2; }
+import "C"
+
+import "strings"
+
+func hasX(s string) bool {
+	return strings.Index(s, "x") != -1 && C.two() == 2
+}
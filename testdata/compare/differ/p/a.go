@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasXYZ(s string) (bool, bool, bool) {
+	return strings.Index(s, "x") != -1, strings.Index(s, "y") != -1, strings.Index(s, "z") != -1
+}
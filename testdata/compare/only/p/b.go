@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasY(s string) bool {
+	return strings.Index(s, "y") >= 0
+}
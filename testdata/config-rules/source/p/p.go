@@ -0,0 +1,10 @@
+package p
+
+import (
+	"bytes"
+	"strings"
+)
+
+func hasX(s string, b []byte) bool {
+	return strings.Index(s, "x") != -1 && bytes.Index(b, []byte("x")) != -1
+}
@@ -0,0 +1,10 @@
+package api
+
+import (
+	"bytes"
+	"strings"
+)
+
+func f(s string, b []byte) bool {
+	return strings.Index(s, "x") != -1 || strings.Index(s, "y") == 0 || bytes.Index(b, []byte("x")) != -1
+}
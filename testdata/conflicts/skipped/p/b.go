@@ -0,0 +1,13 @@
+package p
+
+import "strings"
+
+<<<<<<< HEAD
+func hasY(s string) bool {
+	return strings.Index(s, "y") != -1
+}
+=======
+func hasY(s string) bool {
+	return strings.Index(s, "y") >= 0
+}
+>>>>>>> branch
@@ -0,0 +1,13 @@
+package log
+
+type Level int
+
+const (
+	Info Level = iota
+	Debug
+	LevelWarn
+)
+
+const LevelInfo = Info
+
+func SetLevel(l Level) {}
@@ -0,0 +1,14 @@
+package p
+
+import (
+	"os"
+
+	"example.com/m/log"
+)
+
+func F(f *os.File) {
+	f.Seek(0, os.SEEK_SET)
+	log.SetLevel(log.Info)
+	log.SetLevel(2)
+	log.SetLevel(1)
+}
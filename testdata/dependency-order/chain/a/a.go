@@ -0,0 +1,11 @@
+package a
+
+import (
+	"strings"
+
+	"example.com/m/b"
+)
+
+func HasXYZ(s string) bool {
+	return b.HasXY(s) && strings.Index(s, "z") != -1
+}
@@ -0,0 +1,11 @@
+package b
+
+import (
+	"strings"
+
+	"example.com/m/c"
+)
+
+func HasXY(s string) bool {
+	return c.HasX(s) && strings.Index(s, "y") != -1
+}
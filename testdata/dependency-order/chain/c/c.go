@@ -0,0 +1,7 @@
+package c
+
+import "strings"
+
+func HasX(s string) bool {
+	return strings.Index(s, "x") != -1
+}
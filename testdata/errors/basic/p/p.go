@@ -0,0 +1,35 @@
+package p
+
+import (
+	"io"
+	"os"
+)
+
+var ErrNotFound = io.ErrUnexpectedEOF
+
+type myErr struct{}
+
+func (myErr) Error() string { return "" }
+
+func (e myErr) Is(target error) bool { return target == io.EOF }
+
+func F(err error) string {
+	if err == io.EOF || err != ErrNotFound {
+		return "eof"
+	}
+	if err == nil {
+		return ""
+	}
+	local := io.EOF
+	if err == local {
+		return "local"
+	}
+	if e, ok := err.(*os.PathError); ok {
+		return e.Path
+	}
+	switch err {
+	case io.EOF:
+		return "switch"
+	}
+	return "other"
+}
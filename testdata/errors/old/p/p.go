@@ -0,0 +1,5 @@
+package p
+
+import "io"
+
+func F(err error) bool { return err == io.EOF }
@@ -0,0 +1,4 @@
+package templates
+
+func before(x int) int   { return x + 1 }
+func after(x int) string { return "" }
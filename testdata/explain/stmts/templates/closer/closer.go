@@ -0,0 +1,19 @@
+package templates
+
+import "os"
+
+func before(name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		panic(err)
+	}
+	f.Close()
+}
+
+func after(name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+}
@@ -0,0 +1,9 @@
+package templates
+
+import (
+	"bytes"
+	"io"
+)
+
+func before(w io.Writer, b *bytes.Buffer) (int64, error) { return io.Copy(w, b) }
+func after(w io.Writer, b *bytes.Buffer) (int64, error)  { return b.WriteTo(w) }
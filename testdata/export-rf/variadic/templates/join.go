@@ -0,0 +1,6 @@
+package templates
+
+import "fmt"
+
+func before(args ...interface{}) string { return fmt.Sprint(args...) }
+func after(args ...interface{}) string  { return fmt.Sprintln(args...) }
@@ -0,0 +1,7 @@
+package p
+
+import "example.com/m/q"
+
+func Greet(name string) string {
+	return "hello, " + q.Old(name)
+}
@@ -0,0 +1,11 @@
+package q
+
+// Old is deprecated: use New.
+func Old(name string) string { return New(name, false) }
+
+func New(name string, strict bool) string {
+	if strict {
+		return name + "!"
+	}
+	return name
+}
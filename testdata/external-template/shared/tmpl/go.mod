@@ -0,0 +1,7 @@
+module example.com/tmpl
+
+go 1.18
+
+require example.com/m v0.0.0
+
+replace example.com/m => ../
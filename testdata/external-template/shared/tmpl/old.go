@@ -0,0 +1,8 @@
+// Package tmpl is a central repository of templates, in a module of its own, which refer to the packages of the
+// modules they rewrite.
+package tmpl
+
+import "example.com/m/q"
+
+func before(name string) string { return q.Old(name) }
+func after(name string) string  { return q.New(name, false) }
@@ -0,0 +1,5 @@
+package q
+
+import "strings"
+
+func F(s string) bool { return strings.Index(s, "x") != -1 }
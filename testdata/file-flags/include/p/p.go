@@ -0,0 +1,9 @@
+package p
+
+import (
+	"strings"
+
+	"example.com/dep"
+)
+
+func F(s string) bool { return strings.Index(s, "x") != -1 || dep.F(s) }
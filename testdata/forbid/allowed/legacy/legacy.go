@@ -0,0 +1,7 @@
+package legacy
+
+import "strings"
+
+func hasV(s string) bool {
+	return strings.Index(s, "v") != -1
+}
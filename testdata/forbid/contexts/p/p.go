@@ -0,0 +1,26 @@
+package p
+
+import (
+	"strings"
+	"unsafe"
+)
+
+var hasX bool
+
+func init() {
+	hasX = strings.Index("x", "x") != -1
+}
+
+//go:nosplit
+func hasY(s string) bool {
+	return strings.Index(s, "y") != -1
+}
+
+func hasZ(s string) bool {
+	_ = unsafe.Sizeof(s)
+	return strings.Index(s, "z") != -1
+}
+
+func hasW(s string) bool {
+	return strings.Index(s, "w") != -1
+}
@@ -0,0 +1,17 @@
+package p
+
+import "fmt"
+
+func Describe(v interface{}, w any, name string) string {
+	fmt.Println(w)
+	switch v := v.(type) {
+	case int:
+		return fmt.Sprint(name, v)
+	}
+	return name + w.(string)
+}
+
+func use() []string {
+	f := Describe
+	return []string{Describe(1, "a", "x"), Describe(nil, "b", "y"), f(2, "c", "z")}
+}
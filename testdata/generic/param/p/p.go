@@ -0,0 +1,7 @@
+package p
+
+var last interface{}
+
+func Store(v interface{}) {
+	last = &v
+}
@@ -0,0 +1,8 @@
+package templates
+
+import "errors"
+
+// eg:go 1.13
+
+func before(err, target error) bool { return err == target }
+func after(err, target error) bool  { return errors.Is(err, target) }
@@ -0,0 +1,7 @@
+package p
+
+import "io"
+
+func eof(err error) bool {
+	return err == io.EOF
+}
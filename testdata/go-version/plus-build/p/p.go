@@ -0,0 +1,9 @@
+// +build go1.13
+
+package p
+
+import "io"
+
+func eof(err error) bool {
+	return err == io.EOF
+}
@@ -0,0 +1,3 @@
+package q
+
+type T struct{ N int }
@@ -0,0 +1,11 @@
+package p
+
+import (
+	"fmt"
+
+	"example.com/m/q"
+)
+
+func show(t *q.T) string {
+	return fmt.Sprintf("%v", t)
+}
@@ -0,0 +1,5 @@
+package metrics
+
+func Inc(name string) {}
+
+func Observe(name string, n int) {}
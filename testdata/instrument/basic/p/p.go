@@ -0,0 +1,11 @@
+package p
+
+type Store struct{}
+
+func (s *Store) Put(key string, v []byte) error { return nil }
+
+func F(s *Store, v []byte) error {
+	s.Put("a", v)
+	err := s.Put("b", v[1:])
+	return err
+}
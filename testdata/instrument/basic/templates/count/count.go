@@ -0,0 +1,16 @@
+package count
+
+import (
+	"example.com/m/metrics"
+	"example.com/m/p"
+)
+
+func before(s *p.Store, key string, v []byte) {
+	metrics.Inc(funcName)
+}
+
+func after(s *p.Store, key string, v []byte) {
+	metrics.Observe(key, len(v))
+}
+
+const funcName = ""
@@ -0,0 +1,18 @@
+package p
+
+type Store struct{}
+
+func (s *Store) Put(key string, v []byte) error { return nil }
+
+func g() string { return "" }
+
+func F(s *Store, v []byte) error {
+	if err := s.Put("b", v); err != nil {
+		return err
+	}
+	defer s.Put("c", v)
+	if v != nil && s.Put("d", v) == nil {
+	}
+	s.Put(g(), v)
+	return s.Put("e", nil)
+}
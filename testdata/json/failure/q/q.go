@@ -0,0 +1,8 @@
+package q
+
+var s string
+
+// v is rewritten at the package level, which the matcher panics for.
+var v = s == ""
+
+func F(t string) bool { return t == "" }
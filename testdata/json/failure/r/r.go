@@ -0,0 +1,3 @@
+package r
+
+func G(t string) bool { return t == "" }
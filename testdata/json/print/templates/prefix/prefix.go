@@ -0,0 +1,6 @@
+package templates
+
+import "strings"
+
+func before(s, prefix string) bool { return strings.Index(s, prefix) == 0 }
+func after(s, prefix string) bool  { return strings.HasPrefix(s, prefix) }
@@ -0,0 +1,11 @@
+package p
+
+import "strings"
+
+func hasX(s string) bool {
+	return strings.Index(s, "x") != -1
+}
+
+func startsX(s string) bool {
+	return strings.Index(s, "x") == 0
+}
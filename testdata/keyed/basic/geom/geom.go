@@ -0,0 +1,5 @@
+package geom
+
+type Point struct{ X, Y int }
+
+type Rect struct{ Min, Max Point }
@@ -0,0 +1,12 @@
+package p
+
+import "example.com/m/geom"
+
+type local struct{ A, B int }
+
+var (
+	R = geom.Rect{geom.Point{0, 0}, geom.Point{1, 2}}
+	P = []geom.Point{{3, 4}}
+	K = geom.Point{X: 5, Y: 6}
+	L = local{1, 2}
+)
@@ -0,0 +1,14 @@
+package p
+
+import "strings"
+
+//line gram.y:10
+func hasX(s string) bool {
+	return strings.Index(s, "x") != -1
+}
+
+func hasY(s string) bool {
+	return strings.Index(s,
+//line gram.y:20
+		"y") != -1
+}
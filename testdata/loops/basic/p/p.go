@@ -0,0 +1,67 @@
+package p
+
+import "sort"
+
+func Contains(s []string, x string) bool {
+	found := false
+	for _, v := range s {
+		if v == x {
+			found = true
+			break
+		}
+	}
+	return found
+}
+
+func Index(s []int, x int) int {
+	i := -1
+	for j, v := range s {
+		if v == x {
+			i = j
+			break
+		}
+	}
+	return i
+}
+
+func Returning(s []int, x int) bool {
+	for _, v := range s {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+func Clone(s []int) []int {
+	var out []int
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
+
+func Keys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Values(m map[string]int) []int {
+	var vals []int
+	for _, v := range m {
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+func Other(s []int) int {
+	var n int
+	for _, v := range s {
+		n += v
+	}
+	return n
+}
@@ -0,0 +1,10 @@
+package p
+
+func Contains(s []string, x string) bool {
+	for _, v := range s {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
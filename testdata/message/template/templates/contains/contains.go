@@ -0,0 +1,8 @@
+// strings.Contains says what's meant, where comparing the index with -1
+// makes the reader work it out.
+package templates
+
+import "strings"
+
+func before(s, sub string) bool { return strings.Index(s, sub) != -1 }
+func after(s, sub string) bool  { return strings.Contains(s, sub) }
@@ -0,0 +1,7 @@
+package q
+
+import "strings"
+
+func hasgen(s string) bool {
+	return strings.Index(s, "x") != -1
+}
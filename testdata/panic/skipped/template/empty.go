@@ -0,0 +1,4 @@
+package tmpl
+
+func before(s string) bool { return s == "" }
+func after(s string) bool  { return len(s) == 0 }
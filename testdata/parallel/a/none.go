@@ -0,0 +1,4 @@
+package a
+
+// None has no matches.
+func None(s string) int { return len(s) }
@@ -0,0 +1,18 @@
+package b
+
+// Empty2 reports whether s is empty.
+func Empty2(s string) bool {
+	return s == ""
+}
+
+func Print2(x int, s string) {
+	if s == "" {
+		println(x)
+		println(x)
+	}
+	// the same, twice
+	println(x)
+	println(x)
+	println(x)
+	println(x)
+}
@@ -0,0 +1,6 @@
+package b
+
+import "example.com/parallel/a"
+
+// None has no matches.
+func None(s string) int { return a.None(s) }
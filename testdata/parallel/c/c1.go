@@ -0,0 +1,18 @@
+package c
+
+// Empty1 reports whether s is empty.
+func Empty1(s string) bool {
+	return s == ""
+}
+
+func Print1(x int, s string) {
+	if s == "" {
+		println(x)
+		println(x)
+	}
+	// the same, twice
+	println(x)
+	println(x)
+	println(x)
+	println(x)
+}
@@ -0,0 +1,6 @@
+package c
+
+import "example.com/parallel/b"
+
+// None has no matches.
+func None(s string) int { return b.None(s) + 1 }
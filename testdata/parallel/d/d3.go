@@ -0,0 +1,18 @@
+package d
+
+// Empty3 reports whether s is empty.
+func Empty3(s string) bool {
+	return s == ""
+}
+
+func Print3(x int, s string) {
+	if s == "" {
+		println(x)
+		println(x)
+	}
+	// the same, twice
+	println(x)
+	println(x)
+	println(x)
+	println(x)
+}
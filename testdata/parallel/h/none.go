@@ -0,0 +1,4 @@
+package h
+
+// None has no matches.
+func None(s string) int { return len(s) }
@@ -0,0 +1,10 @@
+package template
+
+func before(x int) {
+	println(x)
+	println(x)
+}
+
+func after(x int) {
+	println(x, x)
+}
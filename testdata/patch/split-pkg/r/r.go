@@ -0,0 +1,7 @@
+package r
+
+import "strings"
+
+func hasY(s string) bool {
+	return strings.Index(s, "y") != -1
+}
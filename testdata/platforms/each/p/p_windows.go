@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func pwindows(s string) bool {
+	return strings.Index(s, "x") != -1
+}
@@ -0,0 +1,25 @@
+package p
+
+import "context"
+
+type Server struct{}
+
+func Handle() {
+	s := &Server{}
+	s.Serve(1)
+	helper()
+}
+
+func (s *Server) Serve(n int) {
+	fetch(context.TODO(), n)
+}
+
+func fetch(ctx context.Context, n int) {}
+
+func helper() {
+	fetch(context.TODO(), 2)
+}
+
+func Other() {
+	helper()
+}
@@ -0,0 +1,7 @@
+package p
+
+import "example.com/m/q"
+
+func Handle() {
+	q.Do()
+}
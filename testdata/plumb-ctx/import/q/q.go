@@ -0,0 +1,7 @@
+package q
+
+func Do() {}
+
+func Unrelated() {
+	Do()
+}
@@ -0,0 +1,15 @@
+// Package dep is a module required by the cases, served by the tests' module proxy.
+package dep
+
+// Upper returns s in upper case.
+func Upper(s string) string { return upper(s) }
+
+func upper(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return string(b)
+}
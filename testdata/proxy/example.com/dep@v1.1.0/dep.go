@@ -0,0 +1,18 @@
+// Package dep is a module required by the cases, served by the tests' module proxy.
+package dep
+
+// Upper returns s in upper case.
+//
+// Deprecated: use ToUpper.
+func Upper(s string) string { return ToUpper(s) }
+
+// ToUpper returns s in upper case. It's new in v1.1.0.
+func ToUpper(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return string(b)
+}
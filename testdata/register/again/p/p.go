@@ -0,0 +1,14 @@
+package p
+
+import (
+	_ "example.com/m/plugins"
+	"example.com/m/registry"
+)
+
+type Driver struct{}
+
+func (d *Driver) Open(name string) (int, error) { return 0, nil }
+
+func init() {
+	registry.Register("Driver", &Driver{})
+}
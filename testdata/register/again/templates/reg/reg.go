@@ -0,0 +1,15 @@
+package reg
+
+import "example.com/m/registry"
+
+type matches interface {
+	Open(name string) (int, error)
+}
+
+type T struct{ matches }
+
+func init() {
+	registry.Register(typeName, &T{})
+}
+
+const typeName = ""
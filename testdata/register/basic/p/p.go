@@ -0,0 +1,7 @@
+package p
+
+type Driver struct{}
+
+func (d *Driver) Open(name string) (int, error) { return 0, nil }
+
+type Other struct{}
@@ -0,0 +1,3 @@
+package registry
+
+func Register(name string, d interface{}) {}
@@ -0,0 +1,13 @@
+package p
+
+type Getter interface {
+	Get(key string, n int) string
+}
+
+type M map[string]string
+
+func (m M) Get(key string, n int) string { return m[key] }
+
+type Empty struct{}
+
+func (Empty) Get(string, int) string { return "" }
@@ -0,0 +1,9 @@
+package q
+
+import "example.com/m/p"
+
+const two = 2
+
+func Both(g p.Getter, m p.M, s []int) string {
+	return g.Get("a", 1) + m.Get("b", two*len(s))
+}
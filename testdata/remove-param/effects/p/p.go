@@ -0,0 +1,9 @@
+package p
+
+func Do(x, n int) int { return x }
+
+func g() int { return 0 }
+
+func F() int {
+	return Do(3, g())
+}
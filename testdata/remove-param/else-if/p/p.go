@@ -0,0 +1,16 @@
+package p
+
+func Do(x, n int) int { return x }
+
+func g() int { return 0 }
+
+func F(x int) int {
+	if x > 0 {
+		return 1
+	} else if x < 0 {
+		return 2
+	} else if Do(x, g()) == 0 {
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package p
+
+func Do(x, n int) int { return x }
+
+func Log(msg string, args ...int) {}
+
+func g() int { return 0 }
+
+func h() int { return 1 }
+
+func F(ok bool) int {
+	Do(1, g())
+	x := Do(2, g())
+	if Do(x, g()) > 0 {
+		return Do(h(), 0)
+	}
+	defer Do(4, g())
+	switch Do(5, g()) {
+	case 1:
+		x = 2 + Do(6, g())
+	}
+	for range []int{Do(7, g())} {
+	}
+	return x
+}
@@ -0,0 +1,11 @@
+package p
+
+func Do(x, n int) int { return x }
+
+func g() int { return 0 }
+
+func h() int { return 1 }
+
+func F() int {
+	return h() + Do(3, g())
+}
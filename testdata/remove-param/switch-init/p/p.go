@@ -0,0 +1,10 @@
+package p
+
+func Do(x, n int) int { return x }
+
+func g() int { return 0 }
+
+func F() {
+	switch y := Do(1, g()); y {
+	}
+}
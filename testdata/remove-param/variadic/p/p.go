@@ -0,0 +1,14 @@
+package p
+
+func Log(msg string, args ...int) {}
+
+func g() int { return 0 }
+
+func h() int { return 1 }
+
+func F() {
+	Log("a", g(), h())
+	Log("b",
+		g())
+	Log("c", 1, 2)
+}
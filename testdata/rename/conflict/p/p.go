@@ -0,0 +1,5 @@
+package p
+
+func Fetch() int { return 1 }
+
+func Get() int { return 2 }
@@ -0,0 +1,7 @@
+package p
+
+type User struct{ ID int }
+
+type Row struct{ ID int }
+
+func use(r Row) User { return User(r) }
@@ -0,0 +1,14 @@
+package p
+
+type User struct {
+	ID   int
+	Name string
+}
+
+type Order struct{ ID int }
+
+func use() int {
+	u := User{ID: 1, Name: "a"}
+	o := Order{ID: 2}
+	return u.ID + o.ID
+}
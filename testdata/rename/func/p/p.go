@@ -0,0 +1,8 @@
+package p
+
+func Fetch() int { return 1 }
+
+func use() int {
+	f := Fetch
+	return Fetch() + f()
+}
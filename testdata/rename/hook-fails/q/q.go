@@ -0,0 +1,5 @@
+package q
+
+import "example.com/m/p"
+
+func ID(u p.User) int { return u.ID }
@@ -0,0 +1,9 @@
+package p
+
+type Getter interface{ Get(key string) int }
+
+type mapGetter map[string]int
+
+func (m mapGetter) Get(key string) int { return m[key] }
+
+func use(g Getter) int { return g.Get("a") + mapGetter{}.Get("b") }
@@ -0,0 +1,14 @@
+package q
+
+import "example.com/m/p"
+
+type Getter struct{}
+
+func (Getter) Get(key string) int { return 0 }
+
+var _ p.Getter = Getter{}
+
+// Other doesn't implement p.Getter, so its Get is left alone.
+type Other struct{}
+
+func (Other) Get() int { return 1 }
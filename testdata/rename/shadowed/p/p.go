@@ -0,0 +1,8 @@
+package p
+
+func Fetch() int { return 1 }
+
+func use() int {
+	Get := 2
+	return Fetch() + Get
+}
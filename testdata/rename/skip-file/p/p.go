@@ -0,0 +1,4 @@
+// keep: the hook passes for this file
+package p
+
+type User struct{ ID int }
@@ -0,0 +1,8 @@
+package p
+
+type User struct {
+	UserID int    `json:"userID" db:"user_id"`
+	Name   string `json:"name"`
+}
+
+func use(u *User) int { return u.UserID }
@@ -0,0 +1,5 @@
+package lib
+
+func Old() int { return 1 }
+
+type T struct{ F int }
@@ -0,0 +1,5 @@
+package p
+
+import lib "example.com/lib.v2"
+
+func F() int { return lib.Old() + lib.T{F: 1}.F }
@@ -0,0 +1,7 @@
+package p
+
+import "example.com/dep"
+
+func Shout(s string) string {
+	return dep.Upper(s) + "!"
+}
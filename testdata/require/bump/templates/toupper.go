@@ -0,0 +1,9 @@
+// Package templates replaces the deprecated dep.Upper with dep.ToUpper, which is new in v1.1.0.
+package templates
+
+// eg:require example.com/dep v1.1.0
+
+import "example.com/dep"
+
+func before(s string) string { return dep.Upper(s) }
+func after(s string) string  { return dep.ToUpper(s) }
@@ -0,0 +1,13 @@
+// Package templates uses the upper casing of example.com/dep, at the version declared, rather than the latest, v1.1.0.
+package templates
+
+// eg:require example.com/dep v1.0.0
+
+import (
+	"strings"
+
+	"example.com/dep"
+)
+
+func before(s string) string { return strings.ToUpper(s) }
+func after(s string) string  { return dep.Upper(s) }
@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func Shout(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s)) + "!"
+}
@@ -0,0 +1,11 @@
+// Package templates uses the upper casing of example.com/dep, which the module rewritten doesn't require yet.
+package templates
+
+import (
+	"strings"
+
+	"example.com/dep"
+)
+
+func before(s string) string { return strings.ToUpper(s) }
+func after(s string) string  { return dep.Upper(s) }
@@ -0,0 +1,10 @@
+module example.com/m
+
+go 1.18
+
+require (
+	old.example.com/lib v1.2.0
+	old.example.com/library v1.0.0
+)
+
+replace old.example.com/lib => ../lib
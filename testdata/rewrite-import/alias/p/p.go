@@ -0,0 +1,11 @@
+package p
+
+import (
+	"fmt"
+
+	"old.example.com/lib"
+	sub "old.example.com/lib/sub"
+	"old.example.com/library"
+)
+
+func F() { fmt.Println(lib.X, sub.Y, library.Z) }
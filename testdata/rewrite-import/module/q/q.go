@@ -0,0 +1,3 @@
+package q
+
+const Y = 1
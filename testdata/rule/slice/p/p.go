@@ -0,0 +1,5 @@
+package p
+
+func tails(s, t []int) ([]int, []int) {
+	return s[1:len(s)], t[1:len(s)]
+}
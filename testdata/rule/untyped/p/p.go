@@ -0,0 +1,5 @@
+package p
+
+func tail(s []int) []int {
+	return undefined(s[1:len(s)])
+}
@@ -0,0 +1,32 @@
+//go:build ignore
+// +build ignore
+
+package gorules
+
+import "github.com/quasilyte/go-ruleguard/dsl"
+
+func contains(m dsl.Matcher) {
+	m.Match(`strings.Index($s, $sub) != -1`, `strings.Index($s, $sub) >= 0`).
+		Where(m["s"].Type.Is("string") && m["sub"].Type.Is("string")).
+		Suggest(`strings.Contains($s, $sub)`).
+		Report(`use strings.Contains`)
+}
+
+func writeString(m dsl.Matcher) {
+	m.Match(`$w.Write([]byte($s))`).
+		Suggest(`$w.WriteString($s)`)
+}
+
+func partlyTyped(m dsl.Matcher) {
+	m.Match(`$x == $y`).
+		Where(m["x"].Type.Is("error")).
+		Suggest(`errors.Is($x, $y)`)
+}
+
+func noSuggest(m dsl.Matcher) {
+	m.Match(`panic($_)`).Report(`don't panic`)
+}
+
+func at(m dsl.Matcher) {
+	m.Match(`fmt.Sprintf("%s", $x)`).At(m["x"]).Suggest(`fmt.Sprint($x)`)
+}
@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasC(s string) bool {
+	return strings.Index(s, "c") != -1
+}
@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasB(s string) bool {
+	return strings.Index(s, "b") != -1
+}
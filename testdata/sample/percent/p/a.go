@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasA(s string) bool {
+	return strings.Index(s, "a") != -1
+}
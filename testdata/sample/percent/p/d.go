@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasD(s string) bool {
+	return strings.Index(s, "d") != -1
+}
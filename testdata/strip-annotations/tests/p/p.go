@@ -0,0 +1,7 @@
+package p
+
+import "strings"
+
+func hasX(s string) bool {
+	return strings.Contains(s, "x") // MIGRATED(T1)
+}
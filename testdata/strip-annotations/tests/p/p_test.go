@@ -0,0 +1,12 @@
+package p
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHasX(t *testing.T) {
+	if hasX("y") != strings.Contains("y", "x") { // MIGRATED(T1)
+		t.Fail()
+	}
+}
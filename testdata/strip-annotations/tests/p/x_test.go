@@ -0,0 +1,12 @@
+package p_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContains(t *testing.T) {
+	if !strings.Contains("x", "x") { // MIGRATED(T1)
+		t.Fail()
+	}
+}
@@ -0,0 +1,6 @@
+package templates
+
+import "strings"
+
+func before(s, sub string) bool { return strings.Index(s, sub) != nil }
+func after(s, sub string) bool  { return strings.Contain(s, sub) }
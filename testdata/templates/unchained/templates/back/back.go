@@ -0,0 +1,6 @@
+package templates
+
+import "strings"
+
+func before(s, sub string) bool { return strings.Contains(s, sub) }
+func after(s, sub string) bool  { return strings.Index(s, sub) >= 0 }
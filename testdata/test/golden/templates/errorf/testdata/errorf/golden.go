@@ -0,0 +1,10 @@
+package p
+
+import (
+	"errors"
+	"fmt"
+)
+
+func f(name string) error {
+	return errors.New(name)
+}
@@ -0,0 +1,10 @@
+package p
+
+import (
+	"errors"
+	"fmt"
+)
+
+func f(name string) error {
+	return fmt.Errorf("%s: %w", name, errors.New(name))
+}
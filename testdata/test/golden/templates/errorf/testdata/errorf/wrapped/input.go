@@ -0,0 +1,7 @@
+package p
+
+import "fmt"
+
+func f(name string) error {
+	return fmt.Errorf("%s: %w", name, fmt.Errorf("%s", name))
+}
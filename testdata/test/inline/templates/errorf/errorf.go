@@ -0,0 +1,14 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+)
+
+// eg:test in: fmt.Errorf("%s", s) out: errors.New(s)
+// eg:test in: fmt.Errorf("%s", "out: "+s) out: errors.New("out: " + s)
+// eg:test
+// in: fmt.Errorf("%s", `in: x`)
+// out: errors.New(`in: x`)
+func before(s string) error { return fmt.Errorf("%s", s) }
+func after(s string) error  { return errors.New(s) }
@@ -0,0 +1,10 @@
+package p
+
+type Rect struct{ W, H int }
+
+func (r *Rect) Area(scale int) int { return r.W * r.H * scale }
+
+func use(r *Rect) int {
+	f := (*Rect).Area
+	return r.Area(2) + f(r, 1)
+}
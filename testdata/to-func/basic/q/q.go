@@ -0,0 +1,5 @@
+package q
+
+import "example.com/m/p"
+
+func F(r *p.Rect) int { return r.Area(3) }
@@ -0,0 +1,7 @@
+package p
+
+type Rect struct{ W, H int }
+
+func (r Rect) Area() int { return r.W * r.H }
+
+func use(r Rect) func() int { return r.Area }
@@ -0,0 +1,10 @@
+package p
+
+type Rect struct{ W, H int }
+
+func Area(r *Rect, scale int) int { return r.W * r.H * scale }
+
+func use(r *Rect) int {
+	f := Area
+	return Area(r, 2) + f(r, 1)
+}
@@ -0,0 +1,5 @@
+package q
+
+import "example.com/m/p"
+
+var X = p.Area(&p.Rect{W: 1, H: 2}, 3)
@@ -0,0 +1,7 @@
+package p
+
+type Rect struct{ W, H int }
+
+func RectArea(r Rect) int { return r.W * r.H }
+
+var X = RectArea(Rect{1, 2})
@@ -0,0 +1,7 @@
+package a
+
+import "strings"
+
+func HasX(s string) bool {
+	return strings.Index(s, "x") != -1
+}
@@ -0,0 +1,8 @@
+package a
+
+import "strings"
+
+// HasY is left, since its file doesn't type check, and the template needs its types.
+func HasY(s string) bool {
+	return strings.Index(s, "y") != -1 && undefined
+}
@@ -0,0 +1,6 @@
+package b
+
+import "strings"
+
+func HasX(s string) bool {
+	return strings.Index(s, "x") != -1
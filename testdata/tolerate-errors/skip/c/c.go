@@ -0,0 +1,7 @@
+package c
+
+import "strings"
+
+func HasZ(s string) bool {
+	return strings.Index(s, "z") != -1
+}
@@ -0,0 +1,7 @@
+package a
+
+import "fmt"
+
+func Show(n int) string {
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,8 @@
+package a
+
+import "fmt"
+
+// ShowAll is still rewritten, by its syntax alone, though its file doesn't type check.
+func ShowAll(ns []int) string {
+	return fmt.Sprint(ns) + undefined
+}
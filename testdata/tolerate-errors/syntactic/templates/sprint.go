@@ -0,0 +1,7 @@
+package templates
+
+import "fmt"
+
+// The wildcard is an empty interface, matching any expression, so the template needs no types.
+func before(x interface{}) string { return fmt.Sprint(x) }
+func after(x interface{}) string  { return fmt.Sprintf("%v", x) }
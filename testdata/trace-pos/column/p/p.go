@@ -0,0 +1,11 @@
+package p
+
+import "strings"
+
+func has(s string) bool {
+	return strings.Index(s, "x") != -1
+}
+
+func at(s string) bool {
+	return strings.Index(s, "x") != 0
+}
@@ -0,0 +1,10 @@
+package p
+
+import "example.com/m/server"
+
+func F(addr string, h func()) (*server.Server, server.Server, []*server.Server) {
+	a := &server.Server{Addr: addr, Handler: h}
+	b := server.Server{Addr: addr, Handler: h, Timeout: 0}
+	c := []*server.Server{{Addr: "c", Handler: h}}
+	return a, b, c
+}
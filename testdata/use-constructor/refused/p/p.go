@@ -0,0 +1,9 @@
+package p
+
+import "example.com/m/server"
+
+func F(addr string, h func()) (server.Server, *server.Server) {
+	b := server.Server{Addr: addr}
+	c := &server.Server{Addr: addr, Handler: h, Debug: true}
+	return b, c
+}
@@ -0,0 +1,14 @@
+package server
+
+import "time"
+
+type Server struct {
+	Addr    string
+	Handler func()
+	Timeout time.Duration
+	Debug   bool
+}
+
+func NewServer(addr string, h func(), timeout time.Duration) *Server {
+	return &Server{Addr: addr, Handler: h, Timeout: timeout}
+}
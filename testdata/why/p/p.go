@@ -0,0 +1,15 @@
+package p
+
+import (
+	"fmt"
+	"strings"
+)
+
+func F(s string) error {
+	if strings.Index(s, "x") != -1 {
+		return fmt.Errorf("%s", s)
+	}
+	errors := []error{nil}
+	errors[0] = fmt.Errorf("%s", s)
+	return errors[0]
+}
@@ -0,0 +1,11 @@
+package obs
+
+type Span struct{}
+
+func (Span) End() {}
+
+func Trace(name, fn string) Span { return Span{} }
+
+func Wrap(h func(string, int)) func(string, int) { return h }
+
+func Handle(h func(string, int)) {}
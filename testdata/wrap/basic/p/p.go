@@ -0,0 +1,20 @@
+package p
+
+import "example.com/m/obs"
+
+func Handle(name string, n int) {
+	println(name, n)
+}
+
+func Already(s string, k int) {
+	defer obs.Trace(s, "p.Already").End()
+	println(s, k)
+}
+
+func Other(n int) {}
+
+func Serve() {
+	obs.Handle(Handle)
+	obs.Handle(obs.Wrap(Already))
+	Handle("x", 1)
+}
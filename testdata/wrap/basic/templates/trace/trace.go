@@ -0,0 +1,16 @@
+package trace
+
+import "example.com/m/obs"
+
+func wrap(name string, n int) {
+	defer obs.Trace(name, funcName).End()
+	body()
+}
+
+func register(h func(string, int)) func(string, int) {
+	return obs.Wrap(h)
+}
+
+func body() {}
+
+const funcName = ""
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// goVersionDirective is the comment with which a template declares the minimum language version of its
+// replacement, e.g. "// eg:go 1.13" for a template producing errors.Is.
+const goVersionDirective = "eg:go"
+
+// templateGoVersion returns the minimum language version declared by tmplFile, or "" if it declares none.
+func templateGoVersion(tmplFile *ast.File) (string, error) {
+	for _, group := range tmplFile.Comments {
+		for _, c := range group.List {
+			text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+			if !strings.HasPrefix(text, goVersionDirective+" ") {
+				continue
+			}
+			v := strings.TrimPrefix(strings.TrimSpace(text[len(goVersionDirective):]), "go")
+			if _, ok := parseGoVersion(v); !ok {
+				return "", fmt.Errorf("invalid %s directive %q: want e.g. %s 1.13", goVersionDirective, c.Text, goVersionDirective)
+			}
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// requireDirective is the comment with which a template declares the minimum version of a module its replacement
+// needs, e.g. "// eg:require golang.org/x/sync v0.3.0" for one producing errgroup.WithContext.
+const requireDirective = "eg:require"
+
+// A moduleRequire is a module version a template requires.
+type moduleRequire struct {
+	path, version string
+}
+
+// templateRequires returns the module versions declared by tmplFile's eg:require directives.
+func templateRequires(tmplFile *ast.File) ([]moduleRequire, error) {
+	var reqs []moduleRequire
+	for _, group := range tmplFile.Comments {
+		for _, c := range group.List {
+			fields := strings.Fields(strings.TrimPrefix(c.Text, "//"))
+			if len(fields) == 0 || fields[0] != requireDirective {
+				continue
+			}
+			if len(fields) != 3 || !strings.HasPrefix(fields[2], "v") {
+				return nil, fmt.Errorf("invalid %s directive %q: want e.g. %s golang.org/x/sync v0.3.0",
+					requireDirective, c.Text, requireDirective)
+			}
+			reqs = append(reqs, moduleRequire{fields[1], fields[2]})
+		}
+	}
+	return reqs, nil
+}
+
+// semverLess reports whether the module version a precedes b, by semantic versioning: their numbers, and then
+// their prereleases, such as those of pseudo-versions, which precede the release.
+func semverLess(a, b string) bool {
+	split := func(v string) (nums []string, pre string) {
+		v = strings.TrimPrefix(v, "v")
+		if i := strings.IndexByte(v, '+'); i >= 0 {
+			v = v[:i]
+		}
+		if i := strings.IndexByte(v, '-'); i >= 0 {
+			v, pre = v[:i], v[i+1:]
+		}
+		return strings.Split(v, "."), pre
+	}
+	// less compares dot-separated identifiers, numbers numerically and preceding the others
+	less := func(x, y []string) (bool, bool) {
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if x[i] == y[i] {
+				continue
+			}
+			m, errM := strconv.Atoi(x[i])
+			n, errN := strconv.Atoi(y[i])
+			switch {
+			case errM == nil && errN == nil:
+				return m < n, true
+			case errM == nil || errN == nil:
+				return errM == nil, true
+			}
+			return x[i] < y[i], true
+		}
+		if len(x) != len(y) {
+			return len(x) < len(y), true
+		}
+		return false, false
+	}
+	aNums, aPre := split(a)
+	bNums, bPre := split(b)
+	if l, differ := less(aNums, bNums); differ {
+		return l
+	}
+	switch {
+	case aPre == bPre:
+		return false
+	case aPre == "" || bPre == "":
+		return bPre == ""
+	}
+	l, _ := less(strings.Split(aPre, "."), strings.Split(bPre, "."))
+	return l
+}
+
+// parseGoVersion parses a language version such as "1.13" or "1.21.0" into its major and minor numbers.
+func parseGoVersion(v string) ([2]int, bool) {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return [2]int{}, false
+	}
+	var nums [2]int
+	for i := range nums {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return [2]int{}, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
+
+// goVersionLess reports whether language version a predates b; both must be valid.
+func goVersionLess(a, b string) bool {
+	x, _ := parseGoVersion(a)
+	y, _ := parseGoVersion(b)
+	return x[0] < y[0] || x[0] == y[0] && x[1] < y[1]
+}
+
+// moduleVersions caches the go directive of the module enclosing each directory looked up.
+type moduleVersions map[string]string
+
+// lookup returns the language version of the module enclosing dir, and the path of its go.mod, or "" for both
+// if dir isn't within a module. A go.mod without a go directive is taken to be go 1.16, as by the go command.
+func (m moduleVersions) lookup(dir string) (version, gomod string, err error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		gomod = filepath.Join(d, "go.mod")
+		if v, ok := m[gomod]; ok {
+			return v, gomod, nil
+		}
+		if _, err := os.Stat(gomod); err == nil {
+			v, err := readGoDirective(gomod)
+			if err != nil {
+				return "", "", err
+			}
+			m[gomod] = v
+			return v, gomod, nil
+		}
+		if filepath.Dir(d) == d {
+			return "", "", nil
+		}
+	}
+}
+
+func readGoDirective(gomod string) (string, error) {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == "go" {
+			if _, ok := parseGoVersion(fields[1]); !ok {
+				return "", fmt.Errorf("%s: invalid go directive %q", gomod, fields[1])
+			}
+			return fields[1], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "1.16", nil
+}
+
+// A goRange is the range of the go versions a file can be built with: lo and later, if lo isn't "", and only
+// before hi, if hi isn't "".
+type goRange struct {
+	lo, hi string
+}
+
+// fileGoVersions returns the range of the go versions the build constraint of file, its //go:build line, or else
+// its // +build lines, allows it to be built with, from its goN.M tags. A malformed constraint allows any.
+func fileGoVersions(file *ast.File) (lo, hi string) {
+	var expr string
+	var plusBuild []string
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		for _, c := range group.List {
+			switch {
+			case strings.HasPrefix(c.Text, "//go:build "):
+				expr = strings.TrimPrefix(c.Text, "//go:build ")
+			case strings.HasPrefix(c.Text, "// +build "):
+				// space-separated options, OR'd, of comma-separated terms, AND'ed
+				var options []string
+				for _, option := range strings.Fields(strings.TrimPrefix(c.Text, "// +build ")) {
+					options = append(options, "("+strings.Replace(option, ",", " && ", -1)+")")
+				}
+				plusBuild = append(plusBuild, "("+strings.Join(options, " || ")+")")
+			}
+		}
+	}
+	if expr == "" {
+		expr = strings.Join(plusBuild, " && ")
+	}
+	if strings.TrimSpace(expr) == "" {
+		return "", ""
+	}
+	p := &constraintParser{toks: constraintTokens(expr)}
+	r := p.or(false)
+	if p.bad || p.i != len(p.toks) {
+		return "", ""
+	}
+	return r.lo, r.hi
+}
+
+// constraintTokens splits the build constraint expr into its operators, parentheses and tags.
+func constraintTokens(expr string) []string {
+	var toks []string
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '!' || c == '(' || c == ')':
+			toks = append(toks, expr[i:i+1])
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			toks = append(toks, expr[i:i+2])
+			i += 2
+		default:
+			j := i
+			for j < len(expr) && strings.IndexByte(" \t!()&|", expr[j]) < 0 {
+				j++
+			}
+			if j == i {
+				j++ // a lone & or |, which fails to parse
+			}
+			toks = append(toks, expr[i:j])
+			i = j
+		}
+	}
+	return toks
+}
+
+// A constraintParser evaluates the go versions a build constraint allows as it parses it, each of its methods
+// parsing the expression of that precedence, negated if neg is set.
+type constraintParser struct {
+	toks []string
+	i    int
+	bad  bool
+}
+
+func (p *constraintParser) next() string {
+	if p.i < len(p.toks) {
+		return p.toks[p.i]
+	}
+	return ""
+}
+
+func (p *constraintParser) or(neg bool) goRange {
+	r := p.and(neg)
+	for p.next() == "||" {
+		p.i++
+		r = combineRanges(r, p.and(neg), !neg)
+	}
+	return r
+}
+
+func (p *constraintParser) and(neg bool) goRange {
+	r := p.not(neg)
+	for p.next() == "&&" {
+		p.i++
+		r = combineRanges(r, p.not(neg), neg)
+	}
+	return r
+}
+
+func (p *constraintParser) not(neg bool) goRange {
+	switch tok := p.next(); tok {
+	case "!":
+		p.i++
+		return p.not(!neg)
+	case "(":
+		p.i++
+		r := p.or(neg)
+		if p.next() != ")" {
+			p.bad = true
+		}
+		p.i++
+		return r
+	case "", ")", "&&", "||":
+		p.bad = true
+		return goRange{}
+	default:
+		p.i++
+		v := strings.TrimPrefix(tok, "go")
+		if _, ok := parseGoVersion(v); !ok || !strings.HasPrefix(tok, "go1.") {
+			return goRange{}
+		}
+		if neg {
+			return goRange{hi: v}
+		}
+		return goRange{lo: v}
+	}
+}
+
+// combineRanges returns the range of the versions in either a or b, if either is set, or else in both.
+func combineRanges(a, b goRange, either bool) goRange {
+	// pick returns the earlier of the bounds x and y, or the later if later is set. A bound which isn't set, "",
+	// leaves the result unset too if unset is, or else it's the other.
+	pick := func(x, y string, later, unset bool) string {
+		switch {
+		case x == "" || y == "":
+			if unset {
+				return ""
+			}
+			return x + y
+		case goVersionLess(x, y) != later:
+			return x
+		}
+		return y
+	}
+	if either {
+		return goRange{lo: pick(a.lo, b.lo, false, true), hi: pick(a.hi, b.hi, true, true)}
+	}
+	return goRange{lo: pick(a.lo, b.lo, true, false), hi: pick(a.hi, b.hi, false, false)}
+}
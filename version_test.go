@@ -0,0 +1,41 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestGoVersion(t *testing.T) {
+	runMainCases(t, "go-version")
+}
+
+func TestFileGoVersions(t *testing.T) {
+	for _, test := range []struct {
+		constraint string
+		lo, hi     string
+	}{
+		{"", "", ""},
+		{"//go:build go1.13", "1.13", ""},
+		{"//go:build !go1.13", "", "1.13"},
+		{"//go:build go1.13 && !go1.18", "1.13", "1.18"},
+		{"//go:build go1.13 && linux", "1.13", ""},
+		{"//go:build go1.13 || linux", "", ""},
+		{"//go:build go1.13 || go1.15", "1.13", ""},
+		{"//go:build !(go1.13 || linux)", "", "1.13"},
+		{"//go:build go1.13 &&", "", ""},
+		{"// +build go1.13,!go1.18", "1.13", "1.18"},
+		{"// +build linux go1.13", "", ""},
+		{"// +build go1.13\n// +build !go1.18", "1.13", "1.18"},
+		{"//go:build go1.13\n// +build go1.15", "1.13", ""},
+	} {
+		src := test.constraint + "\n\npackage p\n"
+		file, err := parser.ParseFile(token.NewFileSet(), "p.go", src, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lo, hi := fileGoVersions(file); lo != test.lo || hi != test.hi {
+			t.Errorf("%q: got versions from %q before %q, want from %q before %q", test.constraint, lo, hi, test.lo, test.hi)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwilner/eg/egrun"
+	"github.com/jwilner/eg/internal/eg"
+	"go/ast"
+	"go/token"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const whyUsage = `Usage: eg why -t template.go file.go:line[:column]
+
+Attempts to match the template against the expressions at the given location
+and reports, for each one, exactly which comparison failed (wrong type,
+different callee, arity mismatch, ...) or the bindings of a successful match,
+and whether its replacement is refused, as a run would, for its type.
+Without a column, every expression beginning on the line is tried.
+`
+
+func whyMain(args []string) error {
+	fs := flag.NewFlagSet("why", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, whyUsage) }
+	tmplFlag := fs.String("t", "", "template.go file specifying the refactoring")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplFlag == "" {
+		return errors.New("no -t template.go file specified")
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	filename, line, col, err := parseLocation(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if filename, err = filepath.Abs(filename); err != nil {
+		return fmt.Errorf("unable to resolve %v: %v", fs.Arg(0), err)
+	}
+	tmplPath, err := filepath.Abs(*tmplFlag)
+	if err != nil {
+		return fmt.Errorf("unable to resolve tmpl flag: %v", *tmplFlag)
+	}
+
+	fSet := token.NewFileSet()
+	cfg := &packages.Config{Mode: egrun.LoadMode, Fset: fSet}
+	pkgs, err := packages.Load(cfg, "file="+tmplPath, "file="+filename)
+	if err != nil {
+		return fmt.Errorf("load: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		return errors.New("error loading packages")
+	}
+	xform, err := buildTransformer(tmplPath, fSet, &pkgs)
+	if err != nil {
+		return err
+	}
+	if xform.Statements() {
+		return fmt.Errorf("%s is a statement template, but eg why diagnoses the matching of expressions", tmplPath)
+	}
+
+	for _, pkg := range pkgs {
+		if file := findFile(fSet, pkg, filename); file != nil {
+			exprs := exprsAt(fSet, file, line, col)
+			if len(exprs) == 0 {
+				return fmt.Errorf("no expressions at %v", fs.Arg(0))
+			}
+			reportCandidates(os.Stdout, fSet, xform.Diagnose(pkg.TypesInfo, pkg.Types, exprs))
+			return nil
+		}
+	}
+	return fmt.Errorf("didn't find %v in any loaded package", filename)
+}
+
+// parseLocation splits a location of the form file.go:line[:column]; column is zero if absent.
+func parseLocation(loc string) (filename string, line, col int, err error) {
+	var nums []int
+	for len(nums) < 2 {
+		i := strings.LastIndex(loc, ":")
+		if i < 0 {
+			break
+		}
+		n, err := strconv.Atoi(loc[i+1:])
+		if err != nil {
+			break
+		}
+		nums = append([]int{n}, nums...)
+		loc = loc[:i]
+	}
+	if len(nums) == 0 || loc == "" {
+		return "", 0, 0, fmt.Errorf("invalid location %q: want file.go:line[:column]", loc)
+	}
+	if len(nums) == 2 {
+		col = nums[1]
+	}
+	return loc, nums[0], col, nil
+}
+
+// exprsAt returns the expressions enclosing line:col of file, innermost first, or, if col is zero, all the
+// expressions beginning on line, in source order.
+func exprsAt(fSet *token.FileSet, file *ast.File, line, col int) []ast.Expr {
+	tokFile := fSet.File(file.Pos())
+	if line < 1 || line > tokFile.LineCount() {
+		return nil
+	}
+
+	var exprs []ast.Expr
+	if col > 0 {
+		pos := tokFile.LineStart(line) + token.Pos(col-1)
+		path, _ := astutil.PathEnclosingInterval(file, pos, pos)
+		for _, n := range path {
+			if e, ok := n.(ast.Expr); ok {
+				exprs = append(exprs, e)
+			}
+		}
+		return exprs
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil || fSet.Position(n.End()).Line < line || fSet.Position(n.Pos()).Line > line {
+			return false
+		}
+		if e, ok := n.(ast.Expr); ok && fSet.Position(e.Pos()).Line == line {
+			exprs = append(exprs, e)
+		}
+		return true
+	})
+	return exprs
+}
+
+// reportCandidates writes the outcome of each attempted match to w: matches first, then failures of candidates
+// of the same kind as the pattern, and finally a count of those which aren't even the same kind of node.
+func reportCandidates(w io.Writer, fSet *token.FileSet, cands []eg.Candidate) {
+	var matched, near []eg.Candidate
+	kinds := make(map[string]int)
+	for _, c := range cands {
+		switch m := c.Mismatch; {
+		case m == nil:
+			matched = append(matched, c)
+		case m.Pattern != nil && m.Input == astutil.Unparen(c.Expr) && reflect.TypeOf(m.Pattern) != reflect.TypeOf(m.Input):
+			kinds[fmt.Sprintf("%T", m.Input)]++
+		default:
+			near = append(near, c)
+		}
+	}
+
+	for _, c := range matched {
+		var names []string
+		for name := range c.Bindings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = name + " -> " + nodeString(fSet, c.Bindings[name])
+		}
+		fmt.Fprintf(w, "%s: %s matches", fSet.Position(c.Expr.Pos()), nodeString(fSet, c.Expr))
+		if len(names) > 0 {
+			fmt.Fprintf(w, " with %s", strings.Join(names, ", "))
+		}
+		fmt.Fprintln(w)
+		if c.TypeChange != nil {
+			fmt.Fprintf(w, "\t%s\n", typeChangeMessage(*c.TypeChange))
+		}
+	}
+	for _, c := range near {
+		m := c.Mismatch
+		fmt.Fprintf(w, "%s: %s does not match: %s\n", fSet.Position(c.Expr.Pos()), nodeString(fSet, c.Expr), m.Reason)
+		if m.Pattern != nil {
+			fmt.Fprintf(w, "\tpattern: %s\n", nodeString(fSet, m.Pattern))
+		}
+		if m.Input != nil {
+			fmt.Fprintf(w, "\tinput:   %s (%s)\n", nodeString(fSet, m.Input), fSet.Position(m.Input.Pos()))
+		}
+	}
+
+	var names []string
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	for _, kind := range names {
+		fmt.Fprintf(w, "%d %s expression(s) don't match the kind of the pattern\n", kinds[kind], kind)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWhy checks that eg why reports the matches a run refuses for their replacements' types as refused.
+func TestWhy(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(filepath.Join("testdata", "why")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		template, location string
+		want, notWant      string
+	}{
+		{
+			template: "templates/contains/contains.go",
+			location: "p/p.go:9", // an if condition, of untyped bool
+			want:     `p.go:9:5: strings.Index(s, "x") != -1 matches with s -> s, sub -> "x"` + "\n",
+			notWant:  "refused",
+		},
+		{
+			template: "templates/errorf/errorf.go",
+			location: "p/p.go:10:10",
+			want:     `p.go:10:10: fmt.Errorf("%s", s) matches with s -> s` + "\n",
+			notWant:  "refused",
+		},
+		{
+			template: "templates/errorf/errorf.go",
+			location: "p/p.go:13:14", // where errors is a local variable
+			want: `p.go:13:14: fmt.Errorf("%s", s) matches with s -> s` + "\n" +
+				"\trefused rewrite: replacement doesn't type check here: errors.New undefined",
+		},
+	} {
+		out, _, err := captureOutput(t, func() error { return whyMain([]string{"-t", test.template, test.location}) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, test.want) || test.notWant != "" && strings.Contains(out, test.notWant) {
+			t.Errorf("eg why -t %s %s printed\n%s\nwant\n%s", test.template, test.location, out, test.want)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const wrapUsage = `Usage: eg wrap -t wrap.go [-w] <packages>...
+
+Wraps the functions and methods in the loaded packages whose signatures match
+a declaration template, e.g. to inject tracing or panic recovery uniformly.
+The template may declare either or both of:
+
+	// wrap's signature is matched against the declarations; its body, which
+	// must end in a call of body(), is inserted at the start of theirs.
+	func wrap(w http.ResponseWriter, r *http.Request) {
+		defer obs.Trace(r.Context(), funcName).End()
+		body()
+	}
+
+	// register's parameter type is matched; references to the declarations
+	// other than calls, e.g. in http.HandleFunc("/", h), are wrapped by it.
+	func register(h http.HandlerFunc) http.HandlerFunc {
+		return obs.Wrap(h)
+	}
+
+along with the placeholders
+
+	func body()         {}
+	const funcName = "" // the wrapped function's name, as pkg.F or T.M
+
+References to wrap's parameters are renamed to those of each declaration.
+Declarations which already begin with wrap's statements, or references which
+are already wrapped, are left, so wrapping again changes nothing.
+`
+
+func wrapMain(args []string) error {
+	fs := flag.NewFlagSet("wrap", flag.ExitOnError)
+	fs.Usage = func() { io.WriteString(os.Stderr, wrapUsage) }
+	tmplFlag := fs.String("t", "", "the declaration template")
+	outputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tmplFlag == "" {
+		return errors.New("no -t template given")
+	}
+
+	m, tmpl, tmplFile, err := loadWithTemplate(*tmplFlag, fs.Args())
+	if err != nil {
+		return err
+	}
+	w, err := newWrapper(m, tmpl, tmplFile)
+	if err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, d := range sortedFuncDecls(m) {
+		if d.decl.Body == nil {
+			continue
+		}
+		sig := d.pkg.TypesInfo.Defs[d.decl.Name].Type()
+		if w.wrap != nil && types.Identical(sig, w.wrapSig) {
+			if err := w.wrapBody(d); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: can't wrap %s: %v\n", m.position(d.decl), d.decl.Name.Name, err)
+				failed = true
+			}
+		}
+		if w.register != nil && types.Identical(sig, w.registerSig.Underlying()) {
+			if err := w.wrapRefs(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := m.emit(failed); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some functions couldn't be wrapped")
+	}
+	return nil
+}
+
+// sortedFuncDecls returns the declarations of the functions and methods in the loaded packages, in file order.
+func sortedFuncDecls(m *migration) []funcDecl {
+	var decls []funcDecl
+	for _, pkg := range m.pkgs {
+		for _, file := range pkg.Syntax {
+			for _, d := range file.Decls {
+				if d, ok := d.(*ast.FuncDecl); ok {
+					decls = append(decls, funcDecl{d, file, pkg})
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// A wrapper applies a declaration template.
+type wrapper struct {
+	m    *migration
+	tmpl *packages.Package
+
+	wrap      *ast.FuncDecl
+	wrapSig   types.Type
+	wrapStmts []ast.Stmt // the statements of wrap's body preceding body()
+
+	register    *ast.FuncDecl
+	registerSig types.Type // the type of register's parameter
+	registered  ast.Expr   // the expression register returns
+
+	funcName types.Object // the placeholder for the wrapped function's name
+}
+
+// newWrapper validates the declaration template tmplFile.
+func newWrapper(m *migration, tmpl *packages.Package, tmplFile *ast.File) (*wrapper, error) {
+	w := &wrapper{m: m, tmpl: tmpl}
+	var body types.Object
+	for _, decl := range tmplFile.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil {
+				continue
+			}
+			switch decl.Name.Name {
+			case "wrap":
+				w.wrap = decl
+			case "register":
+				w.register = decl
+			case "body":
+				body = tmpl.TypesInfo.Defs[decl.Name]
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if vs, ok := spec.(*ast.ValueSpec); ok && decl.Tok == token.CONST {
+					for _, id := range vs.Names {
+						if id.Name == "funcName" {
+							w.funcName = tmpl.TypesInfo.Defs[id]
+						}
+					}
+				}
+			}
+		}
+	}
+	if w.wrap == nil && w.register == nil {
+		return nil, errors.New("template declares neither wrap nor register")
+	}
+
+	if w.wrap != nil {
+		w.wrapSig = tmpl.TypesInfo.Defs[w.wrap.Name].Type()
+		list := w.wrap.Body.List
+		var last *ast.CallExpr
+		if len(list) > 0 {
+			if stmt, ok := list[len(list)-1].(*ast.ExprStmt); ok {
+				last, _ = stmt.X.(*ast.CallExpr)
+			}
+		}
+		if id, ok := callee(last); !ok || body == nil || tmpl.TypesInfo.Uses[id] != body || len(last.Args) > 0 {
+			return nil, fmt.Errorf("%s: wrap's body must end with a call of body()", m.position(w.wrap))
+		}
+		w.wrapStmts = list[:len(list)-1]
+		for _, stmt := range w.wrapStmts {
+			if err := m.checkTemplateRefs(tmpl, stmt, w.funcName); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if w.register != nil {
+		params, results := w.register.Type.Params.List, w.register.Type.Results
+		var ret *ast.ReturnStmt
+		if len(w.register.Body.List) == 1 {
+			ret, _ = w.register.Body.List[0].(*ast.ReturnStmt)
+		}
+		if len(params) != 1 || len(params[0].Names) != 1 || results == nil || len(results.List) != 1 ||
+			ret == nil || len(ret.Results) != 1 {
+			return nil, fmt.Errorf("%s: register must take and return one value, as in return wrap(h)", m.position(w.register))
+		}
+		w.registerSig = tmpl.TypesInfo.TypeOf(params[0].Type)
+		if _, ok := w.registerSig.Underlying().(*types.Signature); !ok {
+			return nil, fmt.Errorf("%s: register's parameter must be a function", m.position(w.register))
+		}
+		if !types.Identical(w.registerSig, tmpl.TypesInfo.TypeOf(results.List[0].Type)) {
+			return nil, fmt.Errorf("%s: register must return the type it takes", m.position(w.register))
+		}
+		w.registered = ret.Results[0]
+		if err := m.checkTemplateRefs(tmpl, w.registered, w.funcName); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// callee returns the identifier called by call, if it's a call of one.
+func callee(call *ast.CallExpr) (*ast.Ident, bool) {
+	if call == nil {
+		return nil, false
+	}
+	id, ok := call.Fun.(*ast.Ident)
+	return id, ok
+}
+
+// checkTemplateRefs reports an error if the template code n refers to tmpl's package-level declarations, other
+// than the placeholders, since they can't be copied with it.
+func (m *migration) checkTemplateRefs(tmpl *packages.Package, n ast.Node, placeholders ...types.Object) error {
+	isPlaceholder := make(map[types.Object]bool)
+	for _, p := range placeholders {
+		isPlaceholder[p] = true
+	}
+	var err error
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && err == nil {
+			obj := tmpl.TypesInfo.Uses[id]
+			if obj != nil && !isPlaceholder[obj] && obj.Parent() == tmpl.Types.Scope() {
+				err = fmt.Errorf("%s: the template refers to its own declaration %s", m.position(id), id.Name)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// funcNameLit returns a string literal of the name of fn, as pkg.F or T.M.
+func funcNameLit(fn types.Object, pos token.Pos) *ast.BasicLit {
+	name := fn.Pkg().Name() + "." + fn.Name()
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		t := recv.Type()
+		if p, ok := t.(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		if named, ok := t.(*types.Named); ok {
+			name = named.Obj().Name() + "." + fn.Name()
+		}
+	}
+	return &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(name)}
+}
+
+// wrapBody inserts wrap's statements at the start of the body of d, unless they're already there.
+func (w *wrapper) wrapBody(d funcDecl) error {
+	info := d.pkg.TypesInfo
+	scope := info.Scopes[d.decl.Type]
+
+	pos := d.decl.Body.Rbrace
+	if len(d.decl.Body.List) > 0 {
+		pos = d.decl.Body.List[0].Pos()
+	}
+
+	// the template's parameters are referred to by the names of the declaration's, named if need be
+	subst := make(map[string]ast.Expr)
+	if w.funcName != nil {
+		subst[w.funcName.Name()] = funcNameLit(info.Defs[d.decl.Name], pos)
+	}
+	tmplParams := w.wrapSig.(*types.Signature).Params()
+	params := paramIdents(d.decl.Type.Params)
+	for i := 0; i < tmplParams.Len(); i++ {
+		p := tmplParams.At(i)
+		if !w.usesParam(p) {
+			continue
+		}
+		id := params[i]
+		if id == nil || id.Name == "_" {
+			if err := checkNewName(info, d.decl, p.Name()); err != nil {
+				return err
+			}
+			id = nameParam(d.decl.Type.Params, i, p.Name())
+			params = paramIdents(d.decl.Type.Params)
+		}
+		subst[p.Name()] = &ast.Ident{NamePos: pos, Name: id.Name}
+	}
+
+	// the names the statements declare mustn't conflict with, or shadow, those the body uses
+	wrapScope := w.tmpl.TypesInfo.Scopes[w.wrap.Type]
+	for _, stmt := range w.wrapStmts {
+		var err error
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok || err != nil {
+				return err == nil
+			}
+			if obj := w.tmpl.TypesInfo.Defs[id]; obj == nil || obj.Parent() != wrapScope {
+				return true
+			}
+			if scope.Lookup(id.Name) != nil {
+				err = fmt.Errorf("it declares %s, as does the template", id.Name)
+			} else if uses := usesOuter(info, d.decl.Body, id.Name, scope); uses != nil {
+				err = fmt.Errorf("the template would shadow the %s it refers to at %s", id.Name, w.m.position(uses))
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var stmts []ast.Stmt
+	for _, stmt := range w.wrapStmts {
+		n, err := w.m.instantiate(w.tmpl, stmt, d.pkg, d.file, pos, subst)
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, n.(ast.Stmt))
+	}
+	if w.wrapped(d.decl.Body.List, stmts) {
+		return nil
+	}
+	d.decl.Body.List = append(stmts, d.decl.Body.List...)
+	w.m.touch(d.pkg, d.file)
+	fmt.Fprintf(os.Stderr, "%s: wrapped %s\n", w.m.position(d.decl), d.decl.Name.Name)
+	return nil
+}
+
+// usesParam reports whether wrap's statements refer to its parameter p.
+func (w *wrapper) usesParam(p *types.Var) bool {
+	var used bool
+	for _, stmt := range w.wrapStmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && w.tmpl.TypesInfo.Uses[id] == p {
+				used = true
+			}
+			return !used
+		})
+	}
+	return used
+}
+
+// wrapped reports whether list already begins with stmts, as printed.
+func (w *wrapper) wrapped(list, stmts []ast.Stmt) bool {
+	if len(list) < len(stmts) {
+		return false
+	}
+	for i, stmt := range stmts {
+		if compactString(w.m.fSet, stmt) != compactString(w.m.fSet, list[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// compactString returns the source of n without whitespace, to compare code regardless of its layout.
+func compactString(fSet *token.FileSet, n ast.Node) string {
+	return strings.Join(strings.Fields(nodeString(fSet, n)), "")
+}
+
+// usesOuter returns a reference within body to an object named name declared outside scope, if there is one.
+func usesOuter(info *types.Info, body *ast.BlockStmt, name string, scope *types.Scope) *ast.Ident {
+	var found *ast.Ident
+	ast.Inspect(body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && found == nil && id.Name == name {
+			if obj := info.Uses[id]; obj != nil && obj.Parent() != nil && !scopeWithin(obj.Parent(), scope) {
+				found = id
+			}
+		}
+		return found == nil
+	})
+	return found
+}
+
+// scopeWithin reports whether s is outer or nested within it.
+func scopeWithin(s, outer *types.Scope) bool {
+	for ; s != nil; s = s.Parent() {
+		if s == outer {
+			return true
+		}
+	}
+	return false
+}
+
+// paramIdents returns the identifier of each parameter in fields, or nil for those unnamed.
+func paramIdents(fields *ast.FieldList) []*ast.Ident {
+	var ids []*ast.Ident
+	for _, f := range fields.List {
+		if len(f.Names) == 0 {
+			ids = append(ids, nil)
+		}
+		ids = append(ids, f.Names...)
+	}
+	return ids
+}
+
+// nameParam names the at'th parameter in fields name, naming the others _ if they were unnamed, and returns its
+// identifier.
+func nameParam(fields *ast.FieldList, at int, name string) *ast.Ident {
+	var i int
+	var named *ast.Ident
+	for _, f := range fields.List {
+		if len(f.Names) == 0 {
+			f.Names = []*ast.Ident{{NamePos: f.Type.Pos(), Name: "_"}}
+		}
+		for _, id := range f.Names {
+			if i == at {
+				id.Name = name
+				named = id
+			}
+			i++
+		}
+	}
+	return named
+}
+
+// wrapRefs wraps the references to the function declared by d other than calls with register, unless they're
+// already wrapped.
+func (w *wrapper) wrapRefs(d funcDecl) error {
+	fn := d.pkg.TypesInfo.Defs[d.decl.Name]
+	param := w.register.Type.Params.List[0].Names[0].Name
+	for _, u := range w.m.uses(fn) {
+		if u.call() != nil {
+			continue
+		}
+		parent, ref := qualifiedIdent(u)
+		arg := ref.(ast.Expr)
+		if !types.Identical(u.pkg.TypesInfo.TypeOf(arg), w.registerSig) {
+			// the reference is converted to the named function type register takes
+			conv, err := w.m.parseExpr(u.pkg, u.file, ref.Pos(), types.TypeString(w.registerSig, w.m.qualifier(u.pkg, u.file))+"(x)", nil)
+			if err != nil {
+				return err
+			}
+			conv.(*ast.CallExpr).Args[0] = arg
+			arg = conv
+		}
+		subst := map[string]ast.Expr{param: arg}
+		if w.funcName != nil {
+			subst[w.funcName.Name()] = funcNameLit(fn, ref.Pos())
+		}
+		n, err := w.m.instantiate(w.tmpl, w.registered, u.pkg, u.file, ref.Pos(), subst)
+		if err != nil {
+			return err
+		}
+		if w.refWrapped(u, compactString(w.m.fSet, n)) {
+			continue
+		}
+		replaceNode(parent, ref, n)
+		w.m.touch(u.pkg, u.file)
+		fmt.Fprintf(os.Stderr, "%s: wrapped reference to %s\n", w.m.position(u.id), d.decl.Name.Name)
+	}
+	return nil
+}
+
+// refWrapped reports whether the use is already within an expression printed as wrapped.
+func (w *wrapper) refWrapped(u use, wrapped string) bool {
+	for _, n := range u.path[1:] {
+		if _, ok := n.(ast.Expr); !ok {
+			return false
+		}
+		if compactString(w.m.fSet, n) == wrapped {
+			return true
+		}
+	}
+	return false
+}
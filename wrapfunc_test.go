@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestWrap(t *testing.T) {
+	runCommandCases(t, "wrap")
+}